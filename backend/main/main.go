@@ -2,7 +2,14 @@ package main
 
 import (
 	"context"
+
 	"github.com/intmian/platform/backend/platform"
+	// 服务在各自包的 init 中向注册表注册，这里引入即启用
+	_ "github.com/intmian/platform/backend/services/account"
+	_ "github.com/intmian/platform/backend/services/auto"
+	_ "github.com/intmian/platform/backend/services/cmd"
+	_ "github.com/intmian/platform/backend/services/todone"
+	_ "github.com/intmian/platform/backend/services/web-storage"
 )
 
 func main() {
@@ -1,15 +1,19 @@
 package platform
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/mian_go_lib/tool/token"
 	"github.com/intmian/mian_go_lib/xbi"
+	"github.com/intmian/mian_go_lib/xstorage"
 	share3 "github.com/intmian/platform/backend/services/account/share"
 	"github.com/intmian/platform/backend/services/todone/log"
 	"github.com/intmian/platform/backend/share"
@@ -37,18 +41,12 @@ func (m *webMgr) login(c *gin.Context) {
 	}
 
 	// 去账号服验证账号密码是否正确，并获取密码对应的权限
-	ret, err := m.plat.core.sendAndRec(share.FlagAccount, share.MakeMsg(share3.CmdCheckToken, share3.CheckTokenReq{
+	retr, err := share.Call[share3.CheckTokenReq, share3.CheckTokenRet](c.Request.Context(), func(ctx context.Context, to share.SvrFlag, msg share.Msg) (interface{}, error) {
+		return m.plat.core.sendAndRec(ctx, to, msg, share.MakeSysValid())
+	}, share.FlagAccount, share3.CmdCheckToken, share3.CheckTokenReq{
 		Account: body.Username,
 		Pwd:     body.Password,
-	}), share.MakeSysValid())
-	if ret == nil {
-		c.JSON(200, gin.H{
-			"code": 1,
-			"msg":  "Password error",
-		})
-		return
-	}
-	retr := ret.(share3.CheckTokenRet)
+	})
 	if err != nil || retr.Pers == nil {
 		c.JSON(200, gin.H{
 			"code": 1,
@@ -238,6 +236,42 @@ func (m *webMgr) getServices(c *gin.Context) {
 	c.JSON(200, info)
 }
 
+func (m *webMgr) getBusMetrics(c *gin.Context) {
+	c.JSON(200, makeOkReturn(m.plat.core.bus.metrics()))
+}
+
+// checkMetrics 配置了 PLAT.metrics.token 时 Prometheus 用 Authorization: Bearer <token> 拉取，否则按管理员校验
+func (m *webMgr) checkMetrics(c *gin.Context) {
+	token, err := m.plat.cfg.Get("PLAT.metrics.token")
+	if err == nil && metricsTokenValid(c.GetHeader("Authorization"), xstorage.ToBase[string](token)) {
+		return
+	}
+	m.checkAdmin(c)
+}
+
+// metricsTokenValid 没有配置令牌时不放行，比较时间固定避免逐字节试探
+func metricsTokenValid(authorization, token string) bool {
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func (m *webMgr) getMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(200)
+	m.plat.core.metrics.writePrometheus(c.Writer)
+}
+
+func (m *webMgr) getRpcCommands(c *gin.Context) {
+	c.JSON(200, makeOkReturn(m.plat.core.getRpcCommands()))
+}
+
+func (m *webMgr) getRpcPermissions(c *gin.Context) {
+	c.JSON(200, makeOkReturn(m.plat.core.getRpcPermissions()))
+}
+
 func (m *webMgr) startService(c *gin.Context) {
 	name := c.Param("name")
 	flag := m.plat.getFlag(share.SvrName(name))
@@ -286,6 +320,42 @@ func (m *webMgr) stopService(c *gin.Context) {
 	})
 }
 
+// setServiceBootPolicy 修改服务的启动策略，下次平台启动时生效，disabled 会立即阻止手动启动
+func (m *webMgr) setServiceBootPolicy(c *gin.Context) {
+	name := c.Param("name")
+	flag := m.plat.getFlag(share.SvrName(name))
+	if flag == share.FlagNone {
+		c.JSON(200, gin.H{
+			"code": 1,
+			"msg":  "service not exist",
+		})
+		return
+	}
+	body := struct {
+		Policy string `json:"policy"`
+	}{}
+	err := c.BindJSON(&body)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"code": 1,
+			"msg":  err.Error(),
+		})
+		return
+	}
+	err = m.plat.core.setBootPolicy(flag, share.BootPolicy(body.Policy))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"code": 1,
+			"msg":  err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"code": 0,
+		"msg":  "ok",
+	})
+}
+
 func (m *webMgr) getLastLog(c *gin.Context) {
 	logs, err := m.plat.news.GetTopic("PLAT")
 	// 翻转
@@ -558,6 +628,16 @@ func (m *webMgr) searchBiLog(c *gin.Context) {
 		} else {
 			c.JSON(200, makeErrReturn("read log failed: "+err.Error()))
 		}
+	case (&RpcDenyLogEntity{}).TableName():
+		data, count, err := xbi.ReadLogWithFilter[RpcDenyLogEntity](m.plat.bi, table, filter)
+		if err == nil {
+			c.JSON(200, makeOkReturn(map[string]interface{}{
+				"List":  data,
+				"Total": count,
+			}))
+		} else {
+			c.JSON(200, makeErrReturn("read log failed: "+err.Error()))
+		}
 	default:
 		c.JSON(200, makeErrReturn("unknown table: "+table))
 		return
@@ -14,6 +14,7 @@ func (m *webMgr) initAdminRoot(r *gin.Engine) {
 	admin.POST("/services", m.getServices)
 	admin.POST("/service/:name/start", m.startService)
 	admin.POST("/service/:name/stop", m.stopService)
+	admin.POST("/service/:name/boot_policy", m.setServiceBootPolicy)
 	admin.POST("/storage/get", m.plat.stoWebPack.WebGet)
 	admin.POST("/storage/set", m.plat.stoWebPack.WebSet)
 	admin.POST("/storage/get_all", m.plat.stoWebPack.WebGetAll)
@@ -21,4 +22,7 @@ func (m *webMgr) initAdminRoot(r *gin.Engine) {
 	admin.POST("/system/usage", m.getSystemUsage)
 	admin.GET("/system/usage/sse", m.getSystemUsageSSE)
 	admin.POST("/bi_log/:table/search", m.searchBiLog)
+	admin.POST("/bus/metrics", m.getBusMetrics)
+	admin.POST("/rpc/commands", m.getRpcCommands)
+	admin.POST("/rpc/permissions", m.getRpcPermissions)
 }
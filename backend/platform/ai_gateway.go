@@ -1,6 +1,7 @@
 package platform
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"strings"
@@ -30,7 +31,7 @@ func (m *webMgr) aiRun(c *gin.Context) {
 		return
 	}
 
-	ret, err := handler.Run(req.Payload)
+	ret, err := handler.Run(c.Request.Context(), req.Payload)
 	if err != nil {
 		c.JSON(200, makeErrReturn(err.Error()))
 		return
@@ -42,14 +43,14 @@ func (m *webMgr) aiActionHandlers() map[aiAction]aiActionHandler {
 	return map[aiAction]aiActionHandler{
 		aiActionLibraryReviewNotesDigest: {
 			Permissions: []share.Permission{share.PermissionAI},
-			Run: func(payload json.RawMessage) (interface{}, error) {
-				return m.handleLibraryReviewNotesDigest(payload)
+			Run: func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+				return m.handleLibraryReviewNotesDigest(ctx, payload)
 			},
 		},
 	}
 }
 
-func (m *webMgr) handleLibraryReviewNotesDigest(payload json.RawMessage) (libraryReviewDigestResp, error) {
+func (m *webMgr) handleLibraryReviewNotesDigest(ctx context.Context, payload json.RawMessage) (libraryReviewDigestResp, error) {
 	var req libraryReviewNoteDigestPayload
 	if err := json.Unmarshal(payload, &req); err != nil {
 		return libraryReviewDigestResp{}, errors.New("invalid payload")
@@ -90,7 +91,7 @@ func (m *webMgr) handleLibraryReviewNotesDigest(payload json.RawMessage) (librar
 		string(promptBytes),
 	}, "\n")
 
-	content, err := m.chatAI(share.AISceneLibraryReviewDigest, prompt)
+	content, err := m.chatAI(ctx, share.AISceneLibraryReviewDigest, prompt)
 	if err != nil {
 		return libraryReviewDigestResp{}, err
 	}
@@ -102,12 +103,12 @@ func (m *webMgr) handleLibraryReviewNotesDigest(payload json.RawMessage) (librar
 	return normalizeLibraryReviewDigestResp(ret), nil
 }
 
-func (m *webMgr) chatAI(scene share.AIScene, prompt string) (string, error) {
+func (m *webMgr) chatAI(ctx context.Context, scene share.AIScene, prompt string) (string, error) {
 	chat, err := share.NewSceneAI(m.plat.cfg, scene)
 	if err != nil {
 		return "", err
 	}
-	content, err := chat.Chat(prompt)
+	content, err := chat.ChatContext(ctx, prompt)
 	if err != nil {
 		return "", err
 	}
@@ -1,6 +1,7 @@
 package platform
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/intmian/platform/backend/share"
@@ -19,7 +20,7 @@ type aiRunReq struct {
 
 type aiActionHandler struct {
 	Permissions []share.Permission
-	Run         func(json.RawMessage) (interface{}, error)
+	Run         func(context.Context, json.RawMessage) (interface{}, error)
 }
 
 type libraryReviewNoteDigestPayload struct {
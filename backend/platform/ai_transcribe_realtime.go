@@ -92,6 +92,12 @@ func (m *webMgr) aiTranscribeRealtime(c *gin.Context) {
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), realtimeTranscriptionTimeout)
 	defer cancel()
+	stream := &webStream{conn: client, cancel: cancel}
+	if !m.trackStream(stream) {
+		stream.close("server shutdown")
+		return
+	}
+	defer m.untrackStream(stream)
 
 	items, err := m.resolveRealtimeTranscriptionItems()
 	if err != nil {
@@ -0,0 +1,234 @@
+package platform
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/platform/backend/share"
+	"github.com/pkg/errors"
+)
+
+// 消息总线相关参数
+const (
+	busQueueSize      = 256                    // 每个服务的待处理队列长度
+	busPublishTimeout = 100 * time.Millisecond // 队列满时发送方最多等待的时间，超时进入死信
+	busDeadLetterKeep = 100                    // 保留最近的死信条数，方便后台查看
+)
+
+// busItem 队列中的一次投递
+type busItem struct {
+	topic   share.Topic // 直接发送时为空
+	msg     share.Msg
+	handler func(msg share.Msg)
+}
+
+type busSub struct {
+	flag    share.SvrFlag
+	handler func(msg share.Msg)
+}
+
+// busQueue 每个服务一个有界队列，由单独的协程串行处理，避免一个慢服务拖慢其他服务
+type busQueue struct {
+	flag      share.SvrFlag
+	ch        chan busItem
+	delivered atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+}
+
+type deadLetter struct {
+	Time   time.Time
+	To     string
+	Topic  string
+	Cmd    string
+	Reason string
+}
+
+type busQueueMetrics struct {
+	Service   string
+	QueueLen  int
+	QueueCap  int
+	Delivered int64
+	Dropped   int64
+	Failed    int64
+}
+
+type busMetrics struct {
+	Queues      []busQueueMetrics
+	Published   map[string]int64
+	Subscribers map[string][]string
+	DeadLetters []deadLetter
+}
+
+// msgBus 进程内消息总线，支持服务间直接投递与按主题发布订阅
+type msgBus struct {
+	ctx     context.Context
+	log     *xlog.XLog
+	getName func(flag share.SvrFlag) share.SvrName
+
+	lock        sync.Mutex
+	queues      map[share.SvrFlag]*busQueue
+	subs        map[share.Topic][]busSub
+	published   map[share.Topic]int64
+	deadLetters []deadLetter
+}
+
+func newMsgBus(ctx context.Context, log *xlog.XLog, getName func(flag share.SvrFlag) share.SvrName) *msgBus {
+	return &msgBus{
+		ctx:       ctx,
+		log:       log,
+		getName:   getName,
+		queues:    make(map[share.SvrFlag]*busQueue),
+		subs:      make(map[share.Topic][]busSub),
+		published: make(map[share.Topic]int64),
+	}
+}
+
+func (b *msgBus) getQueue(flag share.SvrFlag) *busQueue {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	q, ok := b.queues[flag]
+	if ok {
+		return q
+	}
+	q = &busQueue{
+		flag: flag,
+		ch:   make(chan busItem, busQueueSize),
+	}
+	b.queues[flag] = q
+	go b.runQueue(q)
+	return q
+}
+
+func (b *msgBus) runQueue(q *busQueue) {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case item := <-q.ch:
+			err := safeServiceCall(func() error {
+				item.handler(item.msg)
+				return nil
+			})
+			if err != nil {
+				q.failed.Add(1)
+				b.deadLetter(q.flag, item, err.Error())
+				continue
+			}
+			q.delivered.Add(1)
+		}
+	}
+}
+
+// deliver 投递到指定服务的队列，队列满时最多等待 busPublishTimeout，仍然满则进入死信
+func (b *msgBus) deliver(flag share.SvrFlag, item busItem) {
+	q := b.getQueue(flag)
+	select {
+	case q.ch <- item:
+		return
+	default:
+	}
+	timer := time.NewTimer(busPublishTimeout)
+	defer timer.Stop()
+	select {
+	case q.ch <- item:
+	case <-timer.C:
+		q.dropped.Add(1)
+		b.deadLetter(flag, item, "queue full")
+	case <-b.ctx.Done():
+		q.dropped.Add(1)
+		b.deadLetter(flag, item, "bus closed")
+	}
+}
+
+// send 直接投递给某个服务
+func (b *msgBus) send(flag share.SvrFlag, msg share.Msg, handler func(msg share.Msg)) {
+	b.deliver(flag, busItem{msg: msg, handler: handler})
+}
+
+// publish 发布给所有订阅了该主题的服务
+func (b *msgBus) publish(topic share.Topic, msg share.Msg) {
+	b.lock.Lock()
+	b.published[topic]++
+	subs := append([]busSub(nil), b.subs[topic]...)
+	b.lock.Unlock()
+	for _, sub := range subs {
+		b.deliver(sub.flag, busItem{topic: topic, msg: msg, handler: sub.handler})
+	}
+}
+
+func (b *msgBus) subscribe(flag share.SvrFlag, topic share.Topic, handler func(msg share.Msg)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subs[topic] = append(b.subs[topic], busSub{flag: flag, handler: handler})
+}
+
+// unsubscribeAll 服务停止时退订其全部主题，重新启动时由服务自己再订阅
+func (b *msgBus) unsubscribeAll(flag share.SvrFlag) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for topic, subs := range b.subs {
+		kept := subs[:0]
+		for _, sub := range subs {
+			if sub.flag != flag {
+				kept = append(kept, sub)
+			}
+		}
+		if len(kept) == 0 {
+			delete(b.subs, topic)
+			continue
+		}
+		b.subs[topic] = kept
+	}
+}
+
+func (b *msgBus) deadLetter(flag share.SvrFlag, item busItem, reason string) {
+	d := deadLetter{
+		Time:   time.Now(),
+		To:     string(b.getName(flag)),
+		Topic:  string(item.topic),
+		Cmd:    string(item.msg.Cmd()),
+		Reason: reason,
+	}
+	b.lock.Lock()
+	b.deadLetters = append(b.deadLetters, d)
+	if len(b.deadLetters) > busDeadLetterKeep {
+		b.deadLetters = b.deadLetters[len(b.deadLetters)-busDeadLetterKeep:]
+	}
+	b.lock.Unlock()
+	if b.log != nil {
+		b.log.WarningErr("PLAT.BUS", errors.Errorf("dead letter to %s topic %s cmd %s: %s", d.To, d.Topic, d.Cmd, reason))
+	}
+}
+
+func (b *msgBus) metrics() busMetrics {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ret := busMetrics{
+		Published:   make(map[string]int64, len(b.published)),
+		Subscribers: make(map[string][]string, len(b.subs)),
+		DeadLetters: append([]deadLetter(nil), b.deadLetters...),
+	}
+	for _, q := range b.queues {
+		ret.Queues = append(ret.Queues, busQueueMetrics{
+			Service:   string(b.getName(q.flag)),
+			QueueLen:  len(q.ch),
+			QueueCap:  cap(q.ch),
+			Delivered: q.delivered.Load(),
+			Dropped:   q.dropped.Load(),
+			Failed:    q.failed.Load(),
+		})
+	}
+	for topic, count := range b.published {
+		ret.Published[string(topic)] = count
+	}
+	for topic, subs := range b.subs {
+		for _, sub := range subs {
+			ret.Subscribers[string(topic)] = append(ret.Subscribers[string(topic)], string(b.getName(sub.flag)))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,85 @@
+package platform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/share"
+)
+
+func newTestBus(t *testing.T) *msgBus {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return newMsgBus(ctx, nil, func(flag share.SvrFlag) share.SvrName {
+		return share.SvrName(rune('a' + flag))
+	})
+}
+
+func waitBus(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait bus timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMsgBusPublishSubscribe(t *testing.T) {
+	b := newTestBus(t)
+	got := make(chan share.TodoneTaskDoneEvent, 2)
+	b.subscribe(share.FlagAuto, share.TopicTodoneTaskDone, func(msg share.Msg) {
+		var event share.TodoneTaskDoneEvent
+		if err := msg.Data(&event); err != nil {
+			t.Errorf("data err: %v", err)
+		}
+		got <- event
+	})
+
+	b.publish(share.TopicTodoneTaskDone, share.MakeMsg("done", share.TodoneTaskDoneEvent{UserID: "u", TaskID: 1}))
+	select {
+	case event := <-got:
+		if event.UserID != "u" || event.TaskID != 1 {
+			t.Fatalf("event = %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	b.unsubscribeAll(share.FlagAuto)
+	b.publish(share.TopicTodoneTaskDone, share.MakeMsg("done", share.TodoneTaskDoneEvent{}))
+	m := b.metrics()
+	if m.Published[string(share.TopicTodoneTaskDone)] != 2 || len(m.Subscribers) != 0 {
+		t.Fatalf("metrics = %+v", m)
+	}
+}
+
+func TestMsgBusDeadLetter(t *testing.T) {
+	b := newTestBus(t)
+	b.send(share.FlagCmd, share.MakeMsg("panic", nil), func(msg share.Msg) {
+		panic("handler broken")
+	})
+	waitBus(t, func() bool {
+		return len(b.metrics().DeadLetters) == 1
+	})
+
+	// 处理协程被阻塞后队列写满，后续消息进入死信
+	block := make(chan struct{})
+	defer close(block)
+	for i := 0; i < busQueueSize+2; i++ {
+		b.send(share.FlagTodone, share.MakeMsg("slow", nil), func(msg share.Msg) {
+			<-block
+		})
+	}
+	m := b.metrics()
+	var dropped int64
+	for _, q := range m.Queues {
+		dropped += q.Dropped
+	}
+	if dropped == 0 {
+		t.Fatalf("expected dropped messages, metrics = %+v", m.Queues)
+	}
+}
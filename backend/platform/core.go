@@ -3,14 +3,11 @@ package platform
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/mian_go_lib/xstorage"
-	"github.com/intmian/platform/backend/services/account"
-	"github.com/intmian/platform/backend/services/auto"
-	"github.com/intmian/platform/backend/services/cmd"
-	"github.com/intmian/platform/backend/services/todone"
 	coreShare "github.com/intmian/platform/backend/share"
 	"github.com/pkg/errors"
 )
@@ -23,6 +20,12 @@ type core struct {
 	startTime   time.Time
 	service     map[coreShare.SvrFlag]coreShare.IService
 	serviceMeta map[coreShare.SvrFlag]*coreShare.ServiceMeta
+	serviceDesc map[coreShare.SvrFlag]coreShare.ServiceDesc
+	startOrder  []coreShare.SvrFlag // 按依赖排好的启动顺序
+	lock        sync.Mutex          // 保护服务的启停与 serviceMeta
+	closed      bool                // 平台退出中，不再启动任何服务
+	bus         *msgBus
+	metrics     *rpcMetrics
 	plat        *PlatForm
 }
 
@@ -34,9 +37,16 @@ func (c *core) Init(plat *PlatForm) error {
 	c.ctx = c.plat.ctx
 	c.service = make(map[coreShare.SvrFlag]coreShare.IService)
 	c.serviceMeta = make(map[coreShare.SvrFlag]*coreShare.ServiceMeta)
+	c.serviceDesc = make(map[coreShare.SvrFlag]coreShare.ServiceDesc)
 	c.startTime = time.Now()
-	c.registerSvr()
-	err := c.plat.push.Push("PLAT", "服务器已启动", false)
+	c.bus = newMsgBus(c.ctx, c.plat.log, c.plat.getName)
+	c.metrics = newRpcMetrics()
+	err := c.registerSvr(coreShare.GServiceRegistry)
+	if err != nil {
+		return errors.WithMessage(err, "registerSvr err")
+	}
+	go c.supervise()
+	err = c.plat.push.Push("PLAT", "服务器已启动", false)
 	if err != nil {
 		c.plat.log.WarningErr("PLAT", errors.WithMessage(err, "push Init err"))
 	}
@@ -45,6 +55,12 @@ func (c *core) Init(plat *PlatForm) error {
 }
 
 func (c *core) startService(flag coreShare.SvrFlag) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.startServiceLocked(flag)
+}
+
+func (c *core) startServiceLocked(flag coreShare.SvrFlag) error {
 	name := c.plat.getName(flag)
 	//v := xstorage.ToUnit[bool](true, xstorage.ValueTypeBool)
 	//err := c.plat.Lobal.c.plat.storage.Set(xstorage.Join(string(name), "open"), v)
@@ -54,32 +70,69 @@ func (c *core) startService(flag coreShare.SvrFlag) error {
 	if _, ok := c.service[flag]; !ok {
 		return errors.New("service not exist")
 	}
-	err := c.service[flag].Start(coreShare.ServiceShare{
-		Log:     c.plat.log,
-		Push:    c.plat.push,
-		Storage: c.plat.storage,
-		Cfg:     c.plat.cfg,
-		Bi:      c.plat.bi,
-		CallOther: func(to coreShare.SvrFlag, msg coreShare.Msg) {
-			c.onRec(to, msg, coreShare.Valid{FromSys: true})
-		},
-		CallOtherRpc: func(to coreShare.SvrFlag, msg coreShare.Msg) (interface{}, error) {
-			return c.onRecRpc(to, msg, coreShare.Valid{FromSys: true})
-		},
-		BaseSetting: c.plat.baseSetting.Copy(),
-		Ctx:         c.ctx,
+	if c.closed {
+		return errors.New("platform shutting down")
+	}
+	meta := c.serviceMeta[flag]
+	if meta.BootPolicy == coreShare.BootDisabled {
+		return errors.New("service disabled")
+	}
+	if isRunning(meta.Status) {
+		return errors.New("service already started")
+	}
+	for _, dep := range c.serviceDesc[flag].Deps {
+		depFlag := c.plat.getFlag(dep)
+		if depMeta := c.serviceMeta[depFlag]; depMeta == nil || !isRunning(depMeta.Status) {
+			// 记为失败，依赖恢复后由监管按退避重试
+			err := errors.Errorf("dependency %s not started", dep)
+			c.markFailedLocked(flag, err)
+			return err
+		}
+	}
+	err := safeServiceCall(func() error {
+		return c.service[flag].Start(coreShare.ServiceShare{
+			Log:     c.plat.log,
+			Push:    c.plat.push,
+			Storage: c.plat.storage,
+			Cfg:     c.plat.cfg,
+			Bi:      c.plat.bi,
+			CallOther: func(to coreShare.SvrFlag, msg coreShare.Msg) {
+				c.onRec(to, msg, coreShare.Valid{FromSys: true})
+			},
+			CallOtherRpc: func(ctx context.Context, to coreShare.SvrFlag, msg coreShare.Msg) (interface{}, error) {
+				return c.onRecRpc(ctx, to, msg, coreShare.Valid{FromSys: true})
+			},
+			Publish: func(topic coreShare.Topic, msg coreShare.Msg) {
+				c.bus.publish(topic, msg)
+			},
+			Subscribe: func(topic coreShare.Topic, handler func(msg coreShare.Msg)) {
+				c.bus.subscribe(flag, topic, handler)
+			},
+			BaseSetting: c.plat.baseSetting.Copy(),
+			Ctx:         c.ctx,
+		})
 	})
 	if err != nil {
-		c.plat.log.ErrorErr("PLAT", errors.WithMessagef(err, "startService %d err", flag))
+		err = errors.WithMessagef(err, "startService %s err", name)
+		c.plat.log.ErrorErr("PLAT", err)
+		c.markFailedLocked(flag, err)
+		return err
 	}
 	//err = c.plat.push.Push("PLAT", fmt.Sprintf("服务 %s 成功启动", name), false)
-	c.serviceMeta[flag].Status = coreShare.StatusStart
-	c.serviceMeta[flag].StartTime = time.Now()
+	meta.Status = coreShare.StatusStart
+	meta.StartTime = time.Now()
+	meta.LastErr = ""
+	if _, ok := c.service[flag].(coreShare.IHealthProbe); !ok {
+		// 没有健康检查的服务启动成功即视为恢复
+		c.resetFailLocked(flag)
+	}
 	c.plat.log.Info("PLAT", fmt.Sprintf("服务 %s 成功启动", name))
 	return nil
 }
 
 func (c *core) stopService(flag coreShare.SvrFlag) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	name := c.plat.getName(flag)
 	//v := xstorage.ToUnit[bool](false, xstorage.ValueTypeBool)
 	//err := c.plat.Lobal.c.plat.storage.Set(xstorage.Join(string(name), "open"), v)
@@ -90,12 +143,30 @@ func (c *core) stopService(flag coreShare.SvrFlag) error {
 		return errors.New("service not exist")
 	}
 	svr := c.service[flag]
-	if misc.HasProperty(svr.GetProp(), coreShare.SvrPropCore) || misc.HasProperty(svr.GetProp(), coreShare.SvrPropCoreOptional) {
+	if isCoreService(svr) {
 		return errors.New("can't stop core service")
 	}
-	err := svr.Stop()
+	for _, other := range c.startOrder {
+		if !isRunning(c.serviceMeta[other].Status) {
+			continue
+		}
+		for _, dep := range c.serviceDesc[other].Deps {
+			if dep == name {
+				return errors.Errorf("service %s depends on it", c.plat.getName(other))
+			}
+		}
+	}
+	var err error
+	if isRunning(c.serviceMeta[flag].Status) {
+		// 失败状态的服务已经在失败时停止过了，不再重复调用
+		c.plat.webMgr.closeStreams(flag, "service stopped")
+		err = safeServiceCall(svr.Stop)
+	}
+	c.bus.unsubscribeAll(flag)
 	c.serviceMeta[flag].StartTime = time.Now()
 	c.serviceMeta[flag].Status = coreShare.StatusStop
+	c.serviceMeta[flag].FailCount = 0
+	c.serviceMeta[flag].LastErr = ""
 	if err != nil {
 		c.plat.log.ErrorErr("PLAT", errors.WithMessagef(err, "stopService %s err", name))
 	}
@@ -107,21 +178,48 @@ func (c *core) stopService(flag coreShare.SvrFlag) error {
 	return nil
 }
 
-func (c *core) registerSvr() {
-	c.service[coreShare.FlagAuto] = &auto.Service{}
-	c.service[coreShare.FlagAccount] = &account.Service{}
-	c.service[coreShare.FlagCmd] = &cmd.Service{}
-	c.service[coreShare.FlagTodone] = &todone.Service{}
-	// 新增服务要在这里注册
-	for k, _ := range c.service {
-		c.serviceMeta[k] = &coreShare.ServiceMeta{}
-		u := xstorage.ToUnit[bool](true, xstorage.ValueTypeBool)
-		openV, err := c.plat.storage.GetAndSetDefault(xstorage.Join(string(coreShare.NameAuto), "open_when_start"), u)
+// stopAll 平台退出时按依赖逆序停止所有运行中的服务，核心服务也一并停止
+func (c *core) stopAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	for i := len(c.startOrder) - 1; i >= 0; i-- {
+		flag := c.startOrder[i]
+		meta := c.serviceMeta[flag]
+		running := isRunning(meta.Status)
+		meta.Status = coreShare.StatusStop
+		meta.StartTime = time.Now()
+		if !running {
+			continue
+		}
+		name := c.plat.getName(flag)
+		c.bus.unsubscribeAll(flag)
+		c.plat.webMgr.closeStreams(flag, "service stopped")
+		err := safeServiceCall(c.service[flag].Stop)
 		if err != nil {
-			c.plat.log.ErrorErr("PLAT", errors.WithMessagef(err, "registerSvr get %s open err", coreShare.NameAuto))
+			c.plat.log.ErrorErr("PLAT", errors.WithMessagef(err, "stopAll stop %s err", name))
 			continue
 		}
-		if openV == nil || !xstorage.ToBase[bool](openV) {
+		c.plat.log.Info("PLAT", fmt.Sprintf("服务 %s 已停止", name))
+	}
+}
+
+// registerSvr 从注册表中发现服务，并按依赖顺序启动
+func (c *core) registerSvr(registry *coreShare.ServiceRegistry) error {
+	descs, err := registry.StartOrder()
+	if err != nil {
+		return err
+	}
+	for _, desc := range descs {
+		c.service[desc.Flag] = desc.New()
+		c.serviceMeta[desc.Flag] = &coreShare.ServiceMeta{}
+		c.serviceDesc[desc.Flag] = desc
+		c.startOrder = append(c.startOrder, desc.Flag)
+	}
+	for _, k := range c.startOrder {
+		c.serviceMeta[k].BootPolicy = c.loadBootPolicy(k)
+		if c.serviceMeta[k].BootPolicy != coreShare.BootAuto {
+			c.plat.log.Info("PLAT", fmt.Sprintf("服务 %s 启动策略为 %s，跳过启动", c.plat.getName(k), c.serviceMeta[k].BootPolicy))
 			continue
 		}
 		err = c.startService(k)
@@ -129,6 +227,61 @@ func (c *core) registerSvr() {
 			c.plat.log.ErrorErr("PLAT", errors.WithMessage(err, "registerSvr start err"))
 		}
 	}
+	return nil
+}
+
+func bootPolicyKey(name coreShare.SvrName) string {
+	return xstorage.Join(string(name), "boot_policy")
+}
+
+// isCoreService 核心服务不能停止，也不受启动策略影响
+func isCoreService(svr coreShare.IService) bool {
+	return misc.HasProperty(svr.GetProp(), coreShare.SvrPropCore) || misc.HasProperty(svr.GetProp(), coreShare.SvrPropCoreOptional)
+}
+
+// loadBootPolicy 读取服务的启动策略，没有时写入服务声明的默认值
+func (c *core) loadBootPolicy(flag coreShare.SvrFlag) coreShare.BootPolicy {
+	if isCoreService(c.service[flag]) {
+		return coreShare.BootAuto
+	}
+	name := c.plat.getName(flag)
+	def := c.serviceDesc[flag].DefaultBoot
+	v, err := c.plat.storage.GetAndSetDefault(bootPolicyKey(name), xstorage.ToUnit[string](string(def), xstorage.ValueTypeString))
+	if err != nil {
+		c.plat.log.ErrorErr("PLAT", errors.WithMessagef(err, "loadBootPolicy get %s err", name))
+		return def
+	}
+	if v == nil {
+		return def
+	}
+	policy := coreShare.BootPolicy(xstorage.ToBase[string](v))
+	if !policy.Valid() {
+		c.plat.log.Warning("PLAT", "服务 %s 启动策略 %s 非法，使用默认值 %s", name, policy, def)
+		return def
+	}
+	return policy
+}
+
+func (c *core) setBootPolicy(flag coreShare.SvrFlag, policy coreShare.BootPolicy) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.service[flag]; !ok {
+		return errors.New("service not exist")
+	}
+	if !policy.Valid() {
+		return errors.Errorf("invalid boot policy %s", policy)
+	}
+	if isCoreService(c.service[flag]) && policy != coreShare.BootAuto {
+		return errors.New("core service must auto start")
+	}
+	name := c.plat.getName(flag)
+	err := c.plat.storage.Set(bootPolicyKey(name), xstorage.ToUnit[string](string(policy), xstorage.ValueTypeString))
+	if err != nil {
+		return errors.WithMessagef(err, "setBootPolicy %s err", name)
+	}
+	c.serviceMeta[flag].BootPolicy = policy
+	c.plat.log.Info("PLAT", fmt.Sprintf("服务 %s 启动策略修改为 %s", name, policy))
+	return nil
 }
 
 func (c *core) getServiceMeta(flag coreShare.SvrFlag) *coreShare.ServiceMeta {
@@ -136,43 +289,132 @@ func (c *core) getServiceMeta(flag coreShare.SvrFlag) *coreShare.ServiceMeta {
 }
 
 func (c *core) getWebInfo() []coreShare.ServicesInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	var ret []coreShare.ServicesInfo
 	ret = append(ret, coreShare.ServicesInfo{
-		Name:      "core",
-		Status:    getStatusStr(coreShare.StatusStart),
-		StartTime: c.startTime.Format("2006-01-02 15:04:05"),
-		Props:     int(misc.CreateProperty(coreShare.SvrPropCore)),
+		Name:       "core",
+		Status:     getStatusStr(coreShare.StatusStart),
+		StartTime:  c.startTime.Format("2006-01-02 15:04:05"),
+		Props:      int(misc.CreateProperty(coreShare.SvrPropCore)),
+		BootPolicy: string(coreShare.BootAuto),
 	})
-	for k, v := range c.serviceMeta {
+	for _, k := range c.startOrder {
+		v := c.serviceMeta[k]
 		service := c.service[k]
 		if service != nil {
+			var deps []string
+			for _, dep := range c.serviceDesc[k].Deps {
+				deps = append(deps, string(dep))
+			}
 			ret = append(ret, coreShare.ServicesInfo{
-				Name:      string(c.plat.getName(k)),
-				Status:    getStatusStr(v.Status),
-				StartTime: v.StartTime.Format("2006-01-02 15:04:05"),
-				Props:     int(service.GetProp()),
+				Name:       string(c.plat.getName(k)),
+				Status:     getStatusStr(v.Status),
+				StartTime:  v.StartTime.Format("2006-01-02 15:04:05"),
+				Props:      int(service.GetProp()),
+				Deps:       deps,
+				BootPolicy: string(v.BootPolicy),
+				FailCount:  v.FailCount,
+				LastErr:    v.LastErr,
 			})
 		}
 	}
 	return ret
 }
 
+// rpcCommands 服务名与其注册的全部命令
+type rpcCommands struct {
+	Service  string
+	Commands []coreShare.RpcCmdInfo
+}
+
+// getRpcCommands 列出所有使用 RpcRouter 的服务的命令及请求、返回的结构，停止的服务也会列出
+func (c *core) getRpcCommands() []rpcCommands {
+	var ret []rpcCommands
+	for _, flag := range c.startOrder {
+		rpcSvr, ok := c.service[flag].(coreShare.IRpcService)
+		if !ok || rpcSvr.RpcRouter() == nil {
+			continue
+		}
+		ret = append(ret, rpcCommands{
+			Service:  string(c.serviceDesc[flag].Name),
+			Commands: rpcSvr.RpcRouter().Commands(),
+		})
+	}
+	return ret
+}
+
 func (c *core) getStartTime() time.Time {
 	return c.startTime
 }
 
-func (c *core) onRecRpc(flag coreShare.SvrFlag, msg coreShare.Msg, valid coreShare.Valid) (interface{}, error) {
-	rpc, err := c.service[flag].HandleRpc(msg, valid)
+// onRecRpc 同步调用服务，ctx 取消后服务应尽快返回
+func (c *core) onRecRpc(ctx context.Context, flag coreShare.SvrFlag, msg coreShare.Msg, valid coreShare.Valid) (rpc interface{}, err error) {
+	svr, ok := c.service[flag]
+	if !ok {
+		return nil, errors.New("service not exist")
+	}
+	if c.metrics != nil {
+		begin := time.Now()
+		defer func() {
+			c.metrics.observe(string(c.serviceDesc[flag].Name), metricCmd(svr, msg.Cmd()), time.Since(begin), err != nil)
+		}()
+	}
+	err = c.checkRpcPermission(flag, svr, msg.Cmd(), valid)
+	if err != nil {
+		return nil, err
+	}
+	rpc, err = svr.HandleRpc(ctx, msg, valid)
 	if err != nil {
 		return nil, err
 	}
 	return rpc, nil
 }
 
-func (c *core) sendAndRec(flag coreShare.SvrFlag, msg coreShare.Msg, valid coreShare.Valid) (interface{}, error) {
-	return c.onRecRpc(flag, msg, valid)
+// onRecStream 把升级后的长连接交给服务，权限与 onRecRpc 一样按声明校验，服务没有实现 IStreamService 时返回 ErrRpcCmdNotFound。
+// 连接时长不进rpc耗时直方图，只单独记录连接次数与出错次数
+func (c *core) onRecStream(ctx context.Context, flag coreShare.SvrFlag, cmd coreShare.Cmd, valid coreShare.Valid, conn coreShare.StreamConn) (err error) {
+	svr, ok := c.service[flag]
+	if !ok {
+		return errors.New("service not exist")
+	}
+	if c.metrics != nil {
+		defer func() {
+			c.metrics.observeStream(string(c.serviceDesc[flag].Name), metricCmd(svr, cmd), err != nil)
+		}()
+	}
+	streamSvr, ok := svr.(coreShare.IStreamService)
+	if !ok {
+		return errors.Wrap(coreShare.ErrRpcCmdNotFound, string(cmd))
+	}
+	err = c.checkRpcPermission(flag, svr, cmd, valid)
+	if err != nil {
+		return err
+	}
+	return streamSvr.HandleStream(ctx, cmd, valid, conn)
+}
+
+// metricCmd 指标中使用的命令名，使用 RpcRouter 的服务未注册的命令统一归为 unknownCmd
+func metricCmd(svr coreShare.IService, cmd coreShare.Cmd) string {
+	rpcSvr, ok := svr.(coreShare.IRpcService)
+	if ok && rpcSvr.RpcRouter() != nil && !rpcSvr.RpcRouter().Has(cmd) {
+		return unknownCmd
+	}
+	return string(cmd)
 }
 
+func (c *core) sendAndRec(ctx context.Context, flag coreShare.SvrFlag, msg coreShare.Msg, valid coreShare.Valid) (interface{}, error) {
+	return c.onRecRpc(ctx, flag, msg, valid)
+}
+
+// onRec 通过总线异步投递给服务的 Handle，同一个服务的消息按顺序处理
 func (c *core) onRec(flag coreShare.SvrFlag, msg coreShare.Msg, valid coreShare.Valid) {
-	go c.service[flag].Handle(msg, valid)
+	svr, ok := c.service[flag]
+	if !ok {
+		c.bus.deadLetter(flag, busItem{msg: msg}, "service not exist")
+		return
+	}
+	c.bus.send(flag, msg, func(msg coreShare.Msg) {
+		svr.Handle(msg, valid)
+	})
 }
@@ -0,0 +1,160 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rpcLatencyBuckets 耗时直方图的分桶上限(秒)，覆盖从本地调用到D1慢查询的范围
+var rpcLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// unknownCmd 未注册的命令统一记到这个标签下，避免外部随意传入的命令撑爆指标
+const unknownCmd = "_unknown"
+
+type rpcMetricKey struct {
+	service string
+	cmd     string
+}
+
+type rpcHistogram struct {
+	buckets []uint64 // 与 rpcLatencyBuckets 一一对应，非累计
+	count   uint64
+	sum     float64
+	errors  uint64
+}
+
+// streamCounter 长连接只计次数与出错次数，连接时长没有意义，不进耗时直方图
+type streamCounter struct {
+	sessions uint64
+	errors   uint64
+}
+
+// rpcMetrics 按服务与命令统计的耗时直方图与错误数，以Prometheus文本格式输出
+type rpcMetrics struct {
+	lock    sync.Mutex
+	data    map[rpcMetricKey]*rpcHistogram
+	streams map[rpcMetricKey]*streamCounter
+}
+
+func newRpcMetrics() *rpcMetrics {
+	return &rpcMetrics{
+		data:    make(map[rpcMetricKey]*rpcHistogram),
+		streams: make(map[rpcMetricKey]*streamCounter),
+	}
+}
+
+// observeStream 记录一次结束的长连接
+func (m *rpcMetrics) observeStream(service, cmd string, failed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := rpcMetricKey{service: service, cmd: cmd}
+	s, ok := m.streams[key]
+	if !ok {
+		s = &streamCounter{}
+		m.streams[key] = s
+	}
+	s.sessions++
+	if failed {
+		s.errors++
+	}
+}
+
+func (m *rpcMetrics) observe(service, cmd string, cost time.Duration, failed bool) {
+	seconds := cost.Seconds()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := rpcMetricKey{service: service, cmd: cmd}
+	h, ok := m.data[key]
+	if !ok {
+		h = &rpcHistogram{buckets: make([]uint64, len(rpcLatencyBuckets))}
+		m.data[key] = h
+	}
+	for i, le := range rpcLatencyBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if failed {
+		h.errors++
+	}
+}
+
+// writePrometheus 输出Prometheus文本格式，按服务、命令排序保证输出稳定
+func (m *rpcMetrics) writePrometheus(w io.Writer) {
+	m.lock.Lock()
+	keys := make([]rpcMetricKey, 0, len(m.data))
+	snapshot := make(map[rpcMetricKey]rpcHistogram, len(m.data))
+	for k, h := range m.data {
+		keys = append(keys, k)
+		c := *h
+		c.buckets = append([]uint64(nil), h.buckets...)
+		snapshot[k] = c
+	}
+	streamKeys := make([]rpcMetricKey, 0, len(m.streams))
+	streams := make(map[rpcMetricKey]streamCounter, len(m.streams))
+	for k, s := range m.streams {
+		streamKeys = append(streamKeys, k)
+		streams[k] = *s
+	}
+	m.lock.Unlock()
+	sortMetricKeys(keys)
+	sortMetricKeys(streamKeys)
+
+	_, _ = fmt.Fprintln(w, "# HELP platform_rpc_duration_seconds rpc latency by service and cmd.")
+	_, _ = fmt.Fprintln(w, "# TYPE platform_rpc_duration_seconds histogram")
+	for _, k := range keys {
+		h := snapshot[k]
+		labels := promLabels(k)
+		var cumulative uint64
+		for i, le := range rpcLatencyBuckets {
+			cumulative += h.buckets[i]
+			_, _ = fmt.Fprintf(w, "platform_rpc_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		_, _ = fmt.Fprintf(w, "platform_rpc_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		_, _ = fmt.Fprintf(w, "platform_rpc_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		_, _ = fmt.Fprintf(w, "platform_rpc_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+	_, _ = fmt.Fprintln(w, "# HELP platform_rpc_errors_total rpc calls returning an error by service and cmd.")
+	_, _ = fmt.Fprintln(w, "# TYPE platform_rpc_errors_total counter")
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(w, "platform_rpc_errors_total{%s} %d\n", promLabels(k), snapshot[k].errors)
+	}
+	_, _ = fmt.Fprintln(w, "# HELP platform_stream_sessions_total finished stream connections by service and cmd.")
+	_, _ = fmt.Fprintln(w, "# TYPE platform_stream_sessions_total counter")
+	for _, k := range streamKeys {
+		_, _ = fmt.Fprintf(w, "platform_stream_sessions_total{%s} %d\n", promLabels(k), streams[k].sessions)
+	}
+	_, _ = fmt.Fprintln(w, "# HELP platform_stream_errors_total stream connections ending with an error by service and cmd.")
+	_, _ = fmt.Fprintln(w, "# TYPE platform_stream_errors_total counter")
+	for _, k := range streamKeys {
+		_, _ = fmt.Fprintf(w, "platform_stream_errors_total{%s} %d\n", promLabels(k), streams[k].errors)
+	}
+}
+
+// sortMetricKeys 按服务、命令排序保证输出稳定
+func sortMetricKeys(keys []rpcMetricKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].service != keys[j].service {
+			return keys[i].service < keys[j].service
+		}
+		return keys[i].cmd < keys[j].cmd
+	})
+}
+
+func promLabels(k rpcMetricKey) string {
+	return fmt.Sprintf("service=\"%s\",cmd=\"%s\"", promEscape(k.service), promEscape(k.cmd))
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(s string) string {
+	return promEscaper.Replace(s)
+}
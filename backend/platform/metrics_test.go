@@ -0,0 +1,86 @@
+package platform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/intmian/platform/backend/share"
+)
+
+func TestRpcMetricsPrometheus(t *testing.T) {
+	m := newRpcMetrics()
+	m.observe("todone", "getTasks", 3*time.Millisecond, false)
+	m.observe("todone", "getTasks", 2*time.Second, true)
+	m.observe("auto", "getReport", 20*time.Millisecond, false)
+	m.observeStream("todone", "changes", false)
+	m.observeStream("todone", "changes", true)
+
+	var out strings.Builder
+	m.writePrometheus(&out)
+	text := out.String()
+	for _, line := range []string{
+		`platform_rpc_duration_seconds_bucket{service="todone",cmd="getTasks",le="0.005"} 1`,
+		`platform_rpc_duration_seconds_bucket{service="todone",cmd="getTasks",le="2.5"} 2`,
+		`platform_rpc_duration_seconds_bucket{service="todone",cmd="getTasks",le="+Inf"} 2`,
+		`platform_rpc_duration_seconds_count{service="todone",cmd="getTasks"} 2`,
+		`platform_rpc_errors_total{service="todone",cmd="getTasks"} 1`,
+		`platform_rpc_errors_total{service="auto",cmd="getReport"} 0`,
+		`platform_stream_sessions_total{service="todone",cmd="changes"} 2`,
+		`platform_stream_errors_total{service="todone",cmd="changes"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, text)
+		}
+	}
+	if strings.Index(text, `service="auto"`) > strings.Index(text, `service="todone"`) {
+		t.Fatal("output not sorted by service")
+	}
+}
+
+func TestReqIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(reqIDMiddleware)
+	engine.GET("/", func(c *gin.Context) {
+		if share.ReqIDFromContext(c.Request.Context()) != getReqID(c) {
+			t.Error("req id not in request ctx")
+		}
+		c.String(200, getReqID(c))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(share.ReqIDHeader, "abc")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Body.String() != "abc" || w.Header().Get(share.ReqIDHeader) != "abc" {
+		t.Fatalf("passed id: body = %q header = %q", w.Body.String(), w.Header().Get(share.ReqIDHeader))
+	}
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() == "" || w.Header().Get(share.ReqIDHeader) != w.Body.String() {
+		t.Fatalf("generated id: body = %q header = %q", w.Body.String(), w.Header().Get(share.ReqIDHeader))
+	}
+}
+
+func TestMetricsTokenValid(t *testing.T) {
+	for _, c := range []struct {
+		authorization, token string
+		want                 bool
+	}{
+		{"Bearer scrape", "scrape", true},
+		{"Bearer scrape", "", false},
+		{"", "", false},
+		{"Bearer other", "scrape", false},
+		{"scrape", "scrape", false},
+		{"Basic scrape", "scrape", false},
+	} {
+		if got := metricsTokenValid(c.authorization, c.token); got != c.want {
+			t.Fatalf("metricsTokenValid(%q, %q) = %v", c.authorization, c.token, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package platform
+
+import (
+	"github.com/intmian/mian_go_lib/xbi"
+	coreShare "github.com/intmian/platform/backend/share"
+	"github.com/pkg/errors"
+)
+
+// RpcDenyLog rpc 权限校验失败的审计日志
+type RpcDenyLog struct {
+	User        string
+	Service     string
+	Cmd         string
+	Required    string // 命令声明的权限，逗号分隔
+	Permissions string // 调用方持有的权限，逗号分隔
+}
+
+type RpcDenyLogEntity struct {
+	RpcDenyLog
+}
+
+func (d *RpcDenyLogEntity) TableName() string {
+	return "plat_rpc_deny_log"
+}
+
+func (d *RpcDenyLogEntity) GetWriteableData() *RpcDenyLog {
+	return &d.RpcDenyLog
+}
+
+// rpcPermission 权限矩阵中的一行
+type rpcPermission struct {
+	Service     string
+	Cmd         string
+	Permissions []coreShare.Permission
+}
+
+func joinPermissions(pers []coreShare.Permission) string {
+	s := ""
+	for i, p := range pers {
+		if i > 0 {
+			s += ","
+		}
+		s += string(p)
+	}
+	return s
+}
+
+// checkRpcPermission 按服务注册命令时声明的权限统一校验，rpc与长连接共用，未使用 RpcRouter 的服务由其自己校验
+func (c *core) checkRpcPermission(flag coreShare.SvrFlag, svr coreShare.IService, cmd coreShare.Cmd, valid coreShare.Valid) error {
+	rpcSvr, ok := svr.(coreShare.IRpcService)
+	if !ok || rpcSvr.RpcRouter() == nil {
+		return nil
+	}
+	router := rpcSvr.RpcRouter()
+	err := router.CheckPermission(cmd, valid)
+	if !errors.Is(err, coreShare.ErrRpcNoPermission) {
+		return err
+	}
+	required, _ := router.Permissions(cmd)
+	c.auditDeny(flag, cmd, required, valid)
+	return err
+}
+
+func (c *core) auditDeny(flag coreShare.SvrFlag, cmd coreShare.Cmd, required []coreShare.Permission, valid coreShare.Valid) {
+	entity := &RpcDenyLogEntity{}
+	data := entity.GetWriteableData()
+	data.User = valid.User
+	data.Service = string(c.serviceDesc[flag].Name)
+	data.Cmd = string(cmd)
+	data.Required = joinPermissions(required)
+	data.Permissions = joinPermissions(valid.Permissions)
+	if c.plat.log != nil {
+		c.plat.log.Warning("PLAT", "rpc permission denied: user %s service %s cmd %s", data.User, data.Service, data.Cmd)
+	}
+	if c.plat.bi == nil {
+		return
+	}
+	err := xbi.WriteLog[RpcDenyLog](c.plat.bi, entity)
+	if err != nil && c.plat.log != nil {
+		c.plat.log.WarningErr("PLAT", errors.WithMessage(err, "write rpc deny log err"))
+	}
+}
+
+// getRpcPermissions 命令到权限的矩阵，用于后台核对各账号能访问的命令
+func (c *core) getRpcPermissions() []rpcPermission {
+	var ret []rpcPermission
+	for _, svr := range c.getRpcCommands() {
+		for _, cmd := range svr.Commands {
+			ret = append(ret, rpcPermission{
+				Service:     svr.Service,
+				Cmd:         string(cmd.Cmd),
+				Permissions: cmd.Permissions,
+			})
+		}
+	}
+	return ret
+}
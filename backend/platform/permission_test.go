@@ -0,0 +1,89 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/share"
+)
+
+type rpcTestService struct {
+	flakyService
+	rpc   *share.RpcRouter
+	calls int
+}
+
+func (r *rpcTestService) HandleRpc(ctx context.Context, msg share.Msg, valid share.Valid) (interface{}, error) {
+	r.calls++
+	return r.rpc.Handle(ctx, msg, valid)
+}
+
+func (r *rpcTestService) RpcRouter() *share.RpcRouter {
+	return r.rpc
+}
+
+func TestOnRecRpcEnforcesDeclaredPermissions(t *testing.T) {
+	svr := &rpcTestService{rpc: share.NewRpcRouter()}
+	share.Register(svr.rpc, "echo", func(valid share.Valid, req string) (string, error) {
+		return req, nil
+	}, share.PermissionAdmin, share.PermissionTodone)
+	c := newTestSupervisorCore(t, svr)
+
+	valid := share.Valid{User: "u", Permissions: []share.Permission{share.PermissionAuto}, ValidTime: time.Now().Add(time.Hour).Unix()}
+	_, err := c.onRecRpc(context.Background(), share.FlagTodone, share.MakeMsg("echo", "hi"), valid)
+	if !errors.Is(err, share.ErrRpcNoPermission) || svr.calls != 0 {
+		t.Fatalf("denied call: err = %v calls = %d", err, svr.calls)
+	}
+
+	valid.Permissions = []share.Permission{share.PermissionTodone}
+	ret, err := c.onRecRpc(context.Background(), share.FlagTodone, share.MakeMsg("echo", "hi"), valid)
+	if err != nil || ret != "hi" {
+		t.Fatalf("allowed call: ret = %v err = %v", ret, err)
+	}
+
+	matrix := c.getRpcPermissions()
+	if len(matrix) != 1 || matrix[0].Service != string(share.NameTodone) || len(matrix[0].Permissions) != 2 {
+		t.Fatalf("matrix = %+v", matrix)
+	}
+}
+
+type streamTestService struct {
+	rpcTestService
+	streams int
+}
+
+func (s *streamTestService) HandleStream(ctx context.Context, cmd share.Cmd, valid share.Valid, conn share.StreamConn) error {
+	s.streams++
+	return nil
+}
+
+func TestOnRecStreamEnforcesDeclaredPermissions(t *testing.T) {
+	svr := &streamTestService{rpcTestService: rpcTestService{rpc: share.NewRpcRouter()}}
+	share.RegisterStream[string, string](svr.rpc, "watch", share.PermissionTodone)
+	c := newTestSupervisorCore(t, svr)
+	c.metrics = newRpcMetrics()
+
+	valid := share.Valid{User: "u", Permissions: []share.Permission{share.PermissionAuto}, ValidTime: time.Now().Add(time.Hour).Unix()}
+	err := c.onRecStream(context.Background(), share.FlagTodone, "watch", valid, nil)
+	if !errors.Is(err, share.ErrRpcNoPermission) || svr.streams != 0 {
+		t.Fatalf("denied stream: err = %v streams = %d", err, svr.streams)
+	}
+	valid.Permissions = []share.Permission{share.PermissionTodone}
+	if err = c.onRecStream(context.Background(), share.FlagTodone, "watch", valid, nil); err != nil || svr.streams != 1 {
+		t.Fatalf("allowed stream: err = %v streams = %d", err, svr.streams)
+	}
+	if _, err = c.onRecRpc(context.Background(), share.FlagTodone, share.MakeMsg("watch", ""), valid); !errors.Is(err, share.ErrRpcCmdNotFound) {
+		t.Fatalf("stream cmd as rpc err = %v", err)
+	}
+
+	// 长连接单独计数，不进rpc耗时直方图
+	key := rpcMetricKey{service: string(share.NameTodone), cmd: "watch"}
+	if s := c.metrics.streams[key]; s == nil || s.sessions != 2 || s.errors != 1 {
+		t.Fatalf("stream metrics = %+v", s)
+	}
+	if h := c.metrics.data[key]; h == nil || h.count != 1 || h.errors != 1 {
+		t.Fatalf("rpc metrics = %+v", h)
+	}
+}
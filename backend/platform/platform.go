@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -38,6 +39,7 @@ type PlatForm struct {
 	baseSetting *misc.FileUnit[share.BaseSetting]
 	cfg         *xstorage.CfgExt
 	bi          *xbi.XBi
+	biWrites    writeTracker
 	tool        tool
 
 	// 子模块
@@ -47,11 +49,18 @@ type PlatForm struct {
 	moneyBookMgr    *moneyBookMgr
 
 	// 内部状态
-	startTime int64
+	startTime    int64
+	cancel       context.CancelFunc
+	shutdownOnce sync.Once
 }
 
+const (
+	shutdownTimeout      = 30 * time.Second // 等待进行中请求的最长时间
+	shutdownFlushTimeout = 5 * time.Second  // 取消ctx前等待bi队列、bi写入与日志落盘的最长时间
+)
+
 func (p *PlatForm) Init(c context.Context) error {
-	p.ctx = c
+	p.ctx, p.cancel = context.WithCancel(c)
 	if !misc.PathExist("base_setting.toml") {
 		return errors.New("base_setting.toml not exist")
 	}
@@ -141,12 +150,19 @@ func (p *PlatForm) Init(c context.Context) error {
 	if err = sqlDB.PingContext(p.ctx); err != nil {
 		return errors.WithMessage(err, "ping d1 log worker err")
 	}
+	if err = p.biWrites.register(db); err != nil {
+		return errors.WithMessage(err, "track bi write err")
+	}
 	biS.Db = db
 	xBi, err := xbi.NewXBi(biS)
 	if err != nil {
 		return errors.WithMessage(err, "xbi.NewXBi err")
 	}
 	p.bi = xBi
+	err = xbi.RegisterLogEntity(p.bi, &RpcDenyLogEntity{})
+	if err != nil {
+		return errors.WithMessage(err, "register RpcDenyLogEntity err")
+	}
 
 	p.storage = storage
 	p.stoWebPack, err = xstorage.NewWebPack(
@@ -167,17 +183,12 @@ func (p *PlatForm) Init(c context.Context) error {
 	p.push = push
 	p.log = xLog
 
-	// 初始化工具
+	// 初始化工具，服务名与flag的映射来自服务注册表，新增服务只需要在服务包内注册
 	p.tool.flag2name = make(map[share.SvrFlag]share.SvrName)
 	p.tool.name2flag = make(map[share.SvrName]share.SvrFlag)
-	p.tool.flag2name[share.FlagAuto] = share.NameAuto
-	p.tool.flag2name[share.FlagNote] = share.NameNote
-	p.tool.flag2name[share.FlagAccount] = share.NameAccount
-	p.tool.flag2name[share.FlagCmd] = share.NameCmd
-	p.tool.flag2name[share.FlagTodone] = share.NameTodone
-	// 新增服务要在这里注册
-	for k, v := range p.tool.flag2name {
-		p.tool.name2flag[v] = k
+	for _, desc := range share.GServiceRegistry.Descs() {
+		p.tool.flag2name[desc.Flag] = desc.Name
+		p.tool.name2flag[desc.Name] = desc.Flag
 	}
 
 	// 初始化子模块
@@ -196,17 +207,12 @@ func (p *PlatForm) Init(c context.Context) error {
 	p.startTime = time.Now().Unix()
 
 	// 做下退出的警报
-	sigC := make(chan os.Signal)
+	sigC := make(chan os.Signal, 1)
 	signal.Notify(sigC, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigC
 		p.log.Info("PLAT", "receive signal %v, exit", sig)
-		err := p.push.Push("PLAT", "因外部信号，服务器已退出", false)
-		if err != nil {
-			p.log.WarningErr("PLAT", errors.WithMessage(err, "push exit err"))
-		}
-		time.Sleep(time.Second)
-		os.Exit(0)
+		p.Shutdown()
 	}()
 
 	// 初始化pprof
@@ -275,6 +281,12 @@ func (p *PlatForm) InitCfg() error {
 			CanUser:   false,
 			RealKey:   "auto.news.keys",
 		},
+		{
+			Key:       "PLAT.metrics.token",
+			ValueType: xstorage.ValueTypeString,
+			CanUser:   false,
+			RealKey:   "PLAT.metrics.token",
+		},
 		{
 			Key:       "PLAT.r2.endpoint",
 			ValueType: xstorage.ValueTypeString,
@@ -320,6 +332,45 @@ func (p *PlatForm) Run() {
 	<-p.ctx.Done()
 }
 
+// Shutdown 有序退出，可重复调用：
+// 停止接受http请求，关闭长连接并等待进行中的服务调用，按依赖逆序停止服务，停止订阅监控，
+// 同步推送退出通知，关闭xbi让队列写完并等待正在执行的bi写入，刷新日志，最后取消ctx，日志、bi、推送的后台协程随ctx结束，Run 随之返回
+func (p *PlatForm) Shutdown() {
+	p.shutdownOnce.Do(func() {
+		p.log.Info("PLAT", "开始退出")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := p.webMgr.Shutdown(ctx)
+		if err != nil {
+			p.log.WarningErr("PLAT", errors.WithMessage(err, "web shutdown err"))
+		}
+		p.core.stopAll()
+		if p.subscriptionMgr != nil {
+			p.subscriptionMgr.Stop()
+		}
+		err = p.push.Push("PLAT", "服务器已退出", false)
+		if err != nil {
+			p.log.WarningErr("PLAT", errors.WithMessage(err, "push exit err"))
+		}
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+		defer flushCancel()
+		// 先让xbi把队列中的日志交给gorm，再等gorm中正在执行的写入
+		if p.bi != nil {
+			if err = drainBi(flushCtx, p.bi); err != nil {
+				p.log.WarningErr("PLAT", errors.WithMessage(err, "drain bi err"))
+			}
+		}
+		if err = p.biWrites.wait(flushCtx); err != nil {
+			p.log.WarningErr("PLAT", err)
+		}
+		p.log.Info("PLAT", "退出完成")
+		if err = flushLog(flushCtx, p.log); err != nil {
+			p.log.WarningErr("PLAT", errors.WithMessage(err, "flush log err"))
+		}
+		p.cancel()
+	})
+}
+
 func (p *PlatForm) getFlag(name share.SvrName) share.SvrFlag {
 	return p.tool.name2flag[name]
 }
@@ -146,10 +146,11 @@ type subscriptionInfo struct {
 }
 
 type subscriptionMgr struct {
-	plat   *PlatForm
-	mu     sync.Mutex
-	client *http.Client
-	stopCh chan struct{}
+	plat     *PlatForm
+	mu       sync.Mutex
+	client   *http.Client
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 func newSubscriptionMgr(plat *PlatForm) *subscriptionMgr {
@@ -166,6 +167,13 @@ func (m *subscriptionMgr) Start() {
 	go m.monitorLoop()
 }
 
+// Stop 停止后台监控，可重复调用
+func (m *subscriptionMgr) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+}
+
 func (m *subscriptionMgr) userKey(user string) string {
 	return xstorage.Join("misc", "subscription", "user", user)
 }
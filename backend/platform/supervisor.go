@@ -0,0 +1,213 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	coreShare "github.com/intmian/platform/backend/share"
+	"github.com/pkg/errors"
+)
+
+// 服务监管相关参数
+const (
+	superviseInterval  = 10 * time.Second // 健康检查与失败重试的轮询间隔
+	healthCheckTimeout = 5 * time.Second  // 单次健康检查的超时
+	restartBackoffBase = 5 * time.Second  // 第一次失败后的重启等待，之后每次翻倍
+	restartBackoffMax  = 5 * time.Minute  // 重启等待的上限
+	failResetAfter     = 10 * time.Minute // 重启后稳定运行这么久才清零失败次数，避免抖动时反复推送
+	flapPushCount      = 3                // 连续失败达到该次数时推送抖动告警
+)
+
+func isRunning(status coreShare.ServiceStatus) bool {
+	return status == coreShare.StatusStart || status == coreShare.StatusDegraded
+}
+
+func restartBackoff(failCount int) time.Duration {
+	backoff := restartBackoffBase
+	for i := 1; i < failCount; i++ {
+		backoff *= 2
+		if backoff >= restartBackoffMax {
+			return restartBackoffMax
+		}
+	}
+	return backoff
+}
+
+// safeServiceCall 调用服务的启停与探测，服务内部panic时转为错误，避免拖垮整个平台
+func safeServiceCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+func (c *core) pushSupervise(content string) {
+	err := c.plat.push.Push("PLAT", content, false)
+	if err != nil {
+		c.plat.log.WarningErr("PLAT", errors.WithMessage(err, "supervise push err"))
+	}
+}
+
+// markFailedLocked 将服务标记为失败并安排下一次重启，第一次失败与连续失败达到阈值时推送
+func (c *core) markFailedLocked(flag coreShare.SvrFlag, err error) {
+	c.bus.unsubscribeAll(flag)
+	meta := c.serviceMeta[flag]
+	meta.Status = coreShare.StatusFailed
+	meta.FailCount++
+	meta.LastErr = err.Error()
+	backoff := restartBackoff(meta.FailCount)
+	meta.NextRetry = time.Now().Add(backoff)
+	name := c.plat.getName(flag)
+	switch meta.FailCount {
+	case 1:
+		c.pushSupervise(fmt.Sprintf("服务 %s 异常，%s 后自动重启：%s", name, backoff, meta.LastErr))
+	case flapPushCount:
+		c.pushSupervise(fmt.Sprintf("服务 %s 已连续失败 %d 次，重启间隔逐步延长至 %s：%s", name, meta.FailCount, restartBackoffMax, meta.LastErr))
+	}
+}
+
+// resetFailLocked 服务恢复稳定后清零失败次数，之前失败过的推送恢复通知
+func (c *core) resetFailLocked(flag coreShare.SvrFlag) {
+	meta := c.serviceMeta[flag]
+	if meta.FailCount == 0 {
+		return
+	}
+	failCount := meta.FailCount
+	meta.FailCount = 0
+	meta.NextRetry = time.Time{}
+	c.pushSupervise(fmt.Sprintf("服务 %s 已恢复，此前连续失败 %d 次", c.plat.getName(flag), failCount))
+}
+
+// supervise 定期对运行中的服务做健康检查，并按退避重启失败的服务
+func (c *core) supervise() {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.superviseOnce()
+		}
+	}
+}
+
+func (c *core) superviseOnce() {
+	type probeTarget struct {
+		flag  coreShare.SvrFlag
+		probe coreShare.IHealthProbe
+	}
+	var probes []probeTarget
+	var retries []coreShare.SvrFlag
+	now := time.Now()
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	for _, flag := range c.startOrder {
+		meta := c.serviceMeta[flag]
+		switch {
+		case isRunning(meta.Status):
+			if probe, ok := c.service[flag].(coreShare.IHealthProbe); ok {
+				probes = append(probes, probeTarget{flag: flag, probe: probe})
+			}
+		case meta.Status == coreShare.StatusFailed:
+			if meta.BootPolicy != coreShare.BootDisabled && !now.Before(meta.NextRetry) {
+				retries = append(retries, flag)
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	// 探测可能比较慢，不持有锁，回来后再确认状态没有被其他地方改掉
+	for _, target := range probes {
+		ctx, cancel := context.WithTimeout(c.ctx, healthCheckTimeout)
+		err := safeServiceCall(func() error {
+			return target.probe.HealthCheck(ctx)
+		})
+		cancel()
+		c.lock.Lock()
+		c.onHealthResultLocked(target.flag, err)
+		c.lock.Unlock()
+	}
+
+	for _, flag := range retries {
+		c.lock.Lock()
+		if c.serviceMeta[flag].Status == coreShare.StatusFailed {
+			err := c.startServiceLocked(flag)
+			if err == nil {
+				c.plat.log.Info("PLAT", fmt.Sprintf("服务 %s 自动重启成功", c.plat.getName(flag)))
+			}
+		}
+		c.lock.Unlock()
+	}
+}
+
+// stopDependentsLocked 服务被停掉前，按依赖逆序先停掉直接或间接依赖它的运行中服务并记为失败，
+// 它们在依赖恢复后由监管按退避重启
+func (c *core) stopDependentsLocked(flag coreShare.SvrFlag) {
+	down := map[coreShare.SvrName]bool{c.plat.getName(flag): true}
+	var dependents []coreShare.SvrFlag
+	for _, other := range c.startOrder {
+		if other == flag {
+			continue
+		}
+		for _, dep := range c.serviceDesc[other].Deps {
+			if down[dep] {
+				down[c.plat.getName(other)] = true
+				dependents = append(dependents, other)
+				break
+			}
+		}
+	}
+	cause := errors.Errorf("dependency %s failed", c.plat.getName(flag))
+	for i := len(dependents) - 1; i >= 0; i-- {
+		other := dependents[i]
+		if !isRunning(c.serviceMeta[other].Status) {
+			continue
+		}
+		c.plat.webMgr.closeStreams(other, "service stopped")
+		if err := safeServiceCall(c.service[other].Stop); err != nil {
+			c.plat.log.WarningErr("PLAT", errors.WithMessagef(err, "stop dependent %s err", c.plat.getName(other)))
+		}
+		c.markFailedLocked(other, cause)
+	}
+}
+
+func (c *core) onHealthResultLocked(flag coreShare.SvrFlag, err error) {
+	meta := c.serviceMeta[flag]
+	if !isRunning(meta.Status) {
+		return
+	}
+	name := c.plat.getName(flag)
+	switch {
+	case err == nil:
+		if meta.Status == coreShare.StatusDegraded {
+			c.plat.log.Info("PLAT", fmt.Sprintf("服务 %s 从降级中恢复", name))
+		}
+		meta.Status = coreShare.StatusStart
+		meta.LastErr = ""
+		if time.Since(meta.StartTime) >= failResetAfter {
+			c.resetFailLocked(flag)
+		}
+	case errors.Is(err, coreShare.ErrServiceDegraded):
+		if meta.Status != coreShare.StatusDegraded {
+			c.pushSupervise(fmt.Sprintf("服务 %s 降级：%s", name, err.Error()))
+		}
+		meta.Status = coreShare.StatusDegraded
+		meta.LastErr = err.Error()
+	default:
+		c.plat.log.ErrorErr("PLAT", errors.WithMessagef(err, "服务 %s 健康检查失败", name))
+		c.stopDependentsLocked(flag)
+		c.plat.webMgr.closeStreams(flag, "service stopped")
+		stopErr := safeServiceCall(c.service[flag].Stop)
+		if stopErr != nil {
+			c.plat.log.WarningErr("PLAT", errors.WithMessagef(stopErr, "stop unhealthy %s err", name))
+		}
+		c.markFailedLocked(flag, err)
+	}
+}
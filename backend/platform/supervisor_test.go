@@ -0,0 +1,218 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/intmian/mian_go_lib/tool/misc"
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/mian_go_lib/xpush"
+	"github.com/intmian/platform/backend/share"
+)
+
+type flakyService struct {
+	startErr  error
+	healthErr error
+	starts    int
+	stops     int
+}
+
+func (f *flakyService) Start(share share.ServiceShare) error {
+	f.starts++
+	return f.startErr
+}
+func (f *flakyService) Stop() error {
+	f.stops++
+	return nil
+}
+func (f *flakyService) Handle(msg share.Msg, valid share.Valid) {}
+func (f *flakyService) HandleRpc(ctx context.Context, msg share.Msg, valid share.Valid) (interface{}, error) {
+	return nil, nil
+}
+func (f *flakyService) GetProp() share.ServiceProp {
+	return misc.CreateProperty(share.SvrPropMicro)
+}
+func (f *flakyService) DebugCommand(req share.DebugReq) interface{} { return nil }
+func (f *flakyService) HealthCheck(ctx context.Context) error {
+	return f.healthErr
+}
+
+func newTestSupervisorCore(t *testing.T, svr share.IService) *core {
+	t.Helper()
+	push, err := xpush.NewXPush(false)
+	if err != nil {
+		t.Fatalf("new push failed: %v", err)
+	}
+	logS := xlog.DefaultSetting()
+	logS.LogAddr = t.TempDir()
+	logS.IfPush = false
+	log, err := xlog.NewXLog(logS)
+	if err != nil {
+		t.Fatalf("new log failed: %v", err)
+	}
+	baseSetting := misc.NewFileUnit[share.BaseSetting](misc.FileUnitToml, "")
+	plat := &PlatForm{
+		ctx:         context.Background(),
+		log:         log,
+		push:        push,
+		baseSetting: baseSetting,
+	}
+	plat.tool.flag2name = map[share.SvrFlag]share.SvrName{share.FlagTodone: share.NameTodone}
+	plat.tool.name2flag = map[share.SvrName]share.SvrFlag{share.NameTodone: share.FlagTodone}
+	c := &core{
+		ctx:         plat.ctx,
+		service:     map[share.SvrFlag]share.IService{share.FlagTodone: svr},
+		serviceMeta: map[share.SvrFlag]*share.ServiceMeta{share.FlagTodone: {BootPolicy: share.BootAuto}},
+		serviceDesc: map[share.SvrFlag]share.ServiceDesc{share.FlagTodone: {Name: share.NameTodone, Flag: share.FlagTodone}},
+		startOrder:  []share.SvrFlag{share.FlagTodone},
+		plat:        plat,
+	}
+	c.bus = newMsgBus(plat.ctx, log, plat.getName)
+	return c
+}
+
+func TestRestartBackoff(t *testing.T) {
+	if got := restartBackoff(1); got != restartBackoffBase {
+		t.Fatalf("backoff(1) = %s", got)
+	}
+	if got := restartBackoff(3); got != restartBackoffBase*4 {
+		t.Fatalf("backoff(3) = %s", got)
+	}
+	if got := restartBackoff(100); got != restartBackoffMax {
+		t.Fatalf("backoff(100) = %s", got)
+	}
+}
+
+func TestStartServiceFailureIsNotReportedAsStarted(t *testing.T) {
+	svr := &flakyService{startErr: errors.New("boom")}
+	c := newTestSupervisorCore(t, svr)
+
+	if err := c.startService(share.FlagTodone); err == nil {
+		t.Fatal("expected start error")
+	}
+	meta := c.serviceMeta[share.FlagTodone]
+	if meta.Status != share.StatusFailed || meta.FailCount != 1 || meta.LastErr == "" {
+		t.Fatalf("meta after failed start = %+v", meta)
+	}
+
+	// 退避时间没到不会重试
+	c.superviseOnce()
+	if svr.starts != 1 {
+		t.Fatalf("restarted before backoff, starts = %d", svr.starts)
+	}
+
+	svr.startErr = nil
+	meta.NextRetry = time.Now().Add(-time.Second)
+	c.superviseOnce()
+	if svr.starts != 2 || meta.Status != share.StatusStart {
+		t.Fatalf("restart after backoff: starts = %d status = %d", svr.starts, meta.Status)
+	}
+}
+
+func TestSuperviseHealthCheck(t *testing.T) {
+	svr := &flakyService{}
+	c := newTestSupervisorCore(t, svr)
+	if err := c.startService(share.FlagTodone); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	meta := c.serviceMeta[share.FlagTodone]
+
+	svr.healthErr = errors.Join(share.ErrServiceDegraded, errors.New("slow db"))
+	c.superviseOnce()
+	if meta.Status != share.StatusDegraded || svr.stops != 0 {
+		t.Fatalf("degraded: status = %d stops = %d", meta.Status, svr.stops)
+	}
+
+	svr.healthErr = errors.New("db gone")
+	c.superviseOnce()
+	if meta.Status != share.StatusFailed || svr.stops != 1 || meta.FailCount != 1 {
+		t.Fatalf("failed: meta = %+v stops = %d", meta, svr.stops)
+	}
+
+	svr.healthErr = nil
+	meta.NextRetry = time.Now().Add(-time.Second)
+	c.superviseOnce()
+	if meta.Status != share.StatusStart || svr.starts != 2 {
+		t.Fatalf("recovered: status = %d starts = %d", meta.Status, svr.starts)
+	}
+	// 刚重启完不立即清零，防止抖动时反复推送恢复
+	if meta.FailCount != 1 {
+		t.Fatalf("fail count reset too early: %d", meta.FailCount)
+	}
+	meta.StartTime = time.Now().Add(-failResetAfter)
+	c.superviseOnce()
+	if meta.FailCount != 0 {
+		t.Fatalf("fail count not reset: %d", meta.FailCount)
+	}
+}
+
+// newTestDependentCore todone 依赖 auto，两个服务都可以单独控制启动与健康检查
+func newTestDependentCore(t *testing.T, dep, svr *flakyService) *core {
+	t.Helper()
+	c := newTestSupervisorCore(t, svr)
+	c.plat.tool.flag2name[share.FlagAuto] = share.NameAuto
+	c.plat.tool.name2flag[share.NameAuto] = share.FlagAuto
+	c.service[share.FlagAuto] = dep
+	c.serviceMeta[share.FlagAuto] = &share.ServiceMeta{BootPolicy: share.BootAuto}
+	c.serviceDesc[share.FlagAuto] = share.ServiceDesc{Name: share.NameAuto, Flag: share.FlagAuto}
+	c.serviceDesc[share.FlagTodone] = share.ServiceDesc{Name: share.NameTodone, Flag: share.FlagTodone, Deps: []share.SvrName{share.NameAuto}}
+	c.startOrder = []share.SvrFlag{share.FlagAuto, share.FlagTodone}
+	return c
+}
+
+func TestDependencyFailureRetriesDependent(t *testing.T) {
+	dep := &flakyService{startErr: errors.New("boom")}
+	svr := &flakyService{}
+	c := newTestDependentCore(t, dep, svr)
+
+	// 启动时依赖失败，依赖它的服务也记为失败，而不是停在未启动
+	_ = c.startService(share.FlagAuto)
+	if err := c.startService(share.FlagTodone); err == nil {
+		t.Fatal("dependent started without its dependency")
+	}
+	meta := c.serviceMeta[share.FlagTodone]
+	if meta.Status != share.StatusFailed || meta.FailCount != 1 || svr.starts != 0 {
+		t.Fatalf("dependent after dependency failure = %+v starts = %d", meta, svr.starts)
+	}
+
+	// 依赖恢复后按启动顺序一起重试
+	dep.startErr = nil
+	c.serviceMeta[share.FlagAuto].NextRetry = time.Now().Add(-time.Second)
+	meta.NextRetry = time.Now().Add(-time.Second)
+	c.superviseOnce()
+	if c.serviceMeta[share.FlagAuto].Status != share.StatusStart || meta.Status != share.StatusStart || svr.starts != 1 {
+		t.Fatalf("after retry: dep = %+v dependent = %+v", c.serviceMeta[share.FlagAuto], meta)
+	}
+}
+
+func TestUnhealthyDependencyStopsDependents(t *testing.T) {
+	dep := &flakyService{}
+	svr := &flakyService{}
+	c := newTestDependentCore(t, dep, svr)
+	for _, flag := range c.startOrder {
+		if err := c.startService(flag); err != nil {
+			t.Fatalf("start %d: %v", flag, err)
+		}
+	}
+
+	// 依赖健康检查失败被停掉时，依赖它的服务先停掉并记为失败
+	dep.healthErr = errors.New("db gone")
+	c.superviseOnce()
+	meta := c.serviceMeta[share.FlagTodone]
+	if c.serviceMeta[share.FlagAuto].Status != share.StatusFailed || dep.stops != 1 {
+		t.Fatalf("dependency = %+v stops = %d", c.serviceMeta[share.FlagAuto], dep.stops)
+	}
+	if meta.Status != share.StatusFailed || svr.stops != 1 || meta.LastErr != "dependency auto failed" {
+		t.Fatalf("dependent = %+v stops = %d", meta, svr.stops)
+	}
+
+	dep.healthErr = nil
+	c.serviceMeta[share.FlagAuto].NextRetry = time.Now().Add(-time.Second)
+	meta.NextRetry = time.Now().Add(-time.Second)
+	c.superviseOnce()
+	if c.serviceMeta[share.FlagAuto].Status != share.StatusStart || meta.Status != share.StatusStart || svr.starts != 2 {
+		t.Fatalf("after recovery: dep = %+v dependent = %+v", c.serviceMeta[share.FlagAuto], meta)
+	}
+}
@@ -16,6 +16,9 @@ import (
 	"time"
 )
 
+// serviceHandleSlow 单次服务调用超过该耗时会记录警告日志
+const serviceHandleSlow = 5 * time.Second
+
 func (m *webMgr) serviceHandle(c *gin.Context) {
 	name := c.Param("name")
 	cmd := c.Param("cmd")
@@ -35,19 +38,24 @@ func (m *webMgr) serviceHandle(c *gin.Context) {
 		})
 		return
 	}
-	msg := share.MakeMsgJson(share.Cmd(cmd), string(bodyStr))
+	if !m.beginInflight() {
+		c.JSON(200, gin.H{
+			"code": 1,
+			"msg":  "server shutting down",
+		})
+		return
+	}
+	defer m.inflight.Done()
+	reqID := getReqID(c)
+	msg := share.MakeMsgJson(share.Cmd(cmd), string(bodyStr)).WithReqID(reqID)
 	valid := m.getValid(c)
 	t1 := time.Now()
-	finish := make(chan interface{})
-	go func() {
-		<-finish
-		delta := time.Now().Sub(t1)
-		if delta > time.Minute {
-			m.plat.log.Warning("PLAT", "serviceHandle too long [%s] [%s] [%s]", name, cmd, delta.String())
-		}
-	}()
-	rec, err := m.plat.core.onRecRpc(flag, msg, valid)
-	finish <- nil
+	// 客户端断开时 Request.Context 会取消，服务据此停止还在进行的数据库、AI调用
+	rec, err := m.plat.core.onRecRpc(c.Request.Context(), flag, msg, valid)
+	// 耗时分布见 /metrics，这里只记录异常慢的单次请求，方便按请求ID查日志
+	if delta := time.Since(t1); delta > serviceHandleSlow {
+		m.plat.log.Warning("PLAT", "serviceHandle too long [%s] [%s] [%s] req [%s]", name, cmd, delta.String(), reqID)
+	}
 	if err != nil {
 		debug := false
 		m.plat.baseSetting.SafeUseData(func(data share.BaseSetting) {
@@ -268,7 +276,7 @@ func (m *webMgr) gptRewrite(c *gin.Context) {
 	}
 
 	ask := "以下是我的语音输入内容，其中可能包含口误、口语化表达或识别错误。请对其进行优化和重写，使其语法正确、逻辑清晰，去除重复和冗长的表述。确保不遗漏任何内容，对于不确定的部分，请使用括号标注。无需添加任何官话或套话:\n" + req.Content
-	newContent, err := m.chatAI(share.AISceneRewrite, ask)
+	newContent, err := m.chatAI(c.Request.Context(), share.AISceneRewrite, ask)
 	if err != nil {
 		c.JSON(200, makeErrReturn(err.Error()))
 		return
@@ -6,8 +6,12 @@ import (
 
 func (m *webMgr) initSvrRoot(r *gin.Engine) {
 	r.GET("/share-link/:username/:token", m.shareLinkDownload)
+	// Prometheus 拉取的指标，需要带拉取令牌或者管理员登录
+	r.GET("/metrics", m.checkMetrics, m.getMetrics)
 	// 服务的直通接口
 	r.POST("/service/:name/:cmd", m.serviceHandle)
+	// 服务的长连接接口，WebSocket
+	r.GET("/service/:name/:cmd", m.serviceStream)
 	r.POST("/debug/:name/:cmd", m.serviceDebugHandle)
 
 	// 目前所有的配置全部注册在主服务处，后续可以拆分为配置服，用来同步配置
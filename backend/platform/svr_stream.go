@@ -0,0 +1,90 @@
+package platform
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/intmian/platform/backend/share"
+	"github.com/pkg/errors"
+)
+
+const (
+	// serviceStreamWriteTimeout 单条消息写出的最长时间，超时视为连接已经失效
+	serviceStreamWriteTimeout = 15 * time.Second
+	// serviceStreamReadMaxBytes 客户端单条消息的上限，长连接只用来订阅，不传大数据
+	serviceStreamReadMaxBytes = 64 << 10
+)
+
+var serviceStreamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     realtimeTranscriptionOriginAllowed,
+}
+
+// serviceStreamConn 给每次写出加上超时，避免对端不读时服务的写协程一直阻塞
+type serviceStreamConn struct {
+	conn *websocket.Conn
+}
+
+func (s serviceStreamConn) ReadJSON(v interface{}) error {
+	return s.conn.ReadJSON(v)
+}
+
+func (s serviceStreamConn) WriteJSON(v interface{}) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(serviceStreamWriteTimeout)); err != nil {
+		return err
+	}
+	return s.conn.WriteJSON(v)
+}
+
+// serviceStream 服务的长连接接口，升级前只检查服务是否存在，权限在 core.onRecStream 中按命令声明校验。
+// 连接登记到webMgr，平台退出或服务停止时主动关闭，处理期间按进行中的调用计
+func (m *webMgr) serviceStream(c *gin.Context) {
+	name := c.Param("name")
+	cmd := c.Param("cmd")
+	flag := m.plat.getFlag(share.SvrName(name))
+	if flag == share.FlagNone {
+		c.JSON(http.StatusNotFound, makeErrReturn("service not exist"))
+		return
+	}
+	valid := m.getValid(c)
+	conn, err := serviceStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(serviceStreamReadMaxBytes)
+
+	// Request.Context 中已经带着请求ID，服务内的日志与sql日志据此关联
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	stream := &webStream{conn: conn, cancel: cancel, flag: flag}
+	if !m.trackStream(stream) {
+		stream.close("server shutting down")
+		return
+	}
+	defer m.untrackStream(stream)
+	err = m.plat.core.onRecStream(ctx, flag, share.Cmd(cmd), valid, serviceStreamConn{conn: conn})
+	if err != nil {
+		m.plat.log.Warning("PLAT", "serviceStream [%s] [%s] user [%s] req [%s] end: %s", name, cmd, valid.User, getReqID(c), err.Error())
+		code, reason := streamCloseReason(err)
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(time.Second))
+	}
+}
+
+// streamCloseReason 关闭帧只带固定的简短原因，原因最长123字节，且不把内部错误暴露给客户端，完整错误只进日志
+func streamCloseReason(err error) (int, string) {
+	switch {
+	case errors.Is(err, share.ErrRpcNoPermission):
+		return websocket.ClosePolicyViolation, "no permission"
+	case errors.Is(err, share.ErrRpcCmdNotFound):
+		return websocket.ClosePolicyViolation, "cmd not found"
+	default:
+		return websocket.CloseInternalServerErr, "stream error"
+	}
+}
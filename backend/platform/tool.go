@@ -20,6 +20,10 @@ func getStatusStr(status share.ServiceStatus) string {
 		return "start"
 	case share.StatusStop:
 		return "stop"
+	case share.StatusFailed:
+		return "failed"
+	case share.StatusDegraded:
+		return "degraded"
 	default:
 		return "unknown"
 	}
@@ -1,24 +1,56 @@
 package platform
 
 import (
+	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/mian_go_lib/tool/token"
 	"github.com/intmian/mian_go_lib/xstorage"
+	"github.com/intmian/platform/backend/share"
 	"io"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
+const reqIDKey = "reqID"
+
 // webMgr web管理器,负责管理gin以及控制台相关，服务的鉴权与内容请从services中处理
 type webMgr struct {
 	platStoWebPack xstorage.WebPack
 	// 使用jwt进行签名。这里有一个缺陷，因为密码修改后jwt依然有效，所以不太安全。jwt也可能会被盗用。也不太好做续签，后面会改成使用session。目前版本先做一个第二个盐随机化的安全处理，防止爆破出秘钥。
 	jwt       token.JwtMgr
 	webEngine *gin.Engine
+	server    *http.Server
 	plat      *PlatForm
+
+	// 退出时需要等待进行中的服务调用结束，draining 之后不再接受新的调用
+	inflightLock sync.Mutex
+	inflight     sync.WaitGroup
+	draining     bool
+	// streams 已经升级的websocket连接，http.Server.Shutdown 不会关闭它们，退出时主动关闭
+	streams map[*webStream]struct{}
+}
+
+// webStream 一条已经升级的websocket连接，cancel 结束处理连接的ctx
+type webStream struct {
+	conn   *websocket.Conn
+	cancel context.CancelFunc
+	flag   share.SvrFlag // 服务的长连接所属的服务，服务停止时一起关闭；其他连接为 FlagNone
+}
+
+// close 通知对端服务正在关闭，取消ctx并关闭连接，处理连接的协程随读写失败退出
+func (s *webStream) close(reason string) {
+	_ = s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, reason),
+		time.Now().Add(time.Second))
+	s.cancel()
+	_ = s.conn.Close()
 }
 
 func (m *webMgr) Init(plat *PlatForm) error {
@@ -34,6 +66,7 @@ func (m *webMgr) Init(plat *PlatForm) error {
 		gin.DefaultWriter = io.MultiWriter(f)
 	}
 	engine := gin.Default()
+	engine.Use(reqIDMiddleware)
 	m.webEngine = engine
 	/*
 		接入前端在gin内部只是可选方案之一，开发时建议单独启动后端与vite dev服务
@@ -60,6 +93,11 @@ func (m *webMgr) Init(plat *PlatForm) error {
 					c.File("./front/assets" + c.Request.URL.Path[7:])
 					return
 				}
+				// 指标接口自己校验令牌或管理员
+				if c.Request.URL.Path == "/metrics" {
+					c.Next()
+					return
+				}
 				if c.Request.URL.Path == "/config.json" {
 					c.File("./front/config.json")
 					return
@@ -85,16 +123,126 @@ func (m *webMgr) Init(plat *PlatForm) error {
 	// 为了权衡安全和方便性，重启时如果跨月就重置一下token，如果每次都重置那会导致每次都需要重新登录，不重置会导致可能的破解？
 	token2 := time.Now().Format("2006-01")
 	m.jwt.SetSalt(xstorage.ToBase[string](s1v), token2)
-	var err error
+	m.server = &http.Server{
+		Addr:    ":" + m.plat.baseSetting.Copy().WebPort,
+		Handler: engine,
+	}
 	go func() {
-		err = engine.Run(":" + m.plat.baseSetting.Copy().WebPort)
-		if err != nil {
+		err := m.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
 	}()
 	return nil
 }
 
+// reqIDMiddleware 为每个请求分配ID，沿用调用方传入的ID，写回响应头并放入请求的ctx
+func reqIDMiddleware(c *gin.Context) {
+	reqID := c.GetHeader(share.ReqIDHeader)
+	if reqID == "" || len(reqID) > 64 {
+		reqID = uuid.NewString()
+	}
+	c.Set(reqIDKey, reqID)
+	c.Header(share.ReqIDHeader, reqID)
+	c.Request = c.Request.WithContext(share.ContextWithReqID(c.Request.Context(), reqID))
+	c.Next()
+}
+
+func getReqID(c *gin.Context) string {
+	return c.GetString(reqIDKey)
+}
+
+// beginInflight 登记一次进行中的服务调用，退出中返回false
+func (m *webMgr) beginInflight() bool {
+	m.inflightLock.Lock()
+	defer m.inflightLock.Unlock()
+	if m.draining {
+		return false
+	}
+	m.inflight.Add(1)
+	return true
+}
+
+// trackStream 登记一条长连接，处理期间按进行中的调用计，退出中返回false。处理结束后调用 untrackStream
+func (m *webMgr) trackStream(s *webStream) bool {
+	m.inflightLock.Lock()
+	defer m.inflightLock.Unlock()
+	if m.draining {
+		return false
+	}
+	if m.streams == nil {
+		m.streams = make(map[*webStream]struct{})
+	}
+	m.streams[s] = struct{}{}
+	m.inflight.Add(1)
+	return true
+}
+
+func (m *webMgr) untrackStream(s *webStream) {
+	m.inflightLock.Lock()
+	defer m.inflightLock.Unlock()
+	if _, ok := m.streams[s]; !ok {
+		return
+	}
+	delete(m.streams, s)
+	m.inflight.Done()
+}
+
+// closeStreams 关闭属于flag服务的长连接，服务停止前调用
+func (m *webMgr) closeStreams(flag share.SvrFlag, reason string) {
+	m.inflightLock.Lock()
+	var streams []*webStream
+	for s := range m.streams {
+		if s.flag == flag {
+			streams = append(streams, s)
+		}
+	}
+	m.inflightLock.Unlock()
+	for _, s := range streams {
+		s.close(reason)
+	}
+}
+
+// Shutdown 停止接受新连接，关闭全部长连接，等待进行中的服务调用结束，超过ctx期限后强制关闭剩余连接(如SSE)
+func (m *webMgr) Shutdown(ctx context.Context) error {
+	m.inflightLock.Lock()
+	m.draining = true
+	streams := make([]*webStream, 0, len(m.streams))
+	for s := range m.streams {
+		streams = append(streams, s)
+	}
+	m.inflightLock.Unlock()
+	for _, s := range streams {
+		s.close("server shutdown")
+	}
+	shutdownErr := make(chan error, 1)
+	if m.server != nil {
+		go func() {
+			shutdownErr <- m.server.Shutdown(ctx)
+		}()
+	} else {
+		shutdownErr <- nil
+	}
+
+	var err error
+	inflightDone := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(inflightDone)
+	}()
+	select {
+	case <-inflightDone:
+	case <-ctx.Done():
+		err = errors.Join(err, errors.New("wait inflight service call timeout"))
+	}
+
+	e := <-shutdownErr
+	if e != nil {
+		err = errors.Join(err, e, m.server.Close())
+	}
+	return err
+}
+
 func (m *webMgr) CheckTokenPermission(data *token.Data, wantPermission string) bool {
 	n := time.Now()
 	return m.jwt.CheckPermission(data, n, wantPermission)
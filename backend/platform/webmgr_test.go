@@ -0,0 +1,157 @@
+package platform
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/intmian/platform/backend/share"
+	"github.com/pkg/errors"
+)
+
+func TestWebMgrShutdownWaitsInflight(t *testing.T) {
+	m := &webMgr{}
+	if !m.beginInflight() {
+		t.Fatal("begin inflight before shutdown should succeed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); err == nil {
+		t.Fatal("shutdown should time out while a call is inflight")
+	}
+	if m.beginInflight() {
+		t.Fatal("new calls should be rejected after shutdown")
+	}
+
+	m.inflight.Done()
+	done := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("inflight not drained")
+	}
+}
+
+// serveTestStream 起一个登记到m的websocket连接，返回客户端与服务端处理结束的通知
+func serveTestStream(t *testing.T, m *webMgr, flag share.SvrFlag) (*websocket.Conn, chan struct{}) {
+	t.Helper()
+	handlerDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stream := &webStream{conn: conn, cancel: cancel, flag: flag}
+		if !m.trackStream(stream) {
+			return
+		}
+		defer m.untrackStream(stream)
+		for ctx.Err() == nil {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m.inflightLock.Lock()
+	before := len(m.streams)
+	m.inflightLock.Unlock()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	// 等服务端登记完连接
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.inflightLock.Lock()
+		n := len(m.streams)
+		m.inflightLock.Unlock()
+		if n > before {
+			return client, handlerDone
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream not tracked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebMgrShutdownClosesStreams(t *testing.T) {
+	m := &webMgr{}
+	client, handlerDone := serveTestStream(t, m, share.FlagNone)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown should close streams and finish: %v", err)
+	}
+	<-handlerDone
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("client read err = %v, want going away", err)
+	}
+	if m.trackStream(&webStream{}) {
+		t.Fatal("new streams should be rejected after shutdown")
+	}
+}
+
+func TestStopServiceClosesServiceStreams(t *testing.T) {
+	svr := &flakyService{}
+	c := newTestSupervisorCore(t, svr)
+	c.serviceMeta[share.FlagTodone].Status = share.StatusStart
+	m := &c.plat.webMgr
+	svrClient, svrDone := serveTestStream(t, m, share.FlagTodone)
+	_, otherDone := serveTestStream(t, m, share.FlagNone)
+
+	if err := c.stopService(share.FlagTodone); err != nil {
+		t.Fatalf("stop service: %v", err)
+	}
+	<-svrDone
+	if _, _, err := svrClient.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("service stream read err = %v, want going away", err)
+	}
+	select {
+	case <-otherDone:
+		t.Fatal("stream of another service closed")
+	default:
+	}
+	m.inflightLock.Lock()
+	n := len(m.streams)
+	m.inflightLock.Unlock()
+	if n != 1 || svr.stops != 1 {
+		t.Fatalf("streams left = %d stops = %d", n, svr.stops)
+	}
+}
+
+func TestStreamCloseReason(t *testing.T) {
+	long := errors.New(strings.Repeat("internal detail ", 20))
+	for _, c := range []struct {
+		err    error
+		code   int
+		reason string
+	}{
+		{errors.Wrap(share.ErrRpcNoPermission, "watch"), websocket.ClosePolicyViolation, "no permission"},
+		{errors.Wrap(share.ErrRpcCmdNotFound, "watch"), websocket.ClosePolicyViolation, "cmd not found"},
+		{long, websocket.CloseInternalServerErr, "stream error"},
+	} {
+		code, reason := streamCloseReason(c.err)
+		if code != c.code || reason != c.reason {
+			t.Fatalf("streamCloseReason(%v) = %d %q", c.err, code, reason)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package platform
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// writeTracker 统计bi库上正在执行的写入，退出时等它们写完再取消ctx，空闲时不用等待
+type writeTracker struct {
+	lock    sync.Mutex
+	running int
+	idle    chan struct{} // 有人等待时创建，running归零时关闭
+}
+
+// register 在db的写入前后挂上计数，需要在db交给xbi之前调用
+func (w *writeTracker) register(db *gorm.DB) error {
+	begin := func(*gorm.DB) { w.begin() }
+	end := func(*gorm.DB) { w.end() }
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("plat:write_begin", begin); err != nil {
+		return errors.WithMessage(err, "register create begin err")
+	}
+	if err := cb.Create().After("gorm:create").Register("plat:write_end", end); err != nil {
+		return errors.WithMessage(err, "register create end err")
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("plat:write_begin", begin); err != nil {
+		return errors.WithMessage(err, "register raw begin err")
+	}
+	if err := cb.Raw().After("gorm:raw").Register("plat:write_end", end); err != nil {
+		return errors.WithMessage(err, "register raw end err")
+	}
+	return nil
+}
+
+func (w *writeTracker) begin() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.running++
+}
+
+func (w *writeTracker) end() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.running--
+	if w.running == 0 && w.idle != nil {
+		close(w.idle)
+		w.idle = nil
+	}
+}
+
+// wait 等到没有正在执行的写入，超过ctx期限返回错误
+func (w *writeTracker) wait(ctx context.Context) error {
+	w.lock.Lock()
+	if w.running == 0 {
+		w.lock.Unlock()
+		return nil
+	}
+	if w.idle == nil {
+		w.idle = make(chan struct{})
+	}
+	idle := w.idle
+	w.lock.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return errors.WithMessage(ctx.Err(), "wait bi write err")
+	}
+}
+
+// drainBi 关闭xbi，让队列中还没交给gorm的日志写完。mian_go_lib 不同版本提供的方法不同，按实现的接口调用，都没有时跳过
+func drainBi(ctx context.Context, bi any) error {
+	switch v := bi.(type) {
+	case interface{ Close() error }:
+		return runWithin(ctx, v.Close)
+	case interface{ Flush() error }:
+		return runWithin(ctx, v.Flush)
+	}
+	return nil
+}
+
+// flushLog 把xlog缓冲中的日志落盘。只刷新不关闭，取消ctx前仍可能有协程在写日志
+func flushLog(ctx context.Context, log any) error {
+	switch v := log.(type) {
+	case interface{ Flush() error }:
+		return runWithin(ctx, v.Flush)
+	case interface{ Flush() }:
+		return runWithin(ctx, func() error {
+			v.Flush()
+			return nil
+		})
+	case interface{ Sync() error }:
+		return runWithin(ctx, v.Sync)
+	}
+	return nil
+}
+
+// runWithin 在ctx期限内等f结束，超时后不再等待，f在后台继续执行
+func runWithin(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.WithMessage(ctx.Err(), "flush timeout")
+	}
+}
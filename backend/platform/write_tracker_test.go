@@ -0,0 +1,97 @@
+package platform
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestWriteTrackerWait(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "bi.sqlite")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var w writeTracker
+	if err = w.register(db); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	type row struct {
+		ID   uint
+		Body string
+	}
+	if err = db.AutoMigrate(&row{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err = db.Create(&row{Body: "a"}).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err = db.Exec("INSERT INTO rows (body) VALUES (?)", "b").Error; err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+
+	// 空闲时立即返回
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err = w.wait(ctx); err != nil {
+		t.Fatalf("idle wait: %v", err)
+	}
+
+	w.begin()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel2()
+	if err = w.wait(ctx2); err == nil {
+		t.Fatal("wait should time out while a write is running")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.end()
+	}()
+	ctx3, cancel3 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel3()
+	if err = w.wait(ctx3); err != nil {
+		t.Fatalf("wait running write: %v", err)
+	}
+}
+
+type testCloser struct {
+	closed bool
+	delay  time.Duration
+}
+
+func (c *testCloser) Close() error {
+	time.Sleep(c.delay)
+	c.closed = true
+	return nil
+}
+
+type testFlusher struct{ flushed bool }
+
+func (f *testFlusher) Flush() { f.flushed = true }
+
+func TestDrainBiAndFlushLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	closer := &testCloser{}
+	if err := drainBi(ctx, closer); err != nil || !closer.closed {
+		t.Fatalf("drain bi: closed = %v err = %v", closer.closed, err)
+	}
+	flusher := &testFlusher{}
+	if err := flushLog(ctx, flusher); err != nil || !flusher.flushed {
+		t.Fatalf("flush log: flushed = %v err = %v", flusher.flushed, err)
+	}
+	// 没有实现对应方法时跳过
+	if err := drainBi(ctx, struct{}{}); err != nil {
+		t.Fatalf("drain without close: %v", err)
+	}
+
+	// 关闭太慢时不拖住退出
+	short, shortCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer shortCancel()
+	if err := drainBi(short, &testCloser{delay: time.Second}); err == nil {
+		t.Fatal("slow close did not time out")
+	}
+}
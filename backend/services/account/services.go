@@ -1,15 +1,29 @@
 package account
 
 import (
+	"context"
 	"errors"
 	"github.com/intmian/mian_go_lib/tool/misc"
 	accShare "github.com/intmian/platform/backend/services/account/share"
 	backendshare "github.com/intmian/platform/backend/share"
 )
 
+func init() {
+	backendshare.RegisterService(backendshare.ServiceDesc{
+		Name: backendshare.NameAccount,
+		Flag: backendshare.FlagAccount,
+		New: func() backendshare.IService {
+			s := &Service{}
+			s.initRpc()
+			return s
+		},
+	})
+}
+
 type Service struct {
 	share backendshare.ServiceShare
 	acc   accountMgr
+	rpc   *backendshare.RpcRouter
 }
 
 func (s *Service) DebugCommand(req backendshare.DebugReq) interface{} {
@@ -40,31 +54,27 @@ func (s *Service) Handle(msg backendshare.Msg, valid backendshare.Valid) {
 	return
 }
 
-func (s *Service) HandleRpc(msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
-	switch msg.Cmd() {
-	case accShare.CmdRegister:
-		return backendshare.HandleRpcTool("register", msg, valid, s.OnRegister)
-	case accShare.CmdDeregister:
-		return backendshare.HandleRpcTool("deregister", msg, valid, s.OnDeregister)
-	case accShare.CmdCheckToken:
-		return backendshare.HandleRpcTool("checkToken", msg, valid, s.OnCheckToken)
-	case accShare.CmdDelToken:
-		return backendshare.HandleRpcTool("delToken", msg, valid, s.OnDelToken)
-	case accShare.CmdChangeToken:
-		return backendshare.HandleRpcTool("changeToken", msg, valid, s.OnChangeToken)
-	case accShare.CmdGetAllAccount:
-		return backendshare.HandleRpcTool("getAllAccount", msg, valid, s.OnGetAllAccount)
-	case accShare.CmdCreateToken:
-		return backendshare.HandleRpcTool("createToken", msg, valid, s.OnCreateToken)
-	default:
-		return nil, ErrUnknownCmd
-	}
+func (s *Service) HandleRpc(ctx context.Context, msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
+	return s.rpc.Handle(ctx, msg, valid)
+}
+
+// initRpc 注册全部rpc命令，新增命令只需要在这里登记
+func (s *Service) initRpc() {
+	s.rpc = backendshare.NewRpcRouter()
+	backendshare.Register(s.rpc, accShare.CmdRegister, s.OnRegister, backendshare.PermissionAdmin)
+	backendshare.Register(s.rpc, accShare.CmdDeregister, s.OnDeregister, backendshare.PermissionAdmin)
+	backendshare.Register(s.rpc, accShare.CmdCheckToken, s.OnCheckToken, backendshare.PermissionAdmin)
+	backendshare.Register(s.rpc, accShare.CmdDelToken, s.OnDelToken, backendshare.PermissionAdmin)
+	backendshare.Register(s.rpc, accShare.CmdChangeToken, s.OnChangeToken, backendshare.PermissionAdmin)
+	backendshare.Register(s.rpc, accShare.CmdGetAllAccount, s.OnGetAllAccount, backendshare.PermissionAdmin)
+	backendshare.Register(s.rpc, accShare.CmdCreateToken, s.OnCreateToken, backendshare.PermissionAdmin)
+}
+
+func (s *Service) RpcRouter() *backendshare.RpcRouter {
+	return s.rpc
 }
 
 func (s *Service) OnRegister(valid backendshare.Valid, req accShare.RegisterReq) (ret accShare.RegisterRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, nil
-	}
 	creator := valid.User
 	if creator == "" {
 		creator = "system"
@@ -77,9 +87,6 @@ func (s *Service) OnRegister(valid backendshare.Valid, req accShare.RegisterReq)
 }
 
 func (s *Service) OnDeregister(valid backendshare.Valid, req accShare.DeregisterReq) (ret accShare.DeregisterRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, nil
-	}
 	err = s.acc.deregister(req.Account)
 	if err == nil {
 		ret.Suc = true
@@ -88,17 +95,11 @@ func (s *Service) OnDeregister(valid backendshare.Valid, req accShare.Deregister
 }
 
 func (s *Service) OnCheckToken(valid backendshare.Valid, req accShare.CheckTokenReq) (ret accShare.CheckTokenRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, nil
-	}
 	ret.Pers, err = s.acc.checkPermission(req.Account, req.Pwd)
 	return
 }
 
 func (s *Service) OnDelToken(valid backendshare.Valid, req accShare.DelTokenReq) (ret accShare.DelTokenRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, nil
-	}
 	err = s.acc.deletePermission(req.Account, req.TokenID)
 	if err == nil {
 		ret.Suc = true
@@ -107,9 +108,6 @@ func (s *Service) OnDelToken(valid backendshare.Valid, req accShare.DelTokenReq)
 }
 
 func (s *Service) OnChangeToken(valid backendshare.Valid, req accShare.ChangeTokenReq) (ret accShare.ChangeTokenRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, nil
-	}
 	var pers []backendshare.Permission
 	for _, v := range req.Pers {
 		pers = append(pers, backendshare.Permission(v))
@@ -122,9 +120,6 @@ func (s *Service) OnChangeToken(valid backendshare.Valid, req accShare.ChangeTok
 }
 
 func (s *Service) OnGetAllAccount(valid backendshare.Valid, req accShare.GetAllAccountReq) (ret accShare.GetAllAccountRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, errors.New("no permission")
-	}
 	accountsInfos, err := s.acc.getAllAccount()
 	if err != nil {
 		return
@@ -140,9 +135,6 @@ func (s *Service) OnGetAllAccount(valid backendshare.Valid, req accShare.GetAllA
 }
 
 func (s *Service) OnCreateToken(valid backendshare.Valid, req accShare.CreateTokenReq) (ret accShare.CreateTokenRet, err error) {
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		return ret, nil
-	}
 	tokenID, err := s.acc.addPermission(req.Account, req.Pwd, req.Pers)
 	if err == nil {
 		ret.TokenID = tokenID
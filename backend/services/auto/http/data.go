@@ -12,22 +12,26 @@ func getLogCache(c *gin.Context) {
 	c.String(200, log_cache.GLogCache.ToString())
 }
 
-func getStatus(c *gin.Context) {
-	c.String(200, task.GMgr.MakeStatusText())
+func getStatus(mgr *task.Mgr) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.String(200, mgr.MakeStatusText())
+	}
 }
 
 func getTitle(c *gin.Context) {
 	c.String(200, status.GStatus.GetTimeStr())
 }
 
-func startTask(c *gin.Context) {
-	taskName := c.Query("name")
-	if taskName == "" {
-		c.String(200, "task name is empty")
-	}
-	if task.GMgr.UnitDo(taskName) {
-		c.String(200, "ok")
-	} else {
-		c.String(200, "not found")
+func startTask(mgr *task.Mgr) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		taskName := c.Query("name")
+		if taskName == "" {
+			c.String(200, "task name is empty")
+		}
+		if mgr.UnitDo(taskName) {
+			c.String(200, "ok")
+		} else {
+			c.String(200, "not found")
+		}
 	}
 }
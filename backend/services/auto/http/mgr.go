@@ -1,12 +1,15 @@
 package http
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/intmian/platform/backend/services/auto/task"
+)
 
-func InitRoot(pEngine *gin.Engine) {
+func InitRoot(pEngine *gin.Engine, mgr *task.Mgr) {
 	pEngine.GET("/api/log_cache", getLogCache)
 	pEngine.GET("/api/title", getTitle)
-	pEngine.GET("/api/task/status", getStatus)
-	pEngine.GET("/api/task/start", startTask)
+	pEngine.GET("/api/task/status", getStatus(mgr))
+	pEngine.GET("/api/task/start", startTask(mgr))
 
 	pEngine.StaticFile("/", "http/static/index.html")
 	pEngine.StaticFile("/task", "http/static/task.html")
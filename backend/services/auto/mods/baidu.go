@@ -5,15 +5,19 @@ import (
 	"fmt"
 	"github.com/intmian/mian_go_lib/tool/spider"
 	"github.com/intmian/mian_go_lib/xstorage"
-	"github.com/intmian/platform/backend/services/auto/setting"
 	"github.com/intmian/platform/backend/services/auto/tool"
 )
 
 type Baidu struct {
+	env *tool.Env
+}
+
+func NewBaidu(env *tool.Env) *Baidu {
+	return &Baidu{env: env}
 }
 
 func (b *Baidu) Init() {
-	err := setting.GSetting.SetDefault(xstorage.Join("auto", "baidu", "keys"), xstorage.ToUnit([]string{
+	err := b.env.Storage.SetDefault(xstorage.Join("auto", "baidu", "keys"), xstorage.ToUnit([]string{
 		"nuc",
 		"群晖",
 		"macbook air",
@@ -21,18 +25,18 @@ func (b *Baidu) Init() {
 		"kindle",
 	}, xstorage.ValueTypeSliceString))
 	if err != nil {
-		tool.GLog.WarningErr("auto.BAIDU", errors.Join(errors.New("func Init() GetAndSetDefault error"), err))
+		b.env.Log.WarningErr("auto.BAIDU", errors.Join(errors.New("func Init() GetAndSetDefault error"), err))
 	}
 }
 
 func (b *Baidu) Do() {
-	keysV, err := setting.GSetting.Get("auto.baidu.keys")
+	keysV, err := b.env.Storage.Get("auto.baidu.keys")
 	if keysV == nil {
-		tool.GLog.Error("BAIDU", "baidu.keys not exist")
+		b.env.Log.Error("BAIDU", "baidu.keys not exist")
 		return
 	}
 	if err != nil {
-		tool.GLog.ErrorErr("BAIDU", errors.Join(errors.New("func Do() Get auto.baidu.keys error"), err))
+		b.env.Log.ErrorErr("BAIDU", errors.Join(errors.New("func Do() Get auto.baidu.keys error"), err))
 		return
 	}
 	keys := xstorage.ToBase[[]string](keysV)
@@ -50,7 +54,7 @@ func (b *Baidu) Do() {
 			continue
 		}
 
-		unit, _ := setting.GSetting.Get(xstorage.Join("auto", "baidu", "key", "last", v))
+		unit, _ := b.env.Storage.Get(xstorage.Join("auto", "baidu", "key", "last", v))
 		var lastLink []string
 		if unit != nil {
 			lastLink = xstorage.ToBase[[]string](unit)
@@ -64,26 +68,26 @@ func (b *Baidu) Do() {
 			errs = append(errs, e)
 		}
 		if len(newLink) != 0 {
-			err = setting.GSetting.Set(xstorage.Join("auto", "baidu", "key", "last", v), xstorage.ToUnit(newLink, xstorage.ValueTypeSliceString))
+			err = b.env.Storage.Set(xstorage.Join("auto", "baidu", "key", "last", v), xstorage.ToUnit(newLink, xstorage.ValueTypeSliceString))
 			if err != nil {
 				e := fmt.Errorf("百度新闻 %s 保存最新链接失败: %s", v, err.Error())
 				errs = append(errs, e)
 			}
 		}
-		tool.GLog.Info("BAIDU", fmt.Sprintf("get %s news suc,num:%d oldLinkLen %d newLinkLen %d foldedLen %d", v, len(news), len(lastLink), len(newLink), folded))
+		b.env.Log.Info("BAIDU", fmt.Sprintf("get %s news suc,num:%d oldLinkLen %d newLinkLen %d foldedLen %d", v, len(news), len(lastLink), len(newLink), folded))
 	}
 	if len(errs) > 0 {
-		tool.GLog.ErrorErr("BAIDU", errors.Join(errors.New("func Do() spider.GetTodayBaiduNews error"), errors.New(fmt.Sprint(errs))))
+		b.env.Log.ErrorErr("BAIDU", errors.Join(errors.New("func Do() spider.GetTodayBaiduNews error"), errors.New(fmt.Sprint(errs))))
 		return
 	}
 	s := spider.ParseNewToMarkdown(keywords, newss)
 	if allRetry > 0 {
 		retryStr := fmt.Sprintf("百度新闻 总重试次数: %d", allRetry)
-		tool.GLog.Debug("BAIDU", retryStr)
+		b.env.Log.Debug("BAIDU", retryStr)
 	}
-	err = tool.GPush.Push("关注新闻", s, true)
+	err = b.env.Push.Push("关注新闻", s, true)
 	if err != nil {
-		tool.GLog.ErrorErr("BAIDU", errors.Join(errors.New("func Do() Push error"), err))
+		b.env.Log.ErrorErr("BAIDU", errors.Join(errors.New("func Do() Push error"), err))
 		return
 	}
 }
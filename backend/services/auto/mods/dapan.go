@@ -6,6 +6,11 @@ import (
 )
 
 type Dapan struct {
+	env *tool.Env
+}
+
+func NewDapan(env *tool.Env) *Dapan {
+	return &Dapan{env: env}
 }
 
 func (d *Dapan) Init() {
@@ -15,13 +20,13 @@ func (d *Dapan) Init() {
 func (d *Dapan) Do() {
 	price, inc, radio := spider.GetDapan000001()
 	if price == "" || inc == "" || radio == "" {
-		tool.GLog.Warning(d.GetName(), "GetDapan000001 error")
+		d.env.Log.Warning(d.GetName(), "GetDapan000001 error")
 		return
 	}
 	s := spider.ParseDapanToMarkdown("上证指数", price, inc, radio)
-	err := tool.GPush.Push("大盘", s, true)
+	err := d.env.Push.Push("大盘", s, true)
 	if err != nil {
-		tool.GLog.WarningErr(d.GetName(), err)
+		d.env.Log.WarningErr(d.GetName(), err)
 	}
 }
 
@@ -1,8 +1,10 @@
 package mods
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -13,7 +15,6 @@ import (
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/mian_go_lib/tool/spider"
 	"github.com/intmian/mian_go_lib/xstorage"
-	"github.com/intmian/platform/backend/services/auto/setting"
 	"github.com/intmian/platform/backend/services/auto/tool"
 	backendshare "github.com/intmian/platform/backend/share"
 )
@@ -22,12 +23,14 @@ import (
 
 // Day 将每日的没有时间要求的都接入此处，比如天气新闻等
 type Day struct {
+	env *tool.Env
 	// 用于存储往期日报。
 	dayReportStorage *xstorage.XStorage
 }
 
-// 用于svr的调用。
-var GDay *Day
+func NewDay(env *tool.Env) *Day {
+	return &Day{env: env}
+}
 
 // DayReport 用于存储一天的日报.
 type DayReport struct {
@@ -53,7 +56,7 @@ type WholeReport struct {
 	}
 }
 
-func getWholeReport(c *http.Client, keywords []string) (*WholeReport, error) {
+func (d *Day) getWholeReport(c *http.Client, keywords []string) (*WholeReport, error) {
 	report := &WholeReport{}
 	bbcNews, err1 := spider.GetBBCRss(c)
 	report.BbcNews = bbcNews
@@ -81,7 +84,7 @@ func getWholeReport(c *http.Client, keywords []string) (*WholeReport, error) {
 	}
 	err := errors.Join(err1, err2, err3)
 	if err != nil {
-		tool.GLog.WarningErr("Day", errors.Join(errors.New("func GetWholeReport() GetWholeReport error"), err))
+		d.env.Log.WarningErr("Day", errors.Join(errors.New("func GetWholeReport() GetWholeReport error"), err))
 	}
 
 	// 进行进一步处理
@@ -97,9 +100,9 @@ func getWholeReport(c *http.Client, keywords []string) (*WholeReport, error) {
 		report.NytNews[i].Link = "https://www.removepaywall.com/search?url=" + news.Link
 	}
 	// 2. 调用ai进行翻译
-	err = translateW(report)
+	err = d.translateW(report)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GenerateWholeReport() translate error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GenerateWholeReport() translate error"), err))
 	}
 
 	return report, err
@@ -140,77 +143,95 @@ func GetDayReport(c *http.Client, keywords []string, city, weatherKey string) (*
 	report.WeatherIndex = weatherIndex
 
 	err := errors.Join(err1, err2, err3, err4, err5)
-	if err != nil {
-		tool.GLog.WarningErr("Day", errors.Join(errors.New("func GetDayReport() GetDayReport error"), err))
-	}
 	return report, err
 }
 
 func (d *Day) Init() {
 	//百度新闻组件已经移除
-	//err1 := setting.GSetting.SetDefault(xstorage.Join("auto", "baidu", "keys"), xstorage.ToUnit([]string{
+	//err1 := d.env.Storage.SetDefault(xstorage.Join("auto", "baidu", "keys"), xstorage.ToUnit([]string{
 	//	"nuc",
 	//	"群晖",
 	//	"macbook air",
 	//	"扫地机器人 发布",
 	//	"kindle",
 	//}, xstorage.ValueTypeSliceString))
-	err1 := setting.GSetting.SetDefault(xstorage.Join("auto", "news", "keys"), xstorage.ToUnit([]string{"need input"}, xstorage.ValueTypeSliceString))
-	err2 := setting.GSetting.SetDefault(xstorage.Join("qweather", "key"), xstorage.ToUnit[string]("need input", xstorage.ValueTypeString))
-	err3 := setting.GSetting.SetDefault(xstorage.Join("auto", "weather", "city"), xstorage.ToUnit[string]("杭州", xstorage.ValueTypeString))
+	err1 := d.env.Storage.SetDefault(xstorage.Join("auto", "news", "keys"), xstorage.ToUnit([]string{"need input"}, xstorage.ValueTypeSliceString))
+	err2 := d.env.Storage.SetDefault(xstorage.Join("qweather", "key"), xstorage.ToUnit[string]("need input", xstorage.ValueTypeString))
+	err3 := d.env.Storage.SetDefault(xstorage.Join("auto", "weather", "city"), xstorage.ToUnit[string]("杭州", xstorage.ValueTypeString))
 	err := misc.JoinErr(err1, err2, err3)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func Init() GetAndSetDefault error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func Init() GetAndSetDefault error"), err))
 	}
 	// 初始化存储
 	d.dayReportStorage, err = xstorage.NewXStorage(xstorage.XStorageSetting{
 		Property: misc.CreateProperty(xstorage.MultiSafe, xstorage.UseDisk),
 		SaveType: xstorage.SqlLiteDB,
-		DBAddr:   "auto_report.db",
+		DBAddr:   d.env.ReportDBAddr,
 	})
 	if err != nil {
-		tool.GLog.ErrorErr("auto.Day", errors.Join(errors.New("func Init() NewXStorage error"), err))
+		d.env.Log.ErrorErr("auto.Day", errors.Join(errors.New("func Init() NewXStorage error"), err))
 		// 这玩意不起就炸了，必须要崩溃
 		panic(err)
 	}
-	GDay = d
+}
+
+// Close 关闭往期日报的存储，释放sqlite文件句柄，服务停止时调用
+func (d *Day) Close() error {
+	if d.dayReportStorage == nil {
+		return nil
+	}
+	storage := d.dayReportStorage
+	d.dayReportStorage = nil
+	closer, ok := any(storage).(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := closer.Close(); err != nil {
+		return errors.Join(errors.New("func Close() close report storage error"), err)
+	}
+	return nil
 }
 
 func (d *Day) GenerateDayReport() (*DayReport, error) {
+	return d.GenerateDayReportContext(context.Background())
+}
+
+// GenerateDayReportContext 生成日报，ctx 取消后中止剩余的抓取与AI调用
+func (d *Day) GenerateDayReportContext(ctx context.Context) (*DayReport, error) {
 	// 读取配置
-	keysV, err := setting.GSetting.Get("auto.news.keys")
+	keysV, err := d.env.Storage.Get("auto.news.keys")
 	if keysV == nil {
-		tool.GLog.Warning("auto.Day", "auto.news.keys not exist")
+		d.env.Log.Warning("auto.Day", "auto.news.keys not exist")
 		return nil, errors.New("auto.news.keys not exist")
 	}
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() Get auto.news.keys error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() Get auto.news.keys error"), err))
 		return nil, errors.Join(errors.New("func GenerateDayReport() Get auto.news.keys error"), err)
 	}
 	keys := xstorage.ToBase[[]string](keysV)
 	if keys == nil || len(keys) == 0 {
 		return nil, errors.New("auto.news.keys is empty")
 	}
-	cityV, err := setting.GSetting.Get("auto.weather.city")
+	cityV, err := d.env.Storage.Get("auto.weather.city")
 	if err != nil {
-		tool.GLog.WarningErr("WEATHER", errors.Join(errors.New("func GenerateDayReport() Get auto.weather.city error"), err))
+		d.env.Log.WarningErr("WEATHER", errors.Join(errors.New("func GenerateDayReport() Get auto.weather.city error"), err))
 		return nil, errors.Join(errors.New("func GenerateDayReport() Get auto.weather.city error"), err)
 	}
 	city := xstorage.ToBase[string](cityV)
-	keyV, err := setting.GSetting.Get("qweather.key")
+	keyV, err := d.env.Storage.Get("qweather.key")
 	if keyV == nil {
-		tool.GLog.Warning("WEATHER", "qweather.key not exist")
+		d.env.Log.Warning("WEATHER", "qweather.key not exist")
 		return nil, errors.New("qweather.key not exist")
 	}
 	if err != nil {
-		tool.GLog.WarningErr("WEATHER", errors.Join(errors.New("func GenerateDayReport() Get qweather.key error"), err))
+		d.env.Log.WarningErr("WEATHER", errors.Join(errors.New("func GenerateDayReport() Get qweather.key error"), err))
 		return nil, errors.Join(errors.New("func GenerateDayReport() Get qweather.key error"), err)
 	}
 	weatherKey := xstorage.ToBase[string](keyV)
 
 	// 如果是debug就使用代理
 	var client *http.Client
-	if setting.GBaseSetting.Debug {
+	if d.env.BaseSetting.Debug {
 		client = &http.Client{
 			Transport: &http.Transport{
 				Proxy: http.ProxyURL(&url.URL{
@@ -225,11 +246,14 @@ func (d *Day) GenerateDayReport() (*DayReport, error) {
 
 	var report *DayReport
 	for i := 0; i < 3; i++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		report, err = GetDayReport(client, keys, city, weatherKey)
 		if err == nil {
 			break
 		}
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() GetDayReport error, retrying..."), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() GetDayReport error, retrying..."), err))
 	}
 	if err != nil {
 		return nil, errors.Join(errors.New("func GenerateDayReport() GetDayReport error"), err)
@@ -251,14 +275,18 @@ func (d *Day) GenerateDayReport() (*DayReport, error) {
 		report.NytNews[i].Link = "https://www.removepaywall.com/search?url=" + news.Link
 	}
 	// 2. 调用ai进行翻译
-	err = translate(report)
+	err = d.translate(ctx, report)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() translate error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() translate error"), err))
 	}
 	// 3. 生成摘要
-	err = summary(report)
+	err = d.summary(ctx, report)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() summary error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GenerateDayReport() summary error"), err))
+	}
+	// 被取消时翻译与摘要都不完整，不覆盖已有的日报
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
 	}
 	// 4. 存储
 	timeStr := time.Now().Format("2006-01-02")
@@ -379,13 +407,14 @@ func translateNews(newsBBC []spider.BBCRssItem, newsNYT []spider.NYTimesRssItem,
 	return nil
 }
 
-func summary(report *DayReport) error {
+func (d *Day) summary(ctx context.Context, report *DayReport) error {
 	setDayDigestFailure(report)
 
-	chat, err := backendshare.NewSceneAI(setting.GCfg, backendshare.AISceneSummary)
+	chat, err := backendshare.NewSceneAI(d.env.Cfg, backendshare.AISceneSummary)
 	if err != nil {
 		return err
 	}
+	chat = chat.WithContext(ctx)
 
 	digest, err := generateDayDigest(report, chat)
 	if err != nil {
@@ -405,12 +434,13 @@ func setDayDigestFailure(report *DayReport) {
 	report.Summary = dayDigestFailureSummary
 }
 
-func translate(report *DayReport) error {
+func (d *Day) translate(ctx context.Context, report *DayReport) error {
 	// 获取配置
-	chat, err := backendshare.NewSceneAI(setting.GCfg, backendshare.AISceneTranslate)
+	chat, err := backendshare.NewSceneAI(d.env.Cfg, backendshare.AISceneTranslate)
 	if err != nil {
 		return err
 	}
+	chat = chat.WithContext(ctx)
 
 	// 翻译 DayReport 的新闻
 	if err := translateNews(report.BbcNews, report.NytNews, chat); err != nil {
@@ -420,9 +450,9 @@ func translate(report *DayReport) error {
 	return nil
 }
 
-func translateW(report *WholeReport) error {
+func (d *Day) translateW(report *WholeReport) error {
 	// 获取配置
-	chat, err := backendshare.NewSceneAI(setting.GCfg, backendshare.AISceneTranslate)
+	chat, err := backendshare.NewSceneAI(d.env.Cfg, backendshare.AISceneTranslate)
 	if err != nil {
 		return err
 	}
@@ -438,7 +468,7 @@ func translateW(report *WholeReport) error {
 func (d *Day) Do() {
 	report, err := d.GenerateDayReport()
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func Do() GenerateDayReport error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func Do() GenerateDayReport error"), err))
 		return
 	}
 
@@ -447,9 +477,9 @@ func (d *Day) Do() {
 	// TODO: 日后可以做成配置的基础url方便别人用
 	reportLink := fmt.Sprintf("[点击查看日报](https://plat.intmian.com/day-report/%s)", time.Now().Format("2006-01-02"))
 	pushContent := buildDailyPushMarkdown(report, todayStr, reportLink)
-	err = tool.GPush.Push("日报", pushContent, true)
+	err = d.env.Push.Push("日报", pushContent, true)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func Do() Push error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func Do() Push error"), err))
 	}
 }
 
@@ -472,20 +502,20 @@ func (d *Day) GetDayReport(day time.Time) (*DayReport, error) {
 }
 
 func (d *Day) GetWholeReport() (*WholeReport, error) {
-	keysV, err := setting.GSetting.Get("auto.news.keys")
+	keysV, err := d.env.Storage.Get("auto.news.keys")
 	if keysV == nil {
-		tool.GLog.Warning("auto.Day", "auto.news.keys not exist")
+		d.env.Log.Warning("auto.Day", "auto.news.keys not exist")
 		return nil, errors.New("auto.news.keys not exist")
 	}
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() Get auto.news.keys error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() Get auto.news.keys error"), err))
 		return nil, errors.Join(errors.New("func GetWholeReport() Get auto.news.keys error"), err)
 	}
 	keys := xstorage.ToBase[[]string](keysV)
 
 	// 代理
 	var client *http.Client
-	if setting.GBaseSetting.Debug {
+	if d.env.BaseSetting.Debug {
 		client = &http.Client{
 			Transport: &http.Transport{
 				Proxy: http.ProxyURL(&url.URL{
@@ -506,20 +536,20 @@ func (d *Day) GetWholeReport() (*WholeReport, error) {
 		return cachedReport, nil
 	}
 	if !errors.Is(err, xstorage.ErrNoData) {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() GetFromJson error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() GetFromJson error"), err))
 		return nil, errors.Join(errors.New("func GetWholeReport() GetFromJson error"), err)
 	}
 
 	// 获取全量日报
-	report, err := getWholeReport(client, keys)
+	report, err := d.getWholeReport(client, keys)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() getWholeReport error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() getWholeReport error"), err))
 	}
 
 	// 存储全量日报和日期
 	err = d.dayReportStorage.SetToJson(timeStr+"_whole", report)
 	if err != nil {
-		tool.GLog.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() SetToJson error"), err))
+		d.env.Log.WarningErr("auto.Day", errors.Join(errors.New("func GetWholeReport() SetToJson error"), err))
 		return nil, errors.Join(errors.New("func GetWholeReport() SetToJson error"), err)
 	}
 
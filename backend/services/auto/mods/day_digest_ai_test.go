@@ -1,12 +1,13 @@
 package mods
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"strings"
 	"testing"
 
-	"github.com/intmian/platform/backend/services/auto/setting"
+	"github.com/intmian/platform/backend/services/auto/tool"
 )
 
 type fakeDigestChat struct {
@@ -276,17 +277,14 @@ func TestGenerateDayDigestFailsAfterTwoInvalidReplies(t *testing.T) {
 }
 
 func TestGenerateDayDigestSummarySetupFailureSetsFallback(t *testing.T) {
-	oldCfg := setting.GCfg
-	setting.GCfg = nil
-	defer func() {
-		setting.GCfg = oldCfg
-	}()
+	// 没有AI配置时创建场景失败
+	d := NewDay(&tool.Env{})
 
 	report := sampleDigestReport()
 	report.Digest = &DayDigest{Overview: "stale digest"}
 	report.Summary = "stale summary"
 
-	err := summary(report)
+	err := d.summary(context.Background(), report)
 	if err == nil {
 		t.Fatalf("expected summary setup error")
 	}
@@ -4,55 +4,61 @@ import (
 	"fmt"
 
 	"github.com/intmian/mian_go_lib/tool/spider"
+	"github.com/intmian/mian_go_lib/xlog"
 	"github.com/intmian/mian_go_lib/xstorage"
-	"github.com/intmian/platform/backend/services/auto/setting"
 	"github.com/intmian/platform/backend/services/auto/tool"
 	backendshare "github.com/intmian/platform/backend/share"
 	"github.com/pkg/errors"
 	"time"
 )
 
-type GNews struct{}
+type GNews struct {
+	env *tool.Env
+}
+
+func NewGNews(env *tool.Env) GNews {
+	return GNews{env: env}
+}
 
 func (G GNews) Init() {
-	err := setting.GSetting.SetDefault(xstorage.Join("auto", "GNews", "newsToken"), xstorage.ToUnit[string]("need input", xstorage.ValueTypeString))
+	err := G.env.Storage.SetDefault(xstorage.Join("auto", "GNews", "newsToken"), xstorage.ToUnit[string]("need input", xstorage.ValueTypeString))
 	if err != nil {
-		tool.GLog.WarningErr("auto.GNews", errors.WithMessage(err, "func Init() GetAndSetDefault error"))
+		G.env.Log.WarningErr("auto.GNews", errors.WithMessage(err, "func Init() GetAndSetDefault error"))
 	}
 }
 
 func (G GNews) Do() {
-	chat, err := backendshare.NewSceneAI(setting.GCfg, backendshare.AISceneSummary)
+	chat, err := backendshare.NewSceneAI(G.env.Cfg, backendshare.AISceneSummary)
 	if err != nil {
-		tool.GLog.WarningErr("GNews", errors.WithMessage(err, "func Do() NewSceneAI error"))
+		G.env.Log.WarningErr("GNews", errors.WithMessage(err, "func Do() NewSceneAI error"))
 		return
 	}
-	newsTokenV, err := setting.GSetting.Get("auto.GNews.newsToken")
+	newsTokenV, err := G.env.Storage.Get("auto.GNews.newsToken")
 	if newsTokenV == nil {
-		tool.GLog.Warning("GNews", "auto.GNews.newsToken not exist")
+		G.env.Log.Warning("GNews", "auto.GNews.newsToken not exist")
 		return
 	}
 	if err != nil {
-		tool.GLog.WarningErr("GNews", errors.WithMessage(err, "func Do() Get auto.GNews.newsToken error"))
+		G.env.Log.WarningErr("GNews", errors.WithMessage(err, "func Do() Get auto.GNews.newsToken error"))
 		return
 	}
 	newsToken := xstorage.ToBase[string](newsTokenV)
 	if newsToken == "" || newsToken == "need input" {
-		tool.GLog.Warning("GNews", "auto.GNews.newsToken is empty")
+		G.env.Log.Warning("GNews", "auto.GNews.newsToken is empty")
 		return
 	}
-	md, err := getNews(newsToken, chat)
+	md, err := getNews(G.env.Log, newsToken, chat)
 	if err != nil {
-		tool.GLog.WarningErr("GNews", errors.WithMessage(err, "func Do() getNews error"))
+		G.env.Log.WarningErr("GNews", errors.WithMessage(err, "func Do() getNews error"))
 		return
 	}
-	err = tool.GPush.Push("每日热点", md, true)
+	err = G.env.Push.Push("每日热点", md, true)
 	if err != nil {
-		tool.GLog.WarningErr("GNews", errors.WithMessage(err, "func Do() Push error"))
+		G.env.Log.WarningErr("GNews", errors.WithMessage(err, "func Do() Push error"))
 	}
 }
 
-func getNews(newsToken string, chat digestChat) (string, error) {
+func getNews(log *xlog.XLog, newsToken string, chat digestChat) (string, error) {
 	// 获取昨天0点到今天0点的新闻，今天发生新闻可能还没有稳定下来，如果到当前时间可能会导致新的新闻永远上不了榜或者重复报。近期的新闻也可能浮动变动过大，等待热度固定。
 	from := time.Now().AddDate(0, 0, -1)
 	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
@@ -112,7 +118,7 @@ The following is the original content:
 			done = true
 			break
 		}
-		tool.GLog.Info("auto.GNews", "open ai response is empty, retry %d", retry+1)
+		log.Info("auto.GNews", "open ai response is empty, retry %d", retry+1)
 		retry++
 		time.Sleep(time.Minute)
 	}
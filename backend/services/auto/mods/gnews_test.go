@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/intmian/mian_go_lib/tool/ai"
+	"github.com/intmian/mian_go_lib/xlog"
 	backendshare "github.com/intmian/platform/backend/share"
 )
 
@@ -24,7 +25,11 @@ func Test_getNews(t *testing.T) {
 		},
 	}
 	chat := ai.NewOpenAIWithMode(cfg.Base, cfg.Token, cfg.ModeForScene(backendshare.AISceneSummary, ai.ModelModeCheap), cfg.ModelPools)
-	s, err := getNews("ee54b7595ba81fc612c56689416abf6a", chat)
+	log, err := xlog.NewXLog(xlog.DefaultSetting())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := getNews(log, "ee54b7595ba81fc612c56689416abf6a", chat)
 	if err != nil {
 		t.Fatal(err)
 	}
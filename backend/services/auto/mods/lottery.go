@@ -6,6 +6,11 @@ import (
 )
 
 type Lottery struct {
+	env *tool.Env
+}
+
+func NewLottery(env *tool.Env) *Lottery {
+	return &Lottery{env: env}
 }
 
 func (l *Lottery) Init() {
@@ -14,13 +19,13 @@ func (l *Lottery) Init() {
 func (l *Lottery) Do() {
 	lotteries := spider.GetLotteryNow()
 	if lotteries == nil {
-		tool.GLog.Warning(l.GetName(), "接口失效")
+		l.env.Log.Warning(l.GetName(), "接口失效")
 		return
 	}
 	s := spider.ParseLotteriesToMarkDown(lotteries)
-	err := tool.GPush.Push("彩票", s, true)
+	err := l.env.Push.Push("彩票", s, true)
 	if err != nil {
-		tool.GLog.WarningErr(l.GetName(), err)
+		l.env.Log.WarningErr(l.GetName(), err)
 	}
 }
 
@@ -2,10 +2,22 @@ package mods
 
 import (
 	"testing"
+
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/mian_go_lib/xpush"
+	"github.com/intmian/platform/backend/services/auto/tool"
 )
 
 func TestLottery(t *testing.T) {
-	l := Lottery{}
+	log, err := xlog.NewXLog(xlog.DefaultSetting())
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, err := xpush.NewXPush(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLottery(&tool.Env{Log: log, Push: push})
 	l.Init()
 	l.Do()
 }
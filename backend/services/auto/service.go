@@ -1,19 +1,46 @@
 package auto
 
 import (
+	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/platform/backend/services/auto/mods"
-	"github.com/intmian/platform/backend/services/auto/setting"
 	"github.com/intmian/platform/backend/services/auto/task"
 	"github.com/intmian/platform/backend/services/auto/tool"
 	backendshare "github.com/intmian/platform/backend/share"
+	"sync"
 	"time"
 )
 
+func init() {
+	backendshare.RegisterService(backendshare.ServiceDesc{
+		Name: backendshare.NameAuto,
+		Flag: backendshare.FlagAuto,
+		New: func() backendshare.IService {
+			s := &Service{}
+			s.initRpc()
+			return s
+		},
+	})
+}
+
+// defaultReportDBAddr 往期日报的默认存储路径
+const defaultReportDBAddr = "auto_report.db"
+
+var errServiceNotStarted = errors.New("service not started")
+
 type Service struct {
+	// lock 保护实例状态，rpc持有读锁，Start、Stop持有写锁，Stop会等进行中的rpc结束
+	lock  sync.RWMutex
 	share backendshare.ServiceShare
+	env   *tool.Env
+	tasks *task.Mgr
+	day   *mods.Day
+	rpc   *backendshare.RpcRouter
+
+	// reportDBAddr 非空时替代默认的日报存储路径，供测试启动互不影响的实例
+	reportDBAddr string
 }
 
 func (s *Service) DebugCommand(req backendshare.DebugReq) interface{} {
@@ -25,33 +52,31 @@ func (s *Service) GetProp() backendshare.ServiceProp {
 	return misc.CreateProperty(backendshare.SvrPropMicro)
 }
 
-func (s *Service) HandleRpc(msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
-	if !(valid.HasOnePermission(backendshare.PermissionAdmin, backendshare.PermissionAuto, backendshare.PermissionAutoReport)) {
-		return nil, errors.New("no permission")
+func (s *Service) HandleRpc(ctx context.Context, msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	// 停止后实例状态已经释放
+	if s.day == nil {
+		return nil, errServiceNotStarted
 	}
+	return s.rpc.Handle(ctx, msg, valid)
+}
 
-	if !valid.HasPermission(backendshare.PermissionAdmin) {
-		switch msg.Cmd() {
-		case CmdGetReportList, CmdGenerateReport, CmdGetReport, CmdGetWholeReport:
-			if !valid.HasPermission(backendshare.PermissionAutoReport) {
-				return nil, errors.New("no permission")
-			}
-		default:
-			return nil, errors.New("no permission")
-		}
-	}
+// initRpc 注册全部rpc命令，新增命令只需要在这里登记
+func (s *Service) initRpc() {
+	s.rpc = backendshare.NewRpcRouter()
+	// 日报相关的命令对只有 auto.report 权限的账号开放
+	reportPers := []backendshare.Permission{backendshare.PermissionAdmin, backendshare.PermissionAutoReport}
+	backendshare.Register(s.rpc, CmdGetReport, s.OnGetReport, reportPers...)
+	backendshare.Register(s.rpc, CmdGetWholeReport, s.OnGetWholeReport, reportPers...)
+	backendshare.Register(s.rpc, CmdGetReportList, s.OnGetReportList, reportPers...)
+	backendshare.RegisterCtx(s.rpc, CmdGenerateReport, s.OnGenerateReport, reportPers...)
+	// 生成日报需要抓取新闻并多次调用AI
+	s.rpc.SetTimeout(CmdGenerateReport, 10*time.Minute)
+}
 
-	switch msg.Cmd() {
-	case CmdGetReport:
-		return backendshare.HandleRpcTool("getReport", msg, valid, s.OnGetReport)
-	case CmdGetWholeReport:
-		return backendshare.HandleRpcTool("getWholeReport", msg, valid, s.OnGetWholeReport)
-	case CmdGetReportList:
-		return backendshare.HandleRpcTool("getReportList", msg, valid, s.OnGetReportList)
-	case CmdGenerateReport:
-		return backendshare.HandleRpcTool("generateReport", msg, valid, s.OnGenerateReport)
-	}
-	return nil, nil
+func (s *Service) RpcRouter() *backendshare.RpcRouter {
+	return s.rpc
 }
 
 func (s *Service) Handle(msg backendshare.Msg, valid backendshare.Valid) {
@@ -63,14 +88,31 @@ func (s *Service) Handle(msg backendshare.Msg, valid backendshare.Valid) {
 }
 
 func (s *Service) Start(share backendshare.ServiceShare) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.share = share
-	setting.GSetting = share.Storage
-	setting.GCfg = share.Cfg
-	setting.GBaseSetting = share.BaseSetting
-	tool.Init(share.Push, share.Log)
-	tool.GLog.Info("AUTO", "初始化开始")
-	task.Init()
-	tool.GLog.Info("AUTO", "task初始化完成")
+	reportDBAddr := s.reportDBAddr
+	if reportDBAddr == "" {
+		reportDBAddr = defaultReportDBAddr
+	}
+	s.env = &tool.Env{
+		Log:          share.Log,
+		Push:         share.Push,
+		Storage:      share.Storage,
+		Cfg:          share.Cfg,
+		BaseSetting:  share.BaseSetting,
+		ReportDBAddr: reportDBAddr,
+	}
+	s.env.Log.Info("AUTO", "初始化开始")
+	s.day = mods.NewDay(s.env)
+	s.tasks = task.NewMgr(s.env)
+	s.tasks.Add(mods.NewDapan(s.env))
+	s.tasks.Add(mods.NewLottery(s.env))
+	//废除百度新闻统一整合进日报
+	//s.tasks.Add(mods.NewBaidu(s.env))
+	//s.tasks.Add(mods.NewGNews(s.env))
+	s.tasks.Add(s.day)
+	s.env.Log.Info("AUTO", "task初始化完成")
 	// 网页被做到了外部，因此这里不需要了
 	//ok, isDebug, err := xstorage.Get[bool](setting.GSetting, "web.debug")
 	//if ok && isDebug {
@@ -94,14 +136,26 @@ func (s *Service) Start(share backendshare.ServiceShare) error {
 	//	tool.GLog.Info("SYS", "web启动失败")
 	//}
 
-	tool.GLog.Info("AUTO", "初始化完成")
+	s.env.Log.Info("AUTO", "初始化完成")
 
 	return nil
 }
 
 func (s *Service) Stop() error {
-	task.GMgr.AllStop()
-	return nil
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tasks != nil {
+		s.tasks.AllStop()
+	}
+	var err error
+	if s.day != nil {
+		err = s.day.Close()
+	}
+	// 释放本实例的全部状态，下次start重新创建
+	s.tasks = nil
+	s.day = nil
+	s.env = nil
+	return err
 }
 
 func (s *Service) RegisterWeb(gin *gin.Engine) {
@@ -119,7 +173,7 @@ func (s *Service) OnGetReport(valid backendshare.Valid, req GetReportReq) (ret G
 		return
 	}
 	// 获取当天的报告
-	rep, err := mods.GDay.GetDayReport(day)
+	rep, err := s.day.GetDayReport(day)
 	if err != nil {
 		return
 	}
@@ -130,7 +184,7 @@ func (s *Service) OnGetReport(valid backendshare.Valid, req GetReportReq) (ret G
 
 func (s *Service) OnGetWholeReport(valid backendshare.Valid, req GetWholeReportReq) (ret GetWholeReportRet, err error) {
 	// 获取整体报告
-	rep, err := mods.GDay.GetWholeReport()
+	rep, err := s.day.GetWholeReport()
 	if err != nil {
 		return
 	}
@@ -141,7 +195,7 @@ func (s *Service) OnGetWholeReport(valid backendshare.Valid, req GetWholeReportR
 
 func (s *Service) OnGetReportList(valid backendshare.Valid, req GetReportListReq) (ret GetReportListRet, err error) {
 	// 获取报告列表
-	list, err := mods.GDay.GetReportList()
+	list, err := s.day.GetReportList()
 	if err != nil {
 		return
 	}
@@ -150,9 +204,9 @@ func (s *Service) OnGetReportList(valid backendshare.Valid, req GetReportListReq
 	return
 }
 
-func (s *Service) OnGenerateReport(valid backendshare.Valid, req GenerateReportReq) (ret GenerateReportRet, err error) {
+func (s *Service) OnGenerateReport(ctx context.Context, valid backendshare.Valid, req GenerateReportReq) (ret GenerateReportRet, err error) {
 	// 生成报告
-	_, err = mods.GDay.GenerateDayReport()
+	_, err = s.day.GenerateDayReportContext(ctx)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,111 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intmian/mian_go_lib/tool/misc"
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/mian_go_lib/xpush"
+	"github.com/intmian/mian_go_lib/xstorage"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+// newLocalTestService 在临时目录中启动一个独立的auto实例，测试结束时自动停止
+func newLocalTestService(t *testing.T) *Service {
+	t.Helper()
+	dir := t.TempDir()
+	push, err := xpush.NewXPush(false)
+	if err != nil {
+		t.Fatalf("new push: %v", err)
+	}
+	logS := xlog.DefaultSetting()
+	logS.LogAddr = dir
+	logS.IfPush = false
+	log, err := xlog.NewXLog(logS)
+	if err != nil {
+		t.Fatalf("new log: %v", err)
+	}
+	storage, err := xstorage.NewXStorage(xstorage.XStorageSetting{
+		Property: misc.CreateProperty(xstorage.UseCache, xstorage.MultiSafe, xstorage.UseDisk, xstorage.FullInitLoad),
+		SaveType: xstorage.SqlLiteDB,
+		DBAddr:   filepath.Join(dir, "setting.sqlite"),
+	})
+	if err != nil {
+		t.Fatalf("new storage: %v", err)
+	}
+	s := &Service{reportDBAddr: filepath.Join(dir, "report.sqlite")}
+	s.initRpc()
+	err = s.Start(backendshare.ServiceShare{Log: log, Push: push, Storage: storage, Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("start auto: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Stop()
+	})
+	return s
+}
+
+func TestServiceInstancesAreIsolated(t *testing.T) {
+	a := newLocalTestService(t)
+	b := newLocalTestService(t)
+	if a.env == b.env || a.tasks == b.tasks || a.day == b.day {
+		t.Fatal("instances share state")
+	}
+	if a.env.ReportDBAddr == b.env.ReportDBAddr {
+		t.Fatalf("instances share report db %s", a.env.ReportDBAddr)
+	}
+	if len(a.tasks.Units) == 0 || len(a.tasks.Units) != len(b.tasks.Units) {
+		t.Fatalf("units a=%d b=%d", len(a.tasks.Units), len(b.tasks.Units))
+	}
+}
+
+func TestServiceStopReleasesState(t *testing.T) {
+	s := newLocalTestService(t)
+	if err := s.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if s.env != nil || s.tasks != nil || s.day != nil {
+		t.Fatal("stop kept instance state")
+	}
+	_, err := s.HandleRpc(context.Background(), backendshare.MakeMsg(CmdGetReportList, GetReportListReq{}), backendshare.MakeSysValid())
+	if err == nil {
+		t.Fatal("stopped service handled rpc")
+	}
+}
+
+func TestServiceStopWhileHandling(t *testing.T) {
+	s := newLocalTestService(t)
+	msg := backendshare.MakeMsg(CmdGetReportList, GetReportListReq{})
+
+	done := make(chan struct{})
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// 这里只关心停止过程中不会访问已经释放的状态，日报列表本身是否为空不重要
+				_, _ = s.HandleRpc(context.Background(), msg, backendshare.MakeSysValid())
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	close(done)
+	wait.Wait()
+	if _, err := s.HandleRpc(context.Background(), msg, backendshare.MakeSysValid()); !errors.Is(err, errServiceNotStarted) {
+		t.Fatalf("rpc after stop err = %v", err)
+	}
+}
@@ -2,15 +2,18 @@ package task
 
 import (
 	"fmt"
+
+	"github.com/intmian/platform/backend/services/auto/tool"
 )
 
 type Mgr struct {
+	env   *tool.Env
 	Units map[string]*Unit
 }
 
 // Add 新增单元
 func (mgr *Mgr) Add(task Task) {
-	t := NewUnit(task)
+	t := NewUnit(mgr.env, task)
 	t.Init()
 	mgr.Units[task.GetName()] = t
 }
@@ -66,8 +69,9 @@ func (mgr *Mgr) MakeStatusText() string {
 	return text
 }
 
-func NewMgr() *Mgr {
+func NewMgr(env *tool.Env) *Mgr {
 	return &Mgr{
+		env:   env,
 		Units: make(map[string]*Unit),
 	}
 }
@@ -3,7 +3,6 @@ package task
 import (
 	"fmt"
 	"github.com/intmian/mian_go_lib/xstorage"
-	"github.com/intmian/platform/backend/services/auto/setting"
 	"github.com/intmian/platform/backend/services/auto/tool"
 	"time"
 
@@ -40,6 +39,7 @@ type Task interface {
 }
 
 type Unit struct {
+	env     *tool.Env
 	c       *cron.Cron
 	timeStr string
 	status  Status
@@ -52,15 +52,15 @@ func (u *Unit) Start() {
 	if u.status != StatusClose {
 		return
 	}
-	//err := setting.GSetting.Set(xstorage.Join(u.name, "open"), xstorage.ToUnit(true, xstorage.ValueTypeBool))
+	//err := u.env.Storage.Set(xstorage.Join(u.name, "open"), xstorage.ToUnit(true, xstorage.ValueTypeBool))
 	//if err != nil {
-	//	tool.GLog.Error(u.name, "start失败:"+err.Error())
+	//	u.env.Log.Error(u.name, "start失败:"+err.Error())
 	//	return
 	//}
 	u.c = cron.New()
 	err := u.c.AddFunc(u.timeStr, u.do)
 	if err != nil {
-		tool.GLog.Error(u.name, "start失败:"+err.Error())
+		u.env.Log.Error(u.name, "start失败:"+err.Error())
 	}
 	u.c.Start()
 	u.status = StatusPending
@@ -70,9 +70,9 @@ func (u *Unit) Stop() {
 	if u.status == StatusClose {
 		return
 	}
-	//err := setting.GSetting.Set(u.name+".openwhenstart", xstorage.ToUnit(false, xstorage.ValueTypeBool))
+	//err := u.env.Storage.Set(u.name+".openwhenstart", xstorage.ToUnit(false, xstorage.ValueTypeBool))
 	//if err != nil {
-	//	tool.GLog.Error(u.name, "stop失败:"+err.Error())
+	//	u.env.Log.Error(u.name, "stop失败:"+err.Error())
 	//	return
 	//}
 	u.c.Stop()
@@ -85,13 +85,13 @@ func (u *Unit) Status() Status {
 
 func (u *Unit) do() {
 	u.status = StatusRunning
-	tool.GLog.Info(u.name, "执行开始")
+	u.env.Log.Info(u.name, "执行开始")
 	ok := make(chan bool)
 	begin := time.Now()
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
-				tool.GLog.Error(u.name, "协程崩溃:"+u.name+" "+fmt.Sprint(err))
+				u.env.Log.Error(u.name, "协程崩溃:"+u.name+" "+fmt.Sprint(err))
 				ok <- true
 			}
 		}()
@@ -105,10 +105,10 @@ loop:
 			break loop
 		case <-time.After(time.Hour):
 			now := time.Now()
-			tool.GLog.Warning(u.name, "执行超时:"+now.Sub(begin).String())
+			u.env.Log.Warning(u.name, "执行超时:"+now.Sub(begin).String())
 		}
 	}
-	tool.GLog.Info(u.name, "执行完成")
+	u.env.Log.Info(u.name, "执行完成")
 	u.status = StatusPending
 
 }
@@ -133,51 +133,52 @@ func (u *Unit) GetNextRemain() string {
 	return u.c.Entries()[0].Next.Sub(time.Now()).String()
 }
 
-func NewUnit(task Task) *Unit {
+func NewUnit(env *tool.Env, task Task) *Unit {
 	u := Unit{
+		env:     env,
 		timeStr: task.GetInitTimeStr(),
 		name:    task.GetName(),
 		f:       task.Do,
 		init:    task.Init,
 	}
-	//t := setting.GSetting.Get(u.name + ".time_str")
+	//t := u.env.Storage.Get(u.name + ".time_str")
 	//if t != nil {
 	//	switch t.(type) {
 	//	case string:
 	//		u.timeStr = t.(string)
 	//	}
 	//}
-	//setting.GSetting.Set(u.name+".time_str", u.timeStr)
+	//u.env.Storage.Set(u.name+".time_str", u.timeStr)
 	var v xstorage.ValueUnit
-	ok, err, c := setting.GSetting.GetAndSetDefaultAsync(u.name+".time_str", xstorage.ToUnit(u.timeStr, xstorage.ValueTypeString), &v)
+	ok, err, c := u.env.Storage.GetAndSetDefaultAsync(u.name+".time_str", xstorage.ToUnit(u.timeStr, xstorage.ValueTypeString), &v)
 	if err != nil {
-		tool.GLog.Error(u.name, fmt.Sprintf("NewUnit(%v) GetAndSetDefaultAsync error:%v", task, err))
+		u.env.Log.Error(u.name, fmt.Sprintf("NewUnit(%v) GetAndSetDefaultAsync error:%v", task, err))
 		return nil
 	}
 	if ok {
 		u.timeStr = xstorage.ToBase[string](&v)
 	}
-	xlog.GoWaitError(tool.GLog, c, u.name, fmt.Sprintf("NewUnit(%v) GetAndSetDefaultAsync error", task))
+	xlog.GoWaitError(u.env.Log, c, u.name, fmt.Sprintf("NewUnit(%v) GetAndSetDefaultAsync error", task))
 	return &u
 }
 
 func (u *Unit) Init() {
 	u.init()
-	//if !setting.GSetting.Exist(u.name + ".open") {
-	//	setting.GSetting.Set(u.name+".open", true)
-	//	setting.GSetting.Save()
+	//if !u.env.Storage.Exist(u.name + ".open") {
+	//	u.env.Storage.Set(u.name+".open", true)
+	//	u.env.Storage.Save()
 	//	u.Run()
 	//} else {
-	//	if setting.GSetting.Get(u.name + ".open").(bool) {
+	//	if u.env.Storage.Get(u.name + ".open").(bool) {
 	//		u.Run()
 	//	} else {
 	//		u.Stop()
 	//	}
 	//}
 	v := &xstorage.ValueUnit{}
-	ok, err, c := setting.GSetting.GetAndSetDefaultAsync(u.name+".open_when_start", xstorage.ToUnit(true, xstorage.ValueTypeBool), v)
+	ok, err, c := u.env.Storage.GetAndSetDefaultAsync(u.name+".open_when_start", xstorage.ToUnit(true, xstorage.ValueTypeBool), v)
 	if err != nil {
-		tool.GLog.Error(u.name, fmt.Sprintf("Unit.Init() GetAndSetDefaultAsync error:%v", err))
+		u.env.Log.Error(u.name, fmt.Sprintf("Unit.Init() GetAndSetDefaultAsync error:%v", err))
 		return
 	}
 	if !ok {
@@ -189,11 +190,11 @@ func (u *Unit) Init() {
 			u.Stop()
 		}
 	}
-	xlog.GoWaitError(tool.GLog, c, u.name, "Unit.Init() GetAndSetDefaultAsync error")
+	xlog.GoWaitError(u.env.Log, c, u.name, "Unit.Init() GetAndSetDefaultAsync error")
 }
 
 func (u *Unit) check() {
-	//i := setting.GSetting.Get(u.name + ".open")
+	//i := u.env.Storage.Get(u.name + ".open")
 	//if i != nil {
 	//	switch i.(type) {
 	//	case bool:
@@ -205,7 +206,7 @@ func (u *Unit) check() {
 	//	}
 	//}
 	//
-	//i = setting.GSetting.Get(u.name + ".time_str")
+	//i = u.env.Storage.Get(u.name + ".time_str")
 	//if i != nil {
 	//	switch i.(type) {
 	//	case string:
@@ -214,9 +215,9 @@ func (u *Unit) check() {
 	//		u.c.Run()
 	//	}
 	//}
-	getV, err := setting.GSetting.Get(u.name + ".open")
+	getV, err := u.env.Storage.Get(u.name + ".open")
 	if err != nil {
-		tool.GLog.Error(u.name, fmt.Sprintf("Unit.check() Get error:%v", err))
+		u.env.Log.Error(u.name, fmt.Sprintf("Unit.check() Get error:%v", err))
 	}
 	if getV != nil {
 		if xstorage.ToBase[bool](getV) {
@@ -225,9 +226,9 @@ func (u *Unit) check() {
 			u.Stop()
 		}
 	}
-	getV, err = setting.GSetting.Get(u.name + ".time_str")
+	getV, err = u.env.Storage.Get(u.name + ".time_str")
 	if err != nil {
-		tool.GLog.Error(u.name, fmt.Sprintf("Unit.check() Get error:%v", err))
+		u.env.Log.Error(u.name, fmt.Sprintf("Unit.check() Get error:%v", err))
 	}
 	if getV != nil {
 		if xstorage.ToBase[string](getV) != u.timeStr {
@@ -0,0 +1,19 @@
+package tool
+
+import (
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/mian_go_lib/xpush"
+	"github.com/intmian/mian_go_lib/xstorage"
+	"github.com/intmian/platform/backend/share"
+)
+
+// Env 一个auto服务实例的运行环境，任务与模块都从这里取日志、推送与配置，不同实例之间互不影响
+type Env struct {
+	Log         *xlog.XLog
+	Push        *xpush.XPush
+	Storage     *xstorage.XStorage
+	Cfg         *xstorage.CfgExt
+	BaseSetting share.BaseSetting
+	// ReportDBAddr 往期日报的sqlite存储路径
+	ReportDBAddr string
+}
@@ -169,6 +169,16 @@ func (e *Env) GetTask(index int) *Task {
 	return task
 }
 
+// StopAllTask 强制结束所有运行中的任务
+func (e *Env) StopAllTask() {
+	e.tasks.Range(func(index int, task *Task) bool {
+		if task.GetStatus() == TaskStatusRunning {
+			task.Stop()
+		}
+		return true
+	})
+}
+
 func (e *Env) DelTask(index int) error {
 	task, ok := e.tasks.Get(index)
 	if !ok {
@@ -151,6 +151,14 @@ func (m *RunMgr) DeleteEnv(id uint32) {
 	})
 }
 
+// StopAllTask 强制结束所有环境中运行的任务，用于服务停止
+func (m *RunMgr) StopAllTask() {
+	m.envId2Env.Range(func(key uint32, env *Env) bool {
+		env.StopAllTask()
+		return true
+	})
+}
+
 func (m *RunMgr) GetEnvIDs() []uint32 {
 	ids := make([]uint32, 0)
 	m.envId2Env.Range(func(key uint32, value *Env) bool {
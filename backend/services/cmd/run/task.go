@@ -131,7 +131,9 @@ func (t *Task) Input(content string) error {
 }
 
 func (t *Task) Stop() {
-	t.end()
+	if t.end != nil {
+		t.end()
+	}
 	t.status = TaskStatusForceEnd
 }
 
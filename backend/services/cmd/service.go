@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/platform/backend/services/cmd/run"
@@ -22,11 +23,26 @@ import (
 //	return svr
 //}
 
+func init() {
+	backendshare.RegisterService(backendshare.ServiceDesc{
+		Name: backendshare.NameCmd,
+		Flag: backendshare.FlagCmd,
+		// cmd 可以执行任意脚本，默认不随平台启动
+		DefaultBoot: backendshare.BootManual,
+		New: func() backendshare.IService {
+			s := &Service{}
+			s.initRpc()
+			return s
+		},
+	})
+}
+
 type Service struct {
 	share   backendshare.ServiceShare
 	toolMgr *tool.ToolMgr
 	runMgr  *run.RunMgr
 	baseDir string
+	rpc     *backendshare.RpcRouter
 }
 
 func (s *Service) DebugCommand(req backendshare.DebugReq) interface{} {
@@ -71,53 +87,42 @@ func (s *Service) Start(share backendshare.ServiceShare) error {
 }
 
 func (s *Service) Stop() error {
-	//s.runMgr.Stop()
-	// TODO: 需要对runmgr进行改造。
+	if s.runMgr != nil {
+		s.runMgr.StopAllTask()
+	}
 	return nil
 }
 
 func (s *Service) Handle(msg backendshare.Msg, valid backendshare.Valid) {}
 
-func (s *Service) HandleRpc(msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
-	if !s.share.BaseSetting.Debug && !valid.HasPermission(backendshare.PermissionAdmin) && !valid.HasPermission(backendshare.PermissionCmd) {
-		return nil, errors.New("no permission")
-	}
-	switch msg.Cmd() {
-	case CmdCreateTool:
-		return backendshare.HandleRpcTool("createTool", msg, valid, s.OnCreateTool)
-	case CmdUpdateTool:
-		return backendshare.HandleRpcTool("updateTool", msg, valid, s.OnUpdateTool)
-	case CmdGetTools:
-		return backendshare.HandleRpcTool("getToolIds", msg, valid, s.OnGetTools)
-	case CmdGetToolScript:
-		return backendshare.HandleRpcTool("getToolScript", msg, valid, s.OnGetToolScript)
-	case CmdCreateEnv:
-		return backendshare.HandleRpcTool("createEnv", msg, valid, s.OnCreateEnv)
-	case CmdGetEnvs:
-		return backendshare.HandleRpcTool("getEnvs", msg, valid, s.OnGetEnvs)
-	case CmdGetEnv:
-		return backendshare.HandleRpcTool("getEnv", msg, valid, s.OnGetEnv)
-	case CmdGetFile:
-		return backendshare.HandleRpcTool("getFile", msg, valid, s.OnGetFile)
-	case CmdSetFile:
-		return backendshare.HandleRpcTool("setFile", msg, valid, s.OnSetFile)
-	case CmdSetEnv:
-		return backendshare.HandleRpcTool("setEnv", msg, valid, s.OnSetEnv)
-	case CmdRunEnv:
-		return backendshare.HandleRpcTool("runEnv", msg, valid, s.OnRunEnv)
-	case CmdGetTasks:
-		return backendshare.HandleRpcTool("getTasks", msg, valid, s.OnGetTasks)
-	case CmdGetTask:
-		return backendshare.HandleRpcTool("getTask", msg, valid, s.OnGetTask)
-	case CmdStopTask:
-		return backendshare.HandleRpcTool("stopTask", msg, valid, s.OnStopTask)
-	case CmdTaskInput:
-		return backendshare.HandleRpcTool("taskInput", msg, valid, s.OnTaskInput)
-	case CmdDeleteTool:
-		return backendshare.HandleRpcTool("deleteTool", msg, valid, s.OnDeleteTool)
-	}
+func (s *Service) HandleRpc(ctx context.Context, msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
+	return s.rpc.Handle(ctx, msg, valid)
+}
+
+// initRpc 注册全部rpc命令，新增命令只需要在这里登记
+func (s *Service) initRpc() {
+	s.rpc = backendshare.NewRpcRouter()
+	pers := []backendshare.Permission{backendshare.PermissionAdmin, backendshare.PermissionCmd}
+	backendshare.Register(s.rpc, CmdCreateTool, s.OnCreateTool, pers...)
+	backendshare.Register(s.rpc, CmdUpdateTool, s.OnUpdateTool, pers...)
+	backendshare.Register(s.rpc, CmdGetTools, s.OnGetTools, pers...)
+	backendshare.Register(s.rpc, CmdGetToolScript, s.OnGetToolScript, pers...)
+	backendshare.Register(s.rpc, CmdCreateEnv, s.OnCreateEnv, pers...)
+	backendshare.Register(s.rpc, CmdGetEnvs, s.OnGetEnvs, pers...)
+	backendshare.Register(s.rpc, CmdGetEnv, s.OnGetEnv, pers...)
+	backendshare.Register(s.rpc, CmdGetFile, s.OnGetFile, pers...)
+	backendshare.Register(s.rpc, CmdSetFile, s.OnSetFile, pers...)
+	backendshare.Register(s.rpc, CmdSetEnv, s.OnSetEnv, pers...)
+	backendshare.Register(s.rpc, CmdRunEnv, s.OnRunEnv, pers...)
+	backendshare.Register(s.rpc, CmdGetTasks, s.OnGetTasks, pers...)
+	backendshare.Register(s.rpc, CmdGetTask, s.OnGetTask, pers...)
+	backendshare.Register(s.rpc, CmdStopTask, s.OnStopTask, pers...)
+	backendshare.Register(s.rpc, CmdTaskInput, s.OnTaskInput, pers...)
+	backendshare.Register(s.rpc, CmdDeleteTool, s.OnDeleteTool, pers...)
+}
 
-	return nil, errors.New("unknown cmd")
+func (s *Service) RpcRouter() *backendshare.RpcRouter {
+	return s.rpc
 }
 
 func (s *Service) GetProp() backendshare.ServiceProp {
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChangeDB 变更流，Seq 全局递增，客户端用它作为续传的游标
+type ChangeDB struct {
+	Seq          uint32 `gorm:"primaryKey"`
+	UserID       string `gorm:"not null;index"`
+	Kind         string `gorm:"not null"`
+	Op           string `gorm:"not null"`
+	ItemID       uint32
+	ParentID     uint32
+	FromParentID uint32
+	CreatedAt    time.Time `gorm:"index"`
+}
+
+func CreateChange(conn *gorm.DB, change *ChangeDB) error {
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+	return conn.Create(change).Error
+}
+
+// GetChangesAfter 按Seq升序读取游标之后的变更
+func GetChangesAfter(conn *gorm.DB, userID string, after uint32, limit int) ([]ChangeDB, error) {
+	changes := make([]ChangeDB, 0)
+	err := conn.Where("user_id = ? AND seq > ?", userID, after).Order("seq").Limit(limit).Find(&changes).Error
+	return changes, err
+}
+
+// GetChangeSeqRange 全表最小与最大的Seq，没有记录时都为0
+func GetChangeSeqRange(conn *gorm.DB) (uint32, uint32, error) {
+	var res struct {
+		MinSeq uint32
+		MaxSeq uint32
+	}
+	err := conn.Model(&ChangeDB{}).Select("COALESCE(MIN(seq), 0) AS min_seq, COALESCE(MAX(seq), 0) AS max_seq").Scan(&res).Error
+	return res.MinSeq, res.MaxSeq, err
+}
+
+// PruneChanges 删除早于before的变更
+func PruneChanges(conn *gorm.DB, before time.Time) error {
+	return conn.Where("created_at < ?", before).Delete(&ChangeDB{}).Error
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -12,26 +13,47 @@ import (
 	"github.com/intmian/mian_go_lib/xbi"
 	"github.com/intmian/mian_go_lib/xlog"
 	log2 "github.com/intmian/platform/backend/services/todone/log"
+	"github.com/intmian/platform/backend/share"
 	"github.com/intmian/platform/backend/share/utils"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// Driver 存储驱动，两种驱动使用同一套表结构与迁移
+type Driver string
+
+const (
+	// DriverWorker 通过 D1 Worker 访问线上数据库
+	DriverWorker Driver = "worker"
+	// DriverSqlite 本地SQLite文件，用于离线、CI与本机开发
+	DriverSqlite Driver = "sqlite"
+)
+
+// sqliteBusyTimeoutMs 本地文件被其他进程占用时等待的时间
+const sqliteBusyTimeoutMs = 5000
+
+var ErrUnknownDriver = errors.New("unknown todone db driver")
+
+var ErrTxUnsupported = errors.New("todone db driver does not support transaction")
+
 type Setting struct {
+	// Driver 为空时按 DriverWorker 处理，兼容只配置了Worker的旧部署
+	Driver         Driver
 	WorkerEndpoint string
 	WorkerToken    string
-	Ctx            context.Context
-	XBi            *xbi.XBi
-	XLog           *xlog.XLog
+	// SqlitePath DriverSqlite 使用的数据库文件
+	SqlitePath string
+	Ctx        context.Context
+	XBi        *xbi.XBi
+	XLog       *xlog.XLog
 }
 
-// GTodoneDBMgr 全应用全局数据库管理器
-var GTodoneDBMgr Mgr
-
-func InitGMgr(setting Setting) error {
-	err := GTodoneDBMgr.Init(setting)
+// Open 创建数据库管理器并连接、迁移全部表，每个服务实例各自持有一个
+func Open(setting Setting) (*Mgr, error) {
+	mgr, err := NewMgr(setting)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	connections := []struct {
 		connectType ConnectType
@@ -44,23 +66,24 @@ func InitGMgr(setting Setting) error {
 		{ConnectTypeSubGroup, &SubGroupDB{}},
 		{ConnectTypeLibraryNote, &LibraryNoteDB{}},
 		{ConnectTypeLibraryScoreDetail, &LibraryScoreDetailDB{}},
+		{ConnectTypeReminder, &ReminderDB{}},
+		{ConnectTypeHistory, &TaskHistoryDB{}},
+		{ConnectTypeGroupShare, &GroupShareDB{}},
+		{ConnectTypeChange, &ChangeDB{}},
+		{ConnectTypeTagMeta, &TagMetaDB{}},
+		{ConnectTypeDependency, &TaskDependencyDB{}},
 	}
 	for _, connection := range connections {
-		if err = GTodoneDBMgr.Connect(connection.connectType, connection.model); err != nil {
-			return err
+		if err = mgr.Connect(connection.connectType, connection.model); err != nil {
+			return nil, errors.Join(err, mgr.Close())
 		}
 	}
-	connectDir := GTodoneDBMgr.GetConnect(ConnectTypeDir)
-	connectGroup := GTodoneDBMgr.GetConnect(ConnectTypeGroup)
-	connectTask := GTodoneDBMgr.GetConnect(ConnectTypeTask)
-	connectTags := GTodoneDBMgr.GetConnect(ConnectTypeTags)
-	connectSubGroup := GTodoneDBMgr.GetConnect(ConnectTypeSubGroup)
-	connectLibraryNote := GTodoneDBMgr.GetConnect(ConnectTypeLibraryNote)
-	connectLibraryScoreDetail := GTodoneDBMgr.GetConnect(ConnectTypeLibraryScoreDetail)
-	if connectDir == nil || connectGroup == nil || connectTask == nil || connectTags == nil || connectSubGroup == nil || connectLibraryNote == nil || connectLibraryScoreDetail == nil {
-		return errors.New("connect is nil")
+	// 全文索引依赖上面的表，建立失败时退化为LIKE检索，不影响启动
+	mgr.searchFts, err = EnsureSearchIndex(mgr.db)
+	if err != nil && setting.XLog != nil {
+		setting.XLog.WarningErr("todone.db", errors.Join(err, errors.New("ensure search index failed")))
 	}
-	return nil
+	return mgr, nil
 }
 
 type Mgr struct {
@@ -68,6 +91,7 @@ type Mgr struct {
 	type2connect map[ConnectType]*gorm.DB
 	db           *gorm.DB
 	logger       logger.Interface
+	searchFts    bool
 }
 
 func NewMgr(setting Setting) (*Mgr, error) {
@@ -126,6 +150,7 @@ func (d *Mgr) Init(setting Setting) error {
 			dbLogEntity.GetWriteableData().Rows = rows
 			durationMillis := duration.Milliseconds()
 			dbLogEntity.GetWriteableData().Duration = durationMillis
+			dbLogEntity.GetWriteableData().ReqID = share.ReqIDFromContext(ctx)
 			if err != nil {
 				dbLogEntity.GetWriteableData().Err = err.Error()
 			}
@@ -145,11 +170,11 @@ func (d *Mgr) Init(setting Setting) error {
 
 func (d *Mgr) Connect(t ConnectType, orm interface{}) error {
 	if d.db == nil {
-		db, err := gorm.Open(gormd1.OpenConfig(d1.Config{
-			Mode:           d1.ExecutorModeWorker,
-			WorkerEndpoint: d.Setting.WorkerEndpoint,
-			WorkerToken:    d.Setting.WorkerToken,
-		}), &gorm.Config{
+		dialector, err := d.dialector()
+		if err != nil {
+			return errors.Join(err, ErrConnectDbFailed)
+		}
+		db, err := gorm.Open(dialector, &gorm.Config{
 			Logger: d.logger,
 		})
 		if err != nil {
@@ -163,12 +188,16 @@ func (d *Mgr) Connect(t ConnectType, orm interface{}) error {
 		if err != nil {
 			return errors.Join(err, ErrConnectDbFailed)
 		}
+		if d.Setting.Driver == DriverSqlite {
+			// SQLite同一时刻只允许一个写入，单连接串行执行，避免并发请求互相报 database is locked
+			sqlDB.SetMaxOpenConns(1)
+		}
 		if err = sqlDB.PingContext(ctx); err != nil {
 			return errors.Join(err, ErrConnectDbFailed)
 		}
 		d.db = db
 	}
-	// 同一个D1只使用一个GORM根连接，所有表按顺序迁移。
+	// 同一个库只使用一个GORM根连接，所有表按顺序迁移。
 	err := d.db.AutoMigrate(orm)
 	if err != nil {
 		return errors.Join(err, ErrAutoMigrateFailed)
@@ -177,10 +206,136 @@ func (d *Mgr) Connect(t ConnectType, orm interface{}) error {
 	return nil
 }
 
+// dialector 按配置的驱动选择连接方式
+func (d *Mgr) dialector() (gorm.Dialector, error) {
+	switch d.Setting.Driver {
+	case DriverWorker, "":
+		return gormd1.OpenConfig(d1.Config{
+			Mode:           d1.ExecutorModeWorker,
+			WorkerEndpoint: d.Setting.WorkerEndpoint,
+			WorkerToken:    d.Setting.WorkerToken,
+		}), nil
+	case DriverSqlite:
+		if d.Setting.SqlitePath == "" {
+			return nil, errors.New("todone sqlite path is empty")
+		}
+		return sqlite.Open(fmt.Sprintf("%s?_busy_timeout=%d&_journal_mode=WAL", d.Setting.SqlitePath, sqliteBusyTimeoutMs)), nil
+	default:
+		return nil, errors.Join(ErrUnknownDriver, errors.New(string(d.Setting.Driver)))
+	}
+}
+
+// Close 关闭根连接，之后需要重新 Init 与 Connect 才能使用
+func (d *Mgr) Close() error {
+	if d.db == nil {
+		return nil
+	}
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	d.db = nil
+	d.type2connect = make(map[ConnectType]*gorm.DB)
+	return sqlDB.Close()
+}
+
+// Ping 检查根连接是否可用，供服务健康检查使用
+func (d *Mgr) Ping(ctx context.Context) error {
+	if d.db == nil {
+		return ErrConnectDbFailed
+	}
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return errors.Join(err, ErrConnectDbFailed)
+	}
+	if err = sqlDB.PingContext(ctx); err != nil {
+		return errors.Join(err, ErrConnectDbFailed)
+	}
+	return nil
+}
+
+// SearchFts 全文索引是否可用
+func (d *Mgr) SearchFts() bool {
+	return d.searchFts
+}
+
 func (d *Mgr) GetConnect(t ConnectType) *gorm.DB {
 	return d.type2connect[t]
 }
 
+// GetConnectCtx 返回绑定了ctx的连接，请求取消或超时后正在进行的sql会中止，ctx中的请求ID也会进入sql日志。
+// ctx来自 Transaction 时返回事务连接
+func (d *Mgr) GetConnectCtx(ctx context.Context, t ConnectType) *gorm.DB {
+	connect := d.type2connect[t]
+	if connect == nil || ctx == nil {
+		return connect
+	}
+	if state := d.txFromCtx(ctx); state != nil {
+		return state.tx.WithContext(ctx)
+	}
+	return connect.WithContext(ctx)
+}
+
+type txCtxKey struct{}
+
+// txState 进行中的事务，afterCommit 在提交后按顺序执行，回滚时丢弃
+type txState struct {
+	mgr         *Mgr
+	tx          *gorm.DB
+	afterCommit []func()
+}
+
+func (d *Mgr) txFromCtx(ctx context.Context) *txState {
+	state, ok := ctx.Value(txCtxKey{}).(*txState)
+	if !ok || state.mgr != d {
+		return nil
+	}
+	return state
+}
+
+// SupportTx 当前驱动是否支持事务，D1 Worker 不支持
+func (d *Mgr) SupportTx() bool {
+	return d.Setting.Driver == DriverSqlite
+}
+
+// Transaction 在一个事务中执行fn，fn中用 GetConnectCtx(ctx) 取到的连接都在事务内，fn返回错误时整体回滚。
+// ctx已经在事务中时直接执行fn。SQLite只有一个连接，fn中不能用其他ctx访问数据库，否则会一直等待。
+// 驱动不支持事务时返回 ErrTxUnsupported
+func (d *Mgr) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if d.txFromCtx(ctx) != nil {
+		return fn(ctx)
+	}
+	if !d.SupportTx() {
+		return ErrTxUnsupported
+	}
+	if d.db == nil {
+		return ErrConnectDbFailed
+	}
+	state := &txState{mgr: d}
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		state.tx = tx
+		return fn(context.WithValue(ctx, txCtxKey{}, state))
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range state.afterCommit {
+		f()
+	}
+	return nil
+}
+
+// AfterCommit ctx在事务中时f在事务提交后执行，回滚时不执行；不在事务中时立即执行
+func (d *Mgr) AfterCommit(ctx context.Context, f func()) {
+	if ctx != nil {
+		if state := d.txFromCtx(ctx); state != nil {
+			state.afterCommit = append(state.afterCommit, f)
+			return
+		}
+	}
+	f()
+}
+
 type ConnectType int
 
 const (
@@ -192,4 +347,10 @@ const (
 	ConnectTypeSubGroup
 	ConnectTypeLibraryNote
 	ConnectTypeLibraryScoreDetail
+	ConnectTypeReminder
+	ConnectTypeHistory
+	ConnectTypeGroupShare
+	ConnectTypeChange
+	ConnectTypeTagMeta
+	ConnectTypeDependency
 )
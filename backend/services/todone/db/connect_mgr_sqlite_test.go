@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/intmian/mian_go_lib/xbi"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func sqliteTestSetting(t *testing.T, path string) Setting {
+	t.Helper()
+	biDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "bi.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open local xbi db: %v", err)
+	}
+	bi, err := xbi.NewXBi(xbi.Setting{Db: biDB, ErrorChan: make(chan error, 16), Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("create xbi: %v", err)
+	}
+	return Setting{
+		Driver:     DriverSqlite,
+		SqlitePath: path,
+		Ctx:        context.Background(),
+		XBi:        bi,
+	}
+}
+
+func TestOpenSqliteDriverMigratesAllTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todone.sqlite")
+	mgr, err := Open(sqliteTestSetting(t, path))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	models := []any{&DirDB{}, &GroupDB{}, &TaskDB{}, &TagsDB{}, &SubGroupDB{}, &LibraryNoteDB{}, &LibraryScoreDetailDB{}}
+	for _, model := range models {
+		if !mgr.GetConnect(ConnectTypeDir).Migrator().HasTable(model) {
+			t.Fatalf("table of %T not migrated", model)
+		}
+	}
+	dir, err := CreateDir(mgr.GetConnect(ConnectTypeDir), "local-test", 0, "title", "content")
+	if err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	if err = mgr.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// 重新打开同一个文件，迁移可重复执行且数据仍在
+	mgr, err = Open(sqliteTestSetting(t, path))
+	if err != nil {
+		t.Fatalf("reopen sqlite: %v", err)
+	}
+	defer mgr.Close()
+	var got DirDB
+	if err = mgr.GetConnect(ConnectTypeDir).Where("id = ?", dir.ID).First(&got).Error; err != nil {
+		t.Fatalf("dir lost after reopen: %v", err)
+	}
+}
+
+func TestOpenRejectsUnknownDriver(t *testing.T) {
+	setting := sqliteTestSetting(t, "")
+	setting.Driver = "mysql"
+	if _, err := Open(setting); !errors.Is(err, ErrUnknownDriver) {
+		t.Fatalf("err = %v", err)
+	}
+	setting.Driver = DriverSqlite
+	if _, err := Open(setting); err == nil {
+		t.Fatal("sqlite driver without path opened")
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaskDependencyDB 任务之间的阻塞关系，BlockerID 完成前 TaskID 处于阻塞状态。两个任务可以在不同的子分组中
+type TaskDependencyDB struct {
+	ID        uint32 `gorm:"primaryKey"`
+	UserID    string `gorm:"not null;index"`
+	TaskID    uint32 `gorm:"not null;uniqueIndex:idx_task_dependency,priority:1"`
+	BlockerID uint32 `gorm:"not null;uniqueIndex:idx_task_dependency,priority:2;index"`
+	CreatedAt time.Time
+}
+
+// TaskBlocker 阻塞某个任务的一项，带阻塞任务当前的状态
+type TaskBlocker struct {
+	TaskID    uint32
+	BlockerID uint32
+	Done      bool
+	Deleted   bool
+}
+
+func CreateTaskDependency(conn *gorm.DB, userID string, taskID, blockerID uint32) error {
+	return conn.Create(&TaskDependencyDB{UserID: userID, TaskID: taskID, BlockerID: blockerID}).Error
+}
+
+// DeleteTaskDependency 返回是否删除了记录
+func DeleteTaskDependency(conn *gorm.DB, userID string, taskID, blockerID uint32) (bool, error) {
+	result := conn.Where("user_id = ? AND task_id = ? AND blocker_id = ?", userID, taskID, blockerID).Delete(&TaskDependencyDB{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetTaskDependencies 用户全部的阻塞关系，用于检查循环
+func GetTaskDependencies(conn *gorm.DB, userID string) ([]TaskDependencyDB, error) {
+	deps := make([]TaskDependencyDB, 0)
+	err := conn.Where("user_id = ?", userID).Find(&deps).Error
+	return deps, err
+}
+
+// GetTaskBlockers 这些任务的阻塞任务，按添加顺序。已经彻底删除的阻塞任务不返回
+func GetTaskBlockers(conn *gorm.DB, taskIDs []uint32) ([]TaskBlocker, error) {
+	res := make([]TaskBlocker, 0)
+	for i := 0; i < len(taskIDs); i += MaxInSize {
+		end := min(i+MaxInSize, len(taskIDs))
+		var rows []TaskBlocker
+		err := conn.Table("task_dependency_dbs AS d").
+			Select("d.task_id AS task_id, d.blocker_id AS blocker_id, t.done AS done, t.deleted AS deleted").
+			Joins("JOIN task_dbs AS t ON t.task_id = d.blocker_id").
+			Where("d.task_id IN ?", taskIDs[i:end]).
+			Order("d.id").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rows...)
+	}
+	return res, nil
+}
+
+// GetBlockedTaskIDs 被这个任务阻塞的任务
+func GetBlockedTaskIDs(conn *gorm.DB, blockerID uint32) ([]uint32, error) {
+	ids := make([]uint32, 0)
+	err := conn.Model(&TaskDependencyDB{}).Where("blocker_id = ?", blockerID).Order("id").Pluck("task_id", &ids).Error
+	return ids, err
+}
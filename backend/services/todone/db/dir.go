@@ -9,6 +9,9 @@ type DirDB struct {
 	Note     string
 	ParentID uint32
 	Index    float32 `gorm:"index"`
+	Deleted  bool
+	// DeletedUnix 删除时间的秒数，回收站按它计算保留期限
+	DeletedUnix int64
 }
 
 func CreateDir(db *gorm.DB, userID string, parentID uint32, title, note string) (*DirDB, error) {
@@ -45,12 +48,17 @@ func ChangeDirParent(db *gorm.DB, dirID, parentID uint32) error {
 	return db.Model(&DirDB{}).Where("id = ?", dirID).Update("parent_id", parentID).Error
 }
 
-func DeleteDir(db *gorm.DB, dirID uint32) error {
+// DeleteDir 放入回收站，由 PurgeDir 真正删除
+func DeleteDir(db *gorm.DB, dirID uint32, deletedUnix int64) error {
+	return db.Model(&DirDB{}).Where("id = ?", dirID).Updates(map[string]interface{}{"deleted": true, "deleted_unix": deletedUnix}).Error
+}
+
+func PurgeDir(db *gorm.DB, dirID uint32) error {
 	return db.Where("id = ?", dirID).Delete(&DirDB{}).Error
 }
 
 func GetDirsByUserID(db *gorm.DB, userID string) ([]DirDB, error) {
 	var dirs []DirDB
-	result := db.Where("user_id = ?", userID).Find(&dirs)
+	result := db.Where("user_id = ? AND deleted = ?", userID, false).Find(&dirs)
 	return dirs, result.Error
 }
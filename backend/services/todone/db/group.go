@@ -1,6 +1,13 @@
 package db
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrGroupRevisionConflict 写入时库中的版本已经不是读取时的版本，期间被其他请求或实例改过
+var ErrGroupRevisionConflict = errors.New("group revision conflict")
 
 type GroupType int
 
@@ -11,22 +18,30 @@ const (
 
 type GroupDB struct {
 	ID        uint32 `gorm:"primaryKey"`
-	UserID    string `gorm:"index"`
+	UserID    string `gorm:"index;uniqueIndex:idx_groups_request,priority:1"`
 	Type      GroupType
 	Title     string
 	Note      string
 	ParentDir uint32
 	Deleted   bool
 	Index     float32 `gorm:"index"`
+	// DeletedUnix 删除时间的秒数，回收站按它计算保留期限
+	DeletedUnix int64
+	// Revision 每次保存加一，客户端修改时带回，不一致说明期间被别处改过
+	Revision uint32 `gorm:"not null;default:1"`
+	// ClientRequestID 客户端创建时带的请求ID，重试时返回已经创建的分组
+	ClientRequestID *string `gorm:"uniqueIndex:idx_groups_request,priority:2"`
 }
 
-func CreateGroup(db *gorm.DB, userID string, title, note string, parentDirID uint32, groupType GroupType) (*GroupDB, error) {
+func CreateGroup(db *gorm.DB, userID string, title, note string, parentDirID uint32, groupType GroupType, requestID *string) (*GroupDB, error) {
 	group := GroupDB{
-		UserID:    userID,
-		Type:      groupType,
-		Title:     title,
-		Note:      note,
-		ParentDir: parentDirID,
+		UserID:          userID,
+		Type:            groupType,
+		Title:           title,
+		Note:            note,
+		ParentDir:       parentDirID,
+		Revision:        1,
+		ClientRequestID: requestID,
 	}
 	result := db.Create(&group)
 	return &group, result.Error
@@ -38,8 +53,33 @@ func GetGroup(db *gorm.DB, groupID uint32) (*GroupDB, error) {
 	return &group, result.Error
 }
 
+// ChangeGroup 整行保存并把Revision加一，只在库中仍是orm读取时的Revision时写入，否则返回 ErrGroupRevisionConflict
 func ChangeGroup(db *gorm.DB, orm *GroupDB) error {
-	return db.Save(orm).Error
+	expected := orm.Revision
+	orm.Revision++
+	result := db.Model(&GroupDB{}).Where("id = ? AND revision = ?", orm.ID, expected).Select("*").Updates(orm)
+	err := result.Error
+	if err == nil && result.RowsAffected != 1 {
+		err = ErrGroupRevisionConflict
+	}
+	if err != nil {
+		orm.Revision = expected
+		return err
+	}
+	return nil
+}
+
+// GetGroupByRequestID 按客户端请求ID查找创建过的分组，包括已经删除的，没有时返回nil
+func GetGroupByRequestID(db *gorm.DB, userID, requestID string) (*GroupDB, error) {
+	var group GroupDB
+	err := db.Where("user_id = ? AND client_request_id = ?", userID, requestID).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
 }
 
 func ChangeGroupTitle(db *gorm.DB, groupID uint32, title string) error {
@@ -50,9 +90,27 @@ func ChangeGroupNote(db *gorm.DB, groupID uint32, note string) error {
 	return db.Model(&GroupDB{}).Where("id = ?", groupID).Update("note", note).Error
 }
 
-func DeleteGroup(db *gorm.DB, groupID uint32) error {
+func DeleteGroup(db *gorm.DB, groupID uint32, deletedUnix int64) error {
 	// 将deleted字段置为true
-	return db.Model(&GroupDB{}).Where("id = ?", groupID).Update("deleted", true).Error
+	return db.Model(&GroupDB{}).Where("id = ?", groupID).Updates(map[string]interface{}{"deleted": true, "deleted_unix": deletedUnix}).Error
+}
+
+// PurgeGroup 连同其下的子分组与任务一起真正删除
+func PurgeGroup(db *gorm.DB, groupID uint32) error {
+	var subGroupIDs []uint32
+	err := db.Model(&SubGroupDB{}).Where("parent_group_id = ?", groupID).Pluck("id", &subGroupIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, subGroupID := range subGroupIDs {
+		if err = PurgeSubGroup(db, subGroupID); err != nil {
+			return err
+		}
+	}
+	if err = db.Where("group_id = ?", groupID).Delete(&GroupShareDB{}).Error; err != nil {
+		return err
+	}
+	return db.Where("id = ?", groupID).Delete(&GroupDB{}).Error
 }
 
 func GetGroupsByUser(db *gorm.DB, userID string) ([]GroupDB, error) {
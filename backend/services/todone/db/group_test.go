@@ -23,7 +23,7 @@ func debugGetConnect(t *testing.T, conType ConnectType) *gorm.DB {
 func TestGroupDB(t *testing.T) {
 	conn := debugGetConnect(t, ConnectTypeGroup)
 
-	group, err := CreateGroup(conn, "worker-test", "debug", "title", 0, GroupTypeNormal)
+	group, err := CreateGroup(conn, "worker-test", "debug", "title", 0, GroupTypeNormal, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
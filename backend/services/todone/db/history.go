@@ -0,0 +1,95 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	HistoryOpCreate = "create"
+	HistoryOpChange = "change"
+	HistoryOpMove   = "move"
+	HistoryOpTag    = "tag"
+	HistoryOpDelete = "delete"
+	HistoryOpUndo   = "undo"
+	HistoryOpBatch  = "batch"
+)
+
+// TaskHistoryDB 任务修改记录，只追加不修改。撤销也是追加一条 undo 记录并在 RevertID 中指向被撤销的记录
+type TaskHistoryDB struct {
+	ID     uint32 `gorm:"primaryKey"`
+	UserID string `gorm:"not null;index"`
+	Op     string `gorm:"not null"`
+	// TaskIDs 涉及的任务，形如 ,1,2, 方便按任务查询
+	TaskIDs string
+	// Before After 操作前后的任务快照json，由logic层定义格式
+	Before    string
+	After     string
+	RevertID  uint32 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// JoinHistoryTaskIDs 把任务ID拼成 TaskIDs 的格式
+func JoinHistoryTaskIDs(taskIDs []uint32) string {
+	var b strings.Builder
+	b.WriteString(",")
+	for _, id := range taskIDs {
+		b.WriteString(strconv.FormatUint(uint64(id), 10))
+		b.WriteString(",")
+	}
+	return b.String()
+}
+
+func CreateTaskHistory(conn *gorm.DB, history *TaskHistoryDB) error {
+	if history.CreatedAt.IsZero() {
+		history.CreatedAt = time.Now()
+	}
+	return conn.Create(history).Error
+}
+
+// GetTaskHistory 按时间倒序分页读取，taskID非0时只看涉及该任务的记录，beforeID非0时只读更早的记录
+func GetTaskHistory(conn *gorm.DB, userID string, taskID uint32, beforeID uint32, limit int) ([]TaskHistoryDB, error) {
+	histories := make([]TaskHistoryDB, 0)
+	conn = conn.Where("user_id = ?", userID)
+	if taskID != 0 {
+		conn = conn.Where("task_ids LIKE ?", "%,"+strconv.FormatUint(uint64(taskID), 10)+",%")
+	}
+	if beforeID != 0 {
+		conn = conn.Where("id < ?", beforeID)
+	}
+	err := conn.Order("id DESC").Limit(limit).Find(&histories).Error
+	return histories, err
+}
+
+// GetRevertedHistoryIDs 给定记录中已经被撤销的部分
+func GetRevertedHistoryIDs(conn *gorm.DB, userID string, ids []uint32) (map[uint32]bool, error) {
+	res := make(map[uint32]bool)
+	for i := 0; i < len(ids); i += MaxInSize {
+		end := i + MaxInSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var reverted []uint32
+		err := conn.Model(&TaskHistoryDB{}).Where("user_id = ? AND op = ? AND revert_id IN ?", userID, HistoryOpUndo, ids[i:end]).
+			Pluck("revert_id", &reverted).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range reverted {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+// GetUndoCandidates 最近的n条还没有被撤销的操作，按时间倒序
+func GetUndoCandidates(conn *gorm.DB, userID string, n int) ([]TaskHistoryDB, error) {
+	histories := make([]TaskHistoryDB, 0)
+	err := conn.Where("user_id = ? AND op <> ?", userID, HistoryOpUndo).
+		Where("id NOT IN (SELECT revert_id FROM task_history_dbs WHERE user_id = ? AND op = ?)", userID, HistoryOpUndo).
+		Order("id DESC").Limit(n).Find(&histories).Error
+	return histories, err
+}
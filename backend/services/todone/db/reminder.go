@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReminderDB 已经推送过的提醒，重启后据此判断不再重复推送
+type ReminderDB struct {
+	ID     uint32 `gorm:"primaryKey"`
+	UserID string `gorm:"not null;uniqueIndex:idx_reminder_key,priority:1"`
+	// TaskID 每日汇总为0
+	TaskID uint32 `gorm:"not null;uniqueIndex:idx_reminder_key,priority:2"`
+	Kind   string `gorm:"not null;uniqueIndex:idx_reminder_key,priority:3"`
+	// DueUnix 推送时任务截止时间的秒数，截止时间修改后会重新提醒；每日汇总为当天0点
+	DueUnix int64 `gorm:"not null;uniqueIndex:idx_reminder_key,priority:4;index"`
+	SentAt  time.Time
+}
+
+// ClaimReminder 推送前先登记，已经登记过时返回false。
+// 只有提醒调度一个写入方，先查后写即可。
+func ClaimReminder(conn *gorm.DB, reminder *ReminderDB) (bool, error) {
+	var existing ReminderDB
+	err := conn.Where("user_id = ? AND task_id = ? AND kind = ? AND due_unix = ?",
+		reminder.UserID, reminder.TaskID, reminder.Kind, reminder.DueUnix).First(&existing).Error
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	if reminder.SentAt.IsZero() {
+		reminder.SentAt = time.Now()
+	}
+	if err = conn.Create(reminder).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseReminder 推送失败时撤销登记，下次扫描重试
+func ReleaseReminder(conn *gorm.DB, reminderID uint32) error {
+	return conn.Where("id = ?", reminderID).Delete(&ReminderDB{}).Error
+}
+
+// PruneReminders 删除截止时间早于before的登记，这些提醒已经不会再触发
+func PruneReminders(conn *gorm.DB, before time.Time) error {
+	return conn.Where("due_unix < ?", before.Unix()).Delete(&ReminderDB{}).Error
+}
+
+// RemindFilter 提醒扫描读取的范围，满足任意一个条件的未完成任务都会读取
+type RemindFilter struct {
+	// EndFrom EndTo 截止时间在 [EndFrom, EndTo] 内，用于截止与逾期提醒
+	EndFrom time.Time
+	EndTo   time.Time
+	// EndBefore 不为零时截止时间早于它的任务也读取，每日汇总用来带上全部逾期与今天截止的任务
+	EndBefore time.Time
+	// Waiting 有等待对象的任务也读取，只有每日汇总需要
+	Waiting bool
+}
+
+// GetOpenTasksForRemind 所有用户未完成、未删除且所在子分组与分组都未删除的任务，只读取提醒用到的列，不含备注。
+// 截止时间用 julianday 比较，不受写入时时区后缀不同的影响
+func GetOpenTasksForRemind(conn *gorm.DB, filter RemindFilter) ([]TaskDB, error) {
+	conds := []string{"(julianday(t.time_end_time) >= julianday(?) AND julianday(t.time_end_time) <= julianday(?))"}
+	args := []any{filter.EndFrom, filter.EndTo}
+	if !filter.EndBefore.IsZero() {
+		// 没有截止时间的任务存的是零值
+		conds = append(conds, "(julianday(t.time_end_time) > julianday(?) AND julianday(t.time_end_time) < julianday(?))")
+		args = append(args, time.Time{}, filter.EndBefore)
+	}
+	if filter.Waiting {
+		conds = append(conds, "t.wait4 != ''")
+	}
+	tasks := make([]TaskDB, 0)
+	err := conn.Table("task_dbs AS t").
+		Select("t.task_id, t.user_id, t.title, t.time_end_time, t.wait4").
+		Joins("JOIN sub_group_dbs AS s ON s.id = t.parent_sub_group_id").
+		Joins("JOIN group_dbs AS g ON g.id = s.parent_group_id").
+		Where("t.done = ? AND t.deleted = ? AND s.deleted = ? AND g.deleted = ?", false, false, false, false).
+		Where(strings.Join(conds, " OR "), args...).
+		Order("t.user_id, t.task_id").
+		Find(&tasks).Error
+	return tasks, err
+}
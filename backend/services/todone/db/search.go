@@ -0,0 +1,433 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 全文检索使用 FTS5 的 trigram 分词，中文不需要分词也能按子串命中；
+// 索引表由触发器跟随业务表更新，业务代码无需关心。
+const (
+	taskFtsTable        = "todone_task_fts"
+	libraryNoteFtsTable = "todone_library_note_fts"
+	// ftsMinKeywordLen trigram 只能匹配不少于3个字符的关键词
+	ftsMinKeywordLen = 3
+	// searchCandidateLimit 单次检索从库中取出的候选上限，排序后再截断
+	searchCandidateLimit = 500
+	snippetRadius        = 20
+)
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+)
+
+var searchIndexDDL = []string{
+	"CREATE VIRTUAL TABLE " + taskFtsTable + " USING fts5(title, note, tags, tokenize='trigram')",
+	"CREATE VIRTUAL TABLE " + libraryNoteFtsTable + " USING fts5(content, tokenize='trigram')",
+	"INSERT INTO " + taskFtsTable + "(rowid, title, note, tags) SELECT t.task_id, t.title, t.note, " +
+		"COALESCE((SELECT group_concat(g.tag, ' ') FROM tags_dbs g WHERE g.task_id = t.task_id), '') FROM task_dbs t",
+	"INSERT INTO " + libraryNoteFtsTable + "(rowid, content) SELECT rowid, content FROM library_notes WHERE deleted_at IS NULL",
+	"CREATE TRIGGER todone_task_fts_ai AFTER INSERT ON task_dbs BEGIN " +
+		"INSERT INTO " + taskFtsTable + "(rowid, title, note, tags) VALUES (new.task_id, new.title, new.note, ''); END",
+	"CREATE TRIGGER todone_task_fts_au AFTER UPDATE OF title, note ON task_dbs BEGIN " +
+		"UPDATE " + taskFtsTable + " SET title = new.title, note = new.note WHERE rowid = new.task_id; END",
+	"CREATE TRIGGER todone_task_fts_ad AFTER DELETE ON task_dbs BEGIN " +
+		"DELETE FROM " + taskFtsTable + " WHERE rowid = old.task_id; END",
+	"CREATE TRIGGER todone_tags_fts_ai AFTER INSERT ON tags_dbs BEGIN " +
+		"UPDATE " + taskFtsTable + " SET tags = (SELECT COALESCE(group_concat(tag, ' '), '') FROM tags_dbs WHERE task_id = new.task_id) WHERE rowid = new.task_id; END",
+	// 标签改名是update
+	"CREATE TRIGGER todone_tags_fts_au AFTER UPDATE OF tag ON tags_dbs BEGIN " +
+		"UPDATE " + taskFtsTable + " SET tags = (SELECT COALESCE(group_concat(tag, ' '), '') FROM tags_dbs WHERE task_id = new.task_id) WHERE rowid = new.task_id; END",
+	"CREATE TRIGGER todone_tags_fts_ad AFTER DELETE ON tags_dbs BEGIN " +
+		"UPDATE " + taskFtsTable + " SET tags = (SELECT COALESCE(group_concat(tag, ' '), '') FROM tags_dbs WHERE task_id = old.task_id) WHERE rowid = old.task_id; END",
+	"CREATE TRIGGER todone_library_note_fts_ai AFTER INSERT ON library_notes BEGIN " +
+		"INSERT INTO " + libraryNoteFtsTable + "(rowid, content) SELECT new.rowid, new.content WHERE new.deleted_at IS NULL; END",
+	// 软删除也是update，先删后按是否删除重新写入
+	"CREATE TRIGGER todone_library_note_fts_au AFTER UPDATE ON library_notes BEGIN " +
+		"DELETE FROM " + libraryNoteFtsTable + " WHERE rowid = old.rowid; " +
+		"INSERT INTO " + libraryNoteFtsTable + "(rowid, content) SELECT new.rowid, new.content WHERE new.deleted_at IS NULL; END",
+	"CREATE TRIGGER todone_library_note_fts_ad AFTER DELETE ON library_notes BEGIN " +
+		"DELETE FROM " + libraryNoteFtsTable + " WHERE rowid = old.rowid; END",
+}
+
+var searchIndexTriggers = []string{
+	"todone_task_fts_ai", "todone_task_fts_au", "todone_task_fts_ad",
+	"todone_tags_fts_ai", "todone_tags_fts_au", "todone_tags_fts_ad",
+	"todone_library_note_fts_ai", "todone_library_note_fts_au", "todone_library_note_fts_ad",
+}
+
+// EnsureSearchIndex 建立全文索引表与同步触发器，返回是否可用。
+// 索引不完整(例如上次建立到一半退出)时整体重建；当前SQLite不支持FTS5或trigram时返回false，检索退化为LIKE。
+func EnsureSearchIndex(conn *gorm.DB) (bool, error) {
+	var count int64
+	names := append([]string{taskFtsTable, libraryNoteFtsTable}, searchIndexTriggers...)
+	err := conn.Raw("SELECT count(*) FROM sqlite_master WHERE name IN ?", names).Scan(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if int(count) == len(names) {
+		return true, nil
+	}
+	if err = dropSearchIndex(conn); err != nil {
+		return false, err
+	}
+	if err = conn.Exec(searchIndexDDL[0]).Error; err != nil {
+		// 没有fts5模块或trigram分词器
+		return false, nil
+	}
+	for _, ddl := range searchIndexDDL[1:] {
+		if err = conn.Exec(ddl).Error; err != nil {
+			return false, errors.Join(err, dropSearchIndex(conn))
+		}
+	}
+	return true, nil
+}
+
+func dropSearchIndex(conn *gorm.DB) error {
+	for _, trigger := range searchIndexTriggers {
+		if err := conn.Exec("DROP TRIGGER IF EXISTS " + trigger).Error; err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{taskFtsTable, libraryNoteFtsTable} {
+		if err := conn.Exec("DROP TABLE IF EXISTS " + table).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchQuery 检索条件，关键词之间是与的关系，筛选条件均作用于任务本身
+type SearchQuery struct {
+	UserID   string
+	Keywords []string
+	Done     *bool
+	TaskType *TaskType
+	Tag      string
+	// SubGroupIDs 非nil时只在这些子分组中查找，用于按分组筛选
+	SubGroupIDs []uint32
+	// CreatedFrom CreatedTo 按创建时间筛选，零值表示不限制
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Limit       int
+}
+
+// SearchHit 一个任务的检索结果，同一任务命中多处时合并为一条
+type SearchHit struct {
+	Task  TaskDB
+	Tags  []string
+	Score float64 // 越大越相关
+
+	MatchTitle bool
+	MatchNote  bool
+	MatchTag   bool
+	// LibraryNoteID 命中娱乐笔记时为得分最高的笔记ID
+	LibraryNoteID string
+	Snippet       string
+}
+
+type libraryNoteCandidate struct {
+	ID      string
+	TaskID  uint32
+	Content string
+	Score   float64
+}
+
+type taskCandidate struct {
+	TaskDB
+	Score float64
+}
+
+// SearchTasks 检索用户的任务标题、备注、标签与娱乐笔记。
+// useFts 为true且关键词都足够长时使用全文索引并按bm25排序，否则使用LIKE并按命中字段加权排序。
+func SearchTasks(conn *gorm.DB, useFts bool, q SearchQuery) ([]SearchHit, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		q.Limit = MaxSearchLimit
+	}
+	if q.SubGroupIDs != nil && len(q.SubGroupIDs) == 0 {
+		return make([]SearchHit, 0), nil
+	}
+	keywords := make([]string, 0, len(q.Keywords))
+	for _, keyword := range q.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	q.Keywords = keywords
+	useFts = useFts && len(keywords) > 0
+	for _, keyword := range keywords {
+		if utf8.RuneCountInString(keyword) < ftsMinKeywordLen {
+			useFts = false
+		}
+	}
+
+	var tasks []taskCandidate
+	var notes []libraryNoteCandidate
+	var err error
+	if useFts {
+		tasks, notes, err = searchByFts(conn, q)
+	} else {
+		tasks, notes, err = searchByLike(conn, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 只命中笔记的任务需要补齐任务数据
+	hitMap := make(map[uint32]*SearchHit)
+	for _, task := range tasks {
+		hitMap[task.TaskID] = &SearchHit{Task: task.TaskDB, Score: task.Score}
+	}
+	var missing []uint32
+	for _, note := range notes {
+		if _, ok := hitMap[note.TaskID]; !ok {
+			missing = append(missing, note.TaskID)
+			hitMap[note.TaskID] = &SearchHit{}
+		}
+	}
+	for i := 0; i < len(missing); i += MaxInSize {
+		end := i + MaxInSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		loaded, err := GetTaskByIds(conn, missing[i:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range loaded {
+			hitMap[task.TaskID].Task = task
+		}
+	}
+	ids := make([]uint32, 0, len(hitMap))
+	for id := range hitMap {
+		ids = append(ids, id)
+	}
+	tags := GetTagsByMultipleTaskID(conn, ids)
+
+	for id, hit := range hitMap {
+		hit.Tags = tags[id]
+		hit.MatchTitle = containsAny(hit.Task.Title, keywords)
+		hit.MatchNote = containsAny(hit.Task.Note, keywords)
+		for _, tag := range hit.Tags {
+			if containsAny(tag, keywords) {
+				hit.MatchTag = true
+			}
+		}
+		if !useFts {
+			hit.Score = likeScore(hit, keywords)
+		}
+		if hit.MatchNote {
+			hit.Snippet = makeSnippet(hit.Task.Note, keywords)
+		}
+	}
+	// 每个任务只保留最相关的一条笔记，笔记的权重低于任务本身
+	bestNote := make(map[uint32]libraryNoteCandidate)
+	for _, note := range notes {
+		if best, ok := bestNote[note.TaskID]; !ok || note.Score > best.Score {
+			bestNote[note.TaskID] = note
+		}
+	}
+	for taskID, note := range bestNote {
+		hit := hitMap[taskID]
+		hit.LibraryNoteID = note.ID
+		hit.Score += note.Score / 2
+		if hit.Snippet == "" {
+			hit.Snippet = makeSnippet(note.Content, keywords)
+		}
+	}
+
+	res := make([]SearchHit, 0, len(hitMap))
+	for _, hit := range hitMap {
+		if hit.Task.TaskID == 0 || hit.Task.Deleted {
+			continue
+		}
+		res = append(res, *hit)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Task.TaskID > res[j].Task.TaskID
+	})
+	if len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+// applySearchFilter 在别名为 t 的任务表上追加筛选条件
+func applySearchFilter(conn *gorm.DB, q SearchQuery) *gorm.DB {
+	conn = conn.Where("t.user_id = ? AND t.deleted = ?", q.UserID, false)
+	if q.Done != nil {
+		conn = conn.Where("t.done = ?", *q.Done)
+	}
+	if q.TaskType != nil {
+		conn = conn.Where("t.task_type = ?", *q.TaskType)
+	}
+	if q.Tag != "" {
+		conn = conn.Where("t.task_id IN (SELECT task_id FROM tags_dbs WHERE user_id = ? AND tag = ?)", q.UserID, q.Tag)
+	}
+	if q.SubGroupIDs != nil {
+		conn = conn.Where("t.parent_sub_group_id IN ?", q.SubGroupIDs)
+	}
+	if !q.CreatedFrom.IsZero() {
+		conn = conn.Where("t.time_created_at >= ?", q.CreatedFrom)
+	}
+	if !q.CreatedTo.IsZero() {
+		conn = conn.Where("t.time_created_at <= ?", q.CreatedTo)
+	}
+	return conn
+}
+
+func searchByFts(conn *gorm.DB, q SearchQuery) ([]taskCandidate, []libraryNoteCandidate, error) {
+	match := ftsMatchExpr(q.Keywords)
+	var tasks []taskCandidate
+	// bm25越小越相关，取反后与LIKE模式一样越大越好；标题权重最高，其次是标签
+	err := applySearchFilter(conn.Table("task_dbs AS t").
+		Select("t.*, -bm25("+taskFtsTable+", 3.0, 1.0, 2.0) AS score").
+		Joins("JOIN "+taskFtsTable+" ON "+taskFtsTable+".rowid = t.task_id").
+		Where(taskFtsTable+" MATCH ?", match), q).
+		Order("score DESC").Limit(searchCandidateLimit).Scan(&tasks).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var notes []libraryNoteCandidate
+	err = applySearchFilter(conn.Table("library_notes AS n").
+		Select("n.id, n.task_id, n.content, -bm25("+libraryNoteFtsTable+") AS score").
+		Joins("JOIN "+libraryNoteFtsTable+" ON "+libraryNoteFtsTable+".rowid = n.rowid").
+		Joins("JOIN task_dbs AS t ON t.task_id = n.task_id").
+		Where(libraryNoteFtsTable+" MATCH ?", match).
+		Where("n.user_id = ? AND n.deleted_at IS NULL", q.UserID), q).
+		Order("score DESC").Limit(searchCandidateLimit).Scan(&notes).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return tasks, notes, nil
+}
+
+func searchByLike(conn *gorm.DB, q SearchQuery) ([]taskCandidate, []libraryNoteCandidate, error) {
+	taskQuery := applySearchFilter(conn.Table("task_dbs AS t").Select("t.*"), q)
+	for _, keyword := range q.Keywords {
+		pattern := likePattern(keyword)
+		taskQuery = taskQuery.Where("(t.title LIKE ? ESCAPE '\\' OR t.note LIKE ? ESCAPE '\\' OR "+
+			"t.task_id IN (SELECT task_id FROM tags_dbs WHERE user_id = ? AND tag LIKE ? ESCAPE '\\'))",
+			pattern, pattern, q.UserID, pattern)
+	}
+	var tasks []taskCandidate
+	err := taskQuery.Order("t.task_id DESC").Limit(searchCandidateLimit).Scan(&tasks).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	// 没有关键词时只是按条件筛选任务
+	if len(q.Keywords) == 0 {
+		return tasks, nil, nil
+	}
+	noteQuery := applySearchFilter(conn.Table("library_notes AS n").
+		Select("n.id, n.task_id, n.content").
+		Joins("JOIN task_dbs AS t ON t.task_id = n.task_id").
+		Where("n.user_id = ? AND n.deleted_at IS NULL", q.UserID), q)
+	for _, keyword := range q.Keywords {
+		noteQuery = noteQuery.Where("n.content LIKE ? ESCAPE '\\'", likePattern(keyword))
+	}
+	var notes []libraryNoteCandidate
+	err = noteQuery.Order("n.updated_at DESC").Limit(searchCandidateLimit).Scan(&notes).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range notes {
+		notes[i].Score = float64(countAll(notes[i].Content, q.Keywords))
+	}
+	return tasks, notes, nil
+}
+
+// likeScore LIKE模式下按命中字段加权：标题3、标签2、备注1
+func likeScore(hit *SearchHit, keywords []string) float64 {
+	score := 3*countAll(hit.Task.Title, keywords) + countAll(hit.Task.Note, keywords)
+	for _, tag := range hit.Tags {
+		score += 2 * countAll(tag, keywords)
+	}
+	return float64(score)
+}
+
+// ftsMatchExpr 每个关键词作为短语，避免用户输入被当作FTS语法
+func ftsMatchExpr(keywords []string) string {
+	parts := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		parts = append(parts, `"`+strings.ReplaceAll(keyword, `"`, `""`)+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+func likePattern(keyword string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(keyword) + "%"
+}
+
+func containsAny(text string, keywords []string) bool {
+	lower := strings.ToLower(text)
+	for _, keyword := range keywords {
+		if strings.Contains(lower, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+func countAll(text string, keywords []string) int {
+	lower := strings.ToLower(text)
+	count := 0
+	for _, keyword := range keywords {
+		count += strings.Count(lower, strings.ToLower(keyword))
+	}
+	return count
+}
+
+// makeSnippet 截取第一个命中关键词前后的片段
+func makeSnippet(text string, keywords []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	pos := -1
+	for _, keyword := range keywords {
+		k := []rune(strings.ToLower(keyword))
+		for i := 0; i+len(k) <= len(lower); i++ {
+			if string(lower[i:i+len(k)]) == string(k) {
+				if pos < 0 || i < pos {
+					pos = i
+				}
+				break
+			}
+		}
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	// 大小写转换可能改变长度，越界时退回开头
+	if len(lower) != len(runes) {
+		pos = 0
+	}
+	begin := pos - snippetRadius
+	if begin < 0 {
+		begin = 0
+	}
+	end := pos + snippetRadius*2
+	if end > len(runes) {
+		end = len(runes)
+	}
+	snippet := string(runes[begin:end])
+	if begin > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(runes) {
+		snippet += "..."
+	}
+	return snippet
+}
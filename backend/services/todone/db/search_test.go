@@ -0,0 +1,134 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSearchTestMgr(t *testing.T) *Mgr {
+	t.Helper()
+	mgr, err := Open(sqliteTestSetting(t, filepath.Join(t.TempDir(), "todone.sqlite")))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = mgr.Close()
+	})
+	conn := mgr.GetConnect(ConnectTypeTask)
+	create := func(userID string, subGroupID uint32, title, note string) *TaskDB {
+		task, err := CreateTask(conn, userID, subGroupID, 0, title, note, false, TaskTypeTodo, nil)
+		if err != nil {
+			t.Fatalf("create task: %v", err)
+		}
+		return task
+	}
+	milk := create("u1", 1, "买牛奶和面包", "去超市")
+	create("u1", 1, "写周报", "总结本周的牛奶项目进展")
+	done := create("u1", 2, "Read paper", "about milk tea")
+	done.Done = true
+	if err = UpdateTask(conn, done); err != nil {
+		t.Fatalf("update task: %v", err)
+	}
+	create("u2", 3, "买牛奶", "other user")
+	if err = AddTags(conn, "u1", milk.TaskID, "购物清单"); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+	movie := create("u1", 2, "星际穿越", "")
+	_, err = CreateLibraryNote(conn, &LibraryNoteDB{
+		ID: "note-1", UserID: "u1", TaskID: movie.TaskID, RoundID: "r1", EventTime: time.Now(),
+		Content: "第二遍看，黑洞的画面依旧震撼", Revision: 1,
+	})
+	if err != nil {
+		t.Fatalf("create note: %v", err)
+	}
+	return mgr
+}
+
+func searchTitles(t *testing.T, mgr *Mgr, useFts bool, q SearchQuery) []string {
+	t.Helper()
+	q.UserID = "u1"
+	hits, err := SearchTasks(mgr.GetConnect(ConnectTypeTask), useFts, q)
+	if err != nil {
+		t.Fatalf("search %v: %v", q.Keywords, err)
+	}
+	titles := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		titles = append(titles, hit.Task.Title)
+	}
+	return titles
+}
+
+func testSearchTasks(t *testing.T, mgr *Mgr, useFts bool) {
+	// 标题命中排在只命中备注的任务前面，其他用户的任务不可见
+	got := searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"牛奶"}})
+	if len(got) != 2 || got[0] != "买牛奶和面包" || got[1] != "写周报" {
+		t.Fatalf("search 牛奶 = %v", got)
+	}
+	got = searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"购物清单"}})
+	if len(got) != 1 || got[0] != "买牛奶和面包" {
+		t.Fatalf("search tag = %v", got)
+	}
+	got = searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"黑洞的画面"}})
+	if len(got) != 1 || got[0] != "星际穿越" {
+		t.Fatalf("search library note = %v", got)
+	}
+	// 英文不区分大小写，多个关键词需要同时命中
+	got = searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"MILK", "paper"}})
+	if len(got) != 1 || got[0] != "Read paper" {
+		t.Fatalf("search MILK paper = %v", got)
+	}
+
+	notDone := false
+	got = searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"milk"}, Done: &notDone})
+	if len(got) != 0 {
+		t.Fatalf("done filter = %v", got)
+	}
+	got = searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"牛奶"}, SubGroupIDs: []uint32{2}})
+	if len(got) != 0 {
+		t.Fatalf("sub group filter = %v", got)
+	}
+	got = searchTitles(t, mgr, useFts, SearchQuery{Tag: "购物清单"})
+	if len(got) != 1 {
+		t.Fatalf("tag only filter = %v", got)
+	}
+	got = searchTitles(t, mgr, useFts, SearchQuery{Keywords: []string{"牛奶"}, CreatedFrom: time.Now().Add(time.Hour)})
+	if len(got) != 0 {
+		t.Fatalf("created filter = %v", got)
+	}
+}
+
+func TestSearchTasksLike(t *testing.T) {
+	testSearchTasks(t, newSearchTestMgr(t), false)
+}
+
+func TestSearchTasksFts(t *testing.T) {
+	mgr := newSearchTestMgr(t)
+	if !mgr.SearchFts() {
+		t.Skip("sqlite driver built without fts5, run with -tags sqlite_fts5")
+	}
+	testSearchTasks(t, mgr, true)
+
+	// 触发器保持索引同步
+	conn := mgr.GetConnect(ConnectTypeTask)
+	if err := DeleteTag(conn, 1, "购物清单"); err != nil {
+		t.Fatalf("delete tag: %v", err)
+	}
+	if got := searchTitles(t, mgr, true, SearchQuery{Keywords: []string{"购物清单"}}); len(got) != 0 {
+		t.Fatalf("deleted tag still indexed: %v", got)
+	}
+	if err := DeleteLibraryNote(conn, "u1", 5, "note-1", 1); err != nil {
+		t.Fatalf("delete note: %v", err)
+	}
+	if got := searchTitles(t, mgr, true, SearchQuery{Keywords: []string{"黑洞的画面"}}); len(got) != 0 {
+		t.Fatalf("deleted note still indexed: %v", got)
+	}
+}
+
+func TestMakeSnippet(t *testing.T) {
+	text := "0123456789012345678901234567890123456789关键词0123456789012345678901234567890123456789"
+	got := makeSnippet(text, []string{"关键词"})
+	if got != "..."+text[20:40]+"关键词"+text[49:86]+"..." {
+		t.Fatalf("snippet = %q", got)
+	}
+}
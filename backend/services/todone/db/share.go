@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GroupShareDB 分组分享给其他账号的记录，一个分组对一个账号只有一条
+type GroupShareDB struct {
+	ID        uint32 `gorm:"primaryKey"`
+	GroupID   uint32 `gorm:"not null;uniqueIndex:idx_group_share,priority:1"`
+	GranteeID string `gorm:"not null;uniqueIndex:idx_group_share,priority:2;index"`
+	OwnerID   string `gorm:"not null;index"`
+	Role      string `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// GetGroupShare 没有分享时返回nil
+func GetGroupShare(conn *gorm.DB, groupID uint32, granteeID string) (*GroupShareDB, error) {
+	var share GroupShareDB
+	err := conn.Where("group_id = ? AND grantee_id = ?", groupID, granteeID).First(&share).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// SaveGroupShare 已经分享过时只修改角色
+func SaveGroupShare(conn *gorm.DB, share *GroupShareDB) error {
+	existing, err := GetGroupShare(conn, share.GroupID, share.GranteeID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return conn.Create(share).Error
+	}
+	existing.Role = share.Role
+	existing.OwnerID = share.OwnerID
+	*share = *existing
+	return conn.Save(share).Error
+}
+
+func DeleteGroupShare(conn *gorm.DB, groupID uint32, granteeID string) error {
+	return conn.Where("group_id = ? AND grantee_id = ?", groupID, granteeID).Delete(&GroupShareDB{}).Error
+}
+
+// GetSharesByGroup 分组的全部分享，按分享时间排序
+func GetSharesByGroup(conn *gorm.DB, groupID uint32) ([]GroupShareDB, error) {
+	shares := make([]GroupShareDB, 0)
+	err := conn.Where("group_id = ?", groupID).Order("id").Find(&shares).Error
+	return shares, err
+}
+
+// GetSharesByGrantee 分享给该账号的全部分组，按分享时间排序
+func GetSharesByGrantee(conn *gorm.DB, granteeID string) ([]GroupShareDB, error) {
+	shares := make([]GroupShareDB, 0)
+	err := conn.Where("grantee_id = ?", granteeID).Order("id").Find(&shares).Error
+	return shares, err
+}
@@ -1,23 +1,39 @@
 package db
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrSubGroupRevisionConflict 写入时库中的版本已经不是读取时的版本，期间被其他请求或实例改过
+var ErrSubGroupRevisionConflict = errors.New("sub group revision conflict")
 
 type SubGroupDB struct {
 	ID            uint32 `gorm:"primaryKey"`
-	ParentGroupID uint32 `gorm:"index"`
+	ParentGroupID uint32 `gorm:"index;uniqueIndex:idx_sub_groups_request,priority:1"`
 	Title         string
 	Note          string
 	Index         float32 `gorm:"index"`
 	TaskSequence  string
+	Deleted       bool
+	// DeletedUnix 删除时间的秒数，回收站按它计算保留期限
+	DeletedUnix int64
+	// Revision 标题与备注每次修改加一，任务顺序的变化不算
+	Revision uint32 `gorm:"not null;default:1"`
+	// ClientRequestID 客户端创建时带的请求ID，重试时返回已经创建的子分组
+	ClientRequestID *string `gorm:"uniqueIndex:idx_sub_groups_request,priority:2"`
 }
 
-func CreateSubGroup(db *gorm.DB, parentGroupID uint32, title, note string, index float32, TaskSequence string) (uint32, error) {
+func CreateSubGroup(db *gorm.DB, parentGroupID uint32, title, note string, index float32, TaskSequence string, requestID *string) (uint32, error) {
 	subGroup := SubGroupDB{
-		ParentGroupID: parentGroupID,
-		Title:         title,
-		Note:          note,
-		Index:         index,
-		TaskSequence:  TaskSequence,
+		ParentGroupID:   parentGroupID,
+		Title:           title,
+		Note:            note,
+		Index:           index,
+		TaskSequence:    TaskSequence,
+		Revision:        1,
+		ClientRequestID: requestID,
 	}
 	result := db.Create(&subGroup)
 	return subGroup.ID, result.Error
@@ -26,29 +42,94 @@ func CreateSubGroup(db *gorm.DB, parentGroupID uint32, title, note string, index
 func GetSubGroupByParentSortByIndex(db *gorm.DB, parentGroupID uint32) []*SubGroupDB {
 	var subGroups []*SubGroupDB
 	subGroups = make([]*SubGroupDB, 0)
-	db.Where("parent_group_id = ?", parentGroupID).Order("`Index`").Find(&subGroups)
+	db.Where("parent_group_id = ? AND deleted = ?", parentGroupID, false).Order("`Index`").Find(&subGroups)
 	return subGroups
 }
 
-func DeleteSubGroup(db *gorm.DB, subGroupID uint32) error {
-	return db.Where("id = ?", subGroupID).Delete(&SubGroupDB{}).Error
+// DeleteSubGroup 放入回收站，其下的任务保持原样，恢复时一起回来
+func DeleteSubGroup(db *gorm.DB, subGroupID uint32, deletedUnix int64) error {
+	return db.Model(&SubGroupDB{}).Where("id = ?", subGroupID).Updates(map[string]interface{}{"deleted": true, "deleted_unix": deletedUnix}).Error
+}
+
+// RestoreSubGroup 从回收站恢复到指定分组
+func RestoreSubGroup(db *gorm.DB, subGroupID, parentGroupID uint32, index float32) error {
+	return db.Model(&SubGroupDB{}).Where("id = ?", subGroupID).Updates(map[string]interface{}{
+		"deleted":         false,
+		"deleted_unix":    0,
+		"parent_group_id": parentGroupID,
+		"index":           index,
+	}).Error
 }
 
 func DeleteSubGroupByParentGroupID(db *gorm.DB, parentGroupID uint32) error {
 	return db.Where("parent_group_id = ?", parentGroupID).Delete(&SubGroupDB{}).Error
 }
 
+// PurgeSubGroup 连同其下的任务一起真正删除
+func PurgeSubGroup(db *gorm.DB, subGroupID uint32) error {
+	var taskIDs []uint32
+	err := db.Model(&TaskDB{}).Where("parent_sub_group_id = ?", subGroupID).Pluck("task_id", &taskIDs).Error
+	if err != nil {
+		return err
+	}
+	if err = PurgeTasks(db, taskIDs); err != nil {
+		return err
+	}
+	return db.Where("id = ?", subGroupID).Delete(&SubGroupDB{}).Error
+}
+
 func GetParentGroupIDMaxIndex(db *gorm.DB, parentGroupID uint32) float32 {
 	var maxIndex float32
 	db.Model(&SubGroupDB{}).Where("parent_group_id = ?", parentGroupID).Select("max(index)").Scan(&maxIndex)
 	return maxIndex
 }
 
-func UpdateSubGroup(db *gorm.DB, subGroupID uint32, title, note string, index float32, taskSequence string) error {
-	return db.Model(&SubGroupDB{}).Where("id = ?", subGroupID).Updates(SubGroupDB{
-		Title:        title,
-		Note:         note,
-		Index:        index,
-		TaskSequence: taskSequence,
-	}).Error
+// UpdateSubGroup 修改标题与备注并把Revision加一，只在库中仍是expectedRevision时写入，否则返回 ErrSubGroupRevisionConflict
+func UpdateSubGroup(db *gorm.DB, subGroupID uint32, expectedRevision uint32, title, note string) error {
+	result := db.Model(&SubGroupDB{}).Where("id = ? AND revision = ?", subGroupID, expectedRevision).Updates(map[string]any{
+		"title":    title,
+		"note":     note,
+		"revision": gorm.Expr("revision + 1"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrSubGroupRevisionConflict
+	}
+	return nil
+}
+
+// UpdateSubGroupTaskSequence 保存任务顺序，顺序的变化不算版本
+func UpdateSubGroupTaskSequence(db *gorm.DB, subGroupID uint32, taskSequence string) error {
+	return db.Model(&SubGroupDB{}).Where("id = ?", subGroupID).Update("task_sequence", taskSequence).Error
+}
+
+// GetSubGroupByRequestID 按客户端请求ID查找分组下创建过的子分组，包括已经删除的，没有时返回nil
+func GetSubGroupByRequestID(db *gorm.DB, parentGroupID uint32, requestID string) (*SubGroupDB, error) {
+	var subGroup SubGroupDB
+	err := db.Where("parent_group_id = ? AND client_request_id = ?", parentGroupID, requestID).First(&subGroup).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subGroup, nil
+}
+
+func GetSubGroupsByIDs(db *gorm.DB, subGroupIDs []uint32) ([]SubGroupDB, error) {
+	res := make([]SubGroupDB, 0, len(subGroupIDs))
+	for i := 0; i < len(subGroupIDs); i += MaxInSize {
+		end := i + MaxInSize
+		if end > len(subGroupIDs) {
+			end = len(subGroupIDs)
+		}
+		var subGroups []SubGroupDB
+		if err := db.Where("id IN ?", subGroupIDs[i:end]).Find(&subGroups).Error; err != nil {
+			return nil, err
+		}
+		res = append(res, subGroups...)
+	}
+	return res, nil
 }
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TagMetaDB 标签的展示信息，一个用户的一个标签只有一条，没有记录时按默认样式展示
+type TagMetaDB struct {
+	ID        uint32 `gorm:"primaryKey"`
+	UserID    string `gorm:"not null;uniqueIndex:idx_tag_meta,priority:1"`
+	Tag       string `gorm:"not null;uniqueIndex:idx_tag_meta,priority:2"`
+	Color     string
+	Pinned    bool
+	UpdatedAt time.Time
+}
+
+func GetTagMetas(conn *gorm.DB, userID string) ([]TagMetaDB, error) {
+	metas := make([]TagMetaDB, 0)
+	err := conn.Where("user_id = ?", userID).Find(&metas).Error
+	return metas, err
+}
+
+// GetTagMeta 没有设置过时返回nil
+func GetTagMeta(conn *gorm.DB, userID, tag string) (*TagMetaDB, error) {
+	var meta TagMetaDB
+	err := conn.Where("user_id = ? AND tag = ?", userID, tag).First(&meta).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// SaveTagMeta 已经有记录时只修改颜色与置顶
+func SaveTagMeta(conn *gorm.DB, meta *TagMetaDB) error {
+	existing, err := GetTagMeta(conn, meta.UserID, meta.Tag)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return conn.Create(meta).Error
+	}
+	existing.Color = meta.Color
+	existing.Pinned = meta.Pinned
+	*meta = *existing
+	return conn.Save(meta).Error
+}
+
+// RenameTagMeta 标签改名时带上展示信息，目标标签已经有展示信息时保留目标的
+func RenameTagMeta(conn *gorm.DB, userID, from, to string) error {
+	target, err := GetTagMeta(conn, userID, to)
+	if err != nil {
+		return err
+	}
+	if target != nil {
+		return DeleteTagMeta(conn, userID, from)
+	}
+	return conn.Model(&TagMetaDB{}).Where("user_id = ? AND tag = ?", userID, from).Update("tag", to).Error
+}
+
+func DeleteTagMeta(conn *gorm.DB, userID, tag string) error {
+	return conn.Where("user_id = ? AND tag = ?", userID, tag).Delete(&TagMetaDB{}).Error
+}
@@ -77,3 +77,40 @@ func DeleteTag(db *gorm.DB, taskID uint32, tag string) error {
 func DeleteTagByTaskID(db *gorm.DB, taskID uint32) error {
 	return db.Where("task_id = ?", taskID).Delete(&TagsDB{}).Error
 }
+
+// TagCount 标签与使用它的任务数，回收站中的任务不计入
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+func GetTagCounts(db *gorm.DB, userID string) ([]TagCount, error) {
+	res := make([]TagCount, 0)
+	err := db.Table("tags_dbs AS g").Select("g.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN task_dbs AS t ON t.task_id = g.task_id").
+		Where("g.user_id = ? AND t.deleted = ?", userID, false).
+		Group("g.tag").Scan(&res).Error
+	return res, err
+}
+
+// GetTaskIDsByTags 带有任一标签的任务，包括回收站中的
+func GetTaskIDsByTags(db *gorm.DB, userID string, tags []string) ([]uint32, error) {
+	ids := make([]uint32, 0)
+	err := db.Model(&TagsDB{}).Where("user_id = ? AND tag IN ?", userID, tags).Distinct().Pluck("task_id", &ids).Error
+	return ids, err
+}
+
+// RenameTag 把用户所有任务上的from改为to，已经带有to的任务直接去掉from，不会出现重复的标签
+func RenameTag(db *gorm.DB, userID, from, to string) error {
+	err := db.Exec("DELETE FROM tags_dbs WHERE user_id = ? AND tag = ? AND task_id IN "+
+		"(SELECT task_id FROM tags_dbs WHERE user_id = ? AND tag = ?)", userID, from, userID, to).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&TagsDB{}).Where("user_id = ? AND tag = ?", userID, from).Update("tag", to).Error
+}
+
+// DeleteTagByUser 从用户所有任务上去掉该标签
+func DeleteTagByUser(db *gorm.DB, userID, tag string) error {
+	return db.Where("user_id = ? AND tag = ?", userID, tag).Delete(&TagsDB{}).Error
+}
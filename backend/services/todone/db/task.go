@@ -1,10 +1,14 @@
 package db
 
 import (
+	"errors"
 	"gorm.io/gorm"
 	"time"
 )
 
+// ErrTaskRevisionConflict 写入时库中的版本已经不是读取时的版本，期间被其他请求或实例改过
+var ErrTaskRevisionConflict = errors.New("task revision conflict")
+
 type TaskType int
 
 const (
@@ -13,16 +17,18 @@ const (
 )
 
 type TaskDB struct {
-	UserID           string `gorm:"index"`
+	UserID           string `gorm:"index;uniqueIndex:idx_tasks_request,priority:1"`
 	TaskID           uint32 `gorm:"primaryKey"`
 	Title            string
 	Note             string
 	ParentSubGroupID uint32 `gorm:"index"`
 	ParentTaskID     uint32
 	Deleted          bool
-	Done             bool
-	CreatedAt        time.Time `gorm:"column:time_created_at"`
-	UpdatedAt        time.Time `gorm:"column:time_updated_at"`
+	// DeletedUnix 删除时间的秒数，同一次删除的任务相同，恢复时据此把一起删除的子任务带回来
+	DeletedUnix int64
+	Done        bool
+	CreatedAt   time.Time `gorm:"column:time_created_at"`
+	UpdatedAt   time.Time `gorm:"column:time_updated_at"`
 
 	// 额外信息
 	TaskType TaskType
@@ -32,9 +38,19 @@ type TaskDB struct {
 	// 结束时间或者截止时间
 	EndTime time.Time `gorm:"column:time_end_time"`
 	Wait4   string
+
+	// Repeat 重复规则的json，空表示不重复
+	Repeat string
+	// RepeatNextID 已经生成的下一次任务，防止反复勾选完成时重复生成
+	RepeatNextID uint32
+
+	// Revision 每次保存加一，客户端修改时带回，不一致说明期间被别处改过
+	Revision uint32 `gorm:"not null;default:1"`
+	// ClientRequestID 客户端创建时带的请求ID，重试时返回已经创建的任务
+	ClientRequestID *string `gorm:"uniqueIndex:idx_tasks_request,priority:2"`
 }
 
-func CreateTask(db *gorm.DB, userID string, parentSubGroupID, parentTaskID uint32, title, note string, started bool, taskType TaskType) (*TaskDB, error) {
+func CreateTask(db *gorm.DB, userID string, parentSubGroupID, parentTaskID uint32, title, note string, started bool, taskType TaskType, requestID *string) (*TaskDB, error) {
 	task := TaskDB{
 		UserID:           userID,
 		ParentSubGroupID: parentSubGroupID,
@@ -47,14 +63,43 @@ func CreateTask(db *gorm.DB, userID string, parentSubGroupID, parentTaskID uint3
 		TaskType:         taskType,
 		CreatedAt:        time.Now(),
 		UpdatedAt:        time.Now(),
+		Revision:         1,
+		ClientRequestID:  requestID,
 	}
 	result := db.Create(&task)
 	return &task, result.Error
 }
 
+// UpdateTask 整行保存并把Revision加一，只在库中仍是task读取时的Revision时写入，否则返回 ErrTaskRevisionConflict
 func UpdateTask(db *gorm.DB, task *TaskDB) error {
+	expected := task.Revision
+	updatedAt := task.UpdatedAt
 	task.UpdatedAt = time.Now()
-	return db.Save(task).Error
+	task.Revision++
+	result := db.Model(&TaskDB{}).Where("task_id = ? AND revision = ?", task.TaskID, expected).Select("*").Updates(task)
+	err := result.Error
+	if err == nil && result.RowsAffected != 1 {
+		err = ErrTaskRevisionConflict
+	}
+	if err != nil {
+		task.Revision = expected
+		task.UpdatedAt = updatedAt
+		return err
+	}
+	return nil
+}
+
+// GetTaskByRequestID 按客户端请求ID查找创建过的任务，包括已经删除的，没有时返回nil
+func GetTaskByRequestID(db *gorm.DB, userID, requestID string) (*TaskDB, error) {
+	var task TaskDB
+	err := db.Where("user_id = ? AND client_request_id = ?", userID, requestID).First(&task).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
 }
 
 func GetTaskByID(db *gorm.DB, taskID uint32) (*TaskDB, error) {
@@ -156,5 +201,35 @@ func UpdateTasksParentTaskID(db *gorm.DB, parentTaskID uint32, taskIDs []uint32)
 }
 
 func UpdateTasksSubGroupID(db *gorm.DB, subGroupID uint32, taskIDs []uint32) error {
-	return db.Model(&TaskDB{}).Where("task_id in (?)", taskIDs).Update("parent_sub_group_id", subGroupID).Error
+	return db.Model(&TaskDB{}).Where("task_id in (?)", taskIDs).Updates(map[string]interface{}{
+		"parent_sub_group_id": subGroupID,
+		"revision":            gorm.Expr("revision + 1"),
+	}).Error
+}
+
+// PurgeTasks 真正删除任务以及它们的标签、笔记、评分明细与阻塞关系
+func PurgeTasks(db *gorm.DB, taskIDs []uint32) error {
+	for i := 0; i < len(taskIDs); i += MaxInSize {
+		end := i + MaxInSize
+		if end > len(taskIDs) {
+			end = len(taskIDs)
+		}
+		ids := taskIDs[i:end]
+		if err := db.Where("task_id IN ?", ids).Delete(&TagsDB{}).Error; err != nil {
+			return err
+		}
+		if err := db.Unscoped().Where("task_id IN ?", ids).Delete(&LibraryNoteDB{}).Error; err != nil {
+			return err
+		}
+		if err := db.Unscoped().Where("task_id IN ?", ids).Delete(&LibraryScoreDetailDB{}).Error; err != nil {
+			return err
+		}
+		if err := db.Where("task_id IN ? OR blocker_id IN ?", ids, ids).Delete(&TaskDependencyDB{}).Error; err != nil {
+			return err
+		}
+		if err := db.Where("task_id IN ?", ids).Delete(&TaskDB{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+func GetDeletedDirs(conn *gorm.DB, userID string) ([]DirDB, error) {
+	dirs := make([]DirDB, 0)
+	err := conn.Where("user_id = ? AND deleted = ?", userID, true).Find(&dirs).Error
+	return dirs, err
+}
+
+func GetDeletedGroups(conn *gorm.DB, userID string) ([]GroupDB, error) {
+	groups := make([]GroupDB, 0)
+	err := conn.Where("user_id = ? AND deleted = ?", userID, true).Find(&groups).Error
+	return groups, err
+}
+
+// GetDeletedSubGroups 子分组没有用户字段，通过所属分组筛选，所属分组已经删除的也会返回
+func GetDeletedSubGroups(conn *gorm.DB, userID string) ([]SubGroupDB, error) {
+	subGroups := make([]SubGroupDB, 0)
+	err := conn.Table("sub_group_dbs AS s").Select("s.*").
+		Joins("JOIN group_dbs AS g ON g.id = s.parent_group_id").
+		Where("g.user_id = ? AND s.deleted = ?", userID, true).
+		Find(&subGroups).Error
+	return subGroups, err
+}
+
+func GetDeletedTasks(conn *gorm.DB, userID string) ([]TaskDB, error) {
+	tasks := make([]TaskDB, 0)
+	err := conn.Where("user_id = ? AND deleted = ?", userID, true).Find(&tasks).Error
+	return tasks, err
+}
+
+// GetTaskDescendants 任务的全部子孙任务，不论是否删除
+func GetTaskDescendants(conn *gorm.DB, taskID uint32) ([]TaskDB, error) {
+	res := make([]TaskDB, 0)
+	parents := []uint32{taskID}
+	for len(parents) > 0 {
+		var children []TaskDB
+		for i := 0; i < len(parents); i += MaxInSize {
+			end := i + MaxInSize
+			if end > len(parents) {
+				end = len(parents)
+			}
+			var part []TaskDB
+			if err := conn.Where("parent_task_id IN ?", parents[i:end]).Find(&part).Error; err != nil {
+				return nil, err
+			}
+			children = append(children, part...)
+		}
+		parents = parents[:0]
+		for _, child := range children {
+			parents = append(parents, child.TaskID)
+		}
+		res = append(res, children...)
+	}
+	return res, nil
+}
+
+// StampTrash 给没有删除时间的旧数据补上当前时间，让它们从现在开始计算保留期限
+func StampTrash(conn *gorm.DB, nowUnix int64) error {
+	for _, model := range []interface{}{&DirDB{}, &GroupDB{}, &SubGroupDB{}, &TaskDB{}} {
+		err := conn.Model(model).Where("deleted = ? AND deleted_unix = ?", true, 0).Update("deleted_unix", nowUnix).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetExpiredTrashUsers 回收站中有早于before删除的内容的用户
+func GetExpiredTrashUsers(conn *gorm.DB, beforeUnix int64) ([]string, error) {
+	set := make(map[string]bool)
+	var res []string
+	add := func(userIDs []string) {
+		for _, userID := range userIDs {
+			if !set[userID] {
+				set[userID] = true
+				res = append(res, userID)
+			}
+		}
+	}
+	for _, model := range []interface{}{&DirDB{}, &GroupDB{}, &TaskDB{}} {
+		var userIDs []string
+		err := conn.Model(model).Where("deleted = ? AND deleted_unix < ?", true, beforeUnix).Distinct().Pluck("user_id", &userIDs).Error
+		if err != nil {
+			return nil, err
+		}
+		add(userIDs)
+	}
+	var userIDs []string
+	err := conn.Table("sub_group_dbs AS s").
+		Joins("JOIN group_dbs AS g ON g.id = s.parent_group_id").
+		Where("s.deleted = ? AND s.deleted_unix < ?", true, beforeUnix).
+		Distinct().Pluck("g.user_id", &userIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	add(userIDs)
+	return res, nil
+}
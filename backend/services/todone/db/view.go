@@ -0,0 +1,36 @@
+package db
+
+import "gorm.io/gorm"
+
+// ViewQuery 视图中能交给数据库的筛选条件，截止时间在内存中筛选
+type ViewQuery struct {
+	UserID      string
+	ContainDone bool
+	Started     bool
+	Waiting     bool
+	// Tags 需要同时带有这些标签
+	Tags []string
+}
+
+// GetViewTasks 用户未删除且所在子分组与分组都未删除的任务，目录与父任务是否删除由调用方判断
+func GetViewTasks(conn *gorm.DB, q ViewQuery) ([]TaskDB, error) {
+	conn = conn.Table("task_dbs AS t").Select("t.*").
+		Joins("JOIN sub_group_dbs AS s ON s.id = t.parent_sub_group_id").
+		Joins("JOIN group_dbs AS g ON g.id = s.parent_group_id").
+		Where("t.user_id = ? AND t.deleted = ? AND s.deleted = ? AND g.deleted = ?", q.UserID, false, false, false)
+	if !q.ContainDone {
+		conn = conn.Where("t.done = ?", false)
+	}
+	if q.Started {
+		conn = conn.Where("t.started = ?", true)
+	}
+	if q.Waiting {
+		conn = conn.Where("t.wait4 <> ?", "")
+	}
+	for _, tag := range q.Tags {
+		conn = conn.Where("t.task_id IN (SELECT task_id FROM tags_dbs WHERE user_id = ? AND tag = ?)", q.UserID, tag)
+	}
+	tasks := make([]TaskDB, 0)
+	err := conn.Order("t.task_id").Find(&tasks).Error
+	return tasks, err
+}
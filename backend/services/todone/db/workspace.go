@@ -0,0 +1,67 @@
+package db
+
+import "gorm.io/gorm"
+
+// 导入工作区时按导出的内容原样写入，ID由数据库重新分配
+
+func ImportDir(conn *gorm.DB, dir *DirDB) error {
+	dir.ID = 0
+	return conn.Create(dir).Error
+}
+
+func ImportGroup(conn *gorm.DB, group *GroupDB) error {
+	group.ID = 0
+	return conn.Create(group).Error
+}
+
+func ImportSubGroup(conn *gorm.DB, subGroup *SubGroupDB) error {
+	subGroup.ID = 0
+	return conn.Create(subGroup).Error
+}
+
+func ImportTask(conn *gorm.DB, task *TaskDB) error {
+	task.TaskID = 0
+	return conn.Create(task).Error
+}
+
+func ImportLibraryNote(conn *gorm.DB, note *LibraryNoteDB) error {
+	return conn.Create(note).Error
+}
+
+func ImportLibraryScoreDetail(conn *gorm.DB, detail *LibraryScoreDetailDB) error {
+	return conn.Create(detail).Error
+}
+
+// GetLibraryNotesByTaskIDs 这些任务全部未删除的笔记
+func GetLibraryNotesByTaskIDs(conn *gorm.DB, userID string, taskIDs []uint32) ([]LibraryNoteDB, error) {
+	res := make([]LibraryNoteDB, 0)
+	for i := 0; i < len(taskIDs); i += MaxInSize {
+		end := i + MaxInSize
+		if end > len(taskIDs) {
+			end = len(taskIDs)
+		}
+		var notes []LibraryNoteDB
+		if err := conn.Where("user_id = ? AND task_id IN ?", userID, taskIDs[i:end]).Order("event_time, id").Find(&notes).Error; err != nil {
+			return nil, err
+		}
+		res = append(res, notes...)
+	}
+	return res, nil
+}
+
+// GetLibraryScoreDetailsByTaskIDs 这些任务全部未删除的评分明细
+func GetLibraryScoreDetailsByTaskIDs(conn *gorm.DB, userID string, taskIDs []uint32) ([]LibraryScoreDetailDB, error) {
+	res := make([]LibraryScoreDetailDB, 0)
+	for i := 0; i < len(taskIDs); i += MaxInSize {
+		end := i + MaxInSize
+		if end > len(taskIDs) {
+			end = len(taskIDs)
+		}
+		var details []LibraryScoreDetailDB
+		if err := conn.Where("user_id = ? AND task_id IN ?", userID, taskIDs[i:end]).Order("id").Find(&details).Error; err != nil {
+			return nil, err
+		}
+		res = append(res, details...)
+	}
+	return res, nil
+}
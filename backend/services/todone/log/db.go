@@ -5,6 +5,7 @@ type DbLog struct {
 	Rows     int64
 	Duration int64
 	Err      string
+	ReqID    string // 触发该sql的请求ID，ctx中没有时为空
 }
 
 type DbLogEntity struct {
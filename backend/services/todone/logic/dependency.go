@@ -0,0 +1,162 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+var (
+	ErrTaskDependencySelf     = errors.New("task can not block itself")
+	ErrTaskDependencyCycle    = errors.New("task dependency cycle")
+	ErrTaskDependencyExists   = errors.New("task dependency already exists")
+	ErrTaskDependencyNotExist = errors.New("task dependency not exist")
+)
+
+// AddTaskBlocker taskID 在 blockerID 完成前处于阻塞状态。两个任务都需要是自己的未删除任务，不能形成循环
+func (u *UserLogic) AddTaskBlocker(ctx context.Context, taskID, blockerID uint32) error {
+	if taskID == blockerID {
+		return ErrTaskDependencySelf
+	}
+	tasks, err := db.GetTaskByIds(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), []uint32{taskID, blockerID})
+	if err != nil {
+		return errors.Join(err, ErrGetTaskDataFailed)
+	}
+	var task *db.TaskDB
+	found := 0
+	for i := range tasks {
+		if tasks[i].UserID != u.userID || tasks[i].Deleted {
+			continue
+		}
+		found++
+		if tasks[i].TaskID == taskID {
+			task = &tasks[i]
+		}
+	}
+	if found != 2 || task == nil {
+		return errors.New("task not exist")
+	}
+
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDependency)
+	deps, err := db.GetTaskDependencies(conn, u.userID)
+	if err != nil {
+		return errors.Join(err, errors.New("load task dependencies failed"))
+	}
+	blockers := make(map[uint32][]uint32, len(deps))
+	for _, dep := range deps {
+		if dep.TaskID == taskID && dep.BlockerID == blockerID {
+			return ErrTaskDependencyExists
+		}
+		blockers[dep.TaskID] = append(blockers[dep.TaskID], dep.BlockerID)
+	}
+	if blockedBy(blockers, blockerID, taskID) {
+		return ErrTaskDependencyCycle
+	}
+	if err = db.CreateTaskDependency(conn, u.userID, taskID, blockerID); err != nil {
+		return err
+	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindTask, protocol.ChangeOpChange, taskID, task.ParentSubGroupID, 0)
+	return nil
+}
+
+// DelTaskBlocker 去掉阻塞关系，任一任务已经删除时也可以调用
+func (u *UserLogic) DelTaskBlocker(ctx context.Context, taskID, blockerID uint32) error {
+	deleted, err := db.DeleteTaskDependency(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDependency), u.userID, taskID, blockerID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrTaskDependencyNotExist
+	}
+	if task, err := db.GetTaskByID(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), taskID); err == nil && !task.Deleted {
+		u.env.publish(ctx, u.userID, protocol.ChangeKindTask, protocol.ChangeOpChange, taskID, task.ParentSubGroupID, 0)
+	}
+	return nil
+}
+
+// blockedBy from 是否直接或间接被 target 阻塞
+func blockedBy(blockers map[uint32][]uint32, from, target uint32) bool {
+	visited := map[uint32]bool{from: true}
+	queue := []uint32{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range blockers[cur] {
+			if next == target {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
+
+// FillBlocked 填充任务的 BlockedBy 与 Blocked。阻塞任务已经完成或者在回收站中时不算阻塞
+func (u *UserLogic) FillBlocked(ctx context.Context, tasks []protocol.PTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	taskIDs := make([]uint32, 0, len(tasks))
+	for _, task := range tasks {
+		taskIDs = append(taskIDs, task.ID)
+	}
+	rows, err := db.GetTaskBlockers(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDependency), taskIDs)
+	if err != nil {
+		return errors.Join(err, errors.New("load task blockers failed"))
+	}
+	byTask := make(map[uint32][]db.TaskBlocker, len(rows))
+	for _, row := range rows {
+		byTask[row.TaskID] = append(byTask[row.TaskID], row)
+	}
+	for i := range tasks {
+		tasks[i].BlockedBy = nil
+		tasks[i].Blocked = false
+		for _, row := range byTask[tasks[i].ID] {
+			tasks[i].BlockedBy = append(tasks[i].BlockedBy, row.BlockerID)
+			if !row.Done && !row.Deleted {
+				tasks[i].Blocked = true
+			}
+		}
+	}
+	return nil
+}
+
+// OnBlockerDone 任务完成后找出因此不再被阻塞的未完成任务，并在变更流中发布
+func (u *UserLogic) OnBlockerDone(ctx context.Context, blockerID uint32) ([]db.TaskDB, error) {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDependency)
+	blocked, err := db.GetBlockedTaskIDs(conn, blockerID)
+	if err != nil || len(blocked) == 0 {
+		return nil, err
+	}
+	rows, err := db.GetTaskBlockers(conn, blocked)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if !row.Done && !row.Deleted {
+			blocked = slices.DeleteFunc(blocked, func(id uint32) bool {
+				return id == row.TaskID
+			})
+		}
+	}
+	if len(blocked) == 0 {
+		return nil, nil
+	}
+	tasks, err := db.GetTaskByIds(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), blocked)
+	if err != nil {
+		return nil, err
+	}
+	tasks = slices.DeleteFunc(tasks, func(task db.TaskDB) bool {
+		return task.Done || task.Deleted
+	})
+	for _, task := range tasks {
+		u.env.publish(ctx, u.userID, protocol.ChangeKindTask, protocol.ChangeOpUnblock, task.TaskID, task.ParentSubGroupID, 0)
+	}
+	return tasks, nil
+}
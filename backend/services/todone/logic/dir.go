@@ -1,17 +1,21 @@
 package logic
 
 import (
+	"context"
 	"errors"
 	"github.com/intmian/platform/backend/services/todone/db"
 	"github.com/intmian/platform/backend/services/todone/protocol"
+	"time"
 )
 
 type DirLogic struct {
+	env    *Env
 	dbData *db.DirDB
 }
 
-func NewDirLogic(ID uint32) *DirLogic {
+func NewDirLogic(env *Env, ID uint32) *DirLogic {
 	return &DirLogic{
+		env: env,
 		dbData: &db.DirDB{
 			ID: ID,
 		},
@@ -31,12 +35,12 @@ func (d *DirLogic) ToProtocol() protocol.PDir {
 	}
 }
 
-func (d *DirLogic) Save() error {
-	conn := db.GTodoneDBMgr.GetConnect(db.ConnectTypeDir)
+func (d *DirLogic) Save(ctx context.Context) error {
+	conn := d.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
 	return db.ChangeDir(conn, d.dbData)
 }
 
-func (d *DirLogic) ChangeData(title, note string, index float32) error {
+func (d *DirLogic) ChangeData(ctx context.Context, title, note string, index float32) error {
 	if title != "" {
 		d.dbData.Title = title
 	}
@@ -46,14 +50,19 @@ func (d *DirLogic) ChangeData(title, note string, index float32) error {
 	if index != 0 {
 		d.dbData.Index = index
 	}
-	err := d.Save()
+	err := d.Save(ctx)
 	if err != nil {
 		return errors.Join(err, errors.New("save dir failed"))
 	}
+	d.env.publish(ctx, d.dbData.UserID, protocol.ChangeKindDir, protocol.ChangeOpChange, d.dbData.ID, d.dbData.ParentID, 0)
 	return nil
 }
 
-func (d *DirLogic) Delete() error {
-	conn := db.GTodoneDBMgr.GetConnect(db.ConnectTypeDir)
-	return db.DeleteDir(conn, d.dbData.ID)
+func (d *DirLogic) Delete(ctx context.Context) error {
+	conn := d.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
+	if err := db.DeleteDir(conn, d.dbData.ID, time.Now().Unix()); err != nil {
+		return err
+	}
+	d.env.publish(ctx, d.dbData.UserID, protocol.ChangeKindDir, protocol.ChangeOpDelete, d.dbData.ID, d.dbData.ParentID, 0)
+	return nil
 }
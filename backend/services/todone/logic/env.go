@@ -0,0 +1,48 @@
+package logic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+// Env 一个todone服务实例的运行环境，所有logic都从这里取数据库与日志，服务停止后整体丢弃。
+// 不同实例之间互不共享，因此可以在同一进程中启动多个实例。
+type Env struct {
+	DB  *db.Mgr
+	Log *xlog.XLog
+	// Ctx 服务的生命周期，取消后自动保存协程做最后一次落盘后退出
+	Ctx context.Context
+	// Feed 修改目录、分组、子分组与任务后在这里发布变更
+	Feed *ChangeFeed
+
+	// autoSaveWait 自动保存协程在ctx取消后还会做最后一次保存，停止服务时需要等它们结束再释放资源
+	autoSaveWait sync.WaitGroup
+}
+
+func NewEnv(ctx context.Context, dbMgr *db.Mgr, log *xlog.XLog) *Env {
+	return &Env{
+		DB:   dbMgr,
+		Log:  log,
+		Ctx:  ctx,
+		Feed: NewChangeFeed(dbMgr, log),
+	}
+}
+
+// WaitAutoSave 等待所有自动保存协程退出，超时返回false
+func (e *Env) WaitAutoSave(timeout time.Duration) bool {
+	waitDone := make(chan struct{})
+	go func() {
+		e.autoSaveWait.Wait()
+		close(waitDone)
+	}()
+	select {
+	case <-waitDone:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,250 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+const (
+	// changeRetention 变更保留的时长，游标早于保留范围时客户端需要全量刷新
+	changeRetention = 7 * 24 * time.Hour
+	// changePruneInterval 清理过期变更的最短间隔，在发布时顺带检查
+	changePruneInterval = time.Hour
+	// changeSubBuffer 每个订阅缓存的变更条数，消费不过来时订阅失效，由客户端带游标重连
+	changeSubBuffer = 256
+	// ChangeReplayLimit 续传时一次补发的最大条数，超过时要求客户端全量刷新
+	ChangeReplayLimit = 1000
+)
+
+var ErrChangeSubOverflow = errors.New("change subscriber overflow")
+
+// ChangeSub 一个长连接对某个用户变更的订阅
+type ChangeSub struct {
+	userID string
+	C      chan protocol.PChange
+	// Lost 缓存满时关闭，之后不再推送，需要重新订阅
+	Lost chan struct{}
+}
+
+// ChangeFeed 按用户的变更流。变更在所有者的用户锁内发布，所以同一用户的推送顺序与Seq一致
+type ChangeFeed struct {
+	db  *db.Mgr
+	log *xlog.XLog
+
+	lock      sync.Mutex
+	subs      map[string]map[*ChangeSub]struct{}
+	lastPrune time.Time
+}
+
+func NewChangeFeed(dbMgr *db.Mgr, log *xlog.XLog) *ChangeFeed {
+	return &ChangeFeed{
+		db:   dbMgr,
+		log:  log,
+		subs: make(map[string]map[*ChangeSub]struct{}),
+	}
+}
+
+// Publish 记录变更并推给该用户的订阅，失败只记录日志，不影响已经完成的修改
+func (f *ChangeFeed) Publish(ctx context.Context, userID string, change protocol.PChange) {
+	if f == nil || userID == "" {
+		return
+	}
+	// 请求方断开时修改已经落库，变更仍然要记下来
+	ctx = context.WithoutCancel(ctx)
+	conn := f.db.GetConnectCtx(ctx, db.ConnectTypeChange)
+	data := db.ChangeDB{
+		UserID:       userID,
+		Kind:         change.Kind,
+		Op:           change.Op,
+		ItemID:       change.ID,
+		ParentID:     change.ParentID,
+		FromParentID: change.FromParentID,
+	}
+	if err := db.CreateChange(conn, &data); err != nil {
+		f.logErr(errors.Join(err, errors.New("record change failed")))
+		return
+	}
+	change.Seq = data.Seq
+	change.CreatedAt = data.CreatedAt
+	// 在事务中发布时等提交后再推送，回滚的修改不会推给客户端
+	f.db.AfterCommit(ctx, func() {
+		f.push(userID, change)
+	})
+
+	f.lock.Lock()
+	needPrune := time.Since(f.lastPrune) > changePruneInterval
+	if needPrune {
+		f.lastPrune = time.Now()
+	}
+	f.lock.Unlock()
+
+	if needPrune {
+		if err := db.PruneChanges(conn, time.Now().Add(-changeRetention)); err != nil {
+			f.logErr(errors.Join(err, errors.New("prune changes failed")))
+		}
+	}
+}
+
+// push 推给该用户的订阅，消费不过来的订阅失效
+func (f *ChangeFeed) push(userID string, change protocol.PChange) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for sub := range f.subs[userID] {
+		select {
+		case sub.C <- change:
+		default:
+			f.removeLocked(sub)
+			close(sub.Lost)
+		}
+	}
+}
+
+func (f *ChangeFeed) logErr(err error) {
+	if f.log != nil {
+		f.log.WarningErr("TODONE", err)
+	}
+}
+
+// Subscribe 订阅之后发布的变更，用完需要Unsubscribe
+func (f *ChangeFeed) Subscribe(userID string) *ChangeSub {
+	sub := &ChangeSub{
+		userID: userID,
+		C:      make(chan protocol.PChange, changeSubBuffer),
+		Lost:   make(chan struct{}),
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.subs[userID] == nil {
+		f.subs[userID] = make(map[*ChangeSub]struct{})
+	}
+	f.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (f *ChangeFeed) Unsubscribe(sub *ChangeSub) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.removeLocked(sub)
+}
+
+func (f *ChangeFeed) removeLocked(sub *ChangeSub) {
+	subs := f.subs[sub.userID]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(f.subs, sub.userID)
+	}
+}
+
+// Replay 读取游标之后的变更，返回的latest为当前最新的Seq。
+// cursor为0表示从现在开始；游标之后的变更已经被清理、超过ChangeReplayLimit条或者游标比最新的还大时reset为true，客户端需要全量刷新
+func (f *ChangeFeed) Replay(ctx context.Context, userID string, cursor uint32) (changes []protocol.PChange, latest uint32, reset bool, err error) {
+	conn := f.db.GetConnectCtx(ctx, db.ConnectTypeChange)
+	minSeq, maxSeq, err := db.GetChangeSeqRange(conn)
+	if err != nil {
+		return nil, 0, false, errors.Join(err, errors.New("load change range failed"))
+	}
+	if cursor == 0 {
+		return nil, maxSeq, false, nil
+	}
+	if cursor > maxSeq || (minSeq > 0 && cursor+1 < minSeq) {
+		return nil, maxSeq, true, nil
+	}
+	rows, err := db.GetChangesAfter(conn, userID, cursor, ChangeReplayLimit+1)
+	if err != nil {
+		return nil, 0, false, errors.Join(err, errors.New("load changes failed"))
+	}
+	if len(rows) > ChangeReplayLimit {
+		return nil, maxSeq, true, nil
+	}
+	changes = make([]protocol.PChange, 0, len(rows))
+	for _, row := range rows {
+		changes = append(changes, protocol.PChange{
+			Seq:          row.Seq,
+			Kind:         row.Kind,
+			Op:           row.Op,
+			ID:           row.ItemID,
+			ParentID:     row.ParentID,
+			FromParentID: row.FromParentID,
+			CreatedAt:    row.CreatedAt,
+		})
+		latest = max(latest, row.Seq)
+	}
+	return changes, max(latest, maxSeq), false, nil
+}
+
+// publish 记录一条变更，fromParentID与parentID相同时视为没有换容器。
+// userID是数据的所有者，变更所在的分组共享给其他人时，被授权人的变更流也会收到一份
+func (e *Env) publish(ctx context.Context, userID, kind, op string, id, parentID, fromParentID uint32) {
+	if fromParentID == parentID {
+		fromParentID = 0
+	}
+	change := protocol.PChange{
+		Kind:         kind,
+		Op:           op,
+		ID:           id,
+		ParentID:     parentID,
+		FromParentID: fromParentID,
+	}
+	if e.Feed == nil {
+		return
+	}
+	e.Feed.Publish(ctx, userID, change)
+	for _, grantee := range e.changeGrantees(ctx, change) {
+		if grantee != userID {
+			e.Feed.Publish(ctx, grantee, change)
+		}
+	}
+}
+
+// changeGrantees 变更涉及的分组的被授权人，移动时原来所在的分组也算。目录不参与共享
+func (e *Env) changeGrantees(ctx context.Context, change protocol.PChange) []string {
+	var groupIDs []uint32
+	switch change.Kind {
+	case protocol.ChangeKindGroup:
+		groupIDs = []uint32{change.ID}
+	case protocol.ChangeKindSubGroup:
+		groupIDs = []uint32{change.ParentID, change.FromParentID}
+	case protocol.ChangeKindTask:
+		subGroupIDs := []uint32{change.ParentID}
+		if change.FromParentID != 0 {
+			subGroupIDs = append(subGroupIDs, change.FromParentID)
+		}
+		subGroups, err := db.GetSubGroupsByIDs(e.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup), subGroupIDs)
+		if err != nil {
+			e.Feed.logErr(errors.Join(err, errors.New("load change sub groups failed")))
+			return nil
+		}
+		for _, subGroup := range subGroups {
+			groupIDs = append(groupIDs, subGroup.ParentGroupID)
+		}
+	default:
+		return nil
+	}
+
+	var grantees []string
+	seen := make(map[string]bool)
+	conn := e.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare)
+	for _, groupID := range groupIDs {
+		if groupID == 0 {
+			continue
+		}
+		shares, err := db.GetSharesByGroup(conn, groupID)
+		if err != nil {
+			e.Feed.logErr(errors.Join(err, errors.New("load change grantees failed")))
+			continue
+		}
+		for _, share := range shares {
+			if !seen[share.GranteeID] {
+				seen[share.GranteeID] = true
+				grantees = append(grantees, share.GranteeID)
+			}
+		}
+	}
+	return grantees
+}
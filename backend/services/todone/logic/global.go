@@ -1,9 +0,0 @@
-package logic
-
-import (
-	"context"
-	"github.com/intmian/platform/backend/share"
-)
-
-var GTodoneShare *share.ServiceShare
-var GTodoneCtx context.Context
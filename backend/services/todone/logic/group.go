@@ -1,20 +1,24 @@
 package logic
 
 import (
+	"context"
 	"errors"
 	"math"
+	"time"
 
 	"github.com/intmian/platform/backend/services/todone/db"
 	"github.com/intmian/platform/backend/services/todone/protocol"
 )
 
 type GroupLogic struct {
+	env       *Env
 	dbData    *db.GroupDB
 	subGroups []*SubGroupLogic
 }
 
-func NewGroupLogic(ID uint32) *GroupLogic {
+func NewGroupLogic(env *Env, ID uint32) *GroupLogic {
 	return &GroupLogic{
+		env: env,
 		dbData: &db.GroupDB{
 			ID: ID,
 		},
@@ -25,12 +29,12 @@ func (g *GroupLogic) OnBindOutData(dbData *db.GroupDB) {
 	g.dbData = dbData
 }
 
-func (g *GroupLogic) GetGroupData() (*db.GroupDB, error) {
+func (g *GroupLogic) GetGroupData(ctx context.Context) (*db.GroupDB, error) {
 	if g.dbData != nil {
 		return g.dbData, nil
 	}
 
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeGroup)
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
 	groupDB, err := db.GetGroup(connect, g.dbData.ID)
 	if err != nil || groupDB == nil {
 		return nil, err
@@ -39,17 +43,17 @@ func (g *GroupLogic) GetGroupData() (*db.GroupDB, error) {
 	return groupDB, nil
 }
 
-func (g *GroupLogic) GetSubGroups() ([]*SubGroupLogic, error) {
+func (g *GroupLogic) GetSubGroups(ctx context.Context) ([]*SubGroupLogic, error) {
 	if g.subGroups != nil {
 		return g.subGroups, nil
 	}
 
 	// 没有缓存，从数据库中获取，并缓存
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
 	subGroupsDB := db.GetSubGroupByParentSortByIndex(connect, g.dbData.ID)
 	for _, subGroupDB := range subGroupsDB {
 		newSubGroupDB := subGroupDB
-		logic := NewSubGroupLogic(newSubGroupDB)
+		logic := NewSubGroupLogic(g.env, g.dbData.UserID, newSubGroupDB)
 		if logic == nil {
 			return nil, errors.New("create sub group logic failed")
 		}
@@ -59,13 +63,13 @@ func (g *GroupLogic) GetSubGroups() ([]*SubGroupLogic, error) {
 	return g.subGroups, nil
 }
 
-func (g *GroupLogic) GetSubGroupLogic(subGroupID uint32) *SubGroupLogic {
+func (g *GroupLogic) GetSubGroupLogic(ctx context.Context, subGroupID uint32) *SubGroupLogic {
 	if g.subGroups == nil {
-		connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
+		connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
 		subGroupsDB := db.GetSubGroupByParentSortByIndex(connect, g.dbData.ID)
 		for _, subGroupDB := range subGroupsDB {
 			newSubGroupDB := subGroupDB
-			g.subGroups = append(g.subGroups, NewSubGroupLogic(newSubGroupDB))
+			g.subGroups = append(g.subGroups, NewSubGroupLogic(g.env, g.dbData.UserID, newSubGroupDB))
 		}
 	}
 	for _, subGroup := range g.subGroups {
@@ -76,8 +80,8 @@ func (g *GroupLogic) GetSubGroupLogic(subGroupID uint32) *SubGroupLogic {
 	return nil
 }
 
-func (g *GroupLogic) GeneSubGroupIndex() float32 {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
+func (g *GroupLogic) GeneSubGroupIndex(ctx context.Context) float32 {
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
 	maxIndex := db.GetParentGroupIDMaxIndex(connect, g.dbData.ID)
 	if math.Floor(float64(maxIndex)) == float64(maxIndex) {
 		return maxIndex + 1
@@ -86,68 +90,120 @@ func (g *GroupLogic) GeneSubGroupIndex() float32 {
 	}
 }
 
-func (g *GroupLogic) CreateSubGroupLogic(title, note string) (*SubGroupLogic, error) {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
-	index := g.GeneSubGroupIndex()
-	id, err := db.CreateSubGroup(connect, g.dbData.ID, title, note, index, "")
+// CreateSubGroupLogic requestID 为空表示不做重试去重，否则先用 FindRequestSubGroup 检查
+func (g *GroupLogic) CreateSubGroupLogic(ctx context.Context, title, note, requestID string) (*SubGroupLogic, error) {
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	index := g.GeneSubGroupIndex(ctx)
+	id, err := db.CreateSubGroup(connect, g.dbData.ID, title, note, index, "", requestIDPtr(requestID))
 	if err != nil {
 		return nil, err
 	}
 	dbData := &db.SubGroupDB{
-		ID:            id,
-		ParentGroupID: g.dbData.ID,
-		Title:         title,
-		Note:          note,
-		Index:         index,
-	}
-	subGroupLogic := NewSubGroupLogic(dbData)
+		ID:              id,
+		ParentGroupID:   g.dbData.ID,
+		Title:           title,
+		Note:            note,
+		Index:           index,
+		Revision:        1,
+		ClientRequestID: requestIDPtr(requestID),
+	}
+	subGroupLogic := NewSubGroupLogic(g.env, g.dbData.UserID, dbData)
 	g.subGroups = append(g.subGroups, subGroupLogic)
+	g.env.publish(ctx, g.dbData.UserID, protocol.ChangeKindSubGroup, protocol.ChangeOpCreate, id, g.dbData.ID, 0)
 	return subGroupLogic, nil
 }
 
+// FindRequestSubGroup 找到同一个请求ID已经创建的子分组，没有时返回nil，已经删除或者标题不同时返回ErrSubGroupRequestConflict
+func (g *GroupLogic) FindRequestSubGroup(ctx context.Context, requestID, title string) (*SubGroupLogic, error) {
+	if requestID == "" {
+		return nil, nil
+	}
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	existing, err := db.GetSubGroupByRequestID(connect, g.dbData.ID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+	if existing.Deleted || existing.Title != title {
+		return nil, ErrSubGroupRequestConflict
+	}
+	subGroup := g.GetSubGroupLogic(ctx, existing.ID)
+	if subGroup == nil {
+		return nil, errors.New("sub group not exist")
+	}
+	return subGroup, nil
+}
+
 func (g *GroupLogic) ToProtocol() protocol.PGroup {
 	return protocol.PGroup{
-		ID:    g.dbData.ID,
-		Title: g.dbData.Title,
-		Note:  g.dbData.Note,
-		Index: g.dbData.Index,
-		Type:  int(g.dbData.Type),
+		ID:       g.dbData.ID,
+		Title:    g.dbData.Title,
+		Note:     g.dbData.Note,
+		Index:    g.dbData.Index,
+		Type:     int(g.dbData.Type),
+		Revision: g.dbData.Revision,
 	}
 }
 
-func (g *GroupLogic) ChangeData(title, note string, index float32) error {
+// CheckRevision 修改前检查客户端带回的版本，必须带上
+func (g *GroupLogic) CheckRevision(revision uint32) error {
+	return checkRevision(g.dbData.Revision, revision, ErrGroupRevisionConflict)
+}
+
+func (g *GroupLogic) ChangeData(ctx context.Context, title, note string, index float32) error {
+	// 在副本上修改，保存成功后再替换缓存
+	changed := *g.dbData
 	if title != "" {
-		g.dbData.Title = title
+		changed.Title = title
 	}
 	if note != "" {
-		g.dbData.Note = note
+		changed.Note = note
 	}
 	if index != 0 {
-		g.dbData.Index = index
+		changed.Index = index
 	}
-	err := g.Save()
-	if err != nil {
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	if err := db.ChangeGroup(connect, &changed); err != nil {
+		if errors.Is(err, db.ErrGroupRevisionConflict) {
+			g.reload(ctx)
+		}
 		return err
 	}
+	*g.dbData = changed
+	g.env.publish(ctx, g.dbData.UserID, protocol.ChangeKindGroup, protocol.ChangeOpChange, g.dbData.ID, g.dbData.ParentDir, 0)
 	return nil
 }
 
-func (g *GroupLogic) Save() error {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeGroup)
+// reload 版本冲突后从库中重新读取，客户端重新读取后拿到的是其他实例改过的数据
+func (g *GroupLogic) reload(ctx context.Context) {
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	if data, err := db.GetGroup(connect, g.dbData.ID); err == nil {
+		*g.dbData = *data
+	}
+}
+
+func (g *GroupLogic) Save(ctx context.Context) error {
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
 	return db.ChangeGroup(connect, g.dbData)
 }
 
-func (g *GroupLogic) Delete() error {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeGroup)
-	return db.DeleteGroup(connect, g.dbData.ID)
+func (g *GroupLogic) Delete(ctx context.Context) error {
+	connect := g.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	if err := db.DeleteGroup(connect, g.dbData.ID, time.Now().Unix()); err != nil {
+		return err
+	}
+	g.env.publish(ctx, g.dbData.UserID, protocol.ChangeKindGroup, protocol.ChangeOpDelete, g.dbData.ID, g.dbData.ParentDir, 0)
+	return nil
 }
 
-func (g *GroupLogic) DeleteSubGroup(subGroupID uint32) error {
-	subGroup := g.GetSubGroupLogic(subGroupID)
+func (g *GroupLogic) DeleteSubGroup(ctx context.Context, subGroupID uint32) error {
+	subGroup := g.GetSubGroupLogic(ctx, subGroupID)
 	if subGroup == nil {
 		return errors.New("sub group not exist")
 	}
-	err := subGroup.OnDelete()
+	err := subGroup.OnDelete(ctx)
 	if err != nil {
 		return errors.Join(err, errors.New("delete sub group failed"))
 	}
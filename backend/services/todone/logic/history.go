@@ -0,0 +1,402 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+const (
+	// MaxHistoryLimit getTaskHistory 单页上限
+	MaxHistoryLimit = 100
+	// MaxUndoCount 一次最多撤销的操作数
+	MaxUndoCount = 20
+)
+
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// TaskSnapshot 任务在某一时刻的完整状态，撤销时按它恢复
+type TaskSnapshot struct {
+	Task db.TaskDB
+	Tags []string
+	// PrevID 同一父任务下排在它前面的任务，0表示在最前
+	PrevID uint32
+	// HasPosition 快照时任务是否在序列中，不在时恢复到末尾
+	HasPosition bool
+}
+
+// SnapshotTask 复制任务当前的状态，之后对任务的修改不会影响快照
+func (s *SubGroupLogic) SnapshotTask(ctx context.Context, task *TaskLogic) (TaskSnapshot, error) {
+	data, err := task.GetTaskData(ctx)
+	if err != nil || data == nil {
+		return TaskSnapshot{}, errors.Join(err, ErrGetTaskDataFailed)
+	}
+	tags, err := task.GetTags(ctx)
+	if err != nil {
+		return TaskSnapshot{}, errors.Join(err, ErrGetTagsFailed)
+	}
+	snapshot := TaskSnapshot{Task: *data, Tags: slices.Clone(tags)}
+	for i, id := range s.taskSequence[data.ParentTaskID] {
+		if id == data.TaskID {
+			snapshot.HasPosition = true
+			if i > 0 {
+				snapshot.PrevID = s.taskSequence[data.ParentTaskID][i-1]
+			}
+			break
+		}
+	}
+	return snapshot, nil
+}
+
+// RecordHistory 追加一条操作记录，before 中没有而 after 中有的任务视为本次新建
+func (u *UserLogic) RecordHistory(ctx context.Context, op string, before, after []TaskSnapshot) error {
+	return u.recordHistory(ctx, op, before, after, 0)
+}
+
+func (u *UserLogic) recordHistory(ctx context.Context, op string, before, after []TaskSnapshot, revertID uint32) error {
+	taskIDs := make([]uint32, 0, len(before)+len(after))
+	for _, snapshot := range append(slices.Clone(before), after...) {
+		if !slices.Contains(taskIDs, snapshot.Task.TaskID) {
+			taskIDs = append(taskIDs, snapshot.Task.TaskID)
+		}
+	}
+	beforeJson, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJson, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	return db.CreateTaskHistory(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeHistory), &db.TaskHistoryDB{
+		UserID:   u.userID,
+		Op:       op,
+		TaskIDs:  db.JoinHistoryTaskIDs(taskIDs),
+		Before:   string(beforeJson),
+		After:    string(afterJson),
+		RevertID: revertID,
+	})
+}
+
+func parseSnapshots(data string) ([]TaskSnapshot, error) {
+	var snapshots []TaskSnapshot
+	if data == "" {
+		return snapshots, nil
+	}
+	err := json.Unmarshal([]byte(data), &snapshots)
+	return snapshots, err
+}
+
+func snapshotsToProtocol(snapshots []TaskSnapshot) []protocol.PTask {
+	res := make([]protocol.PTask, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		pTask := taskDBToProtocol(&snapshot.Task, snapshot.Tags)
+		res = append(res, pTask)
+	}
+	return res
+}
+
+// GetTaskHistory 按时间倒序读取操作记录，taskID非0时只看涉及该任务的记录
+func (u *UserLogic) GetTaskHistory(ctx context.Context, taskID, beforeID uint32, limit int) ([]protocol.PTaskHistory, error) {
+	if limit <= 0 || limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeHistory)
+	histories, err := db.GetTaskHistory(conn, u.userID, taskID, beforeID, limit)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("get task history failed"))
+	}
+	ids := make([]uint32, 0, len(histories))
+	for _, history := range histories {
+		ids = append(ids, history.ID)
+	}
+	reverted, err := db.GetRevertedHistoryIDs(conn, u.userID, ids)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("get reverted history failed"))
+	}
+	res := make([]protocol.PTaskHistory, 0, len(histories))
+	for _, history := range histories {
+		before, err := parseSnapshots(history.Before)
+		if err != nil {
+			return nil, err
+		}
+		after, err := parseSnapshots(history.After)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, protocol.PTaskHistory{
+			ID:        history.ID,
+			Op:        history.Op,
+			Before:    snapshotsToProtocol(before),
+			After:     snapshotsToProtocol(after),
+			RevertID:  history.RevertID,
+			Reverted:  reverted[history.ID],
+			CreatedAt: history.CreatedAt,
+		})
+	}
+	return res, nil
+}
+
+// Undo 按时间倒序撤销最近n条还没有撤销的操作，每撤销一条追加一条 undo 记录。
+// 中途失败时已经撤销的部分保留，返回已经撤销的记录ID。
+func (u *UserLogic) Undo(ctx context.Context, n int) ([]uint32, error) {
+	if n <= 0 {
+		n = 1
+	}
+	if n > MaxUndoCount {
+		n = MaxUndoCount
+	}
+	if err := u.loadDirTree(ctx); err != nil {
+		return nil, err
+	}
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeHistory)
+	histories, err := db.GetUndoCandidates(conn, u.userID, n)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("get undo candidates failed"))
+	}
+	if len(histories) == 0 {
+		return nil, ErrNothingToUndo
+	}
+	undone := make([]uint32, 0, len(histories))
+	for _, history := range histories {
+		if err = u.undoHistory(ctx, history); err != nil {
+			return undone, errors.Join(err, errors.New("undo failed"))
+		}
+		undone = append(undone, history.ID)
+	}
+	return undone, nil
+}
+
+func (u *UserLogic) undoHistory(ctx context.Context, history db.TaskHistoryDB) error {
+	before, err := parseSnapshots(history.Before)
+	if err != nil {
+		return err
+	}
+	after, err := parseSnapshots(history.After)
+	if err != nil {
+		return err
+	}
+	existed := make(map[uint32]bool, len(before))
+	for _, snapshot := range before {
+		existed[snapshot.Task.TaskID] = true
+	}
+
+	var undoBefore, undoAfter []TaskSnapshot
+	// 先删除本次新建的任务，再按顺序恢复其余任务
+	for _, snapshot := range after {
+		if existed[snapshot.Task.TaskID] {
+			continue
+		}
+		cur, err := u.deleteCreatedTask(ctx, snapshot.Task.TaskID)
+		if err != nil {
+			return err
+		}
+		if cur != nil {
+			undoBefore = append(undoBefore, *cur)
+			deleted := *cur
+			deleted.Task.Deleted = true
+			undoAfter = append(undoAfter, deleted)
+		}
+	}
+	for _, snapshot := range before {
+		cur, restored, err := u.restoreTask(ctx, snapshot)
+		if err != nil {
+			return err
+		}
+		undoBefore = append(undoBefore, cur)
+		undoAfter = append(undoAfter, restored)
+	}
+	return u.recordHistory(ctx, db.HistoryOpUndo, undoBefore, undoAfter, history.ID)
+}
+
+// subGroupByID 按ID在当前目录树中查找子分组，所在分组已经删除时返回错误
+func (u *UserLogic) subGroupByID(ctx context.Context, subGroupID uint32) (*SubGroupLogic, error) {
+	subGroups, err := db.GetSubGroupsByIDs(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup), []uint32{subGroupID})
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load sub group failed"))
+	}
+	if len(subGroups) == 0 {
+		return nil, errors.New("sub group not exist")
+	}
+	location, ok := u.groupLocations()[subGroups[0].ParentGroupID]
+	if !ok {
+		return nil, errors.New("group not exist")
+	}
+	subGroup := location.group.GetSubGroupLogic(ctx, subGroupID)
+	if subGroup == nil {
+		return nil, errors.New("sub group not exist")
+	}
+	return subGroup, nil
+}
+
+// deleteCreatedTask 删除操作中新建的任务，已经删除时返回nil
+func (u *UserLogic) deleteCreatedTask(ctx context.Context, taskID uint32) (*TaskSnapshot, error) {
+	data, err := db.GetTaskByID(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), taskID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("task not exist"))
+	}
+	if data.Deleted {
+		return nil, nil
+	}
+	subGroup, err := u.subGroupByID(ctx, data.ParentSubGroupID)
+	if err != nil {
+		return nil, err
+	}
+	task := subGroup.GetTaskLogic(ctx, taskID)
+	if task == nil {
+		return nil, errors.New("task not exist")
+	}
+	snapshot, err := subGroup.SnapshotTask(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	if err = subGroup.OnDeleteTasks(ctx, []uint32{taskID}); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// restoreTask 把任务恢复到快照的状态，包括位置、删除标记、字段与标签，返回恢复前后的快照
+func (u *UserLogic) restoreTask(ctx context.Context, snapshot TaskSnapshot) (cur, restored TaskSnapshot, err error) {
+	taskConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	data, err := db.GetTaskByID(taskConn, snapshot.Task.TaskID)
+	if err != nil {
+		return cur, restored, errors.Join(err, errors.New("task not exist"))
+	}
+	target, err := u.subGroupByID(ctx, snapshot.Task.ParentSubGroupID)
+	if err != nil {
+		return cur, restored, err
+	}
+	want := snapshot.Task
+	if want.Deleted {
+		// 目前只有删除操作会产生删除状态，它的操作前快照总是未删除的
+		return cur, restored, errors.New("restore to deleted state is not supported")
+	}
+
+	var task *TaskLogic
+	op := protocol.ChangeOpChange
+	if data.Deleted {
+		op = protocol.ChangeOpCreate
+		// 已经删除的任务不在任何缓存与序列中，直接改回原位置后重新放入
+		cur = TaskSnapshot{Task: *data, Tags: db.GetTagsByTaskID(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags), data.TaskID)}
+		task = NewTaskLogic(u.env, data.TaskID)
+		task.OnBindOutData(data)
+		task.BindOutTags(cur.Tags)
+		data.ParentSubGroupID = want.ParentSubGroupID
+		data.ParentTaskID = want.ParentTaskID
+		data.Deleted = false
+		data.DeletedUnix = 0
+	} else {
+		from, err := u.subGroupByID(ctx, data.ParentSubGroupID)
+		if err != nil {
+			return cur, restored, err
+		}
+		task = from.GetTaskLogic(ctx, data.TaskID)
+		if task == nil {
+			return cur, restored, errors.New("task not exist")
+		}
+		if cur, err = from.SnapshotTask(ctx, task); err != nil {
+			return cur, restored, err
+		}
+		if from.GetID() != target.GetID() {
+			// 跨子分组时连同子任务一起移动回去
+			seq, needChangeParent, noNeedChangeParent := from.BeforeTaskMove(ctx, []uint32{data.TaskID}, want.ParentTaskID)
+			if seq == nil {
+				return cur, restored, errors.New("move task back failed")
+			}
+			if err = target.AfterTaskMove(ctx, seq, needChangeParent, noNeedChangeParent, want.ParentTaskID, 0, true); err != nil {
+				return cur, restored, err
+			}
+			if task = target.GetTaskLogic(ctx, data.TaskID); task == nil {
+				return cur, restored, errors.New("task not exist")
+			}
+		}
+		task.dbData.ParentTaskID = want.ParentTaskID
+	}
+
+	restoreTaskFields(task.dbData, &want)
+	if err = task.save(ctx, task.dbData); err != nil {
+		return cur, restored, err
+	}
+	if err = task.syncTags(ctx, snapshot.Tags); err != nil {
+		return cur, restored, err
+	}
+	if err = target.placeTask(ctx, task, snapshot); err != nil {
+		return cur, restored, err
+	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindTask, op, task.dbData.TaskID, target.GetID(), 0)
+	restored, err = target.SnapshotTask(ctx, task)
+	return cur, restored, err
+}
+
+// restoreTaskFields 恢复用户可以修改的字段，位置与删除标记由调用方处理
+func restoreTaskFields(data, want *db.TaskDB) {
+	data.Title = want.Title
+	data.Note = want.Note
+	data.Done = want.Done
+	data.Started = want.Started
+	data.TaskType = want.TaskType
+	data.BeginTime = want.BeginTime
+	data.EndTime = want.EndTime
+	data.Wait4 = want.Wait4
+	data.Repeat = want.Repeat
+	data.RepeatNextID = want.RepeatNextID
+}
+
+// syncTags 把标签改成给定的集合
+func (t *TaskLogic) syncTags(ctx context.Context, tags []string) error {
+	cur, err := t.GetTags(ctx)
+	if err != nil {
+		return errors.Join(err, ErrGetTagsFailed)
+	}
+	for _, tag := range slices.Clone(cur) {
+		if !slices.Contains(tags, tag) {
+			if err = t.RemoveTag(ctx, tag); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tag := range tags {
+		if !slices.Contains(t.tagsDB, tag) {
+			if err = t.AddTag(ctx, tag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// placeTask 把未完成的任务放回缓存，并放到快照中的前一个任务之后。
+// 前一个任务已经不在序列中时放到末尾。
+func (s *SubGroupLogic) placeTask(ctx context.Context, task *TaskLogic, snapshot TaskSnapshot) error {
+	if task.dbData.Done {
+		return nil
+	}
+	if !s.unFinTasksLoaded {
+		if err := s.buildSequenceWithLoadData(ctx); err != nil {
+			return err
+		}
+	}
+	if _, ok := s.unFinTasksCache[task.id]; !ok {
+		s.unFinTasksCache[task.id] = task
+	}
+	for parentID := range s.taskSequence {
+		s.taskSequence.Remove(parentID, task.id)
+	}
+	parentID := task.dbData.ParentTaskID
+	children := s.taskSequence[parentID]
+	insertAt := len(children)
+	if snapshot.HasPosition {
+		if snapshot.PrevID == 0 {
+			insertAt = 0
+		} else if i := slices.Index(children, snapshot.PrevID); i >= 0 {
+			insertAt = i + 1
+		}
+	}
+	s.taskSequence[parentID] = slices.Insert(children, insertAt, task.id)
+	return s.OnChangeSeq()
+}
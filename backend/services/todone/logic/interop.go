@@ -0,0 +1,347 @@
+package logic
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+// maxImportTasks 一次导入的任务上限
+const maxImportTasks = 1000
+
+var (
+	ErrImportEmpty         = errors.New("no task to import")
+	ErrImportTooMany       = errors.New("too many tasks to import")
+	ErrImportNoTitleColumn = errors.New("csv has no title column")
+)
+
+// ImportItem 从外部清单解析出的一个任务
+type ImportItem struct {
+	Title string
+	Note  string
+	Done  bool
+	Tags  []string
+	// Parent 同一批中父任务的下标，-1表示挂在导入位置下
+	Parent int
+}
+
+var (
+	mdItemReg = regexp.MustCompile(`^[-*+]\s+(?:\[([ xX])\]\s+)?(.*)$`)
+	mdTagReg  = regexp.MustCompile(`^#[^\s#]*[^\s#0-9][^\s#]*$`)
+)
+
+// indentWidth 行首缩进的宽度，tab按4个空格算
+func indentWidth(line string) (int, string) {
+	width := 0
+	for i, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width, line[i:]
+		}
+	}
+	return width, ""
+}
+
+// splitTags 把标题末尾的#标签拆出来，纯数字的#123视为标题内容
+func splitTags(title string) (string, []string) {
+	fields := strings.Fields(title)
+	end := len(fields)
+	for end > 0 && mdTagReg.MatchString(fields[end-1]) {
+		end--
+	}
+	if end == len(fields) || end == 0 {
+		return strings.TrimSpace(title), nil
+	}
+	tags := make([]string, 0, len(fields)-end)
+	for _, field := range fields[end:] {
+		tags = append(tags, strings.TrimPrefix(field, "#"))
+	}
+	return strings.Join(fields[:end], " "), tags
+}
+
+// ParseMarkdownChecklist 解析Markdown列表，缩进更深的列表项作为子任务，
+// 紧跟在列表项下且缩进更深的普通文本作为备注，其余内容忽略
+func ParseMarkdownChecklist(text string) ([]ImportItem, error) {
+	type level struct {
+		indent int
+		index  int
+	}
+	var items []ImportItem
+	var stack []level
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		indent, content := indentWidth(line)
+		if content == "" {
+			continue
+		}
+		match := mdItemReg.FindStringSubmatch(content)
+		if match == nil {
+			// 属于最近一个缩进更浅的列表项的备注
+			if len(stack) > 0 && indent > stack[len(stack)-1].indent {
+				item := &items[stack[len(stack)-1].index]
+				if item.Note != "" {
+					item.Note += "\n"
+				}
+				item.Note += strings.TrimRightFunc(content, func(r rune) bool { return r == ' ' || r == '\t' })
+			} else {
+				stack = stack[:0]
+			}
+			continue
+		}
+		title, tags := splitTags(match[2])
+		if title == "" {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := -1
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].index
+		}
+		items = append(items, ImportItem{Title: title, Done: match[1] == "x" || match[1] == "X", Tags: tags, Parent: parent})
+		stack = append(stack, level{indent: indent, index: len(items) - 1})
+	}
+	return checkImportItems(items)
+}
+
+// csvColumns 各字段可能的表头，兼容Todoist导出的大写表头
+var csvColumns = map[string][]string{
+	"type":   {"type"},
+	"title":  {"content", "title", "name", "task"},
+	"note":   {"description", "note", "notes"},
+	"done":   {"done", "completed", "status"},
+	"indent": {"indent", "level"},
+	"tags":   {"tags", "labels"},
+}
+
+var todoistLabelReg = regexp.MustCompile(`(?:^|\s)@([^\s@]+)`)
+
+// ParseTaskCSV 解析带表头的CSV，INDENT从1开始表示层级。
+// 有TYPE列时按Todoist格式处理：只导入task行，note行追加到上一个任务的备注，标题中的@标签转为标签
+func ParseTaskCSV(text string) ([]ImportItem, error) {
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(text, "\ufeff")))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Join(err, errors.New("read csv failed"))
+	}
+	if len(rows) == 0 {
+		return nil, ErrImportEmpty
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for field, names := range csvColumns {
+			for _, candidate := range names {
+				if _, ok := columns[field]; !ok && name == candidate {
+					columns[field] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, ErrImportNoTitleColumn
+	}
+	cell := func(row []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	_, todoist := columns["type"]
+
+	type level struct {
+		depth int
+		index int
+	}
+	var items []ImportItem
+	var stack []level
+	for _, row := range rows[1:] {
+		title := cell(row, "title")
+		if todoist {
+			switch strings.ToLower(cell(row, "type")) {
+			case "task":
+			case "note":
+				if len(items) > 0 && title != "" {
+					item := &items[len(items)-1]
+					if item.Note != "" {
+						item.Note += "\n"
+					}
+					item.Note += title
+				}
+				continue
+			default:
+				continue
+			}
+		}
+		var tags []string
+		if todoist {
+			for _, match := range todoistLabelReg.FindAllStringSubmatch(title, -1) {
+				tags = append(tags, match[1])
+			}
+			title = strings.Join(strings.Fields(todoistLabelReg.ReplaceAllString(title, " ")), " ")
+		}
+		for _, tag := range strings.FieldsFunc(cell(row, "tags"), func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+			tags = append(tags, tag)
+		}
+		if title == "" {
+			continue
+		}
+		depth, err := strconv.Atoi(cell(row, "indent"))
+		if err != nil || depth < 1 {
+			depth = 1
+		}
+		for len(stack) > 0 && stack[len(stack)-1].depth >= depth {
+			stack = stack[:len(stack)-1]
+		}
+		parent := -1
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].index
+		}
+		switch strings.ToLower(cell(row, "done")) {
+		case "1", "true", "yes", "x", "done", "completed":
+			items = append(items, ImportItem{Title: title, Note: cell(row, "note"), Done: true, Tags: tags, Parent: parent})
+		default:
+			items = append(items, ImportItem{Title: title, Note: cell(row, "note"), Tags: tags, Parent: parent})
+		}
+		stack = append(stack, level{depth: depth, index: len(items) - 1})
+	}
+	return checkImportItems(items)
+}
+
+func checkImportItems(items []ImportItem) ([]ImportItem, error) {
+	if len(items) == 0 {
+		return nil, ErrImportEmpty
+	}
+	if len(items) > maxImportTasks {
+		return nil, ErrImportTooMany
+	}
+	return items, nil
+}
+
+// ensureTasksLoaded 保证未完成任务的缓存与序列已经建立，新任务按创建顺序追加到序列末尾
+func (s *SubGroupLogic) ensureTasksLoaded(ctx context.Context) error {
+	if s.unFinTasksLoaded {
+		return nil
+	}
+	_, err := s.GetTasks(ctx, false)
+	return err
+}
+
+// ImportTasks 按顺序创建任务，parentTaskID不为0时全部挂在这个任务下。中途失败时已经创建的任务保留并一起返回
+func (s *SubGroupLogic) ImportTasks(ctx context.Context, userID string, items []ImportItem, parentTaskID uint32) ([]*TaskLogic, error) {
+	if _, err := checkImportItems(items); err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		if item.Parent >= i || item.Parent < -1 {
+			return nil, errors.New("import task parent must come first")
+		}
+	}
+	if parentTaskID != 0 {
+		parent := s.GetTaskLogic(ctx, parentTaskID)
+		if parent == nil || parent.dbData.ParentSubGroupID != s.dbData.ID {
+			return nil, errors.New("parent task not exist")
+		}
+	}
+	if err := s.ensureTasksLoaded(ctx); err != nil {
+		return nil, err
+	}
+	res := make([]*TaskLogic, 0, len(items))
+	for _, item := range items {
+		parentID := parentTaskID
+		if item.Parent >= 0 {
+			parentID = res[item.Parent].dbData.TaskID
+		}
+		task, err := s.CreateTask(ctx, userID, item.Title, item.Note, db.TaskTypeTodo, false, parentID, "")
+		if err != nil {
+			return res, errors.Join(err, errors.New("create task failed"))
+		}
+		res = append(res, task)
+		for _, tag := range item.Tags {
+			if err = task.AddTag(ctx, tag); err != nil {
+				return res, errors.Join(err, errors.New("add tag failed"))
+			}
+		}
+		if item.Done {
+			task.dbData.Done = true
+			if err = task.save(ctx, task.dbData); err != nil {
+				return res, errors.Join(err, errors.New("update task failed"))
+			}
+		}
+	}
+	return res, nil
+}
+
+// ExportMarkdown 按展示顺序把子分组的任务渲染为Markdown清单，未完成的在前，已完成的按创建顺序排在同级末尾
+func (s *SubGroupLogic) ExportMarkdown(ctx context.Context) (string, error) {
+	if err := s.ensureTasksLoaded(ctx); err != nil {
+		return "", err
+	}
+	tasks, err := s.GetTasks(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	ids := make(map[uint32]bool, len(tasks))
+	for _, task := range tasks {
+		ids[task.dbData.TaskID] = true
+	}
+	children := make(map[uint32][]*TaskLogic)
+	for _, task := range tasks {
+		parentID := task.dbData.ParentTaskID
+		// 父任务已经删除的子孙任务不展示
+		if parentID != 0 && !ids[parentID] {
+			continue
+		}
+		children[parentID] = append(children[parentID], task)
+	}
+	for _, list := range children {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].index < list[j].index })
+	}
+
+	var sb strings.Builder
+	var write func(parentID uint32, depth int)
+	write = func(parentID uint32, depth int) {
+		indent := strings.Repeat("  ", depth)
+		for _, task := range children[parentID] {
+			sb.WriteString(indent)
+			if task.dbData.Done {
+				sb.WriteString("- [x] ")
+			} else {
+				sb.WriteString("- [ ] ")
+			}
+			sb.WriteString(strings.ReplaceAll(task.dbData.Title, "\n", " "))
+			for _, tag := range task.tagsDB {
+				sb.WriteString(" #")
+				sb.WriteString(tag)
+			}
+			sb.WriteString("\n")
+			if task.dbData.Note != "" {
+				for _, line := range strings.Split(task.dbData.Note, "\n") {
+					if strings.TrimSpace(line) == "" {
+						continue
+					}
+					sb.WriteString(indent)
+					sb.WriteString("  ")
+					sb.WriteString(line)
+					sb.WriteString("\n")
+				}
+			}
+			write(task.dbData.TaskID, depth+1)
+		}
+	}
+	write(0, 0)
+	return sb.String(), nil
+}
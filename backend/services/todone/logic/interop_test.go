@@ -0,0 +1,70 @@
+package logic
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseMarkdownChecklist(t *testing.T) {
+	text := "# Trip\n" +
+		"- [ ] pack #home\n" +
+		"  bring charger\n" +
+		"  - [x] passport\n" +
+		"  - [ ] snacks\n" +
+		"\t- [ ] water\n" +
+		"- plain item #12\n"
+	items, err := ParseMarkdownChecklist(text)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []ImportItem{
+		{Title: "pack", Note: "bring charger", Tags: []string{"home"}, Parent: -1},
+		{Title: "passport", Done: true, Parent: 0},
+		{Title: "snacks", Parent: 0},
+		{Title: "water", Parent: 2},
+		{Title: "plain item #12", Parent: -1},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("items = %+v", items)
+	}
+	if _, err = ParseMarkdownChecklist("just text"); !errors.Is(err, ErrImportEmpty) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestParseTaskCSV(t *testing.T) {
+	todoist := "\ufeffTYPE,CONTENT,DESCRIPTION,PRIORITY,INDENT,AUTHOR\n" +
+		"section,Week,,,,\n" +
+		"task,Write report @work @urgent,draft first,4,1,a\n" +
+		"note,remember charts,,,,\n" +
+		"task,Collect data,,1,2,a\n" +
+		"task,Call mom,,1,1,a\n"
+	items, err := ParseTaskCSV(todoist)
+	if err != nil {
+		t.Fatalf("parse todoist: %v", err)
+	}
+	want := []ImportItem{
+		{Title: "Write report", Note: "draft first\nremember charts", Tags: []string{"work", "urgent"}, Parent: -1},
+		{Title: "Collect data", Parent: 0},
+		{Title: "Call mom", Parent: -1},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("todoist items = %+v", items)
+	}
+
+	items, err = ParseTaskCSV("title,note,done,tags\nbuy milk,2L,yes,\"home,shop\"\nwash car,,,\n")
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	want = []ImportItem{
+		{Title: "buy milk", Note: "2L", Done: true, Tags: []string{"home", "shop"}, Parent: -1},
+		{Title: "wash car", Parent: -1},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("csv items = %+v", items)
+	}
+	if _, err = ParseTaskCSV("foo,bar\n1,2\n"); !errors.Is(err, ErrImportNoTitleColumn) {
+		t.Fatalf("err = %v", err)
+	}
+}
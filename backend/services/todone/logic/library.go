@@ -0,0 +1,30 @@
+package logic
+
+import (
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func LibraryNoteToProtocol(note db.LibraryNoteDB) protocol.PLibraryNote {
+	return protocol.PLibraryNote{
+		ID: note.ID, TaskID: note.TaskID, RoundID: note.RoundID, EventTime: note.EventTime,
+		Content: note.Content, Revision: note.Revision, CreatedAt: note.CreatedAt, UpdatedAt: note.UpdatedAt,
+	}
+}
+
+func dimensionDBToProtocol(value *uint8, adjustment int8, comment string) *protocol.PLibraryScoreDimension {
+	if value == nil {
+		return nil
+	}
+	return &protocol.PLibraryScoreDimension{Value: *value, Adjustment: adjustment, Comment: comment}
+}
+
+func LibraryScoreDetailToProtocol(detail db.LibraryScoreDetailDB) protocol.PLibraryScoreDetail {
+	return protocol.PLibraryScoreDetail{
+		ID: detail.ID, TaskID: detail.TaskID, RoundID: detail.RoundID, Mode: detail.Mode, Comment: detail.Comment,
+		ObjScore:      dimensionDBToProtocol(detail.ObjValue, detail.ObjAdjustment, detail.ObjComment),
+		SubScore:      dimensionDBToProtocol(detail.SubValue, detail.SubAdjustment, detail.SubComment),
+		InnovateScore: dimensionDBToProtocol(detail.InnovateValue, detail.InnovateAdjustment, detail.InnovateComment),
+		Revision:      detail.Revision, CreatedAt: detail.CreatedAt, UpdatedAt: detail.UpdatedAt,
+	}
+}
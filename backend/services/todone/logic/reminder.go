@@ -0,0 +1,75 @@
+package logic
+
+import (
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+const (
+	ReminderKindOverdue = "overdue"
+	ReminderKindSummary = "summary"
+	reminderKindBefore  = "before_"
+)
+
+// OverdueRemindWindow 截止后多久内还会推送逾期提醒，更早逾期的只出现在每日汇总中，避免刚开启时集中推送大量旧任务
+const OverdueRemindWindow = 24 * time.Hour
+
+// ReminderKind 当前应该推送的截止提醒，offsets 为截止前多久提醒。
+// 同时落入多个提醒窗口时只取最近的一个，已经错过的较早提醒不再补发。
+func ReminderKind(offsets []time.Duration, end, now time.Time) (string, bool) {
+	if end.IsZero() {
+		return "", false
+	}
+	if !now.Before(end) {
+		if now.Sub(end) < OverdueRemindWindow {
+			return ReminderKindOverdue, true
+		}
+		return "", false
+	}
+	remain := end.Sub(now)
+	var best time.Duration
+	for _, offset := range offsets {
+		if offset <= 0 || remain > offset {
+			continue
+		}
+		if best == 0 || offset < best {
+			best = offset
+		}
+	}
+	if best == 0 {
+		return "", false
+	}
+	return reminderKindBefore + best.String(), true
+}
+
+// DailySummary 一个用户当天的待办汇总
+type DailySummary struct {
+	Due     []db.TaskDB // 今天截止
+	Overdue []db.TaskDB // 已经逾期
+	Waiting []db.TaskDB // 有等待对象且不在上面两类中
+}
+
+func (d DailySummary) Empty() bool {
+	return len(d.Due) == 0 && len(d.Overdue) == 0 && len(d.Waiting) == 0
+}
+
+// BuildDailySummary 按now所在时区的自然日汇总未完成任务
+func BuildDailySummary(tasks []db.TaskDB, now time.Time) DailySummary {
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	var summary DailySummary
+	for _, task := range tasks {
+		switch {
+		case task.EndTime.IsZero() || !task.EndTime.Before(dayEnd):
+			if task.Wait4 != "" {
+				summary.Waiting = append(summary.Waiting, task)
+			}
+		case task.EndTime.Before(now):
+			summary.Overdue = append(summary.Overdue, task)
+		default:
+			summary.Due = append(summary.Due, task)
+		}
+	}
+	return summary
+}
@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+func TestReminderKind(t *testing.T) {
+	now := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{24 * time.Hour, time.Hour}
+	cases := []struct {
+		end  time.Time
+		kind string
+		ok   bool
+	}{
+		{time.Time{}, "", false},
+		{now.Add(48 * time.Hour), "", false},
+		{now.Add(20 * time.Hour), "before_24h0m0s", true},
+		// 同时落入两个窗口时只取最近的
+		{now.Add(30 * time.Minute), "before_1h0m0s", true},
+		{now, ReminderKindOverdue, true},
+		{now.Add(-2 * time.Hour), ReminderKindOverdue, true},
+		{now.Add(-OverdueRemindWindow), "", false},
+	}
+	for _, c := range cases {
+		kind, ok := ReminderKind(offsets, c.end, now)
+		if kind != c.kind || ok != c.ok {
+			t.Fatalf("end %v: kind=%q ok=%v want %q %v", c.end, kind, ok, c.kind, c.ok)
+		}
+	}
+}
+
+func TestBuildDailySummary(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	tasks := []db.TaskDB{
+		{TaskID: 1, EndTime: now.Add(-26 * time.Hour)},
+		{TaskID: 2, EndTime: now.Add(5 * time.Hour), Wait4: "bob"},
+		{TaskID: 3, EndTime: now.Add(20 * time.Hour)},
+		{TaskID: 4, Wait4: "alice"},
+		{TaskID: 5},
+	}
+	summary := BuildDailySummary(tasks, now)
+	if len(summary.Overdue) != 1 || summary.Overdue[0].TaskID != 1 {
+		t.Fatalf("overdue = %+v", summary.Overdue)
+	}
+	if len(summary.Due) != 1 || summary.Due[0].TaskID != 2 {
+		t.Fatalf("due = %+v", summary.Due)
+	}
+	if len(summary.Waiting) != 1 || summary.Waiting[0].TaskID != 4 {
+		t.Fatalf("waiting = %+v", summary.Waiting)
+	}
+	if !BuildDailySummary(tasks[4:], now).Empty() {
+		t.Fatal("summary without due or waiting tasks should be empty")
+	}
+}
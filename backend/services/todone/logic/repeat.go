@@ -0,0 +1,317 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/robfig/cron"
+)
+
+const (
+	RepeatDaily     = "daily"
+	RepeatWeekly    = "weekly"
+	RepeatMonthly   = "monthly"
+	RepeatCron      = "cron"
+	RepeatAfterDone = "afterDone" // 完成后N天
+)
+
+const (
+	// MaxUpcoming getTasks 预览之后几次的上限
+	MaxUpcoming = 10
+	// maxRepeatSkip 逾期很久才完成时最多跳过的次数，防止规则异常时死循环
+	maxRepeatSkip = 10000
+)
+
+var ErrInvalidRepeatRule = errors.New("invalid repeat rule")
+
+// ValidateRepeatRule 校验规则，返回按默认值补齐后的规则
+func ValidateRepeatRule(rule protocol.PRepeatRule) (protocol.PRepeatRule, error) {
+	if rule.Interval <= 0 {
+		rule.Interval = 1
+	}
+	switch rule.Type {
+	case RepeatDaily, RepeatAfterDone:
+	case RepeatWeekly:
+		for _, weekday := range rule.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return rule, errors.Join(ErrInvalidRepeatRule, errors.New("weekday out of range"))
+			}
+		}
+	case RepeatMonthly:
+		if rule.MonthDay < 0 || rule.MonthDay > 31 {
+			return rule, errors.Join(ErrInvalidRepeatRule, errors.New("month day out of range"))
+		}
+	case RepeatCron:
+		if _, err := cron.ParseStandard(rule.Cron); err != nil {
+			return rule, errors.Join(ErrInvalidRepeatRule, err)
+		}
+	default:
+		return rule, errors.Join(ErrInvalidRepeatRule, errors.New("unknown type "+rule.Type))
+	}
+	return rule, nil
+}
+
+func parseRepeatRule(data string) (*protocol.PRepeatRule, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var rule protocol.PRepeatRule
+	if err := json.Unmarshal([]byte(data), &rule); err != nil {
+		return nil, errors.Join(ErrInvalidRepeatRule, err)
+	}
+	return &rule, nil
+}
+
+// nextRuleTime 规则中严格晚于 anchor 的下一个时间，afterDone 不走这里
+func nextRuleTime(rule protocol.PRepeatRule, anchor time.Time) (time.Time, error) {
+	switch rule.Type {
+	case RepeatDaily:
+		return anchor.AddDate(0, 0, rule.Interval), nil
+	case RepeatWeekly:
+		if len(rule.Weekdays) == 0 {
+			return anchor.AddDate(0, 0, 7*rule.Interval), nil
+		}
+		// 一周从周一开始，跨到下一周时按间隔跳过整周
+		weekIndex := func(t time.Time) int { return (int(t.Weekday()) + 6) % 7 }
+		for d := 1; d <= 7; d++ {
+			next := anchor.AddDate(0, 0, d)
+			for _, weekday := range rule.Weekdays {
+				if int(next.Weekday()) != weekday {
+					continue
+				}
+				if weekIndex(next) <= weekIndex(anchor) {
+					next = next.AddDate(0, 0, 7*(rule.Interval-1))
+				}
+				return next, nil
+			}
+		}
+		return time.Time{}, ErrInvalidRepeatRule
+	case RepeatMonthly:
+		day := rule.MonthDay
+		if day == 0 {
+			day = anchor.Day()
+		}
+		return addMonthsClamped(anchor, rule.Interval, day), nil
+	case RepeatCron:
+		schedule, err := cron.ParseStandard(rule.Cron)
+		if err != nil {
+			return time.Time{}, errors.Join(ErrInvalidRepeatRule, err)
+		}
+		return schedule.Next(anchor), nil
+	}
+	return time.Time{}, ErrInvalidRepeatRule
+}
+
+// pinMonthDay 按月重复且未指定日期时，用任务本身的日期补齐，
+// 避免月末被截断后(1月31日 -> 2月28日)之后每次都沿用截断后的日期。
+// 锚点与 NextOccurrence 一致，都没有时用 doneAt，doneAt 也为零值时保持不变
+func pinMonthDay(rule protocol.PRepeatRule, task *db.TaskDB, doneAt time.Time) protocol.PRepeatRule {
+	if rule.Type != RepeatMonthly || rule.MonthDay != 0 {
+		return rule
+	}
+	anchor := task.EndTime
+	if anchor.IsZero() {
+		anchor = task.BeginTime
+	}
+	if anchor.IsZero() {
+		anchor = doneAt
+	}
+	if !anchor.IsZero() {
+		rule.MonthDay = anchor.Day()
+	}
+	return rule
+}
+
+// addMonthsClamped 加若干个月并落在指定日，当月没有这一天时取月末
+func addMonthsClamped(t time.Time, months, day int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	first = first.AddDate(0, months, 0)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// NextOccurrence 计算重复任务完成后下一次的开始与截止时间，ok为false表示规则已经结束。
+// 以截止时间为锚点，没有截止时间时用开始时间，都没有时用完成时间；
+// 逾期完成时跳过已经错过的次数，下一次一定晚于完成时间。开始与截止同时存在时保持两者的间隔。
+func NextOccurrence(rule protocol.PRepeatRule, task *db.TaskDB, doneAt time.Time) (begin, end time.Time, ok bool, err error) {
+	rule, err = ValidateRepeatRule(rule)
+	if err != nil {
+		return
+	}
+	rule = pinMonthDay(rule, task, doneAt)
+	anchor := task.EndTime
+	if anchor.IsZero() {
+		anchor = task.BeginTime
+	}
+	var next time.Time
+	if rule.Type == RepeatAfterDone {
+		next = doneAt.AddDate(0, 0, rule.Interval)
+		if !anchor.IsZero() {
+			// 沿用原来的时刻，只按完成日期推算日期
+			anchor = anchor.In(doneAt.Location())
+			next = time.Date(next.Year(), next.Month(), next.Day(), anchor.Hour(), anchor.Minute(), anchor.Second(), 0, next.Location())
+		}
+	} else {
+		if anchor.IsZero() {
+			anchor = doneAt
+		}
+		next, err = nextRuleTime(rule, anchor)
+		for i := 0; err == nil && !next.After(doneAt); i++ {
+			if i >= maxRepeatSkip {
+				err = errors.Join(ErrInvalidRepeatRule, errors.New("too many skipped occurrences"))
+				break
+			}
+			next, err = nextRuleTime(rule, next)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if !rule.Until.IsZero() && next.After(rule.Until) {
+		return
+	}
+
+	switch {
+	case !task.BeginTime.IsZero() && !task.EndTime.IsZero() && task.EndTime.After(task.BeginTime):
+		begin = next.Add(-task.EndTime.Sub(task.BeginTime))
+		end = next
+	case !task.BeginTime.IsZero() && task.EndTime.IsZero():
+		begin = next
+	default:
+		end = next
+	}
+	return begin, end, true, nil
+}
+
+// UpcomingOccurrences 预览之后的n次，假设当前这次在现在完成、之后每次都在截止时完成
+func UpcomingOccurrences(rule protocol.PRepeatRule, task *db.TaskDB, n int, now time.Time) ([]protocol.POccurrence, error) {
+	if n > MaxUpcoming {
+		n = MaxUpcoming
+	}
+	res := make([]protocol.POccurrence, 0, n)
+	cur := *task
+	doneAt := now
+	if cur.EndTime.After(doneAt) {
+		doneAt = cur.EndTime
+	} else if cur.EndTime.IsZero() && cur.BeginTime.After(doneAt) {
+		doneAt = cur.BeginTime
+	}
+	rule = pinMonthDay(rule, task, doneAt)
+	for len(res) < n {
+		begin, end, ok, err := NextOccurrence(rule, &cur, doneAt)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		res = append(res, protocol.POccurrence{BeginTime: begin, EndTime: end})
+		cur.BeginTime, cur.EndTime = begin, end
+		doneAt = end
+		if doneAt.IsZero() {
+			doneAt = begin
+		}
+	}
+	return res, nil
+}
+
+// SetRepeat 设置或清除(rule为nil)重复规则
+func (t *TaskLogic) SetRepeat(ctx context.Context, rule *protocol.PRepeatRule) error {
+	data, err := t.GetTaskData(ctx)
+	if err != nil || data == nil {
+		return errors.Join(err, ErrGetTaskDataFailed)
+	}
+	data.Repeat = ""
+	if rule != nil {
+		validated, err := ValidateRepeatRule(*rule)
+		if err != nil {
+			return err
+		}
+		bs, err := json.Marshal(pinMonthDay(validated, data, time.Time{}))
+		if err != nil {
+			return err
+		}
+		data.Repeat = string(bs)
+	}
+	if err = t.save(ctx, data); err != nil {
+		return err
+	}
+	t.publish(ctx, protocol.ChangeOpChange)
+	return nil
+}
+
+// GetUpcoming 未完成的重复任务之后n次的时间，不重复的任务返回nil
+func (t *TaskLogic) GetUpcoming(ctx context.Context, n int, now time.Time) ([]protocol.POccurrence, error) {
+	data, err := t.GetTaskData(ctx)
+	if err != nil || data == nil {
+		return nil, errors.Join(err, ErrGetTaskDataFailed)
+	}
+	rule, err := parseRepeatRule(data.Repeat)
+	if rule == nil || err != nil || data.Done {
+		return nil, err
+	}
+	return UpcomingOccurrences(*rule, data, n, now)
+}
+
+// CreateRepeatTask 重复任务完成后在同一位置生成下一次，复制标题、备注、类型与标签，不复制子任务。
+// 已经生成过或规则已经结束时返回nil。
+func (s *SubGroupLogic) CreateRepeatTask(ctx context.Context, task *TaskLogic, doneAt time.Time) (*TaskLogic, error) {
+	data, err := task.GetTaskData(ctx)
+	if err != nil || data == nil {
+		return nil, errors.Join(err, ErrGetTaskDataFailed)
+	}
+	if data.RepeatNextID != 0 {
+		return nil, nil
+	}
+	rule, err := parseRepeatRule(data.Repeat)
+	if rule == nil || err != nil {
+		return nil, err
+	}
+	// 设置时没有日期可用的规则，在第一次生成时固定下来
+	pinned := pinMonthDay(*rule, data, doneAt)
+	begin, end, ok, err := NextOccurrence(pinned, data, doneAt)
+	if err != nil || !ok {
+		return nil, err
+	}
+	repeat := data.Repeat
+	if pinned.MonthDay != rule.MonthDay {
+		bs, err := json.Marshal(pinned)
+		if err != nil {
+			return nil, err
+		}
+		repeat = string(bs)
+	}
+	next, err := s.CreateTask(ctx, data.UserID, data.Title, data.Note, data.TaskType, false, data.ParentTaskID, "")
+	if err != nil {
+		return nil, errors.Join(err, ErrCreateTaskFailed)
+	}
+	nextData := next.dbData
+	nextData.BeginTime = begin
+	nextData.EndTime = end
+	nextData.Wait4 = data.Wait4
+	nextData.Repeat = repeat
+	if err = next.save(ctx, nextData); err != nil {
+		return nil, err
+	}
+	tags, err := task.GetTags(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ErrGetTagsFailed)
+	}
+	for _, tag := range tags {
+		if err = next.AddTag(ctx, tag); err != nil {
+			return nil, err
+		}
+	}
+	data.RepeatNextID = nextData.TaskID
+	if err = task.save(ctx, data); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
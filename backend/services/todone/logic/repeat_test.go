@@ -0,0 +1,147 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func date(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestNextOccurrence(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    protocol.PRepeatRule
+		task    db.TaskDB
+		doneAt  time.Time
+		wantEnd time.Time
+	}{
+		{
+			name:    "daily on time",
+			rule:    protocol.PRepeatRule{Type: RepeatDaily},
+			task:    db.TaskDB{EndTime: date(2026, 3, 10, 9)},
+			doneAt:  date(2026, 3, 10, 8),
+			wantEnd: date(2026, 3, 11, 9),
+		},
+		{
+			name:    "daily overdue skips missed days",
+			rule:    protocol.PRepeatRule{Type: RepeatDaily, Interval: 2},
+			task:    db.TaskDB{EndTime: date(2026, 3, 10, 9)},
+			doneAt:  date(2026, 3, 15, 12),
+			wantEnd: date(2026, 3, 16, 9),
+		},
+		{
+			name: "weekly on weekdays",
+			// 2026-03-11 是周三
+			rule:    protocol.PRepeatRule{Type: RepeatWeekly, Weekdays: []int{1, 5}},
+			task:    db.TaskDB{EndTime: date(2026, 3, 11, 9)},
+			doneAt:  date(2026, 3, 11, 9),
+			wantEnd: date(2026, 3, 13, 9),
+		},
+		{
+			name:    "biweekly wraps to the week after next",
+			rule:    protocol.PRepeatRule{Type: RepeatWeekly, Interval: 2, Weekdays: []int{1}},
+			task:    db.TaskDB{EndTime: date(2026, 3, 11, 9)},
+			doneAt:  date(2026, 3, 11, 9),
+			wantEnd: date(2026, 3, 23, 9),
+		},
+		{
+			name:    "monthly clamps to month end",
+			rule:    protocol.PRepeatRule{Type: RepeatMonthly, MonthDay: 31},
+			task:    db.TaskDB{EndTime: date(2026, 1, 31, 9)},
+			doneAt:  date(2026, 1, 31, 9),
+			wantEnd: date(2026, 2, 28, 9),
+		},
+		{
+			name:    "cron",
+			rule:    protocol.PRepeatRule{Type: RepeatCron, Cron: "30 8 * * 1"},
+			task:    db.TaskDB{EndTime: date(2026, 3, 11, 9)},
+			doneAt:  date(2026, 3, 11, 9),
+			wantEnd: date(2026, 3, 16, 8).Add(30 * time.Minute),
+		},
+		{
+			name:    "after done keeps clock time",
+			rule:    protocol.PRepeatRule{Type: RepeatAfterDone, Interval: 3},
+			task:    db.TaskDB{EndTime: date(2026, 3, 1, 20)},
+			doneAt:  date(2026, 3, 5, 10),
+			wantEnd: date(2026, 3, 8, 20),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			begin, end, ok, err := NextOccurrence(c.rule, &c.task, c.doneAt)
+			if err != nil || !ok {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			if !begin.IsZero() || !end.Equal(c.wantEnd) {
+				t.Fatalf("begin=%v end=%v want end %v", begin, end, c.wantEnd)
+			}
+		})
+	}
+}
+
+func TestNextOccurrenceKeepsDurationAndUntil(t *testing.T) {
+	task := db.TaskDB{BeginTime: date(2026, 3, 10, 9), EndTime: date(2026, 3, 10, 11)}
+	rule := protocol.PRepeatRule{Type: RepeatDaily, Until: date(2026, 3, 11, 23)}
+	begin, end, ok, err := NextOccurrence(rule, &task, date(2026, 3, 10, 11))
+	if err != nil || !ok || !begin.Equal(date(2026, 3, 11, 9)) || !end.Equal(date(2026, 3, 11, 11)) {
+		t.Fatalf("begin=%v end=%v ok=%v err=%v", begin, end, ok, err)
+	}
+	task.BeginTime, task.EndTime = begin, end
+	if _, _, ok, err = NextOccurrence(rule, &task, end); err != nil || ok {
+		t.Fatalf("rule should end after until, ok=%v err=%v", ok, err)
+	}
+}
+
+func TestUpcomingOccurrences(t *testing.T) {
+	task := db.TaskDB{EndTime: date(2026, 3, 10, 9)}
+	got, err := UpcomingOccurrences(protocol.PRepeatRule{Type: RepeatWeekly}, &task, 3, date(2026, 3, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{date(2026, 3, 17, 9), date(2026, 3, 24, 9), date(2026, 3, 31, 9)}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if !got[i].EndTime.Equal(want[i]) {
+			t.Fatalf("occurrence %d = %v want %v", i, got[i].EndTime, want[i])
+		}
+	}
+}
+
+func TestMonthlyRepeatKeepsMonthDay(t *testing.T) {
+	// 未指定日期时沿用1月31日，2月截断到月末后3月仍回到31日
+	task := db.TaskDB{EndTime: date(2026, 1, 31, 9)}
+	got, err := UpcomingOccurrences(protocol.PRepeatRule{Type: RepeatMonthly}, &task, 3, date(2026, 1, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{date(2026, 2, 28, 9), date(2026, 3, 31, 9), date(2026, 4, 30, 9)}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if !got[i].EndTime.Equal(want[i]) {
+			t.Fatalf("occurrence %d = %v want %v", i, got[i].EndTime, want[i])
+		}
+	}
+}
+
+func TestValidateRepeatRule(t *testing.T) {
+	bad := []protocol.PRepeatRule{
+		{Type: "yearly"},
+		{Type: RepeatWeekly, Weekdays: []int{7}},
+		{Type: RepeatMonthly, MonthDay: 32},
+		{Type: RepeatCron, Cron: "not a cron"},
+	}
+	for _, rule := range bad {
+		if _, err := ValidateRepeatRule(rule); err == nil {
+			t.Fatalf("rule %+v accepted", rule)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package logic
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+var (
+	ErrTaskRevisionConflict     = db.ErrTaskRevisionConflict
+	ErrSubGroupRevisionConflict = db.ErrSubGroupRevisionConflict
+	ErrGroupRevisionConflict    = db.ErrGroupRevisionConflict
+	ErrRevisionRequired         = errors.New("revision required")
+	ErrTaskRequestConflict      = errors.New("task request conflict")
+	ErrSubGroupRequestConflict  = errors.New("sub group request conflict")
+	ErrGroupRequestConflict     = errors.New("group request conflict")
+	ErrClientRequestIDInvalid   = errors.New("client request id invalid")
+)
+
+// checkRevision 客户端必须带回读取时的版本，与缓存不一致时直接返回冲突。
+// 一致时写库仍以版本为条件，缓存过期(其他实例改过)时由数据库返回冲突
+func checkRevision(cur, expected uint32, conflict error) error {
+	if expected == 0 {
+		return ErrRevisionRequired
+	}
+	if expected != cur {
+		return conflict
+	}
+	return nil
+}
+
+// CheckClientRequestID 请求ID可以不填，填了必须是UUID
+func CheckClientRequestID(requestID string) error {
+	if requestID == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(requestID); err != nil {
+		return ErrClientRequestIDInvalid
+	}
+	return nil
+}
+
+// requestIDPtr 空字符串表示没有请求ID，落库为NULL，不占唯一索引
+func requestIDPtr(requestID string) *string {
+	if requestID == "" {
+		return nil
+	}
+	return &requestID
+}
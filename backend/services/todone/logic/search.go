@@ -0,0 +1,125 @@
+package logic
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+type groupLocation struct {
+	dir   *dirTreeNode
+	group *GroupLogic
+}
+
+// groupLocations 当前目录树中所有未删除分组的位置
+func (u *UserLogic) groupLocations() map[uint32]groupLocation {
+	res := make(map[uint32]groupLocation)
+	for _, node := range u.dirMap {
+		for _, group := range node.groups {
+			res[group.dbData.ID] = groupLocation{dir: node, group: group}
+		}
+	}
+	return res
+}
+
+// dirTitles 从顶层目录到该目录的标题，不含根目录
+func (u *UserLogic) dirTitles(node *dirTreeNode) []string {
+	var titles []string
+	for node != nil && node.dir.dbData.ParentID != 0 {
+		titles = append([]string{node.dir.dbData.Title}, titles...)
+		node = u.dirMap[node.dir.dbData.ParentID]
+	}
+	return titles
+}
+
+// SearchTasks 检索用户的任务，groupID 非0时只在该分组中查找。
+// 所在分组已经删除或不在目录树中的任务不会返回。
+func (u *UserLogic) SearchTasks(ctx context.Context, q db.SearchQuery, groupID uint32) ([]protocol.PSearchHit, error) {
+	if err := u.loadDirTree(ctx); err != nil {
+		return nil, err
+	}
+	q.UserID = u.userID
+	locations := u.groupLocations()
+	if groupID != 0 {
+		location, ok := locations[groupID]
+		if !ok {
+			return nil, errors.New("group not exist")
+		}
+		subGroups, err := location.group.GetSubGroups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		q.SubGroupIDs = make([]uint32, 0, len(subGroups))
+		for _, subGroup := range subGroups {
+			q.SubGroupIDs = append(q.SubGroupIDs, subGroup.GetID())
+		}
+	}
+
+	hits, err := db.SearchTasks(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), u.env.DB.SearchFts(), q)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("search tasks failed"))
+	}
+	subGroupIDs := make([]uint32, 0, len(hits))
+	seen := make(map[uint32]bool)
+	for _, hit := range hits {
+		if !seen[hit.Task.ParentSubGroupID] {
+			seen[hit.Task.ParentSubGroupID] = true
+			subGroupIDs = append(subGroupIDs, hit.Task.ParentSubGroupID)
+		}
+	}
+	subGroupDBs, err := db.GetSubGroupsByIDs(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup), subGroupIDs)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load sub groups failed"))
+	}
+	subGroupParent := make(map[uint32]uint32, len(subGroupDBs))
+	for _, subGroup := range subGroupDBs {
+		subGroupParent[subGroup.ID] = subGroup.ParentGroupID
+	}
+
+	res := make([]protocol.PSearchHit, 0, len(hits))
+	for _, hit := range hits {
+		location, ok := locations[subGroupParent[hit.Task.ParentSubGroupID]]
+		if !ok {
+			continue
+		}
+		// 子分组标题可能还在自动保存的缓存中，以内存为准
+		subGroup := location.group.GetSubGroupLogic(ctx, hit.Task.ParentSubGroupID)
+		if subGroup == nil {
+			continue
+		}
+		titles := append(u.dirTitles(location.dir), location.group.dbData.Title, subGroup.dbData.Title)
+		res = append(res, protocol.PSearchHit{
+			Task: taskDBToProtocol(&hit.Task, hit.Tags),
+			Path: protocol.PTaskPath{
+				DirID:      location.dir.dir.dbData.ID,
+				GroupID:    location.group.dbData.ID,
+				SubGroupID: subGroup.GetID(),
+				Titles:     titles,
+			},
+			Score:         hit.Score,
+			MatchFields:   matchFields(hit),
+			LibraryNoteID: hit.LibraryNoteID,
+			Snippet:       hit.Snippet,
+		})
+	}
+	return res, nil
+}
+
+func matchFields(hit db.SearchHit) []string {
+	fields := make([]string, 0, 4)
+	if hit.MatchTitle {
+		fields = append(fields, "title")
+	}
+	if hit.MatchNote {
+		fields = append(fields, "note")
+	}
+	if hit.MatchTag {
+		fields = append(fields, "tag")
+	}
+	if hit.LibraryNoteID != "" {
+		fields = append(fields, "libraryNote")
+	}
+	return fields
+}
@@ -0,0 +1,156 @@
+package logic
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+var (
+	ErrGroupReadOnly    = errors.New("group is read only")
+	ErrInvalidShareRole = errors.New("invalid share role")
+)
+
+// shareRoleLevel 角色的权限高低，所有者最高
+var shareRoleLevel = map[string]int{
+	protocol.ShareRoleViewer: 1,
+	protocol.ShareRoleEditor: 2,
+}
+
+func (u *UserLogic) UserID() string {
+	return u.userID
+}
+
+// hasGroup 分组在自己的目录树中且未删除
+func (u *UserLogic) hasGroup(ctx context.Context, groupID uint32) bool {
+	if err := u.loadDirTree(ctx); err != nil {
+		return false
+	}
+	_, ok := u.groupLocations()[groupID]
+	return ok
+}
+
+// SafeUseGroup 在分组所有者的锁内执行f。
+// 自己的分组以及既不属于自己也没有被分享的分组都在自己的锁内执行，后者交给f按不存在处理；
+// 分享给自己的分组先释放自己的锁再锁住所有者，不会同时持有两把锁，角色低于need时返回ErrGroupReadOnly。
+func (u *UserMgr) SafeUseGroup(ctx context.Context, userID string, groupID uint32, need string, f func(owner *UserLogic), denied func(err error)) {
+	var share *db.GroupShareDB
+	var shareErr error
+	u.SafeUseUserLogic(userID, func(user *UserLogic) {
+		if user.hasGroup(ctx, groupID) {
+			f(user)
+			return
+		}
+		share, shareErr = db.GetGroupShare(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare), groupID, userID)
+		if share == nil && shareErr == nil {
+			f(user)
+		}
+	}, func() {
+		denied(errors.New("user not exist"))
+	})
+	if shareErr != nil {
+		denied(errors.Join(shareErr, errors.New("load group share failed")))
+		return
+	}
+	if share == nil {
+		return
+	}
+	if shareRoleLevel[share.Role] < shareRoleLevel[need] {
+		denied(ErrGroupReadOnly)
+		return
+	}
+	u.SafeUseUserLogic(share.OwnerID, f, func() {
+		denied(errors.New("user not exist"))
+	})
+}
+
+// GroupRole 当前账号在分组上的角色，自己的分组返回空字符串，没有权限时返回错误
+func (u *UserMgr) GroupRole(ctx context.Context, userID string, groupID uint32) (string, error) {
+	group, err := db.GetGroup(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup), groupID)
+	if err != nil || group.Deleted {
+		return "", errors.New("group not exist")
+	}
+	if group.UserID == userID {
+		return "", nil
+	}
+	share, err := db.GetGroupShare(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare), groupID, userID)
+	if err != nil {
+		return "", errors.Join(err, errors.New("load group share failed"))
+	}
+	if share == nil {
+		return "", errors.New("group not exist")
+	}
+	return share.Role, nil
+}
+
+// SharedGroups 分享给userID且所有者仍能看到的分组，逐个锁住所有者读取，调用方不能持有任何用户锁
+func (u *UserMgr) SharedGroups(ctx context.Context, userID string) ([]protocol.PSharedGroup, error) {
+	shares, err := db.GetSharesByGrantee(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare), userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load group shares failed"))
+	}
+	res := make([]protocol.PSharedGroup, 0, len(shares))
+	for _, share := range shares {
+		u.SafeUseUserLogic(share.OwnerID, func(owner *UserLogic) {
+			if owner.loadDirTree(ctx) != nil {
+				return
+			}
+			location, ok := owner.groupLocations()[share.GroupID]
+			if !ok {
+				return
+			}
+			res = append(res, protocol.PSharedGroup{
+				PGroup:    location.group.ToProtocol(),
+				ParentDir: location.dir.dir.dbData.ID,
+				OwnerID:   share.OwnerID,
+				Role:      share.Role,
+			})
+		}, func() {})
+	}
+	return res, nil
+}
+
+// ShareGroup 把自己的分组分享给其他账号，已经分享过时修改角色
+func (u *UserLogic) ShareGroup(ctx context.Context, groupID uint32, granteeID, role string) error {
+	if _, ok := shareRoleLevel[role]; !ok {
+		return ErrInvalidShareRole
+	}
+	if granteeID == "" || granteeID == u.userID {
+		return errors.New("invalid grantee")
+	}
+	if !u.hasGroup(ctx, groupID) {
+		return errors.New("group not exist")
+	}
+	return db.SaveGroupShare(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare), &db.GroupShareDB{
+		GroupID:   groupID,
+		GranteeID: granteeID,
+		OwnerID:   u.userID,
+		Role:      role,
+	})
+}
+
+// UnshareGroup 所有者取消分享，或者被分享的账号自己退出
+func (u *UserLogic) UnshareGroup(ctx context.Context, groupID uint32, granteeID string) error {
+	if granteeID != u.userID && !u.hasGroup(ctx, groupID) {
+		return errors.New("group not exist")
+	}
+	return db.DeleteGroupShare(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare), groupID, granteeID)
+}
+
+// ListGroupShares 自己分组的全部分享
+func (u *UserLogic) ListGroupShares(ctx context.Context, groupID uint32) ([]protocol.PGroupShare, error) {
+	if !u.hasGroup(ctx, groupID) {
+		return nil, errors.New("group not exist")
+	}
+	shares, err := db.GetSharesByGroup(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroupShare), groupID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load group shares failed"))
+	}
+	res := make([]protocol.PGroupShare, 0, len(shares))
+	for _, share := range shares {
+		res = append(res, protocol.PGroupShare{GranteeID: share.GranteeID, Role: share.Role, CreatedAt: share.CreatedAt})
+	}
+	return res, nil
+}
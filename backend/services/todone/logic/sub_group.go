@@ -12,19 +12,23 @@ import (
 )
 
 type subGroupAutoSave struct {
+	env      *Env
 	LastSave db.SubGroupDB
 	SaveTime time.Time
 	realData *db.SubGroupDB
 	ctx      context.Context
 }
 
-func (a *subGroupAutoSave) Init(data *db.SubGroupDB, ctx context.Context) {
+func (a *subGroupAutoSave) Init(env *Env, data *db.SubGroupDB, ctx context.Context) {
+	a.env = env
 	a.LastSave = *data
 	a.SaveTime = time.Now()
 	a.realData = data
 	a.ctx = ctx
 
+	env.autoSaveWait.Add(1)
 	go func() {
+		defer env.autoSaveWait.Done()
 		for {
 			select {
 			case <-a.ctx.Done():
@@ -43,9 +47,9 @@ func (a *subGroupAutoSave) Init(data *db.SubGroupDB, ctx context.Context) {
 	}()
 }
 
-func NewAutoSave(data *db.SubGroupDB, ctx context.Context) *subGroupAutoSave {
+func NewAutoSave(env *Env, data *db.SubGroupDB, ctx context.Context) *subGroupAutoSave {
 	autoSave := &subGroupAutoSave{}
-	autoSave.Init(data, ctx)
+	autoSave.Init(env, data, ctx)
 	return autoSave
 }
 
@@ -53,32 +57,29 @@ func (a *subGroupAutoSave) NeedSave() bool {
 	if a.realData == nil {
 		return false
 	}
-	if a.LastSave.Title != a.realData.Title ||
-		a.LastSave.Note != a.realData.Note ||
-		a.LastSave.Index != a.realData.Index ||
-		a.LastSave.TaskSequence != a.realData.TaskSequence ||
-		a.LastSave.ParentGroupID != a.realData.ParentGroupID {
-		return true
-	}
-	return false
+	// 标题与备注修改时已经按版本直接写库，这里只保存任务顺序
+	return a.LastSave.TaskSequence != a.realData.TaskSequence
 }
 
 func (a *subGroupAutoSave) Save() {
 	if a.realData == nil {
 		return
 	}
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
-	err := db.UpdateSubGroup(connect, a.realData.ID, a.realData.Title, a.realData.Note, a.realData.Index, a.realData.TaskSequence)
+	// 自动保存不属于任何请求，不能随请求取消
+	connect := a.env.DB.GetConnect(db.ConnectTypeSubGroup)
+	err := db.UpdateSubGroupTaskSequence(connect, a.realData.ID, a.realData.TaskSequence)
 	if err != nil {
-		GTodoneShare.Log.ErrorErr("todone.subgtoup.auto", errors.Join(err, errors.New("AutoSave Save error")))
+		a.env.Log.ErrorErr("todone.subgtoup.auto", errors.Join(err, errors.New("AutoSave Save error")))
 		return
 	}
-	GTodoneShare.Log.Info("todone.subgtoup.auto", "AutoSave Save success %v", a.realData)
+	a.env.Log.Info("todone.subgtoup.auto", "AutoSave Save success %v", a.realData)
 	a.LastSave = *a.realData
 	a.SaveTime = time.Now()
 }
 
 type SubGroupLogic struct {
+	env    *Env
+	userID string
 	dbData *db.SubGroupDB
 
 	unFinTasksLoaded bool
@@ -88,15 +89,18 @@ type SubGroupLogic struct {
 	closeGo func()
 }
 
-func NewSubGroupLogic(dbData *db.SubGroupDB) *SubGroupLogic {
+// NewSubGroupLogic userID 为分组所有者，发布变更时使用
+func NewSubGroupLogic(env *Env, userID string, dbData *db.SubGroupDB) *SubGroupLogic {
 	tree := make(MapIdTree)
 	err := tree.FromJSON(dbData.TaskSequence)
 	if err != nil {
 		return nil
 	}
-	ctx, f := context.WithCancel(GTodoneCtx)
-	NewAutoSave(dbData, ctx)
+	ctx, f := context.WithCancel(env.Ctx)
+	NewAutoSave(env, dbData, ctx)
 	return &SubGroupLogic{
+		env:              env,
+		userID:           userID,
 		dbData:           dbData,
 		unFinTasksCache:  make(map[uint32]*TaskLogic),
 		unFinTasksLoaded: false,
@@ -111,27 +115,28 @@ func (s *SubGroupLogic) GetID() uint32 {
 
 func (s *SubGroupLogic) ToProtocol() protocol.PSubGroup {
 	return protocol.PSubGroup{
-		ID:    s.dbData.ID,
-		Title: s.dbData.Title,
-		Note:  s.dbData.Note,
-		Index: s.dbData.Index,
+		ID:       s.dbData.ID,
+		Title:    s.dbData.Title,
+		Note:     s.dbData.Note,
+		Index:    s.dbData.Index,
+		Revision: s.dbData.Revision,
 	}
 }
 
-func (s *SubGroupLogic) GetTasks(containDone bool) ([]*TaskLogic, error) {
+func (s *SubGroupLogic) GetTasks(ctx context.Context, containDone bool) ([]*TaskLogic, error) {
 	if !containDone {
 		if s.unFinTasksLoaded {
 			return s.GetTasksByCache()
 		}
 	}
 
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
 	tasksDB := db.GetTasksByParentSubGroupID(connect, s.dbData.ID, 0, 0, containDone)
 
 	var res []*TaskLogic
 	for _, taskDB := range tasksDB {
 		newDB := taskDB
-		task := NewTaskLogic(newDB.TaskID)
+		task := NewTaskLogic(s.env, newDB.TaskID)
 		task.OnBindOutData(&newDB)
 		res = append(res, task)
 	}
@@ -141,7 +146,7 @@ func (s *SubGroupLogic) GetTasks(containDone bool) ([]*TaskLogic, error) {
 	for _, task := range res {
 		taskIds = append(taskIds, task.dbData.TaskID)
 	}
-	connTag := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTags)
+	connTag := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
 	if connTag == nil {
 		return nil, errors.New("connTag is nil")
 	}
@@ -157,7 +162,7 @@ func (s *SubGroupLogic) GetTasks(containDone bool) ([]*TaskLogic, error) {
 
 	if containDone {
 		if s.taskSequence == nil {
-			err := s.buildSequenceWithLoadData()
+			err := s.buildSequenceWithLoadData(ctx)
 			if err != nil {
 				return nil, err
 			}
@@ -329,7 +334,7 @@ func (s *SubGroupLogic) OnChangeSeq() error {
 
 func (s *SubGroupLogic) Save() error {
 	// DO NOTHING，尝试下自动保存
-	//connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
+	//connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
 	//err := db.UpdateSubGroup(connect, s.dbData.ID, s.dbData.Title, s.dbData.Note, s.dbData.Index, s.dbData.TaskSequence)
 	//if err != nil {
 	//	return err
@@ -337,15 +342,17 @@ func (s *SubGroupLogic) Save() error {
 	return nil
 }
 
-func (s *SubGroupLogic) CreateTask(userID string, title, note string, taskType db.TaskType, Started bool, parentTaskID uint32) (*TaskLogic, error) {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
-	taskDB, err := db.CreateTask(connect, userID, s.dbData.ID, parentTaskID, title, note, Started, taskType)
+// CreateTask requestID 为空表示不做重试去重，否则先用 FindRequestTask 检查
+func (s *SubGroupLogic) CreateTask(ctx context.Context, userID string, title, note string, taskType db.TaskType, Started bool, parentTaskID uint32, requestID string) (*TaskLogic, error) {
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	taskDB, err := db.CreateTask(connect, userID, s.dbData.ID, parentTaskID, title, note, Started, taskType, requestIDPtr(requestID))
 	if taskDB == nil || err != nil {
 		return nil, err
 	}
-	task := NewTaskLogic(taskDB.TaskID)
+	task := NewTaskLogic(s.env, taskDB.TaskID)
 	task.OnBindOutData(taskDB)
 	task.BindOutTags(make([]string, 0))
+	s.env.publish(ctx, userID, protocol.ChangeKindTask, protocol.ChangeOpCreate, taskDB.TaskID, s.dbData.ID, 0)
 
 	// 更新缓存和序列
 	if s.unFinTasksLoaded {
@@ -360,34 +367,104 @@ func (s *SubGroupLogic) CreateTask(userID string, title, note string, taskType d
 	return task, nil
 }
 
-func (s *SubGroupLogic) OnDelete() error {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
-	err := db.DeleteSubGroup(connect, s.dbData.ID)
+// FindRequestTask 找到同一个请求ID已经创建的任务，没有时返回nil。
+// 已经删除、换了位置或者标题不同说明请求ID被用在了别的创建上，返回ErrTaskRequestConflict
+func (s *SubGroupLogic) FindRequestTask(ctx context.Context, userID, requestID string, parentTaskID uint32, title string) (*TaskLogic, error) {
+	if requestID == "" {
+		return nil, nil
+	}
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	existing, err := db.GetTaskByRequestID(connect, userID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+	if existing.Deleted || existing.ParentSubGroupID != s.dbData.ID || existing.ParentTaskID != parentTaskID || existing.Title != title {
+		return nil, ErrTaskRequestConflict
+	}
+	task := s.GetTaskLogic(ctx, existing.TaskID)
+	if task == nil {
+		return nil, errors.New("task not exist")
+	}
+	return task, nil
+}
+
+// CheckTaskRevisions 移动前检查客户端带回的版本，revisions为空时不检查，否则与taskIDs一一对应
+func (s *SubGroupLogic) CheckTaskRevisions(ctx context.Context, taskIDs, revisions []uint32) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+	if len(revisions) != len(taskIDs) {
+		return errors.New("revisions not match task ids")
+	}
+	for i, taskID := range taskIDs {
+		task := s.GetTaskLogic(ctx, taskID)
+		if task == nil {
+			return errors.New("task not exist")
+		}
+		data, err := task.GetTaskData(ctx)
+		if err != nil {
+			return err
+		}
+		if err = checkRevision(data.Revision, revisions[i], ErrTaskRevisionConflict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SubGroupLogic) OnDelete(ctx context.Context) error {
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	err := db.DeleteSubGroup(connect, s.dbData.ID, time.Now().Unix())
 	if err != nil {
 		return err
 	}
+	s.env.publish(ctx, s.userID, protocol.ChangeKindSubGroup, protocol.ChangeOpDelete, s.dbData.ID, s.dbData.ParentGroupID, 0)
 	s.closeGo()
 	return nil
 }
 
-func (s *SubGroupLogic) ChangeFromProtocol(data protocol.PSubGroup) error {
-	if s.dbData.Title != data.Title {
-		s.dbData.Title = data.Title
+func (s *SubGroupLogic) ChangeFromProtocol(ctx context.Context, data protocol.PSubGroup) error {
+	if err := checkRevision(s.dbData.Revision, data.Revision, ErrSubGroupRevisionConflict); err != nil {
+		return err
 	}
-	if s.dbData.Note != data.Note {
-		s.dbData.Note = data.Note
+	// 保存成功后再修改缓存，失败时缓存保持原样
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	err := db.UpdateSubGroup(connect, s.dbData.ID, s.dbData.Revision, data.Title, data.Note)
+	if err != nil {
+		if errors.Is(err, db.ErrSubGroupRevisionConflict) {
+			s.reload(ctx)
+		}
+		return err
 	}
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeSubGroup)
-	return db.UpdateSubGroup(connect, s.dbData.ID, s.dbData.Title, s.dbData.Note, s.dbData.Index, s.dbData.TaskSequence)
+	s.dbData.Title = data.Title
+	s.dbData.Note = data.Note
+	s.dbData.Revision++
+	s.env.publish(ctx, s.userID, protocol.ChangeKindSubGroup, protocol.ChangeOpChange, s.dbData.ID, s.dbData.ParentGroupID, 0)
+	return nil
 }
 
-func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (MapIdTree, []uint32, []uint32) {
+// reload 版本冲突后从库中重新读取标题、备注与版本，任务顺序以内存为准
+func (s *SubGroupLogic) reload(ctx context.Context) {
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	data, err := db.GetSubGroupsByIDs(connect, []uint32{s.dbData.ID})
+	if err != nil || len(data) == 0 {
+		return
+	}
+	s.dbData.Title = data[0].Title
+	s.dbData.Note = data[0].Note
+	s.dbData.Revision = data[0].Revision
+}
+
+func (s *SubGroupLogic) BeforeTaskMove(ctx context.Context, taskIDs []uint32, newParentID uint32) (MapIdTree, []uint32, []uint32) {
 	// 获取所有的任务
-	tasks, err := s.GetTasks(true)
+	tasks, err := s.GetTasks(ctx, true)
 	if err != nil {
 		return nil, nil, nil
 	}
-	err = s.buildSequenceWithLoadData()
+	err = s.buildSequenceWithLoadData(ctx)
 	if err != nil {
 		return nil, nil, nil
 	}
@@ -395,7 +472,7 @@ func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (Ma
 	// 构建 taskID -> *TaskLogic 映射
 	taskMap := make(map[uint32]*TaskLogic)
 	for _, t := range tasks {
-		taskData, _ := t.GetTaskData()
+		taskData, _ := t.GetTaskData(ctx)
 		taskMap[taskData.TaskID] = t
 	}
 
@@ -408,7 +485,7 @@ func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (Ma
 				moveSet.Add(id)
 			}
 			for _, t := range tasks {
-				taskData, _ := t.GetTaskData()
+				taskData, _ := t.GetTaskData(ctx)
 				if taskData.ParentTaskID == id {
 					collect([]uint32{taskData.TaskID})
 				}
@@ -425,7 +502,7 @@ func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (Ma
 		if task == nil {
 			continue
 		}
-		taskData, _ := task.GetTaskData()
+		taskData, _ := task.GetTaskData(ctx)
 		if taskData.ParentTaskID != 0 {
 			if _, parentMoved := moveSet[taskData.ParentTaskID]; parentMoved {
 				noNeedChangeParent = append(noNeedChangeParent, id)
@@ -461,7 +538,7 @@ func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (Ma
 	}
 	// 不需要更改父节点的任务ID，根据原来的顺序来排序，不管是否完成因为多加的会被删掉
 	for _, taskID := range noNeedChangeParent {
-		task := s.GetTaskLogic(taskID)
+		task := s.GetTaskLogic(ctx, taskID)
 		if task == nil {
 			continue
 		}
@@ -491,7 +568,7 @@ func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (Ma
 	}
 	err = s.OnChangeSeq()
 	if err != nil {
-		GTodoneShare.Log.ErrorErr("todone.subgtoup.auto", errors.Join(err, errors.New("OnChangeSeq error")))
+		s.env.Log.ErrorErr("todone.subgtoup.auto", errors.Join(err, errors.New("OnChangeSeq error")))
 		return nil, nil, nil
 	}
 
@@ -506,9 +583,9 @@ func (s *SubGroupLogic) BeforeTaskMove(taskIDs []uint32, newParentID uint32) (Ma
 	return seq, needChangeParent, noNeedChangeParent
 }
 
-func (s *SubGroupLogic) AfterTaskMove(seq MapIdTree, needChangeParent, noNeedChangeParent []uint32, newParentID, newAfterID uint32, after bool) error {
+func (s *SubGroupLogic) AfterTaskMove(ctx context.Context, seq MapIdTree, needChangeParent, noNeedChangeParent []uint32, newParentID, newAfterID uint32, after bool) error {
 	if !s.unFinTasksLoaded {
-		err := s.buildSequenceWithLoadData()
+		err := s.buildSequenceWithLoadData(ctx)
 		if err != nil {
 			return err
 		}
@@ -522,8 +599,13 @@ func (s *SubGroupLogic) AfterTaskMove(seq MapIdTree, needChangeParent, noNeedCha
 	for _, taskID := range noNeedChangeParent {
 		allIDs = append(allIDs, taskID)
 	}
-	conn := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
-	err := db.UpdateTasksParentTaskID(conn, newParentID, needChangeParent)
+	conn := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	// 修改前读出任务，记下原来的子分组用于发布变更，之后在内存中同步修改后的父节点
+	dbs, err := db.GetTaskByIds(conn, allIDs)
+	if err != nil {
+		return errors.Join(err, errors.New("GetTaskByIds error"))
+	}
+	err = db.UpdateTasksParentTaskID(conn, newParentID, needChangeParent)
 	if err != nil {
 		return errors.Join(err, errors.New("UpdateTasksParentTaskID error"))
 	}
@@ -531,6 +613,19 @@ func (s *SubGroupLogic) AfterTaskMove(seq MapIdTree, needChangeParent, noNeedCha
 	if err != nil {
 		return errors.Join(err, errors.New("UpdateTasksSubGroupID error"))
 	}
+	changeParent := make(map[uint32]bool, len(needChangeParent))
+	for _, taskID := range needChangeParent {
+		changeParent[taskID] = true
+	}
+	fromSubGroup := make(map[uint32]uint32, len(dbs))
+	for i := range dbs {
+		fromSubGroup[dbs[i].TaskID] = dbs[i].ParentSubGroupID
+		dbs[i].ParentSubGroupID = s.dbData.ID
+		dbs[i].Revision++
+		if changeParent[dbs[i].TaskID] {
+			dbs[i].ParentTaskID = newParentID
+		}
+	}
 
 	// 合并序列
 	// 先插入子任务们
@@ -583,13 +678,9 @@ func (s *SubGroupLogic) AfterTaskMove(seq MapIdTree, needChangeParent, noNeedCha
 	}
 
 	// 插入缓存
-	conn = db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
-	dbs, err := db.GetTaskByIds(conn, allIDs)
-	if err != nil {
-		return errors.Join(err, errors.New("GetTaskByIds error"))
-	}
 	for _, taskDB := range dbs {
-		task := NewTaskLogic(taskDB.TaskID)
+		s.env.publish(ctx, s.userID, protocol.ChangeKindTask, protocol.ChangeOpMove, taskDB.TaskID, s.dbData.ID, fromSubGroup[taskDB.TaskID])
+		task := NewTaskLogic(s.env, taskDB.TaskID)
 		task.OnBindOutData(&taskDB)
 		if taskDB.Deleted || taskDB.Done {
 			continue
@@ -597,7 +688,7 @@ func (s *SubGroupLogic) AfterTaskMove(seq MapIdTree, needChangeParent, noNeedCha
 		s.unFinTasksCache[task.dbData.TaskID] = task
 		task.BindOutIndex(s.taskSequence.GetSequenceOrAdd(task.dbData.ParentTaskID, task.dbData.TaskID))
 	}
-	conn = db.GTodoneDBMgr.GetConnect(db.ConnectTypeTags)
+	conn = s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
 	if conn == nil {
 		return errors.New("connTag is nil")
 	}
@@ -610,8 +701,8 @@ func (s *SubGroupLogic) AfterTaskMove(seq MapIdTree, needChangeParent, noNeedCha
 	return nil
 }
 
-func (s *SubGroupLogic) buildSequenceWithLoadData() error {
-	tasks, err := s.GetTasks(false)
+func (s *SubGroupLogic) buildSequenceWithLoadData(ctx context.Context) error {
+	tasks, err := s.GetTasks(ctx, false)
 	if err != nil {
 		return errors.Join(err, errors.New("GetTasks error"))
 	}
@@ -622,11 +713,12 @@ func (s *SubGroupLogic) buildSequenceWithLoadData() error {
 	return nil
 }
 
-func (s *SubGroupLogic) OnDeleteTasks(taskIDs []uint32) error {
+func (s *SubGroupLogic) OnDeleteTasks(ctx context.Context, taskIDs []uint32) error {
 	hasUnFin := false
+	deletedUnix := time.Now().Unix()
 	for _, taskID := range taskIDs {
-		task := NewTaskLogic(taskID)
-		err := task.Delete()
+		task := NewTaskLogic(s.env, taskID)
+		err := task.deleteAt(ctx, deletedUnix)
 		if err != nil {
 			return err
 		}
@@ -637,7 +729,7 @@ func (s *SubGroupLogic) OnDeleteTasks(taskIDs []uint32) error {
 	// 如果存在未完成的任务，则删除缓存并且删除序列
 	if hasUnFin {
 		if !s.unFinTasksLoaded {
-			err := s.buildSequenceWithLoadData()
+			err := s.buildSequenceWithLoadData(ctx)
 			if err != nil {
 				return errors.Join(err, errors.New("buildSequenceWithLoadData error"))
 			}
@@ -664,19 +756,19 @@ func (s *SubGroupLogic) OnDeleteTasks(taskIDs []uint32) error {
 	return nil
 }
 
-func (s *SubGroupLogic) GetTaskLogic(id uint32) *TaskLogic {
+func (s *SubGroupLogic) GetTaskLogic(ctx context.Context, id uint32) *TaskLogic {
 	if s.unFinTasksCache != nil {
 		if task, ok := s.unFinTasksCache[id]; ok {
 			return task
 		}
 	}
 
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
+	connect := s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
 	taskDB, err := db.GetTaskByID(connect, id)
 	if err != nil {
 		return nil
 	}
-	task := NewTaskLogic(id)
+	task := NewTaskLogic(s.env, id)
 	task.OnBindOutData(taskDB)
 	if taskDB.Deleted {
 		return nil
@@ -686,6 +778,23 @@ func (s *SubGroupLogic) GetTaskLogic(id uint32) *TaskLogic {
 	return task
 }
 
+// FindTask 与 GetTaskLogic 相同，但是任务不在这个子分组中时返回nil，也不会把它加入序列
+func (s *SubGroupLogic) FindTask(ctx context.Context, id uint32) *TaskLogic {
+	if s.unFinTasksCache != nil {
+		if task, ok := s.unFinTasksCache[id]; ok {
+			return task
+		}
+	}
+	taskDB, err := db.GetTaskByID(s.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), id)
+	if err != nil || taskDB == nil || taskDB.Deleted || taskDB.ParentSubGroupID != s.dbData.ID {
+		return nil
+	}
+	task := NewTaskLogic(s.env, id)
+	task.OnBindOutData(taskDB)
+	task.BindOutIndex(s.taskSequence.GetSequenceOrAdd(taskDB.ParentTaskID, taskDB.TaskID))
+	return task
+}
+
 func (s *SubGroupLogic) RefreshCache(task *TaskLogic) error {
 	// 如果缓存中没有数据，就将其加入缓存，并在下次请求时（加入seq）。
 	if !s.unFinTasksLoaded {
@@ -702,3 +811,31 @@ func (s *SubGroupLogic) RefreshCache(task *TaskLogic) error {
 	}
 	return nil
 }
+
+// SubGroupCheckpoint 子分组内存数据的副本。任务序列只在内存中修改、由自动保存落盘，
+// 数据库事务回滚后用 Restore 把序列恢复到事务前，并丢弃任务缓存重新从数据库加载
+type SubGroupCheckpoint struct {
+	subGroup *SubGroupLogic
+	data     db.SubGroupDB
+}
+
+func (s *SubGroupLogic) Checkpoint() (SubGroupCheckpoint, error) {
+	data := *s.dbData
+	sequence, err := s.taskSequence.JSON()
+	if err != nil {
+		return SubGroupCheckpoint{}, errors.Join(err, errors.New("taskSequence JSON error"))
+	}
+	data.TaskSequence = sequence
+	return SubGroupCheckpoint{subGroup: s, data: data}, nil
+}
+
+func (c SubGroupCheckpoint) Restore() error {
+	tree := make(MapIdTree)
+	if err := tree.FromJSON(c.data.TaskSequence); err != nil {
+		return errors.Join(err, errors.New("taskSequence JSON error"))
+	}
+	*c.subGroup.dbData = c.data
+	c.subGroup.taskSequence = tree
+	c.subGroup.dropTaskCache()
+	return nil
+}
@@ -0,0 +1,236 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+var (
+	ErrTagEmpty        = errors.New("tag empty")
+	ErrTagNotExist     = errors.New("tag not exist")
+	ErrTagColorInvalid = errors.New("tag color invalid")
+)
+
+// tagColorPattern 颜色只接受 #rrggbb，为空表示默认样式
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ListTags 标签目录。设置过展示信息但已经没有任务使用的标签也会列出，次数为0。
+// 置顶的在前，其余按使用次数从多到少，次数相同时按名称
+func (u *UserLogic) ListTags(ctx context.Context) ([]protocol.PTag, error) {
+	counts, err := db.GetTagCounts(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load tag counts failed"))
+	}
+	metas, err := db.GetTagMetas(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load tag metas failed"))
+	}
+	tags := make(map[string]*protocol.PTag, len(counts)+len(metas))
+	for _, count := range counts {
+		tags[count.Tag] = &protocol.PTag{Tag: count.Tag, Count: count.Count}
+	}
+	for _, meta := range metas {
+		tag, ok := tags[meta.Tag]
+		if !ok {
+			tag = &protocol.PTag{Tag: meta.Tag}
+			tags[meta.Tag] = tag
+		}
+		tag.Color = meta.Color
+		tag.Pinned = meta.Pinned
+	}
+	res := make([]protocol.PTag, 0, len(tags))
+	for _, tag := range tags {
+		res = append(res, *tag)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Pinned != res[j].Pinned {
+			return res[i].Pinned
+		}
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Tag < res[j].Tag
+	})
+	return res, nil
+}
+
+// RenameTag 在所有任务上改名，目标名称已经在使用时返回ErrTagAlreadyExists，需要合并时使用MergeTags
+func (u *UserLogic) RenameTag(ctx context.Context, from, to string) error {
+	to = strings.TrimSpace(to)
+	if from == "" || to == "" {
+		return ErrTagEmpty
+	}
+	if from == to {
+		return nil
+	}
+	used, err := db.GetTaskIDsByTags(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags), u.userID, []string{to})
+	if err != nil {
+		return errors.Join(err, errors.New("load tag tasks failed"))
+	}
+	if len(used) > 0 {
+		return ErrTagAlreadyExists
+	}
+	return u.MergeTags(ctx, []string{from}, to)
+}
+
+// MergeTags 把from中的标签在所有任务上并入to，同时带有多个的任务只保留一个to。
+// to可以是新标签；展示信息沿用to已有的，没有时沿用from中的第一个
+func (u *UserLogic) MergeTags(ctx context.Context, from []string, to string) error {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return ErrTagEmpty
+	}
+	sources := make([]string, 0, len(from))
+	for _, tag := range from {
+		if tag != "" && tag != to && !slices.Contains(sources, tag) {
+			sources = append(sources, tag)
+		}
+	}
+	if len(sources) == 0 {
+		return ErrTagEmpty
+	}
+	tagConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
+	metaConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta)
+	taskIDs, err := db.GetTaskIDsByTags(tagConn, u.userID, sources)
+	if err != nil {
+		return errors.Join(err, errors.New("load tag tasks failed"))
+	}
+	if len(taskIDs) == 0 {
+		exist, err := u.hasTagMeta(ctx, sources)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return ErrTagNotExist
+		}
+	}
+	for _, tag := range sources {
+		if err = db.RenameTag(tagConn, u.userID, tag, to); err != nil {
+			return errors.Join(err, errors.New("rename tag failed"))
+		}
+		if err = db.RenameTagMeta(metaConn, u.userID, tag, to); err != nil {
+			return errors.Join(err, errors.New("rename tag meta failed"))
+		}
+	}
+	u.updateCachedTags(func(tags []string) []string {
+		res := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if slices.Contains(sources, tag) {
+				tag = to
+			}
+			if !slices.Contains(res, tag) {
+				res = append(res, tag)
+			}
+		}
+		return res
+	})
+	u.publishTagChanges(ctx, taskIDs)
+	return nil
+}
+
+// DeleteTag 从所有任务上去掉该标签，并删除它的展示信息
+func (u *UserLogic) DeleteTag(ctx context.Context, tag string) error {
+	if tag == "" {
+		return ErrTagEmpty
+	}
+	tagConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
+	taskIDs, err := db.GetTaskIDsByTags(tagConn, u.userID, []string{tag})
+	if err != nil {
+		return errors.Join(err, errors.New("load tag tasks failed"))
+	}
+	if len(taskIDs) == 0 {
+		exist, err := u.hasTagMeta(ctx, []string{tag})
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return ErrTagNotExist
+		}
+	}
+	if err = db.DeleteTagByUser(tagConn, u.userID, tag); err != nil {
+		return errors.Join(err, errors.New("delete tag failed"))
+	}
+	if err = db.DeleteTagMeta(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta), u.userID, tag); err != nil {
+		return errors.Join(err, errors.New("delete tag meta failed"))
+	}
+	u.updateCachedTags(func(tags []string) []string {
+		return slices.DeleteFunc(slices.Clone(tags), func(t string) bool { return t == tag })
+	})
+	u.publishTagChanges(ctx, taskIDs)
+	return nil
+}
+
+// SetTagMeta 设置标签的颜色与置顶，标签还没有任务使用时也可以预先设置
+func (u *UserLogic) SetTagMeta(ctx context.Context, tag, color string, pinned bool) error {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return ErrTagEmpty
+	}
+	if color != "" && !tagColorPattern.MatchString(color) {
+		return ErrTagColorInvalid
+	}
+	meta := db.TagMetaDB{UserID: u.userID, Tag: tag, Color: strings.ToLower(color), Pinned: pinned, UpdatedAt: time.Now()}
+	if err := db.SaveTagMeta(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta), &meta); err != nil {
+		return errors.Join(err, errors.New("save tag meta failed"))
+	}
+	return nil
+}
+
+func (u *UserLogic) hasTagMeta(ctx context.Context, tags []string) (bool, error) {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta)
+	for _, tag := range tags {
+		meta, err := db.GetTagMeta(conn, u.userID, tag)
+		if err != nil {
+			return false, errors.Join(err, errors.New("load tag meta failed"))
+		}
+		if meta != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// updateCachedTags 改写已经加载到内存的任务标签，没有加载的下次从库中读取
+func (u *UserLogic) updateCachedTags(f func(tags []string) []string) {
+	for _, node := range u.dirMap {
+		for _, group := range node.groups {
+			for _, subGroup := range group.subGroups {
+				for _, task := range subGroup.unFinTasksCache {
+					task.updateCachedTags(f)
+				}
+			}
+		}
+	}
+}
+
+func (t *TaskLogic) updateCachedTags(f func(tags []string) []string) {
+	if t.tagsDB != nil {
+		t.tagsDB = f(t.tagsDB)
+	}
+	for _, child := range t.children {
+		child.updateCachedTags(f)
+	}
+}
+
+// publishTagChanges 批量修改标签后逐个任务发布变更，修改已经落库，失败只记录日志
+func (u *UserLogic) publishTagChanges(ctx context.Context, taskIDs []uint32) {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	for i := 0; i < len(taskIDs); i += db.MaxInSize {
+		tasks, err := db.GetTaskByIds(conn, taskIDs[i:min(i+db.MaxInSize, len(taskIDs))])
+		if err != nil {
+			u.env.Log.WarningErr("TODONE", errors.Join(err, errors.New("load tag tasks failed")))
+			return
+		}
+		for _, task := range tasks {
+			u.env.publish(ctx, u.userID, protocol.ChangeKindTask, protocol.ChangeOpTag, task.TaskID, task.ParentSubGroupID, 0)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package logic
 
 import (
+	"context"
 	"errors"
 	"github.com/intmian/platform/backend/services/todone/db"
 	"github.com/intmian/platform/backend/services/todone/protocol"
 	"math"
+	"slices"
+	"time"
 )
 
 /*
@@ -15,6 +18,7 @@ import (
 */
 
 type TaskLogic struct {
+	env         *Env
 	dbData      *db.TaskDB
 	hasChildren *bool
 	children    []*TaskLogic
@@ -26,9 +30,10 @@ type TaskLogic struct {
 	id uint32
 }
 
-func NewTaskLogic(ID uint32) *TaskLogic {
+func NewTaskLogic(env *Env, ID uint32) *TaskLogic {
 	return &TaskLogic{
-		id: ID,
+		env: env,
+		id:  ID,
 	}
 }
 
@@ -53,13 +58,13 @@ func (t *TaskLogic) BindOutIndex(index int) {
 	t.index = index
 }
 
-func (t *TaskLogic) GetTaskData() (*db.TaskDB, error) {
+func (t *TaskLogic) GetTaskData(ctx context.Context) (*db.TaskDB, error) {
 	if t.dbData != nil {
 		return t.dbData, nil
 	}
 
 	// 从数据库中获取数据
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
+	connect := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
 	taskDB, err := db.GetTaskByID(connect, t.id)
 	if err != nil || taskDB == nil {
 		return nil, errors.Join(err, ErrGetTaskDataFailed)
@@ -68,34 +73,34 @@ func (t *TaskLogic) GetTaskData() (*db.TaskDB, error) {
 	return taskDB, nil
 }
 
-func (t *TaskLogic) GetTags() ([]string, error) {
+func (t *TaskLogic) GetTags(ctx context.Context) ([]string, error) {
 	if t.tagsDB != nil {
 		return t.tagsDB, nil
 	}
 
 	// 从数据库中获取数据
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTags)
+	connect := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
 	tagsDB := db.GetTagsByTaskID(connect, t.id)
 	t.tagsDB = tagsDB
 	return tagsDB, nil
 }
 
-func (t *TaskLogic) GetChildren() ([]*TaskLogic, error) {
+func (t *TaskLogic) GetChildren(ctx context.Context) ([]*TaskLogic, error) {
 	if t.children != nil {
 		return t.children, nil
 	}
 
-	res := t.LoadChildren()
+	res := t.LoadChildren(ctx)
 	return res, nil
 }
 
-func (t *TaskLogic) LoadChildren() []*TaskLogic {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
+func (t *TaskLogic) LoadChildren(ctx context.Context) []*TaskLogic {
+	connect := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
 	tasksDB := db.GetTasksByParentTaskID(connect, t.id)
 	var res []*TaskLogic
 	for _, taskDB := range tasksDB {
 		newDB := taskDB
-		task := NewTaskLogic(newDB.TaskID)
+		task := NewTaskLogic(t.env, newDB.TaskID)
 		task.OnBindOutData(&newDB)
 		res = append(res, task)
 	}
@@ -103,8 +108,8 @@ func (t *TaskLogic) LoadChildren() []*TaskLogic {
 	return res
 }
 
-func (t *TaskLogic) AddTag(tag string) error {
-	tags, err := t.GetTags()
+func (t *TaskLogic) AddTag(ctx context.Context, tag string) error {
+	tags, err := t.GetTags(ctx)
 	if err != nil {
 		return errors.Join(err, ErrGetTagsFailed)
 	}
@@ -113,43 +118,62 @@ func (t *TaskLogic) AddTag(tag string) error {
 			return ErrTagAlreadyExists
 		}
 	}
+	tagsDB := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
+	if err = db.AddTags(tagsDB, t.dbData.UserID, t.id, tag); err != nil {
+		return err
+	}
 	t.tagsDB = append(t.tagsDB, tag)
-	tagsDB := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTags)
-	err = db.AddTags(tagsDB, t.dbData.UserID, t.id, tag)
+	t.publish(ctx, protocol.ChangeOpTag)
 	return nil
 }
 
-func (t *TaskLogic) RemoveTag(tag string) error {
-	tags, err := t.GetTags()
+func (t *TaskLogic) RemoveTag(ctx context.Context, tag string) error {
+	tags, err := t.GetTags(ctx)
 	if err != nil {
 		return errors.Join(err, ErrGetTagsFailed)
 	}
-	for i, tag2 := range tags {
-		if tag2 == tag {
-			t.tagsDB = append(tags[:i], tags[i+1:]...)
-			break
-		}
+	tagsDB := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
+	if err = db.DeleteTag(tagsDB, t.id, tag); err != nil {
+		return err
 	}
-	tagsDB := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTags)
-	err = db.DeleteTag(tagsDB, t.id, tag)
+	t.tagsDB = slices.DeleteFunc(slices.Clone(tags), func(tag2 string) bool {
+		return tag2 == tag
+	})
+	t.publish(ctx, protocol.ChangeOpTag)
 	return nil
 }
 
-func (t *TaskLogic) RemoveAllTags() error {
-	tagsDB := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTags)
+func (t *TaskLogic) RemoveAllTags(ctx context.Context) error {
+	tagsDB := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
 	err := db.DeleteTagByTaskID(tagsDB, t.id)
 	t.tagsDB = nil
 	return err
 }
 
-func (t *TaskLogic) BindParentTask(parentID uint32) error {
+func (t *TaskLogic) BindParentTask(ctx context.Context, parentID uint32) error {
 	t.dbData.ParentTaskID = parentID
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
-	return db.UpdateTask(connect, t.dbData)
+	return t.save(ctx, t.dbData)
+}
+
+// save 按读取时的版本写回任务数据，版本冲突说明缓存已经过期(其他实例改过)，从库中重新读取覆盖缓存
+func (t *TaskLogic) save(ctx context.Context, data *db.TaskDB) error {
+	connect := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	err := db.UpdateTask(connect, data)
+	if errors.Is(err, db.ErrTaskRevisionConflict) && t.dbData != nil {
+		if fresh, getErr := db.GetTaskByID(connect, t.dbData.TaskID); getErr == nil && fresh != nil {
+			*t.dbData = *fresh
+		}
+	}
+	return err
 }
 
-func (t *TaskLogic) Delete() error {
-	data, err := t.GetTaskData()
+func (t *TaskLogic) Delete(ctx context.Context) error {
+	return t.deleteAt(ctx, time.Now().Unix())
+}
+
+// deleteAt 放入回收站，同一次删除的任务使用相同的删除时间
+func (t *TaskLogic) deleteAt(ctx context.Context, deletedUnix int64) error {
+	data, err := t.GetTaskData(ctx)
 	if err != nil {
 		return errors.Join(err, ErrGetTaskDataFailed)
 	}
@@ -160,12 +184,16 @@ func (t *TaskLogic) Delete() error {
 		return errors.New("task already deleted")
 	}
 	data.Deleted = true
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
-	return db.UpdateTask(connect, data)
+	data.DeletedUnix = deletedUnix
+	if err = t.save(ctx, data); err != nil {
+		return err
+	}
+	t.publish(ctx, protocol.ChangeOpDelete)
+	return nil
 }
 
-func (t *TaskLogic) GeneSubTaskIndex() float32 {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
+func (t *TaskLogic) GeneSubTaskIndex(ctx context.Context) float32 {
+	connect := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
 	maxIndex := db.GetSubTaskMaxIndex(connect, t.dbData.TaskID)
 	if math.Floor(float64(maxIndex)) == float64(maxIndex) {
 		return maxIndex + 1
@@ -174,34 +202,40 @@ func (t *TaskLogic) GeneSubTaskIndex() float32 {
 	}
 }
 
-func (t *TaskLogic) HasSubTask() (bool, error) {
+func (t *TaskLogic) HasSubTask(ctx context.Context) (bool, error) {
 	if t.hasChildren != nil {
 		return *t.hasChildren, nil
 	}
 
-	hasSubTask := t.LoadHasChildren()
+	hasSubTask := t.LoadHasChildren(ctx)
 	return hasSubTask, nil
 }
 
-func (t *TaskLogic) LoadHasChildren() bool {
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
+func (t *TaskLogic) LoadHasChildren(ctx context.Context) bool {
+	connect := t.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
 	hasSubTask := db.GetHasSubTask(connect, t.id)
 	t.hasChildren = &hasSubTask
 	return hasSubTask
 }
 
-func (t *TaskLogic) ToProtocol() protocol.PTask {
-	data, _ := t.GetTaskData()
-	tags, _ := t.GetTags()
-	var pTask protocol.PTask
+func (t *TaskLogic) ToProtocol(ctx context.Context) protocol.PTask {
+	data, _ := t.GetTaskData(ctx)
+	tags, _ := t.GetTags(ctx)
 	if data == nil {
-		return pTask
+		return protocol.PTask{}
 	}
+	pTask := taskDBToProtocol(data, tags)
+	pTask.Index = float32(t.index)
+	return pTask
+}
+
+// taskDBToProtocol 不含排序信息的转换，用于不经过子分组缓存的场景
+func taskDBToProtocol(data *db.TaskDB, tags []string) protocol.PTask {
+	var pTask protocol.PTask
 	pTask.ID = data.TaskID
 	pTask.Title = data.Title
 	pTask.Note = data.Note
 	pTask.Done = data.Done
-	pTask.Index = float32(t.index)
 	pTask.Tags = tags
 	pTask.ParentID = data.ParentTaskID
 
@@ -210,6 +244,9 @@ func (t *TaskLogic) ToProtocol() protocol.PTask {
 	pTask.BeginTime = data.BeginTime
 	pTask.EndTime = data.EndTime
 	pTask.Wait4 = data.Wait4
+	// 规则损坏时当作不重复展示，不影响任务本身
+	pTask.Repeat, _ = parseRepeatRule(data.Repeat)
+	pTask.Revision = data.Revision
 
 	return pTask
 }
@@ -218,35 +255,36 @@ func (t *TaskLogic) GetID() uint32 {
 	return t.id
 }
 
-func (t *TaskLogic) ChangeFromProtocol(pTask protocol.PTask) error {
-	data, err := t.GetTaskData()
+func (t *TaskLogic) ChangeFromProtocol(ctx context.Context, pTask protocol.PTask) error {
+	data, err := t.GetTaskData(ctx)
 	if err != nil {
 		return errors.Join(err, ErrGetTaskDataFailed)
 	}
-	if pTask.Title != data.Title {
-		data.Title = pTask.Title
-	}
-	if pTask.Note != data.Note {
-		data.Note = pTask.Note
-	}
-	if pTask.Done != data.Done {
-		data.Done = pTask.Done
+	if err = checkRevision(data.Revision, pTask.Revision, ErrTaskRevisionConflict); err != nil {
+		return err
 	}
-	if pTask.Started != data.Started {
-		data.Started = pTask.Started
+	// 在副本上修改，保存成功后再替换缓存，失败时缓存保持原样
+	changed := *data
+	changed.Title = pTask.Title
+	changed.Note = pTask.Note
+	changed.Done = pTask.Done
+	changed.Started = pTask.Started
+	changed.BeginTime = pTask.BeginTime
+	changed.EndTime = pTask.EndTime
+	changed.Wait4 = pTask.Wait4
+	changed.TaskType = db.TaskType(pTask.TaskType)
+	if err = t.save(ctx, &changed); err != nil {
+		return err
 	}
-	if pTask.BeginTime != data.BeginTime {
-		data.BeginTime = pTask.BeginTime
-	}
-	if pTask.EndTime != data.EndTime {
-		data.EndTime = pTask.EndTime
-	}
-	if pTask.Wait4 != data.Wait4 {
-		data.Wait4 = pTask.Wait4
-	}
-	if pTask.TaskType != int(data.TaskType) {
-		data.TaskType = db.TaskType(pTask.TaskType)
+	*data = changed
+	t.publish(ctx, protocol.ChangeOpChange)
+	return nil
+}
+
+// publish 发布任务的变更，任务数据需要已经加载
+func (t *TaskLogic) publish(ctx context.Context, op string) {
+	if t.dbData == nil {
+		return
 	}
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeTask)
-	return db.UpdateTask(connect, data)
+	t.env.publish(ctx, t.dbData.UserID, protocol.ChangeKindTask, op, t.dbData.TaskID, t.dbData.ParentSubGroupID, 0)
 }
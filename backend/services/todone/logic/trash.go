@@ -0,0 +1,399 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+var (
+	ErrTrashItemNotExist = errors.New("trash item not exist")
+	// ErrTrashParentMissing 原来的父节点已经不在，需要指定新的父节点
+	ErrTrashParentMissing = errors.New("trash item parent missing")
+)
+
+// ListTrash 回收站中的全部内容，按删除时间倒序。retention 大于0时给出自动清理的时间
+func (u *UserLogic) ListTrash(ctx context.Context, retention time.Duration) ([]protocol.PTrashItem, error) {
+	if err := u.loadDirTree(ctx); err != nil {
+		return nil, err
+	}
+	res := make([]protocol.PTrashItem, 0)
+	dirs, err := db.GetDeletedDirs(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load deleted dirs failed"))
+	}
+	for _, dir := range dirs {
+		_, ok := u.dirMap[dir.ParentID]
+		res = append(res, protocol.PTrashItem{
+			Type:          protocol.TrashTypeDir,
+			ID:            dir.ID,
+			Title:         dir.Title,
+			ParentID:      dir.ParentID,
+			ParentMissing: !ok,
+			DeletedAt:     unixTime(dir.DeletedUnix),
+		})
+	}
+	groups, err := db.GetDeletedGroups(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load deleted groups failed"))
+	}
+	for _, group := range groups {
+		_, ok := u.dirMap[group.ParentDir]
+		res = append(res, protocol.PTrashItem{
+			Type:          protocol.TrashTypeGroup,
+			ID:            group.ID,
+			Title:         group.Title,
+			ParentID:      group.ParentDir,
+			ParentMissing: !ok,
+			DeletedAt:     unixTime(group.DeletedUnix),
+		})
+	}
+	subGroups, err := db.GetDeletedSubGroups(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load deleted sub groups failed"))
+	}
+	locations := u.groupLocations()
+	for _, subGroup := range subGroups {
+		_, ok := locations[subGroup.ParentGroupID]
+		res = append(res, protocol.PTrashItem{
+			Type:          protocol.TrashTypeSubGroup,
+			ID:            subGroup.ID,
+			Title:         subGroup.Title,
+			ParentID:      subGroup.ParentGroupID,
+			ParentMissing: !ok,
+			DeletedAt:     unixTime(subGroup.DeletedUnix),
+		})
+	}
+	tasks, err := db.GetDeletedTasks(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load deleted tasks failed"))
+	}
+	deleted := make(map[uint32]db.TaskDB, len(tasks))
+	for _, task := range tasks {
+		deleted[task.TaskID] = task
+	}
+	liveSubGroups := make(map[uint32]bool)
+	for _, task := range tasks {
+		if parent, ok := deleted[task.ParentTaskID]; ok && parent.DeletedUnix == task.DeletedUnix {
+			// 与父任务一起删除的，随父任务恢复
+			continue
+		}
+		live, ok := liveSubGroups[task.ParentSubGroupID]
+		if !ok {
+			_, err := u.subGroupByID(ctx, task.ParentSubGroupID)
+			live = err == nil
+			liveSubGroups[task.ParentSubGroupID] = live
+		}
+		res = append(res, protocol.PTrashItem{
+			Type:          protocol.TrashTypeTask,
+			ID:            task.TaskID,
+			Title:         task.Title,
+			ParentID:      task.ParentSubGroupID,
+			ParentTaskID:  task.ParentTaskID,
+			ParentMissing: !live,
+			DeletedAt:     unixTime(task.DeletedUnix),
+		})
+	}
+
+	for i := range res {
+		if retention > 0 && !res[i].DeletedAt.IsZero() {
+			res[i].ExpireAt = res[i].DeletedAt.Add(retention)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].DeletedAt.After(res[j].DeletedAt)
+	})
+	return res, nil
+}
+
+// unixTime 旧数据没有删除时间，返回零值
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// RestoreTrash 把回收站中的一项连同其下的内容恢复到parentID下，parentID为0时恢复到原来的父节点。
+// 目录与分组的父节点是目录，子分组的父节点是分组，任务的父节点是子分组。
+func (u *UserLogic) RestoreTrash(ctx context.Context, itemType string, id, parentID uint32) error {
+	if err := u.loadDirTree(ctx); err != nil {
+		return err
+	}
+	switch itemType {
+	case protocol.TrashTypeDir:
+		return u.restoreDir(ctx, id, parentID)
+	case protocol.TrashTypeGroup:
+		return u.restoreGroup(ctx, id, parentID)
+	case protocol.TrashTypeSubGroup:
+		return u.restoreSubGroup(ctx, id, parentID)
+	case protocol.TrashTypeTask:
+		return u.restoreTrashTask(ctx, id, parentID)
+	default:
+		return errors.New("unknown trash type " + itemType)
+	}
+}
+
+// nextIndex 目录下新放入的节点排在最后
+func (n *dirTreeNode) nextIndex() float32 {
+	maxIndex := float32(0)
+	for _, child := range n.childs {
+		if child.dir.dbData.Index > maxIndex {
+			maxIndex = child.dir.dbData.Index
+		}
+	}
+	for _, group := range n.groups {
+		if group.dbData.Index > maxIndex {
+			maxIndex = group.dbData.Index
+		}
+	}
+	return maxIndex + 1
+}
+
+func (u *UserLogic) restoreDir(ctx context.Context, id, parentID uint32) error {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
+	dir, err := db.GetDir(conn, id)
+	if err != nil || dir.UserID != u.userID || !dir.Deleted {
+		return ErrTrashItemNotExist
+	}
+	if parentID == 0 {
+		parentID = dir.ParentID
+	}
+	parent, ok := u.dirMap[parentID]
+	if !ok {
+		return ErrTrashParentMissing
+	}
+	dir.Deleted = false
+	dir.DeletedUnix = 0
+	dir.ParentID = parentID
+	dir.Index = parent.nextIndex()
+	if err = db.ChangeDir(conn, dir); err != nil {
+		return errors.Join(err, errors.New("save dir failed"))
+	}
+	l := NewDirLogic(u.env, dir.ID)
+	l.OnBindOutData(dir)
+	node := &dirTreeNode{dir: l}
+	u.dirMap[dir.ID] = node
+	parent.childs = append(parent.childs, node)
+	u.env.publish(ctx, u.userID, protocol.ChangeKindDir, protocol.ChangeOpCreate, dir.ID, parentID, 0)
+	return nil
+}
+
+func (u *UserLogic) restoreGroup(ctx context.Context, id, parentID uint32) error {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	group, err := db.GetGroup(conn, id)
+	if err != nil || group.UserID != u.userID || !group.Deleted {
+		return ErrTrashItemNotExist
+	}
+	if parentID == 0 {
+		parentID = group.ParentDir
+	}
+	parent, ok := u.dirMap[parentID]
+	if !ok {
+		return ErrTrashParentMissing
+	}
+	group.Deleted = false
+	group.DeletedUnix = 0
+	group.ParentDir = parentID
+	group.Index = parent.nextIndex()
+	if err = db.ChangeGroup(conn, group); err != nil {
+		return errors.Join(err, errors.New("save group failed"))
+	}
+	l := NewGroupLogic(u.env, group.ID)
+	l.OnBindOutData(group)
+	parent.groups = append(parent.groups, l)
+	u.env.publish(ctx, u.userID, protocol.ChangeKindGroup, protocol.ChangeOpCreate, group.ID, parentID, 0)
+	return nil
+}
+
+func (u *UserLogic) restoreSubGroup(ctx context.Context, id, parentID uint32) error {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	subGroups, err := db.GetSubGroupsByIDs(conn, []uint32{id})
+	if err != nil || len(subGroups) == 0 || !subGroups[0].Deleted {
+		return ErrTrashItemNotExist
+	}
+	subGroup := subGroups[0]
+	owner, err := db.GetGroup(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup), subGroup.ParentGroupID)
+	if err != nil || owner.UserID != u.userID {
+		return ErrTrashItemNotExist
+	}
+	if parentID == 0 {
+		parentID = subGroup.ParentGroupID
+	}
+	location, ok := u.groupLocations()[parentID]
+	if !ok {
+		return ErrTrashParentMissing
+	}
+	group := location.group
+	index := group.GeneSubGroupIndex(ctx)
+	if err = db.RestoreSubGroup(conn, id, parentID, index); err != nil {
+		return errors.Join(err, errors.New("restore sub group failed"))
+	}
+	subGroup.Deleted = false
+	subGroup.DeletedUnix = 0
+	subGroup.ParentGroupID = parentID
+	subGroup.Index = index
+	// 还没有加载过子分组时下次读取会从数据库带上它
+	if group.subGroups != nil {
+		l := NewSubGroupLogic(u.env, u.userID, &subGroup)
+		if l == nil {
+			return errors.New("create sub group logic failed")
+		}
+		group.subGroups = append(group.subGroups, l)
+	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindSubGroup, protocol.ChangeOpCreate, id, parentID, 0)
+	return nil
+}
+
+// restoreTrashTask 恢复任务以及与它一起删除的子孙任务，换了子分组时整棵子树一起移动。
+// 原来的父任务不在目标子分组中时放到顶层。
+func (u *UserLogic) restoreTrashTask(ctx context.Context, id, parentID uint32) error {
+	conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	data, err := db.GetTaskByID(conn, id)
+	if err != nil || data.UserID != u.userID || !data.Deleted {
+		return ErrTrashItemNotExist
+	}
+	if parentID == 0 {
+		parentID = data.ParentSubGroupID
+	}
+	target, err := u.subGroupByID(ctx, parentID)
+	if err != nil {
+		return errors.Join(ErrTrashParentMissing, err)
+	}
+	if data.ParentTaskID != 0 {
+		parent, err := db.GetTaskByID(conn, data.ParentTaskID)
+		if err != nil || parent.Deleted || parent.ParentSubGroupID != parentID {
+			data.ParentTaskID = 0
+		}
+	}
+	descendants, err := db.GetTaskDescendants(conn, id)
+	if err != nil {
+		return errors.Join(err, errors.New("load sub tasks failed"))
+	}
+
+	fromID := data.ParentSubGroupID
+	deletedUnix := data.DeletedUnix
+	data.Deleted = false
+	data.DeletedUnix = 0
+	data.ParentSubGroupID = parentID
+	if err = db.UpdateTask(conn, data); err != nil {
+		return err
+	}
+	for i := range descendants {
+		task := &descendants[i]
+		changed := false
+		if task.Deleted && task.DeletedUnix == deletedUnix {
+			task.Deleted = false
+			task.DeletedUnix = 0
+			changed = true
+		}
+		if task.ParentSubGroupID != parentID {
+			task.ParentSubGroupID = parentID
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err = db.UpdateTask(conn, task); err != nil {
+			return err
+		}
+	}
+
+	target.dropTaskCache()
+	if fromID != parentID {
+		if from, err := u.subGroupByID(ctx, fromID); err == nil {
+			from.dropTaskCache()
+		}
+	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindTask, protocol.ChangeOpCreate, id, parentID, fromID)
+	return nil
+}
+
+// dropTaskCache 任务在缓存之外被批量修改后丢弃缓存，下次读取时重新加载并整理序列
+func (s *SubGroupLogic) dropTaskCache() {
+	s.unFinTasksLoaded = false
+	s.unFinTasksCache = make(map[uint32]*TaskLogic)
+}
+
+// PurgeTrash 彻底删除回收站中的内容，items 为空时清空回收站，返回删除的条数。
+// 删除分组与子分组时其下的内容一起删除，删除任务时子孙任务一起删除。
+func (u *UserLogic) PurgeTrash(ctx context.Context, items []protocol.PTrashKey) (int, error) {
+	trash, err := u.ListTrash(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		for _, item := range trash {
+			items = append(items, protocol.PTrashKey{Type: item.Type, ID: item.ID})
+		}
+	}
+	inTrash := make(map[protocol.PTrashKey]protocol.PTrashItem, len(trash))
+	for _, item := range trash {
+		inTrash[protocol.PTrashKey{Type: item.Type, ID: item.ID}] = item
+	}
+	purged := 0
+	for _, key := range items {
+		item, ok := inTrash[key]
+		if !ok {
+			return purged, ErrTrashItemNotExist
+		}
+		if err = u.purgeTrashItem(ctx, item); err != nil {
+			return purged, errors.Join(err, errors.New("purge "+item.Type+" failed"))
+		}
+		delete(inTrash, key)
+		purged++
+	}
+	return purged, nil
+}
+
+// PurgeExpiredTrash 彻底删除在before之前删除的内容
+func (u *UserLogic) PurgeExpiredTrash(ctx context.Context, before time.Time) (int, error) {
+	trash, err := u.ListTrash(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	items := make([]protocol.PTrashKey, 0)
+	for _, item := range trash {
+		if !item.DeletedAt.IsZero() && item.DeletedAt.Before(before) {
+			items = append(items, protocol.PTrashKey{Type: item.Type, ID: item.ID})
+		}
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	return u.PurgeTrash(ctx, items)
+}
+
+func (u *UserLogic) purgeTrashItem(ctx context.Context, item protocol.PTrashItem) error {
+	switch item.Type {
+	case protocol.TrashTypeDir:
+		return db.PurgeDir(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir), item.ID)
+	case protocol.TrashTypeGroup:
+		return db.PurgeGroup(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup), item.ID)
+	case protocol.TrashTypeSubGroup:
+		return db.PurgeSubGroup(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup), item.ID)
+	case protocol.TrashTypeTask:
+		conn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+		descendants, err := db.GetTaskDescendants(conn, item.ID)
+		if err != nil {
+			return err
+		}
+		taskIDs := []uint32{item.ID}
+		for _, task := range descendants {
+			taskIDs = append(taskIDs, task.TaskID)
+		}
+		if err = db.PurgeTasks(conn, taskIDs); err != nil {
+			return err
+		}
+		// 没有一起删除的子任务可能还在缓存中
+		if subGroup, err := u.subGroupByID(ctx, item.ParentID); err == nil {
+			subGroup.dropTaskCache()
+		}
+		return nil
+	}
+	return errors.New("unknown trash type " + item.Type)
+}
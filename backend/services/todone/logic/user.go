@@ -1,6 +1,7 @@
 package logic
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -16,6 +17,7 @@ type dirTreeNode struct {
 }
 
 type UserLogic struct {
+	env    *Env
 	userID string
 
 	// 目前没有用户数据，所以这里没有数据，仅做锁
@@ -25,8 +27,9 @@ type UserLogic struct {
 	dirMap  map[uint32]*dirTreeNode
 }
 
-func NewUserLogic(userID string) *UserLogic {
+func NewUserLogic(env *Env, userID string) *UserLogic {
 	return &UserLogic{
+		env:    env,
 		userID: userID,
 	}
 }
@@ -39,20 +42,20 @@ func (u *UserLogic) Unlock() {
 	u.l.Unlock()
 }
 
-func (u *UserLogic) loadDirTree() error {
+func (u *UserLogic) loadDirTree(ctx context.Context) error {
 	if u.dirTree != nil {
 		return nil
 	}
-	err := u.buildDirTree()
+	err := u.buildDirTree(ctx)
 	if err != nil {
 		return errors.Join(err, errors.New("build dir tree failed"))
 	}
 	return nil
 }
 
-func (u *UserLogic) GetDirLogic(dirID uint32) *DirLogic {
+func (u *UserLogic) GetDirLogic(ctx context.Context, dirID uint32) *DirLogic {
 	if u.dirTree == nil {
-		err := u.loadDirTree()
+		err := u.loadDirTree(ctx)
 		if err != nil {
 			return nil
 		}
@@ -60,9 +63,9 @@ func (u *UserLogic) GetDirLogic(dirID uint32) *DirLogic {
 	return u.dirMap[dirID].dir
 }
 
-func (u *UserLogic) buildDirTree() error {
+func (u *UserLogic) buildDirTree(ctx context.Context) error {
 	// 加载所有group和dir
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeGroup)
+	connect := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
 	if connect == nil {
 		return errors.New("get connect failed")
 	}
@@ -70,7 +73,7 @@ func (u *UserLogic) buildDirTree() error {
 	if err != nil {
 		return errors.Join(err, errors.New("load groups failed"))
 	}
-	connect = db.GTodoneDBMgr.GetConnect(db.ConnectTypeDir)
+	connect = u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
 	if connect == nil {
 		return errors.New("get connect failed")
 	}
@@ -82,7 +85,7 @@ func (u *UserLogic) buildDirTree() error {
 	// 构建树
 	dirMap := make(map[uint32]*dirTreeNode)
 	for _, dir := range dirs {
-		l := NewDirLogic(dir.ID)
+		l := NewDirLogic(u.env, dir.ID)
 		newDir := dir
 		l.OnBindOutData(&newDir)
 		dirMap[dir.ID] = &dirTreeNode{
@@ -97,7 +100,7 @@ func (u *UserLogic) buildDirTree() error {
 	for _, group := range groups {
 		dirID := group.ParentDir
 		if dirNode, ok := dirMap[dirID]; ok {
-			l := NewGroupLogic(group.ID)
+			l := NewGroupLogic(u.env, group.ID)
 			newGroup := group
 			l.OnBindOutData(&newGroup)
 			dirNode.groups = append(dirNode.groups, l)
@@ -115,7 +118,7 @@ func (u *UserLogic) buildDirTree() error {
 
 	// 如果没有根节点，需要创建一个
 	if u.dirTree == nil {
-		connect = db.GTodoneDBMgr.GetConnect(db.ConnectTypeDir)
+		connect = u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
 		if connect == nil {
 			return errors.New("get connect failed when create root dir")
 		}
@@ -123,15 +126,17 @@ func (u *UserLogic) buildDirTree() error {
 		if err != nil {
 			return err
 		}
-		logic := NewDirLogic(dir.ID)
+		logic := NewDirLogic(u.env, dir.ID)
 		logic.OnBindOutData(dir)
+		u.dirTree = &dirTreeNode{dir: logic}
+		u.dirMap[dir.ID] = u.dirTree
 	}
 	return nil
 }
 
-func (u *UserLogic) GetDirTree() (*protocol.PDirTree, error) {
+func (u *UserLogic) GetDirTree(ctx context.Context) (*protocol.PDirTree, error) {
 	if u.dirTree == nil {
-		err := u.loadDirTree()
+		err := u.loadDirTree(ctx)
 		if err != nil {
 			return nil, errors.Join(err, errors.New("load dir tree failed"))
 		}
@@ -153,7 +158,7 @@ func dirTreeToProtocol(node *dirTreeNode) *protocol.PDirTree {
 	return ret
 }
 
-func (u *UserLogic) CreateDir(parentDirID uint32, title, note string) (*db.DirDB, error) {
+func (u *UserLogic) CreateDir(ctx context.Context, parentDirID uint32, title, note string) (*db.DirDB, error) {
 	// 校验父节点是否存在
 	if parentDirID == 0 {
 		return nil, errors.New("parent dir not exist")
@@ -164,7 +169,7 @@ func (u *UserLogic) CreateDir(parentDirID uint32, title, note string) (*db.DirDB
 	}
 
 	// 更新数据库
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeDir)
+	connect := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
 	if connect == nil {
 		return nil, errors.New("get connect failed")
 	}
@@ -174,7 +179,7 @@ func (u *UserLogic) CreateDir(parentDirID uint32, title, note string) (*db.DirDB
 	}
 
 	// 更新内存
-	dirLogic := NewDirLogic(dir.ID)
+	dirLogic := NewDirLogic(u.env, dir.ID)
 	dirLogic.OnBindOutData(dir)
 	dirNode := &dirTreeNode{
 		dir: dirLogic,
@@ -202,15 +207,16 @@ func (u *UserLogic) CreateDir(parentDirID uint32, title, note string) (*db.DirDB
 		maxIndex = 1
 	}
 	dirNode.dir.dbData.Index = maxIndex + 1
-	err = dirNode.dir.Save()
+	err = dirNode.dir.Save(ctx)
 	if err != nil {
 		return nil, errors.Join(err, errors.New("save dir failed"))
 	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindDir, protocol.ChangeOpCreate, dir.ID, parentDirID, 0)
 
 	return dir, nil
 }
 
-func (u *UserLogic) MoveDir(dirID, trgDir uint32, afterID uint32) (float32, error) {
+func (u *UserLogic) MoveDir(ctx context.Context, dirID, trgDir uint32, afterID uint32) (float32, error) {
 	// 校验目标节点是否存在
 	trg, ok := u.dirMap[trgDir]
 	if !ok {
@@ -279,15 +285,16 @@ func (u *UserLogic) MoveDir(dirID, trgDir uint32, afterID uint32) (float32, erro
 	}
 
 	// 更新数据库
-	err := src.dir.Save()
+	err := src.dir.Save(ctx)
 	if err != nil {
 		return 0, errors.Join(err, errors.New("save dir failed"))
 	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindDir, protocol.ChangeOpMove, dirID, trgDir, oldParentID)
 
 	return src.dir.dbData.Index, nil
 }
 
-func (u *UserLogic) MoveGroup(parentDirID, groupID, trgDir, afterID uint32) (float32, error) {
+func (u *UserLogic) MoveGroup(ctx context.Context, parentDirID, groupID, trgDir, afterID uint32) (float32, error) {
 	// 校验目标节点是否存在
 	trg, ok := u.dirMap[trgDir]
 	if !ok {
@@ -364,15 +371,16 @@ func (u *UserLogic) MoveGroup(parentDirID, groupID, trgDir, afterID uint32) (flo
 	}
 
 	// 更新数据库
-	err := group.Save()
+	err := group.Save(ctx)
 	if err != nil {
 		return 0, errors.Join(err, errors.New("save group failed"))
 	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindGroup, protocol.ChangeOpMove, groupID, trgDir, oldParentID)
 
 	return group.dbData.Index, nil
 }
 
-func (u *UserLogic) DelDir(dirID uint32) error {
+func (u *UserLogic) DelDir(ctx context.Context, dirID uint32) error {
 	// 判断是否存在
 	dir, ok := u.dirMap[dirID]
 	if !ok {
@@ -401,11 +409,11 @@ func (u *UserLogic) DelDir(dirID uint32) error {
 	delete(u.dirMap, dirID)
 
 	// 更新数据库
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeDir)
+	connect := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
 	if connect == nil {
 		return errors.New("get connect failed")
 	}
-	err := dir.dir.Delete()
+	err := dir.dir.Delete(ctx)
 	if err != nil {
 		return errors.Join(err, errors.New("delete dir failed"))
 	}
@@ -413,9 +421,9 @@ func (u *UserLogic) DelDir(dirID uint32) error {
 	return nil
 }
 
-func (u *UserLogic) DelGroup(parentDirID, groupID uint32) error {
+func (u *UserLogic) DelGroup(ctx context.Context, parentDirID, groupID uint32) error {
 	// 判断是否存在
-	group := u.GetGroupLogic(parentDirID, groupID)
+	group := u.GetGroupLogic(ctx, parentDirID, groupID)
 	if group == nil {
 		return errors.New("group not exist")
 	}
@@ -437,7 +445,7 @@ func (u *UserLogic) DelGroup(parentDirID, groupID uint32) error {
 	}
 
 	// 更新数据库
-	err := group.Delete()
+	err := group.Delete(ctx)
 	if err != nil {
 		return errors.Join(err, errors.New("delete group failed"))
 	}
@@ -445,7 +453,8 @@ func (u *UserLogic) DelGroup(parentDirID, groupID uint32) error {
 	return nil
 }
 
-func (u *UserLogic) CreateGroup(parentDirID uint32, title, note string, afterID uint32, groupType db.GroupType) (uint32, error, float32) {
+// CreateGroup requestID 为空表示不做重试去重，否则先用 FindRequestGroup 检查
+func (u *UserLogic) CreateGroup(ctx context.Context, parentDirID uint32, title, note string, afterID uint32, groupType db.GroupType, requestID string) (uint32, error, float32) {
 	// 校验父节点是否存在
 	if parentDirID == 0 {
 		return 0, errors.New("parent dir not exist"), 0
@@ -456,17 +465,17 @@ func (u *UserLogic) CreateGroup(parentDirID uint32, title, note string, afterID
 	}
 
 	// 更新数据库
-	connect := db.GTodoneDBMgr.GetConnect(db.ConnectTypeGroup)
+	connect := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
 	if connect == nil {
 		return 0, errors.New("get connect failed"), 0
 	}
-	groupDB, err := db.CreateGroup(connect, u.userID, title, note, parentDirID, groupType)
+	groupDB, err := db.CreateGroup(connect, u.userID, title, note, parentDirID, groupType, requestIDPtr(requestID))
 	if err != nil {
 		return 0, errors.Join(err, errors.New("create group failed")), 0
 	}
 
 	// 更新内存
-	group := NewGroupLogic(groupDB.ID)
+	group := NewGroupLogic(u.env, groupDB.ID)
 	group.OnBindOutData(groupDB)
 	group.dbData.ParentDir = parentDirID
 	if parentDir, ok := u.dirMap[parentDirID]; ok {
@@ -519,12 +528,13 @@ func (u *UserLogic) CreateGroup(parentDirID uint32, title, note string, afterID
 		group.dbData.Index = maxIndex + 1
 	}
 
-	err = group.Save()
+	err = group.Save(ctx)
 	if err != nil {
 		return 0, errors.Join(err, errors.New("save group failed")), 0
 	}
+	u.env.publish(ctx, u.userID, protocol.ChangeKindGroup, protocol.ChangeOpCreate, groupDB.ID, parentDirID, 0)
 
-	_, err = group.CreateSubGroupLogic("默认", "默认子任务组")
+	_, err = group.CreateSubGroupLogic(ctx, "默认", "默认子任务组", "")
 	if err != nil {
 		return 0, errors.Join(err, errors.New("create default subgroup failed")), 0
 	}
@@ -532,9 +542,32 @@ func (u *UserLogic) CreateGroup(parentDirID uint32, title, note string, afterID
 	return groupDB.ID, nil, group.dbData.Index
 }
 
-func (u *UserLogic) GetGroupLogic(parentDirID, groupID uint32) *GroupLogic {
+// FindRequestGroup 找到同一个请求ID已经创建的分组，没有时返回nil，已经删除或者位置、标题不同时返回ErrGroupRequestConflict
+func (u *UserLogic) FindRequestGroup(ctx context.Context, requestID string, parentDirID uint32, title string) (*GroupLogic, error) {
+	if requestID == "" {
+		return nil, nil
+	}
+	connect := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	existing, err := db.GetGroupByRequestID(connect, u.userID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+	if existing.Deleted || existing.ParentDir != parentDirID || existing.Title != title {
+		return nil, ErrGroupRequestConflict
+	}
+	group := u.GetGroupLogic(ctx, parentDirID, existing.ID)
+	if group == nil {
+		return nil, errors.New("group not exist")
+	}
+	return group, nil
+}
+
+func (u *UserLogic) GetGroupLogic(ctx context.Context, parentDirID, groupID uint32) *GroupLogic {
 	if u.dirTree == nil {
-		err := u.loadDirTree()
+		err := u.loadDirTree(ctx)
 		if err != nil {
 			return nil
 		}
@@ -551,20 +584,20 @@ func (u *UserLogic) GetGroupLogic(parentDirID, groupID uint32) *GroupLogic {
 	return nil
 }
 
-func (u *UserLogic) GetSubGroupLogic(parentDirID, groupID, subGroupID uint32) *SubGroupLogic {
-	group := u.GetGroupLogic(parentDirID, groupID)
+func (u *UserLogic) GetSubGroupLogic(ctx context.Context, parentDirID, groupID, subGroupID uint32) *SubGroupLogic {
+	group := u.GetGroupLogic(ctx, parentDirID, groupID)
 	if group == nil {
 		return nil
 	}
-	return group.GetSubGroupLogic(subGroupID)
+	return group.GetSubGroupLogic(ctx, subGroupID)
 }
 
-func (u *UserLogic) GetTaskLogic(DirID uint32, GroupID uint32, SubgroupID uint32, TaskID uint32) *TaskLogic {
-	subGroup := u.GetSubGroupLogic(DirID, GroupID, SubgroupID)
+func (u *UserLogic) GetTaskLogic(ctx context.Context, DirID uint32, GroupID uint32, SubgroupID uint32, TaskID uint32) *TaskLogic {
+	subGroup := u.GetSubGroupLogic(ctx, DirID, GroupID, SubgroupID)
 	if subGroup == nil {
 		return nil
 	}
-	task := subGroup.GetTaskLogic(TaskID)
+	task := subGroup.GetTaskLogic(ctx, TaskID)
 	if task == nil {
 		return nil
 	}
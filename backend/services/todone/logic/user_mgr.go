@@ -5,14 +5,19 @@ import (
 )
 
 type UserMgr struct {
+	env     *Env
 	userMap multi.SafeMap[string, *UserLogic]
 }
 
+func NewUserMgr(env *Env) *UserMgr {
+	return &UserMgr{env: env}
+}
+
 func (u *UserMgr) GetUserLogic(userID string) *UserLogic {
 	if v, ok := u.userMap.Load(userID); ok {
 		return v
 	}
-	logic := NewUserLogic(userID)
+	logic := NewUserLogic(u.env, userID)
 	u.userMap.Store(userID, logic)
 	return logic
 }
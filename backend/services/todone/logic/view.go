@@ -0,0 +1,209 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"gorm.io/gorm"
+)
+
+// ViewFilter 把内置视图换成筛选条件，日期按now所在时区计算
+func ViewFilter(view, tag string, custom protocol.PViewFilter, now time.Time) (protocol.PViewFilter, error) {
+	dayEnd := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	switch view {
+	case protocol.ViewToday:
+		return protocol.PViewFilter{DueTo: dayEnd}, nil
+	case protocol.ViewWeek:
+		// 周日的Weekday为0，按一周的最后一天处理
+		return protocol.PViewFilter{DueTo: dayEnd.AddDate(0, 0, (7-int(now.Weekday()))%7)}, nil
+	case protocol.ViewStarted:
+		return protocol.PViewFilter{Started: true}, nil
+	case protocol.ViewTag:
+		if tag == "" {
+			return protocol.PViewFilter{}, errors.New("view tag is empty")
+		}
+		return protocol.PViewFilter{Tags: []string{tag}}, nil
+	case protocol.ViewWaiting:
+		return protocol.PViewFilter{Waiting: true}, nil
+	case protocol.ViewCustom:
+		return custom, nil
+	default:
+		return protocol.PViewFilter{}, errors.New("unknown view")
+	}
+}
+
+// GetView 跨全部分组筛选任务，有截止时间的按截止时间在前，其余按创建顺序。
+// 所在目录、分组、子分组或任一父任务已经删除的任务不返回
+func (u *UserLogic) GetView(ctx context.Context, filter protocol.PViewFilter, limit int) ([]protocol.PViewTask, error) {
+	if err := u.loadDirTree(ctx); err != nil {
+		return nil, err
+	}
+	taskConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	tasks, err := db.GetViewTasks(taskConn, db.ViewQuery{
+		UserID:      u.userID,
+		ContainDone: filter.ContainDone,
+		Started:     filter.Started,
+		Waiting:     filter.Waiting,
+		Tags:        filter.Tags,
+	})
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load view tasks failed"))
+	}
+	byDue := !filter.DueFrom.IsZero() || !filter.DueTo.IsZero()
+	matched := tasks[:0]
+	for _, task := range tasks {
+		if byDue {
+			if task.EndTime.IsZero() ||
+				(!filter.DueFrom.IsZero() && task.EndTime.Before(filter.DueFrom)) ||
+				(!filter.DueTo.IsZero() && !task.EndTime.Before(filter.DueTo)) {
+				continue
+			}
+		}
+		matched = append(matched, task)
+	}
+	live, err := liveParents(taskConn, matched)
+	if err != nil {
+		return nil, err
+	}
+
+	subGroupIDs := make([]uint32, 0)
+	seen := make(map[uint32]bool)
+	for _, task := range matched {
+		if !seen[task.ParentSubGroupID] {
+			seen[task.ParentSubGroupID] = true
+			subGroupIDs = append(subGroupIDs, task.ParentSubGroupID)
+		}
+	}
+	subGroupDBs, err := db.GetSubGroupsByIDs(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup), subGroupIDs)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load sub groups failed"))
+	}
+	subGroupParent := make(map[uint32]uint32, len(subGroupDBs))
+	for _, subGroup := range subGroupDBs {
+		subGroupParent[subGroup.ID] = subGroup.ParentGroupID
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i].EndTime, matched[j].EndTime
+		if a.IsZero() != b.IsZero() {
+			return !a.IsZero()
+		}
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return matched[i].TaskID < matched[j].TaskID
+	})
+
+	locations := u.groupLocations()
+	res := make([]protocol.PViewTask, 0, len(matched))
+	var taskIDs []uint32
+	for i := range matched {
+		task := &matched[i]
+		if !live[task.ParentTaskID] {
+			continue
+		}
+		location, ok := locations[subGroupParent[task.ParentSubGroupID]]
+		if !ok {
+			continue
+		}
+		// 子分组标题可能还在自动保存的缓存中，以内存为准
+		subGroup := location.group.GetSubGroupLogic(ctx, task.ParentSubGroupID)
+		if subGroup == nil {
+			continue
+		}
+		res = append(res, protocol.PViewTask{
+			Task: taskDBToProtocol(task, nil),
+			Path: protocol.PTaskPath{
+				DirID:      location.dir.dir.dbData.ID,
+				GroupID:    location.group.dbData.ID,
+				SubGroupID: subGroup.GetID(),
+				Titles:     append(u.dirTitles(location.dir), location.group.dbData.Title, subGroup.dbData.Title),
+			},
+		})
+		taskIDs = append(taskIDs, task.TaskID)
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+	}
+	tags := db.GetTagsByMultipleTaskID(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags), taskIDs)
+	pTasks := make([]protocol.PTask, 0, len(res))
+	for i := range res {
+		res[i].Task.Tags = tags[res[i].Task.ID]
+		pTasks = append(pTasks, res[i].Task)
+	}
+	if err = u.FillBlocked(ctx, pTasks); err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Task = pTasks[i]
+	}
+	return res, nil
+}
+
+// liveParents 返回这些任务的父任务中祖先链全部未删除的部分，0始终有效
+func liveParents(conn *gorm.DB, tasks []db.TaskDB) (map[uint32]bool, error) {
+	live := map[uint32]bool{0: true}
+	parent := make(map[uint32]uint32)
+	deleted := make(map[uint32]bool)
+	var pending []uint32
+	for _, task := range tasks {
+		if task.ParentTaskID != 0 {
+			pending = append(pending, task.ParentTaskID)
+		}
+	}
+	// 逐层向上加载祖先
+	for len(pending) > 0 {
+		var next []uint32
+		var load []uint32
+		for _, id := range pending {
+			if _, ok := parent[id]; !ok && !deleted[id] {
+				load = append(load, id)
+			}
+		}
+		for i := 0; i < len(load); i += db.MaxInSize {
+			end := min(i+db.MaxInSize, len(load))
+			ancestors, err := db.GetTaskByIds(conn, load[i:end])
+			if err != nil {
+				return nil, errors.Join(err, errors.New("load parent tasks failed"))
+			}
+			for _, ancestor := range ancestors {
+				if ancestor.Deleted {
+					deleted[ancestor.TaskID] = true
+					continue
+				}
+				parent[ancestor.TaskID] = ancestor.ParentTaskID
+				if ancestor.ParentTaskID != 0 {
+					next = append(next, ancestor.ParentTaskID)
+				}
+			}
+		}
+		// 查不到的任务视为已经删除
+		for _, id := range load {
+			if _, ok := parent[id]; !ok {
+				deleted[id] = true
+			}
+		}
+		pending = next
+	}
+	var check func(id uint32) bool
+	check = func(id uint32) bool {
+		if ok, known := live[id]; known {
+			return ok
+		}
+		live[id] = false
+		if deleted[id] {
+			return false
+		}
+		ok := check(parent[id])
+		live[id] = ok
+		return ok
+	}
+	for id := range parent {
+		check(id)
+	}
+	return live, nil
+}
@@ -0,0 +1,519 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+var ErrInvalidArchive = errors.New("invalid workspace archive")
+
+// ExportWorkspace 导出目录树下全部未删除的内容，回收站中的内容与挂在已删除父任务下的任务不导出
+func (u *UserLogic) ExportWorkspace(ctx context.Context) (*protocol.PWorkspaceArchive, error) {
+	if err := u.loadDirTree(ctx); err != nil {
+		return nil, err
+	}
+	archive := &protocol.PWorkspaceArchive{
+		Version:    protocol.WorkspaceArchiveVersion,
+		ExportedAt: time.Now(),
+	}
+	var subGroups []*SubGroupLogic
+	// 广度优先，保证父目录在前
+	queue := []*dirTreeNode{u.dirTree}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		archive.Dirs = append(archive.Dirs, protocol.PArchiveDir{PDir: node.dir.ToProtocol(), ParentID: node.dir.dbData.ParentID})
+		queue = append(queue, node.childs...)
+		for _, group := range node.groups {
+			archive.Groups = append(archive.Groups, protocol.PArchiveGroup{PGroup: group.ToProtocol(), ParentDir: node.dir.dbData.ID})
+			groupSubs, err := group.GetSubGroups(ctx)
+			if err != nil {
+				return nil, errors.Join(err, errors.New("load sub groups failed"))
+			}
+			for _, subGroup := range groupSubs {
+				archive.SubGroups = append(archive.SubGroups, protocol.PArchiveSubGroup{
+					PSubGroup:     subGroup.ToProtocol(),
+					ParentGroupID: group.dbData.ID,
+					TaskSequence:  subGroup.dbData.TaskSequence,
+				})
+			}
+			subGroups = append(subGroups, groupSubs...)
+		}
+	}
+	// 根目录的父节点统一为0
+	archive.Dirs[0].ParentID = 0
+
+	taskConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	var taskIDs []uint32
+	for _, subGroup := range subGroups {
+		tasks := db.GetTasksByParentSubGroupID(taskConn, subGroup.GetID(), 0, 0, true)
+		children := make(map[uint32][]db.TaskDB)
+		for _, task := range tasks {
+			children[task.ParentTaskID] = append(children[task.ParentTaskID], task)
+		}
+		// 从顶层任务开始展开，父任务已经删除的子孙任务自然被排除
+		level := children[0]
+		for len(level) > 0 {
+			var next []db.TaskDB
+			for _, task := range level {
+				archive.Tasks = append(archive.Tasks, protocol.PArchiveTask{
+					PTask:        taskDBToProtocol(&task, nil),
+					SubGroupID:   subGroup.GetID(),
+					RepeatNextID: task.RepeatNextID,
+					CreatedAt:    task.CreatedAt,
+					UpdatedAt:    task.UpdatedAt,
+				})
+				taskIDs = append(taskIDs, task.TaskID)
+				next = append(next, children[task.TaskID]...)
+			}
+			level = next
+		}
+	}
+
+	tags := db.GetTagsByMultipleTaskID(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags), taskIDs)
+	for i := range archive.Tasks {
+		archive.Tasks[i].Tags = tags[archive.Tasks[i].ID]
+	}
+	notes, err := db.GetLibraryNotesByTaskIDs(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeLibraryNote), u.userID, taskIDs)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load library notes failed"))
+	}
+	for _, note := range notes {
+		archive.LibraryNotes = append(archive.LibraryNotes, LibraryNoteToProtocol(note))
+	}
+	details, err := db.GetLibraryScoreDetailsByTaskIDs(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeLibraryScoreDetail), u.userID, taskIDs)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load library score details failed"))
+	}
+	for _, detail := range details {
+		archive.LibraryScoreDetails = append(archive.LibraryScoreDetails, LibraryScoreDetailToProtocol(detail))
+	}
+	deps, err := db.GetTaskDependencies(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDependency), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load task dependencies failed"))
+	}
+	exported := make(map[uint32]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		exported[id] = true
+	}
+	for _, dep := range deps {
+		if exported[dep.TaskID] && exported[dep.BlockerID] {
+			archive.Blockers = append(archive.Blockers, protocol.PArchiveBlocker{TaskID: dep.TaskID, BlockerID: dep.BlockerID})
+		}
+	}
+	metas, err := db.GetTagMetas(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta), u.userID)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("load tag metas failed"))
+	}
+	for _, meta := range metas {
+		archive.TagMetas = append(archive.TagMetas, protocol.PArchiveTagMeta{Tag: meta.Tag, Color: meta.Color, Pinned: meta.Pinned})
+	}
+	return archive, nil
+}
+
+// ImportResult 导入后新分配的ID
+type ImportResult struct {
+	DirIDs      map[uint32]uint32
+	GroupIDs    map[uint32]uint32
+	SubGroupIDs map[uint32]uint32
+	TaskIDs     map[uint32]uint32
+	// TagMetas 新建了展示信息的标签，已有展示信息的标签不覆盖
+	TagMetas []string
+}
+
+// validateArchive 检查引用关系，导入前发现问题就不写入任何数据
+func validateArchive(archive *protocol.PWorkspaceArchive) error {
+	if archive.Version != protocol.WorkspaceArchiveVersion {
+		return errors.Join(ErrInvalidArchive, errors.New("unsupported version "+strconv.Itoa(archive.Version)))
+	}
+	if len(archive.Dirs) == 0 || archive.Dirs[0].ParentID != 0 {
+		return errors.Join(ErrInvalidArchive, errors.New("first dir must be the root"))
+	}
+	dirs := map[uint32]bool{archive.Dirs[0].ID: true}
+	for _, dir := range archive.Dirs[1:] {
+		if dirs[dir.ID] || !dirs[dir.ParentID] {
+			return errors.Join(ErrInvalidArchive, errors.New("dir "+strconv.FormatUint(uint64(dir.ID), 10)))
+		}
+		dirs[dir.ID] = true
+	}
+	groups := make(map[uint32]bool)
+	for _, group := range archive.Groups {
+		if groups[group.ID] || !dirs[group.ParentDir] {
+			return errors.Join(ErrInvalidArchive, errors.New("group "+strconv.FormatUint(uint64(group.ID), 10)))
+		}
+		groups[group.ID] = true
+	}
+	subGroups := make(map[uint32]bool)
+	for _, subGroup := range archive.SubGroups {
+		if subGroups[subGroup.ID] || !groups[subGroup.ParentGroupID] {
+			return errors.Join(ErrInvalidArchive, errors.New("sub group "+strconv.FormatUint(uint64(subGroup.ID), 10)))
+		}
+		if err := make(MapIdTree).FromJSON(subGroup.TaskSequence); err != nil {
+			return errors.Join(ErrInvalidArchive, errors.New("sub group "+strconv.FormatUint(uint64(subGroup.ID), 10)+" sequence"))
+		}
+		subGroups[subGroup.ID] = true
+	}
+	taskSubGroup := make(map[uint32]uint32)
+	for _, task := range archive.Tasks {
+		_, dup := taskSubGroup[task.ID]
+		if dup || !subGroups[task.SubGroupID] {
+			return errors.Join(ErrInvalidArchive, errors.New("task "+strconv.FormatUint(uint64(task.ID), 10)))
+		}
+		if task.ParentID != 0 && taskSubGroup[task.ParentID] != task.SubGroupID {
+			return errors.Join(ErrInvalidArchive, errors.New("task "+strconv.FormatUint(uint64(task.ID), 10)+" parent"))
+		}
+		if task.Repeat != nil {
+			if _, err := ValidateRepeatRule(*task.Repeat); err != nil {
+				return errors.Join(ErrInvalidArchive, errors.New("task "+strconv.FormatUint(uint64(task.ID), 10)+" repeat"))
+			}
+		}
+		taskSubGroup[task.ID] = task.SubGroupID
+	}
+	for _, note := range archive.LibraryNotes {
+		if _, ok := taskSubGroup[note.TaskID]; !ok {
+			return errors.Join(ErrInvalidArchive, errors.New("library note "+note.ID))
+		}
+	}
+	for _, detail := range archive.LibraryScoreDetails {
+		if _, ok := taskSubGroup[detail.TaskID]; !ok || detail.ID == "" {
+			return errors.Join(ErrInvalidArchive, errors.New("library score detail "+detail.ID))
+		}
+	}
+	for _, blocker := range archive.Blockers {
+		_, taskOK := taskSubGroup[blocker.TaskID]
+		_, blockerOK := taskSubGroup[blocker.BlockerID]
+		if !taskOK || !blockerOK || blocker.TaskID == blocker.BlockerID {
+			return errors.Join(ErrInvalidArchive, errors.New("blocker "+strconv.FormatUint(uint64(blocker.TaskID), 10)+" <- "+strconv.FormatUint(uint64(blocker.BlockerID), 10)))
+		}
+	}
+	tags := make(map[string]bool, len(archive.TagMetas))
+	for _, meta := range archive.TagMetas {
+		if meta.Tag == "" || tags[meta.Tag] || (meta.Color != "" && !tagColorPattern.MatchString(meta.Color)) {
+			return errors.Join(ErrInvalidArchive, errors.New("tag meta "+meta.Tag))
+		}
+		tags[meta.Tag] = true
+	}
+	return nil
+}
+
+// ImportWorkspace 把导出的内容作为新数据导入到parentDirID下，为0时导入到根目录。
+// 导出时的根目录对应parentDirID本身，其余内容全部新建，ID重新分配，评分明细换新的ID并同步替换任务备注中的引用。
+// SQLite 在一个事务中导入；D1 没有事务，中途失败时按 res 中的ID删除已经写入的内容。成功后才加入目录树并发布变更。
+func (u *UserLogic) ImportWorkspace(ctx context.Context, archive *protocol.PWorkspaceArchive, parentDirID uint32) (*ImportResult, error) {
+	if err := u.loadDirTree(ctx); err != nil {
+		return nil, err
+	}
+	if archive == nil {
+		return nil, ErrInvalidArchive
+	}
+	if err := validateArchive(archive); err != nil {
+		return nil, err
+	}
+	if parentDirID == 0 {
+		parentDirID = u.dirTree.dir.dbData.ID
+	}
+	if _, ok := u.dirMap[parentDirID]; !ok {
+		return nil, errors.New("parent dir not exist")
+	}
+	res := &ImportResult{
+		DirIDs:      map[uint32]uint32{archive.Dirs[0].ID: parentDirID},
+		GroupIDs:    make(map[uint32]uint32),
+		SubGroupIDs: make(map[uint32]uint32),
+		TaskIDs:     make(map[uint32]uint32),
+	}
+
+	var dirs []*db.DirDB
+	var groups []*db.GroupDB
+	write := func(ctx context.Context) error {
+		var err error
+		dirs, groups, err = u.importArchive(ctx, archive, res)
+		return err
+	}
+	var err error
+	if u.env.DB.SupportTx() {
+		err = u.env.DB.Transaction(ctx, write)
+	} else if err = write(ctx); err != nil {
+		// 请求取消导致的失败也要清理干净
+		if err2 := u.purgeImported(context.WithoutCancel(ctx), res, archive.Dirs[0].ID); err2 != nil {
+			err = errors.Join(err, errors.New("clean up import failed"), err2)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 新分组下的子分组与任务由客户端拉取分组时带上，不再逐个发布
+	for _, data := range dirs {
+		parent := u.dirMap[data.ParentID]
+		l := NewDirLogic(u.env, data.ID)
+		l.OnBindOutData(data)
+		node := &dirTreeNode{dir: l}
+		u.dirMap[data.ID] = node
+		parent.childs = append(parent.childs, node)
+		u.env.publish(ctx, u.userID, protocol.ChangeKindDir, protocol.ChangeOpCreate, data.ID, data.ParentID, 0)
+	}
+	for _, data := range groups {
+		parent := u.dirMap[data.ParentDir]
+		l := NewGroupLogic(u.env, data.ID)
+		l.OnBindOutData(data)
+		parent.groups = append(parent.groups, l)
+		u.env.publish(ctx, u.userID, protocol.ChangeKindGroup, protocol.ChangeOpCreate, data.ID, data.ParentDir, 0)
+	}
+	return res, nil
+}
+
+// importArchive 只写数据库，新建的ID记入res，返回新建的目录与分组
+func (u *UserLogic) importArchive(ctx context.Context, archive *protocol.PWorkspaceArchive, res *ImportResult) ([]*db.DirDB, []*db.GroupDB, error) {
+	dirs := make([]*db.DirDB, 0, len(archive.Dirs)-1)
+	dirConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
+	for _, dir := range archive.Dirs[1:] {
+		data := &db.DirDB{UserID: u.userID, Title: dir.Title, Note: dir.Note, ParentID: res.DirIDs[dir.ParentID], Index: dir.Index}
+		if err := db.ImportDir(dirConn, data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import dir failed"))
+		}
+		res.DirIDs[dir.ID] = data.ID
+		dirs = append(dirs, data)
+	}
+
+	groups := make([]*db.GroupDB, 0, len(archive.Groups))
+	groupConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	for _, group := range archive.Groups {
+		data := &db.GroupDB{UserID: u.userID, Type: db.GroupType(group.Type), Title: group.Title, Note: group.Note, ParentDir: res.DirIDs[group.ParentDir], Index: group.Index, Revision: 1}
+		if err := db.ImportGroup(groupConn, data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import group failed"))
+		}
+		res.GroupIDs[group.ID] = data.ID
+		groups = append(groups, data)
+	}
+
+	// 子分组的任务序列要等任务导入后才能换成新的ID
+	subGroupConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	subGroupData := make(map[uint32]*db.SubGroupDB, len(archive.SubGroups))
+	for _, subGroup := range archive.SubGroups {
+		data := &db.SubGroupDB{ParentGroupID: res.GroupIDs[subGroup.ParentGroupID], Title: subGroup.Title, Note: subGroup.Note, Index: subGroup.Index, Revision: 1}
+		if err := db.ImportSubGroup(subGroupConn, data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import sub group failed"))
+		}
+		res.SubGroupIDs[subGroup.ID] = data.ID
+		subGroupData[subGroup.ID] = data
+	}
+
+	// 评分明细的ID是任务备注中引用的UUID，换成新ID避免与已有数据冲突
+	scoreIDs := make(map[uint32]map[string]string)
+	for _, detail := range archive.LibraryScoreDetails {
+		if scoreIDs[detail.TaskID] == nil {
+			scoreIDs[detail.TaskID] = make(map[string]string)
+		}
+		scoreIDs[detail.TaskID][detail.ID] = uuid.NewString()
+	}
+
+	taskConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask)
+	tagConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTags)
+	taskData := make([]*db.TaskDB, 0, len(archive.Tasks))
+	for _, task := range archive.Tasks {
+		note := task.Note
+		for oldID, newID := range scoreIDs[task.ID] {
+			note = strings.ReplaceAll(note, oldID, newID)
+		}
+		data := &db.TaskDB{
+			UserID:           u.userID,
+			Title:            task.Title,
+			Note:             note,
+			ParentSubGroupID: res.SubGroupIDs[task.SubGroupID],
+			ParentTaskID:     res.TaskIDs[task.ParentID],
+			Done:             task.Done,
+			CreatedAt:        task.CreatedAt,
+			UpdatedAt:        task.UpdatedAt,
+			TaskType:         db.TaskType(task.TaskType),
+			Started:          task.Started,
+			BeginTime:        task.BeginTime,
+			EndTime:          task.EndTime,
+			Wait4:            task.Wait4,
+			RepeatNextID:     task.RepeatNextID,
+			Revision:         1,
+		}
+		if task.Repeat != nil {
+			bs, err := json.Marshal(task.Repeat)
+			if err != nil {
+				return nil, nil, err
+			}
+			data.Repeat = string(bs)
+		}
+		if err := db.ImportTask(taskConn, data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import task failed"))
+		}
+		res.TaskIDs[task.ID] = data.TaskID
+		taskData = append(taskData, data)
+		for _, tag := range task.Tags {
+			if err := db.AddTags(tagConn, u.userID, data.TaskID, tag); err != nil {
+				return nil, nil, errors.Join(err, errors.New("import tag failed"))
+			}
+		}
+	}
+	// 已经生成的下一次任务不在导出内容中时清空，完成后会重新生成
+	for _, data := range taskData {
+		if data.RepeatNextID == 0 {
+			continue
+		}
+		data.RepeatNextID = res.TaskIDs[data.RepeatNextID]
+		if err := db.UpdateTask(taskConn, data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	depConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDependency)
+	for _, blocker := range archive.Blockers {
+		if err := db.CreateTaskDependency(depConn, u.userID, res.TaskIDs[blocker.TaskID], res.TaskIDs[blocker.BlockerID]); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import task dependency failed"))
+		}
+	}
+	metaConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta)
+	for _, meta := range archive.TagMetas {
+		existing, err := db.GetTagMeta(metaConn, u.userID, meta.Tag)
+		if err != nil {
+			return nil, nil, errors.Join(err, errors.New("load tag meta failed"))
+		}
+		if existing != nil {
+			continue
+		}
+		data := &db.TagMetaDB{UserID: u.userID, Tag: meta.Tag, Color: strings.ToLower(meta.Color), Pinned: meta.Pinned, UpdatedAt: time.Now()}
+		if err = db.SaveTagMeta(metaConn, data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import tag meta failed"))
+		}
+		res.TagMetas = append(res.TagMetas, meta.Tag)
+	}
+
+	for _, subGroup := range archive.SubGroups {
+		seq := make(MapIdTree)
+		_ = seq.FromJSON(subGroup.TaskSequence)
+		data := subGroupData[subGroup.ID]
+		data.TaskSequence = remapTaskSequence(seq, res.TaskIDs)
+		if err := db.UpdateSubGroupTaskSequence(subGroupConn, data.ID, data.TaskSequence); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import task sequence failed"))
+		}
+	}
+
+	noteConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeLibraryNote)
+	for _, note := range archive.LibraryNotes {
+		data := &db.LibraryNoteDB{
+			ID:        uuid.NewString(),
+			UserID:    u.userID,
+			TaskID:    res.TaskIDs[note.TaskID],
+			RoundID:   note.RoundID,
+			EventTime: note.EventTime,
+			Content:   note.Content,
+			Revision:  max(note.Revision, 1),
+			CreatedAt: note.CreatedAt,
+			UpdatedAt: note.UpdatedAt,
+		}
+		if err := db.ImportLibraryNote(noteConn, data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import library note failed"))
+		}
+	}
+	detailConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeLibraryScoreDetail)
+	for _, detail := range archive.LibraryScoreDetails {
+		data := libraryScoreDetailFromProtocol(detail)
+		data.ID = scoreIDs[detail.TaskID][detail.ID]
+		data.UserID = u.userID
+		data.TaskID = res.TaskIDs[detail.TaskID]
+		if err := db.ImportLibraryScoreDetail(detailConn, &data); err != nil {
+			return nil, nil, errors.Join(err, errors.New("import library score detail failed"))
+		}
+	}
+	return dirs, groups, nil
+}
+
+// purgeImported 删除导入中已经写入的内容。导出时的根目录rootID映射到已有目录，不删除
+func (u *UserLogic) purgeImported(ctx context.Context, res *ImportResult, rootID uint32) error {
+	taskIDs := make([]uint32, 0, len(res.TaskIDs))
+	for _, id := range res.TaskIDs {
+		taskIDs = append(taskIDs, id)
+	}
+	if err := db.PurgeTasks(u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTask), taskIDs); err != nil {
+		return err
+	}
+	metaConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeTagMeta)
+	for _, tag := range res.TagMetas {
+		if err := db.DeleteTagMeta(metaConn, u.userID, tag); err != nil {
+			return err
+		}
+	}
+	subGroupConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeSubGroup)
+	for _, id := range res.SubGroupIDs {
+		if err := db.PurgeSubGroup(subGroupConn, id); err != nil {
+			return err
+		}
+	}
+	groupConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeGroup)
+	for _, id := range res.GroupIDs {
+		if err := db.PurgeGroup(groupConn, id); err != nil {
+			return err
+		}
+	}
+	dirConn := u.env.DB.GetConnectCtx(ctx, db.ConnectTypeDir)
+	for oldID, id := range res.DirIDs {
+		if oldID == rootID {
+			continue
+		}
+		if err := db.PurgeDir(dirConn, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remapTaskSequence 把序列中的任务ID换成新ID，不在映射中的丢弃，下次加载时会重新整理
+func remapTaskSequence(seq MapIdTree, taskIDs map[uint32]uint32) string {
+	res := make(MapIdTree)
+	for parentID, children := range seq {
+		newParent := uint32(0)
+		if parentID != 0 {
+			var ok bool
+			if newParent, ok = taskIDs[parentID]; !ok {
+				continue
+			}
+		}
+		for _, child := range children {
+			if newChild, ok := taskIDs[child]; ok {
+				res.Add(newParent, newChild)
+			}
+		}
+	}
+	if len(res) == 0 {
+		return ""
+	}
+	data, err := res.JSON()
+	if err != nil {
+		return ""
+	}
+	return data
+}
+
+func dimensionProtocolToDB(dimension *protocol.PLibraryScoreDimension) (*uint8, int8, string) {
+	if dimension == nil {
+		return nil, 0, ""
+	}
+	value := dimension.Value
+	return &value, dimension.Adjustment, dimension.Comment
+}
+
+func libraryScoreDetailFromProtocol(detail protocol.PLibraryScoreDetail) db.LibraryScoreDetailDB {
+	objValue, objAdjustment, objComment := dimensionProtocolToDB(detail.ObjScore)
+	subValue, subAdjustment, subComment := dimensionProtocolToDB(detail.SubScore)
+	innovateValue, innovateAdjustment, innovateComment := dimensionProtocolToDB(detail.InnovateScore)
+	return db.LibraryScoreDetailDB{
+		ID: detail.ID, TaskID: detail.TaskID, RoundID: detail.RoundID, Mode: detail.Mode, Comment: detail.Comment,
+		ObjValue: objValue, ObjAdjustment: objAdjustment, ObjComment: objComment,
+		SubValue: subValue, SubAdjustment: subAdjustment, SubComment: subComment,
+		InnovateValue: innovateValue, InnovateAdjustment: innovateAdjustment, InnovateComment: innovateComment,
+		Revision: max(detail.Revision, 1), CreatedAt: detail.CreatedAt, UpdatedAt: detail.UpdatedAt,
+	}
+}
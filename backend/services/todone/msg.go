@@ -13,6 +13,8 @@ type GetDirTreeReq struct {
 
 type GetDirTreeRet struct {
 	DirTree protocol.PDirTree
+	// Shared 其他账号分享给自己的分组
+	Shared []protocol.PSharedGroup
 }
 
 const CmdMoveDir share.Cmd = "moveDir"
@@ -101,6 +103,8 @@ type CreateGroupReq struct {
 	ParentDir uint32
 	AfterID   uint32
 	GroupType int
+	// ClientRequestID 可选的UUID，重试同一个请求时返回已经创建的分组
+	ClientRequestID string
 }
 
 type CreateGroupRet struct {
@@ -116,9 +120,13 @@ type ChangeGroupReq struct {
 	GroupID     uint32
 	Title       string
 	Note        string
+	// Revision 读取时的版本，必须带上，不一致时返回冲突
+	Revision uint32
 }
 
 type ChangeGroupRet struct {
+	// Revision 修改后的版本，下次修改时带回
+	Revision uint32
 }
 
 const CmdGetSubGroup share.Cmd = "getSubGroup"
@@ -158,6 +166,10 @@ type ChangeTaskReq struct {
 }
 
 type ChangeTaskRet struct {
+	// NextTask 重复任务被标记完成时生成的下一次
+	NextTask *protocol.PTask
+	// Revision 修改后的版本，下次修改时带回
+	Revision uint32
 }
 
 type ChangeDoneTaskReq struct {
@@ -182,6 +194,8 @@ type CreateTaskReq struct {
 	AfterID    uint32
 	Started    bool
 	TaskType   int
+	// ClientRequestID 可选的UUID，重试同一个请求时返回已经创建的任务
+	ClientRequestID string
 }
 
 type CreateTaskRet struct {
@@ -210,6 +224,8 @@ type CreateSubGroupReq struct {
 	Title       string
 	Note        string
 	AfterID     uint32
+	// ClientRequestID 可选的UUID，重试同一个请求时返回已经创建的子分组
+	ClientRequestID string
 }
 
 type CreateSubGroupRet struct {
@@ -237,10 +253,13 @@ type GetTasksReq struct {
 	GroupID     uint32
 	SubGroupID  uint32
 	ContainDone bool
+	// Upcoming 大于0时同时返回未完成的重复任务之后几次的时间，最多10次
+	Upcoming int
 }
 
 type GetTasksRet struct {
-	Tasks []protocol.PTask
+	Tasks    []protocol.PTask
+	Upcoming []protocol.PUpcoming
 }
 
 const CmdChangeSubGroup share.Cmd = "changeSubGroup"
@@ -253,6 +272,8 @@ type ChangeSubGroupReq struct {
 }
 
 type ChangeSubGroupRet struct {
+	// Revision 修改后的版本，下次修改时带回
+	Revision uint32
 }
 
 type TaskKey struct {
@@ -280,9 +301,14 @@ type TaskMoveReq struct {
 	TrgTaskID   uint32
 
 	After bool
+
+	// Revisions 与TaskIDs一一对应的读取时版本，为空时不检查
+	Revisions []uint32
 }
 
 type TaskMoveRet struct {
+	// Revisions 与TaskIDs一一对应的移动后版本
+	Revisions []uint32
 }
 
 const CmdTaskAddTag share.Cmd = "taskAddTag"
@@ -0,0 +1,67 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdBatchTaskOps share.Cmd = "batchTaskOps"
+
+// MaxBatchTaskOps 一次批量操作的上限
+const MaxBatchTaskOps = 100
+
+const (
+	BatchOpDone   = "done"
+	BatchOpUndone = "undone"
+	BatchOpAddTag = "addTag"
+	BatchOpDelTag = "delTag"
+	BatchOpMove   = "move"
+	BatchOpDelete = "delete"
+	BatchOpChange = "change"
+)
+
+// BatchTaskOp 批量操作中的一项。SubGroupID 是批量开始前任务所在的子分组，之前的操作移动过任务时会跟着找到。
+type BatchTaskOp struct {
+	Op         string
+	SubGroupID uint32
+	TaskID     uint32
+	// Revision 批量开始前读到的版本，必须带上
+	Revision uint32
+	// Tag addTag/delTag 使用
+	Tag string
+	// Data change 使用，ID 与 Revision 以外层为准
+	Data protocol.PTask
+	// 以下 move 使用，含义与 taskMove 相同
+	TrgDir      uint32
+	TrgGroup    uint32
+	TrgSubGroup uint32
+	TrgParentID uint32
+	TrgTaskID   uint32
+	After       bool
+}
+
+type BatchTaskOpResult struct {
+	// Err 为空表示成功
+	Err string
+	// Revision 这一项完成后任务的版本，删除时为0
+	Revision uint32
+}
+
+type BatchTaskOpsReq struct {
+	UserID  string
+	DirID   uint32
+	GroupID uint32
+	Ops     []BatchTaskOp
+	// Atomic 为 true 时任意一项失败都不写入，在一个事务中执行。
+	// D1 没有事务，原子模式直接返回 atomic batch unsupported，只能用非原子模式按每项结果处理
+	Atomic bool
+}
+
+type BatchTaskOpsRet struct {
+	// Results 与 Ops 一一对应
+	Results []BatchTaskOpResult
+	// Committed 为 true 表示至少写入了一项
+	Committed bool
+	// NextTasks 完成重复任务时生成的下一次
+	NextTasks []protocol.PTask
+}
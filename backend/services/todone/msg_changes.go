@@ -0,0 +1,32 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+// CmdChanges 变更流，通过 GET /service/todone/changes 升级为WebSocket
+const CmdChanges share.Cmd = "changes"
+
+// 服务端推送的消息类型
+const (
+	ChangesEventChange = "change" // 一条变更
+	ChangesEventReady  = "ready"  // 补发结束，之后都是实时推送
+	ChangesEventReset  = "reset"  // 游标之后的变更无法补发，客户端需要全量刷新后从Cursor继续
+	ChangesEventPing   = "ping"   // 保活
+	ChangesEventError  = "error"  // 出错后服务端关闭连接
+)
+
+// ChangesReq 连接后客户端发送的第一条消息，Cursor 为上次收到的最后一个Seq，0表示只接收之后的变更
+type ChangesReq struct {
+	UserID string
+	Cursor uint32
+}
+
+type ChangesEvent struct {
+	Type string
+	// Cursor 客户端应保存的游标，change为该条的Seq，ready与reset为当前最新的Seq
+	Cursor  uint32
+	Change  *protocol.PChange `json:",omitempty"`
+	Message string            `json:",omitempty"`
+}
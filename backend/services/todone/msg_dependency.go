@@ -0,0 +1,28 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdAddTaskBlocker share.Cmd = "addTaskBlocker"
+
+// AddTaskBlockerReq TaskID 在 BlockerID 完成前处于阻塞状态，两个任务可以在不同的子分组中
+type AddTaskBlockerReq struct {
+	UserID    string
+	TaskID    uint32
+	BlockerID uint32
+}
+
+type AddTaskBlockerRet struct {
+}
+
+const CmdDelTaskBlocker share.Cmd = "delTaskBlocker"
+
+type DelTaskBlockerReq struct {
+	UserID    string
+	TaskID    uint32
+	BlockerID uint32
+}
+
+type DelTaskBlockerRet struct {
+}
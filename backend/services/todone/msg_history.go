@@ -0,0 +1,35 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdGetTaskHistory share.Cmd = "getTaskHistory"
+
+type GetTaskHistoryReq struct {
+	UserID string
+	// TaskID 非0时只返回涉及该任务的记录
+	TaskID uint32
+	// BeforeID 非0时返回比它更早的记录，用于翻页
+	BeforeID uint32
+	// Limit 默认与上限都是100
+	Limit int
+}
+
+type GetTaskHistoryRet struct {
+	Histories []protocol.PTaskHistory
+}
+
+const CmdUndo share.Cmd = "undo"
+
+type UndoReq struct {
+	UserID string
+	// Count 撤销最近几次操作，默认1，最多20
+	Count int
+}
+
+type UndoRet struct {
+	// Undone 被撤销的记录ID，按撤销顺序
+	Undone []uint32
+}
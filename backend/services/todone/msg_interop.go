@@ -0,0 +1,36 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdImportTasks share.Cmd = "importTasks"
+
+type ImportTasksReq struct {
+	UserID     string
+	DirID      uint32
+	GroupID    uint32
+	SubGroupID uint32
+	// ParentTask 不为0时导入的顶层任务都挂在这个任务下
+	ParentTask uint32
+	Format     string // markdown csv
+	Content    string
+}
+
+type ImportTasksRet struct {
+	Tasks []protocol.PTask
+}
+
+const CmdExportTasks share.Cmd = "exportTasks"
+
+type ExportTasksReq struct {
+	UserID     string
+	DirID      uint32
+	GroupID    uint32
+	SubGroupID uint32
+}
+
+type ExportTasksRet struct {
+	Markdown string
+}
@@ -1,30 +1,35 @@
 package todone
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/intmian/platform/backend/services/todone/db"
 	"github.com/intmian/platform/backend/services/todone/logic"
+	"github.com/intmian/platform/backend/services/todone/protocol"
 	backendshare "github.com/intmian/platform/backend/share"
 )
 
-func (s *Service) OnGetDirTree(valid backendshare.Valid, req GetDirTreeReq) (ret GetDirTreeRet, err error) {
+func (s *Service) OnGetDirTree(ctx context.Context, valid backendshare.Valid, req GetDirTreeReq) (ret GetDirTreeRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
 		return
 	}
 	user.Lock()
-	defer user.Unlock()
-	tree, err := user.GetDirTree()
+	tree, err := user.GetDirTree(ctx)
+	user.Unlock()
 	if err != nil {
 		return
 	}
 	ret.DirTree = *tree
+	// 读取分享的分组需要逐个锁住所有者，必须在释放自己的锁之后
+	ret.Shared, err = s.userMgr.SharedGroups(ctx, req.UserID)
 	return
 }
 
-func (s *Service) OnMoveDir(valid backendshare.Valid, req MoveDirReq) (ret MoveDirRet, err error) {
+func (s *Service) OnMoveDir(ctx context.Context, valid backendshare.Valid, req MoveDirReq) (ret MoveDirRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
@@ -33,14 +38,14 @@ func (s *Service) OnMoveDir(valid backendshare.Valid, req MoveDirReq) (ret MoveD
 	user.Lock()
 	defer user.Unlock()
 
-	ret.Index, err = user.MoveDir(req.DirID, req.TrgDir, req.AfterID)
+	ret.Index, err = user.MoveDir(ctx, req.DirID, req.TrgDir, req.AfterID)
 	if err != nil {
 		err = errors.Join(err, errors.New("move dir failed"))
 	}
 	return
 }
 
-func (s *Service) OnMoveGroup(valid backendshare.Valid, req MoveGroupReq) (ret MoveGroupRet, err error) {
+func (s *Service) OnMoveGroup(ctx context.Context, valid backendshare.Valid, req MoveGroupReq) (ret MoveGroupRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
@@ -49,14 +54,14 @@ func (s *Service) OnMoveGroup(valid backendshare.Valid, req MoveGroupReq) (ret M
 	user.Lock()
 	defer user.Unlock()
 
-	ret.Index, err = user.MoveGroup(req.ParentDirID, req.GroupID, req.TrgDir, req.AfterID)
+	ret.Index, err = user.MoveGroup(ctx, req.ParentDirID, req.GroupID, req.TrgDir, req.AfterID)
 	if err != nil {
 		err = errors.Join(err, errors.New("move group failed"))
 	}
 	return
 }
 
-func (s *Service) OnDelGroup(valid backendshare.Valid, req DelGroupReq) (ret DelGroupRet, err error) {
+func (s *Service) OnDelGroup(ctx context.Context, valid backendshare.Valid, req DelGroupReq) (ret DelGroupRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
@@ -65,7 +70,7 @@ func (s *Service) OnDelGroup(valid backendshare.Valid, req DelGroupReq) (ret Del
 	user.Lock()
 	defer user.Unlock()
 
-	err = user.DelGroup(req.ParentDir, req.GroupID)
+	err = user.DelGroup(ctx, req.ParentDir, req.GroupID)
 	if err != nil {
 		err = errors.New("del group failed")
 		return
@@ -73,7 +78,7 @@ func (s *Service) OnDelGroup(valid backendshare.Valid, req DelGroupReq) (ret Del
 	return
 }
 
-func (s *Service) OnCreateDir(valid backendshare.Valid, req CreateDirReq) (ret CreateDirRet, err error) {
+func (s *Service) OnCreateDir(ctx context.Context, valid backendshare.Valid, req CreateDirReq) (ret CreateDirRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
@@ -81,14 +86,14 @@ func (s *Service) OnCreateDir(valid backendshare.Valid, req CreateDirReq) (ret C
 	}
 	user.Lock()
 	defer user.Unlock()
-	dirDB, err := user.CreateDir(req.ParentDirID, req.Title, req.Note)
+	dirDB, err := user.CreateDir(ctx, req.ParentDirID, req.Title, req.Note)
 	if err != nil {
 		err = errors.New("create dir failed")
 		return
 	}
 	ret.DirID = dirDB.ID
 	if req.AfterID != 0 {
-		_, err = user.MoveDir(ret.DirID, req.ParentDirID, req.AfterID)
+		_, err = user.MoveDir(ctx, ret.DirID, req.ParentDirID, req.AfterID)
 		if err != nil {
 			err = errors.New("move dir failed")
 			return
@@ -98,7 +103,7 @@ func (s *Service) OnCreateDir(valid backendshare.Valid, req CreateDirReq) (ret C
 	return
 }
 
-func (s *Service) OnChangeDir(valid backendshare.Valid, req ChangeDirReq) (ret ChangeDirRet, err error) {
+func (s *Service) OnChangeDir(ctx context.Context, valid backendshare.Valid, req ChangeDirReq) (ret ChangeDirRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
@@ -106,12 +111,12 @@ func (s *Service) OnChangeDir(valid backendshare.Valid, req ChangeDirReq) (ret C
 	}
 	user.Lock()
 	defer user.Unlock()
-	dir := user.GetDirLogic(req.DirID)
+	dir := user.GetDirLogic(ctx, req.DirID)
 	if dir == nil {
 		err = errors.New("dir not exist")
 		return
 	}
-	err = dir.ChangeData(req.Title, req.Note, 0)
+	err = dir.ChangeData(ctx, req.Title, req.Note, 0)
 	if err != nil {
 		err = errors.New("change dir failed")
 		return
@@ -119,7 +124,7 @@ func (s *Service) OnChangeDir(valid backendshare.Valid, req ChangeDirReq) (ret C
 	return
 }
 
-func (s *Service) OnDelDir(valid backendshare.Valid, req DelDirReq) (ret DelDirRet, err error) {
+func (s *Service) OnDelDir(ctx context.Context, valid backendshare.Valid, req DelDirReq) (ret DelDirRet, err error) {
 	user := s.userMgr.GetUserLogic(req.UserID)
 	if user == nil {
 		err = errors.New("user not exist")
@@ -127,7 +132,7 @@ func (s *Service) OnDelDir(valid backendshare.Valid, req DelDirReq) (ret DelDirR
 	}
 	user.Lock()
 	defer user.Unlock()
-	err = user.DelDir(req.DirID)
+	err = user.DelDir(ctx, req.DirID)
 	if err != nil {
 		err = errors.New("del dir failed")
 		return
@@ -135,9 +140,23 @@ func (s *Service) OnDelDir(valid backendshare.Valid, req DelDirReq) (ret DelDirR
 	return
 }
 
-func (s *Service) OnCreateGroup(valid backendshare.Valid, req CreateGroupReq) (ret CreateGroupRet, err error) {
+func (s *Service) OnCreateGroup(ctx context.Context, valid backendshare.Valid, req CreateGroupReq) (ret CreateGroupRet, err error) {
+	if err = logic.CheckClientRequestID(req.ClientRequestID); err != nil {
+		return
+	}
 	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		ID, err2, index := user.CreateGroup(req.ParentDir, req.Title, req.Note, req.AfterID, db.GroupType(req.GroupType))
+		existing, err2 := user.FindRequestGroup(ctx, req.ClientRequestID, req.ParentDir, req.Title)
+		if err2 != nil {
+			err = err2
+			return
+		}
+		if existing != nil {
+			pGroup := existing.ToProtocol()
+			ret.GroupID = pGroup.ID
+			ret.Index = pGroup.Index
+			return
+		}
+		ID, err2, index := user.CreateGroup(ctx, req.ParentDir, req.Title, req.Note, req.AfterID, db.GroupType(req.GroupType), req.ClientRequestID)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
@@ -150,28 +169,37 @@ func (s *Service) OnCreateGroup(valid backendshare.Valid, req CreateGroupReq) (r
 	return
 }
 
-func (s *Service) OnChangeGroup(valid backendshare.Valid, req ChangeGroupReq) (ret ChangeGroupRet, err error) {
+func (s *Service) OnChangeGroup(ctx context.Context, valid backendshare.Valid, req ChangeGroupReq) (ret ChangeGroupRet, err error) {
 	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.ParentDirID, req.GroupID)
-		err2 := group.ChangeData(req.Title, req.Note, 0)
+		group := user.GetGroupLogic(ctx, req.ParentDirID, req.GroupID)
+		if group == nil {
+			err = errors.New("group not exist")
+			return
+		}
+		if err2 := group.CheckRevision(req.Revision); err2 != nil {
+			err = err2
+			return
+		}
+		err2 := group.ChangeData(ctx, req.Title, req.Note, 0)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
+		ret.Revision = group.ToProtocol().Revision
 	}, func() {
 		err = errors.New("user not exist")
 	})
 	return
 }
 
-func (s *Service) OnGetSubGroup(valid backendshare.Valid, req GetSubGroupReq) (ret GetSubGroupRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.ParentDirID, req.GroupID)
+func (s *Service) OnGetSubGroup(ctx context.Context, valid backendshare.Valid, req GetSubGroupReq) (ret GetSubGroupRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleViewer, func(user *logic.UserLogic) {
+		group := user.GetGroupLogic(ctx, req.ParentDirID, req.GroupID)
 		if group == nil {
 			err = errors.New("group not exist")
 			return
 		}
-		subGroups, err2 := group.GetSubGroups()
+		subGroups, err2 := group.GetSubGroups(ctx)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
@@ -179,41 +207,87 @@ func (s *Service) OnGetSubGroup(valid backendshare.Valid, req GetSubGroupReq) (r
 		for _, subGroup := range subGroups {
 			ret.SubGroups = append(ret.SubGroups, subGroup.ToProtocol())
 		}
-	}, func() {
-		err = errors.New("user not exist")
+	}, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnGetTask(valid backendshare.Valid, req GetTaskReq) (ret GetTaskRet, err error) {
+func (s *Service) OnGetTask(ctx context.Context, valid backendshare.Valid, req GetTaskReq) (ret GetTaskRet, err error) {
 	f := func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.DirID, req.GroupID)
+		group := user.GetGroupLogic(ctx, req.DirID, req.GroupID)
 		if group == nil {
 			err = errors.New("group not exist")
 			return
 		}
-		subGroup := group.GetSubGroupLogic(req.SubGroupID)
+		subGroup := group.GetSubGroupLogic(ctx, req.SubGroupID)
 		if subGroup == nil {
 			err = errors.New("sub group not exist")
 			return
 		}
-		task := subGroup.GetTaskLogic(req.TaskID)
+		task := subGroup.GetTaskLogic(ctx, req.TaskID)
 		if task == nil {
 			err = errors.New("task not exist")
 			return
 		}
-		ret.Task = task.ToProtocol()
+		ret.Task = task.ToProtocol(ctx)
+		tasks := []protocol.PTask{ret.Task}
+		if err = user.FillBlocked(ctx, tasks); err != nil {
+			return
+		}
+		ret.Task = tasks[0]
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleViewer, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnChangeTask(valid backendshare.Valid, req ChangeTaskReq) (ret ChangeTaskRet, err error) {
+// publishTaskDone 任务完成时向总线发布事件，供其他服务订阅。userID 为分组所有者，共享分组中由被授权人完成的任务同样归属所有者
+func (s *Service) publishTaskDone(userID string, task protocol.PTask) {
+	if s.share.Publish == nil {
+		return
+	}
+	s.share.Publish(backendshare.TopicTodoneTaskDone, backendshare.MakeMsg(backendshare.Cmd(backendshare.TopicTodoneTaskDone), backendshare.TodoneTaskDoneEvent{
+		UserID: userID,
+		TaskID: task.ID,
+		Title:  task.Title,
+	}))
+}
+
+// publishUnblocked 任务完成后，向总线发布因此不再被阻塞的任务
+func (s *Service) publishUnblocked(ctx context.Context, user *logic.UserLogic, blockerID uint32) {
+	tasks, err := user.OnBlockerDone(ctx, blockerID)
+	if err != nil {
+		s.share.Log.WarningErr("TODONE", errors.Join(errors.New("find unblocked tasks failed"), err))
+		return
+	}
+	if s.share.Publish == nil {
+		return
+	}
+	for _, task := range tasks {
+		s.share.Publish(backendshare.TopicTodoneTaskUnblocked, backendshare.MakeMsg(backendshare.Cmd(backendshare.TopicTodoneTaskUnblocked), backendshare.TodoneTaskUnblockedEvent{
+			UserID:    task.UserID,
+			TaskID:    task.TaskID,
+			Title:     task.Title,
+			BlockerID: blockerID,
+		}))
+	}
+}
+
+func (s *Service) OnChangeTask(ctx context.Context, valid backendshare.Valid, req ChangeTaskReq) (ret ChangeTaskRet, err error) {
 	f := func(user *logic.UserLogic) {
-		task := user.GetTaskLogic(req.DirID, req.GroupID, req.SubGroupID, req.Data.ID)
-		data, err2 := task.GetTaskData()
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		if subGroup == nil {
+			err = errors.New("sub group not exist")
+			return
+		}
+		task := subGroup.GetTaskLogic(ctx, req.Data.ID)
+		if task == nil {
+			err = errors.New("task not exist")
+			return
+		}
+		data, err2 := task.GetTaskData(ctx)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
@@ -222,102 +296,169 @@ func (s *Service) OnChangeTask(valid backendshare.Valid, req ChangeTaskReq) (ret
 			err = errors.New("task not exist")
 			return
 		}
-		needRefreshCache := false
-		// 由于缓存限制，如果曾经的任务是未完成的，修改为完成的，缓存需要刷新
-		if data.Done != req.Data.Done && data.Done {
-			needRefreshCache = true
-		}
-		err2 = task.ChangeFromProtocol(req.Data)
+		before := snapshotTasks(ctx, subGroup, []uint32{task.GetID()})
+		changedIDs := []uint32{task.GetID()}
+		becomeDone := !data.Done && req.Data.Done
+		next, err2 := changeTask(ctx, subGroup, task, req.Data)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
-		if needRefreshCache {
-			subGroup := user.GetSubGroupLogic(req.DirID, req.GroupID, req.SubGroupID)
-			err = subGroup.RefreshCache(task)
-			if err != nil {
-				err = errors.Join(err, err2)
-				return
-			}
+		if becomeDone {
+			s.publishTaskDone(user.UserID(), req.Data)
+			s.publishUnblocked(ctx, user, task.GetID())
 		}
+		if next != nil {
+			pTask := next.ToProtocol(ctx)
+			ret.NextTask = &pTask
+			changedIDs = append(changedIDs, next.GetID())
+		}
+		// 完成重复任务时还会记下生成的下一次，最后再取版本
+		ret.Revision = data.Revision
+		s.recordHistory(ctx, user, db.HistoryOpChange, before, snapshotTasks(ctx, subGroup, changedIDs))
 
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnCreateTask(valid backendshare.Valid, req CreateTaskReq) (ret CreateTaskRet, err error) {
+// changeTask 按客户端数据修改任务，完成重复任务时返回生成的下一次
+func changeTask(ctx context.Context, subGroup *logic.SubGroupLogic, task *logic.TaskLogic, pTask protocol.PTask) (*logic.TaskLogic, error) {
+	data, err := task.GetTaskData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 由于缓存限制，如果曾经的任务是未完成的，修改为完成的，缓存需要刷新
+	needRefreshCache := data.Done != pTask.Done && data.Done
+	becomeDone := !data.Done && pTask.Done
+	if err = task.ChangeFromProtocol(ctx, pTask); err != nil {
+		return nil, err
+	}
+	var next *logic.TaskLogic
+	if becomeDone {
+		next, err = subGroup.CreateRepeatTask(ctx, task, time.Now())
+		if err != nil {
+			return nil, errors.Join(errors.New("create repeat task failed"), err)
+		}
+	}
+	if needRefreshCache {
+		if err = subGroup.RefreshCache(task); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+func (s *Service) OnCreateTask(ctx context.Context, valid backendshare.Valid, req CreateTaskReq) (ret CreateTaskRet, err error) {
+	if err = logic.CheckClientRequestID(req.ClientRequestID); err != nil {
+		return
+	}
 	f := func(user *logic.UserLogic) {
+		if req.ClientRequestID != "" {
+			subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+			if subGroup == nil {
+				err = errors.New("group not exist")
+				return
+			}
+			existing, err2 := subGroup.FindRequestTask(ctx, user.UserID(), req.ClientRequestID, req.ParentTask, req.Title)
+			if err2 != nil {
+				err = err2
+				return
+			}
+			if existing != nil {
+				ret.Task = existing.ToProtocol(ctx)
+				return
+			}
+		}
 		var task *logic.TaskLogic
 		if req.ParentTask == 0 {
-			group := user.GetSubGroupLogic(req.DirID, req.GroupID, req.SubGroupID)
+			group := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
 			if group == nil {
 				err = errors.New("group not exist")
 				return
 			}
 			var err2 error
-			task, err2 = group.CreateTask(req.UserID, req.Title, req.Note, db.TaskType(req.TaskType), req.Started, 0)
+			task, err2 = group.CreateTask(ctx, user.UserID(), req.Title, req.Note, db.TaskType(req.TaskType), req.Started, 0, req.ClientRequestID)
 			if err2 != nil {
 				err = errors.Join(errors.New("create task failed"), err2)
 				return
 			}
 		} else {
-			parent := user.GetTaskLogic(req.DirID, req.GroupID, req.SubGroupID, req.ParentTask)
-			data, _ := parent.GetTaskData()
+			parent := user.GetTaskLogic(ctx, req.DirID, req.GroupID, req.SubGroupID, req.ParentTask)
+			data, _ := parent.GetTaskData(ctx)
 			if data == nil {
 				err = errors.New("parent task not exist")
 				return
 			}
 			var err2 error
-			group := user.GetSubGroupLogic(req.DirID, req.GroupID, req.SubGroupID)
+			group := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
 			if group == nil {
 				err = errors.New("group not exist")
 				return
 			}
-			task, err2 = group.CreateTask(req.UserID, req.Title, req.Note, db.TaskType(req.TaskType), req.Started, req.ParentTask)
+			task, err2 = group.CreateTask(ctx, user.UserID(), req.Title, req.Note, db.TaskType(req.TaskType), req.Started, req.ParentTask, req.ClientRequestID)
 			if err2 != nil {
 				err = errors.Join(errors.New("create sub parent failed"), err2)
 				return
 			}
 		}
-		ret.Task = task.ToProtocol()
-
+		ret.Task = task.ToProtocol(ctx)
+		if subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID); subGroup != nil {
+			s.recordHistory(ctx, user, db.HistoryOpCreate, nil, snapshotTasks(ctx, subGroup, []uint32{task.GetID()}))
+		}
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnDelTask(valid backendshare.Valid, req DelTaskReq) (ret DelTaskRet, err error) {
+func (s *Service) OnDelTask(ctx context.Context, valid backendshare.Valid, req DelTaskReq) (ret DelTaskRet, err error) {
 	f := func(user *logic.UserLogic) {
-		subGroup := user.GetSubGroupLogic(req.DirID, req.GroupID, req.SubGroupID)
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
 		if subGroup == nil {
 			err = errors.New("sub group not exist")
 			return
 		}
-		err2 := subGroup.OnDeleteTasks(req.TaskID)
+		before := snapshotTasks(ctx, subGroup, req.TaskID)
+		err2 := subGroup.OnDeleteTasks(ctx, req.TaskID)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
+		after := make([]logic.TaskSnapshot, 0, len(before))
+		for _, snapshot := range before {
+			snapshot.Task.Deleted = true
+			after = append(after, snapshot)
+		}
+		s.recordHistory(ctx, user, db.HistoryOpDelete, before, after)
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnCreateSubGroup(valid backendshare.Valid, req CreateSubGroupReq) (ret CreateSubGroupRet, err error) {
+func (s *Service) OnCreateSubGroup(ctx context.Context, valid backendshare.Valid, req CreateSubGroupReq) (ret CreateSubGroupRet, err error) {
+	if err = logic.CheckClientRequestID(req.ClientRequestID); err != nil {
+		return
+	}
 	f := func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.ParentDirID, req.GroupID)
+		group := user.GetGroupLogic(ctx, req.ParentDirID, req.GroupID)
 		if group == nil {
 			err = errors.New("group not exist")
 			return
 		}
-		subGroup, err2 := group.CreateSubGroupLogic(req.Title, req.Note)
+		subGroup, err2 := group.FindRequestSubGroup(ctx, req.ClientRequestID, req.Title)
+		if err2 != nil {
+			err = err2
+			return
+		}
+		if subGroup == nil {
+			subGroup, err2 = group.CreateSubGroupLogic(ctx, req.Title, req.Note, req.ClientRequestID)
+		}
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
@@ -326,100 +467,141 @@ func (s *Service) OnCreateSubGroup(valid backendshare.Valid, req CreateSubGroupR
 		ret.SubGroupID = protocolSubGroup.ID
 		ret.Index = protocolSubGroup.Index
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnDelSubGroup(valid backendshare.Valid, req DelSubGroupReq) (ret DelSubGroupRet, err error) {
+func (s *Service) OnDelSubGroup(ctx context.Context, valid backendshare.Valid, req DelSubGroupReq) (ret DelSubGroupRet, err error) {
 	f := func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.ParentDirID, req.GroupID)
+		group := user.GetGroupLogic(ctx, req.ParentDirID, req.GroupID)
 		if group == nil {
 			err = errors.New("group not exist")
 			return
 		}
-		err2 := group.DeleteSubGroup(req.SubGroupID)
+		err2 := group.DeleteSubGroup(ctx, req.SubGroupID)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnGetTasks(valid backendshare.Valid, req GetTasksReq) (ret GetTasksRet, err error) {
+func (s *Service) OnGetTasks(ctx context.Context, valid backendshare.Valid, req GetTasksReq) (ret GetTasksRet, err error) {
 	f := func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.ParentDirID, req.GroupID)
+		group := user.GetGroupLogic(ctx, req.ParentDirID, req.GroupID)
 		if group == nil {
 			err = errors.New("group not exist")
 			return
 		}
-		subGroup := group.GetSubGroupLogic(req.SubGroupID)
+		subGroup := group.GetSubGroupLogic(ctx, req.SubGroupID)
 		if subGroup == nil {
 			err = errors.New("sub group not exist")
 			return
 		}
-		tasks, err2 := subGroup.GetTasks(req.ContainDone)
+		tasks, err2 := subGroup.GetTasks(ctx, req.ContainDone)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
 
+		now := time.Now()
 		for _, task := range tasks {
-			ret.Tasks = append(ret.Tasks, task.ToProtocol())
+			ret.Tasks = append(ret.Tasks, task.ToProtocol(ctx))
+			if req.Upcoming <= 0 {
+				continue
+			}
+			occurrences, err2 := task.GetUpcoming(ctx, req.Upcoming, now)
+			if err2 != nil {
+				err = errors.Join(err, err2)
+				return
+			}
+			if len(occurrences) > 0 {
+				ret.Upcoming = append(ret.Upcoming, protocol.PUpcoming{TaskID: task.GetID(), Occurrences: occurrences})
+			}
 		}
+		err = user.FillBlocked(ctx, ret.Tasks)
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleViewer, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnSubGroup(valid backendshare.Valid, req ChangeSubGroupReq) (ret ChangeSubGroupRet, err error) {
+func (s *Service) OnSubGroup(ctx context.Context, valid backendshare.Valid, req ChangeSubGroupReq) (ret ChangeSubGroupRet, err error) {
 	f := func(user *logic.UserLogic) {
-		group := user.GetGroupLogic(req.ParentDirID, req.GroupID)
+		group := user.GetGroupLogic(ctx, req.ParentDirID, req.GroupID)
 		if group == nil {
 			err = errors.New("group not exist")
 			return
 		}
-		subGroup := group.GetSubGroupLogic(req.Data.ID)
+		subGroup := group.GetSubGroupLogic(ctx, req.Data.ID)
 		if subGroup == nil {
 			err = errors.New("sub group not exist")
 			return
 		}
-		err2 := subGroup.ChangeFromProtocol(req.Data)
+		err2 := subGroup.ChangeFromProtocol(ctx, req.Data)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
+		ret.Revision = subGroup.ToProtocol().Revision
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnTaskMove(valid backendshare.Valid, req TaskMoveReq) (ret TaskMoveRet, err error) {
+func (s *Service) OnTaskMove(ctx context.Context, valid backendshare.Valid, req TaskMoveReq) (ret TaskMoveRet, err error) {
+	// 跨分组移动时目标分组也需要可以修改，两个分组属于不同所有者时下面找不到目标子分组
+	if req.TrgGroup != req.GroupID {
+		role, err2 := s.userMgr.GroupRole(ctx, req.UserID, req.TrgGroup)
+		if err2 != nil {
+			err = err2
+			return
+		}
+		if role == protocol.ShareRoleViewer {
+			err = logic.ErrGroupReadOnly
+			return
+		}
+	}
 	f := func(user *logic.UserLogic) {
-		oldSubGroup := user.GetSubGroupLogic(req.DirID, req.GroupID, req.SubGroupID)
-		newSubGroup := user.GetSubGroupLogic(req.TrgDir, req.TrgGroup, req.TrgSubGroup)
+		oldSubGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		newSubGroup := user.GetSubGroupLogic(ctx, req.TrgDir, req.TrgGroup, req.TrgSubGroup)
 		if oldSubGroup == nil || newSubGroup == nil {
 			err = errors.New("sub group not exist")
 			return
 		}
-		newSeq, ids1, ids2 := oldSubGroup.BeforeTaskMove(req.TaskIDs, req.TrgParentID)
-		err2 := newSubGroup.AfterTaskMove(newSeq, ids1, ids2, req.TrgParentID, req.TrgTaskID, req.After)
+		if err2 := oldSubGroup.CheckTaskRevisions(ctx, req.TaskIDs, req.Revisions); err2 != nil {
+			err = err2
+			return
+		}
+		before := snapshotTasks(ctx, oldSubGroup, req.TaskIDs)
+		newSeq, ids1, ids2 := oldSubGroup.BeforeTaskMove(ctx, req.TaskIDs, req.TrgParentID)
+		err2 := newSubGroup.AfterTaskMove(ctx, newSeq, ids1, ids2, req.TrgParentID, req.TrgTaskID, req.After)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
+		for _, taskID := range req.TaskIDs {
+			var revision uint32
+			if task := newSubGroup.GetTaskLogic(ctx, taskID); task != nil {
+				if data, _ := task.GetTaskData(ctx); data != nil {
+					revision = data.Revision
+				}
+			}
+			ret.Revisions = append(ret.Revisions, revision)
+		}
+		s.recordHistory(ctx, user, db.HistoryOpMove, before, snapshotTasks(ctx, newSubGroup, req.TaskIDs))
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	if err != nil {
 		// 有一个奇怪的问题，有时出现移动失败，打个日志看看
@@ -428,40 +610,54 @@ func (s *Service) OnTaskMove(valid backendshare.Valid, req TaskMoveReq) (ret Tas
 	return
 }
 
-func (s *Service) OnTaskAddTag(valid backendshare.Valid, req TaskAddTagReq) (ret TaskAddTagRet, err error) {
+func (s *Service) OnTaskAddTag(ctx context.Context, valid backendshare.Valid, req TaskAddTagReq) (ret TaskAddTagRet, err error) {
 	f := func(user *logic.UserLogic) {
-		task := user.GetTaskLogic(req.DirID, req.GroupID, req.SubGroupID, req.TaskID)
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		if subGroup == nil {
+			err = errors.New("sub group not exist")
+			return
+		}
+		task := subGroup.GetTaskLogic(ctx, req.TaskID)
 		if task == nil {
 			err = errors.New("task not exist")
 			return
 		}
-		err2 := task.AddTag(req.Tag)
+		before := snapshotTasks(ctx, subGroup, []uint32{req.TaskID})
+		err2 := task.AddTag(ctx, req.Tag)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
+		s.recordHistory(ctx, user, db.HistoryOpTag, before, snapshotTasks(ctx, subGroup, []uint32{req.TaskID}))
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
 
-func (s *Service) OnTaskDelTag(valid backendshare.Valid, req TaskDelTagReq) (ret TaskDelTagRet, err error) {
+func (s *Service) OnTaskDelTag(ctx context.Context, valid backendshare.Valid, req TaskDelTagReq) (ret TaskDelTagRet, err error) {
 	f := func(user *logic.UserLogic) {
-		task := user.GetTaskLogic(req.DirID, req.GroupID, req.SubGroupID, req.TaskID)
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		if subGroup == nil {
+			err = errors.New("sub group not exist")
+			return
+		}
+		task := subGroup.GetTaskLogic(ctx, req.TaskID)
 		if task == nil {
 			err = errors.New("task not exist")
 			return
 		}
-		err2 := task.RemoveTag(req.Tag)
+		before := snapshotTasks(ctx, subGroup, []uint32{req.TaskID})
+		err2 := task.RemoveTag(ctx, req.Tag)
 		if err2 != nil {
 			err = errors.Join(err, err2)
 			return
 		}
+		s.recordHistory(ctx, user, db.HistoryOpTag, before, snapshotTasks(ctx, subGroup, []uint32{req.TaskID}))
 	}
-	s.userMgr.SafeUseUserLogic(req.UserID, f, func() {
-		err = errors.New("user not exist")
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, f, func(e error) {
+		err = e
 	})
 	return
 }
@@ -0,0 +1,335 @@
+package todone
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+var (
+	errBatchTooManyOps = errors.New("too many batch ops")
+	errBatchOpInvalid  = errors.New("batch op invalid")
+	errBatchAborted    = errors.New("batch aborted")
+	// errBatchAtomicUnsupported D1 没有事务，做不到整批要么全写要么不写，直接拒绝
+	errBatchAtomicUnsupported = errors.New("atomic batch unsupported")
+)
+
+func (s *Service) OnBatchTaskOps(ctx context.Context, valid backendshare.Valid, req BatchTaskOpsReq) (ret BatchTaskOpsRet, err error) {
+	if len(req.Ops) > MaxBatchTaskOps {
+		err = errBatchTooManyOps
+		return
+	}
+	if req.Atomic && !s.db.SupportTx() {
+		err = errBatchAtomicUnsupported
+		return
+	}
+	// 移动到其他分组时目标分组也需要可以修改，与 taskMove 相同
+	checked := map[uint32]bool{req.GroupID: true}
+	for _, op := range req.Ops {
+		if op.Op != BatchOpMove || checked[op.TrgGroup] {
+			continue
+		}
+		checked[op.TrgGroup] = true
+		role, err2 := s.userMgr.GroupRole(ctx, req.UserID, op.TrgGroup)
+		if err2 != nil {
+			err = err2
+			return
+		}
+		if role == protocol.ShareRoleViewer {
+			err = logic.ErrGroupReadOnly
+			return
+		}
+	}
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		ret, err = s.batchTaskOps(ctx, user, req)
+	}, func(e error) {
+		err = e
+	})
+	return
+}
+
+// batchTaskOps 先检查全部操作再依次执行。原子模式在一个事务中执行，任意一项失败整体回滚，只有支持事务的 SQLite 可以使用
+func (s *Service) batchTaskOps(ctx context.Context, user *logic.UserLogic, req BatchTaskOpsReq) (ret BatchTaskOpsRet, err error) {
+	run := &batchRun{
+		user:      user,
+		where:     make(map[uint32]*logic.SubGroupLogic),
+		touched:   make(map[uint32]bool),
+		subGroups: make(map[*logic.SubGroupLogic]bool),
+	}
+	ret.Results = make([]BatchTaskOpResult, len(req.Ops))
+	checked := make([]bool, len(req.Ops))
+	failed := false
+	for i, op := range req.Ops {
+		if err2 := run.check(ctx, req, op); err2 != nil {
+			ret.Results[i].Err = err2.Error()
+			failed = true
+			continue
+		}
+		checked[i] = true
+	}
+	if failed && req.Atomic {
+		abortResults(ret.Results)
+		return ret, nil
+	}
+
+	applied := 0
+	opFailed := false
+	applyAll := func(ctx context.Context) error {
+		for i, op := range req.Ops {
+			if !checked[i] {
+				continue
+			}
+			revision, err2 := run.apply(ctx, op)
+			if err2 != nil {
+				ret.Results[i].Err = err2.Error()
+				if !req.Atomic {
+					continue
+				}
+				opFailed = true
+				return err2
+			}
+			ret.Results[i].Revision = revision
+			applied++
+		}
+		return nil
+	}
+	if !req.Atomic {
+		_ = applyAll(ctx)
+	} else {
+		checkpoints, err2 := run.checkpoint()
+		if err2 != nil {
+			return ret, err2
+		}
+		if err2 = s.db.Transaction(ctx, applyAll); err2 != nil {
+			// 数据库已经回滚，内存中的序列与任务缓存也恢复到批量之前
+			for _, checkpoint := range checkpoints {
+				if err3 := checkpoint.Restore(); err3 != nil {
+					s.share.Log.ErrorErr("TODONE", errors.Join(errors.New("batch restore cache failed"), err3))
+				}
+			}
+			abortResults(ret.Results)
+			if !opFailed {
+				err = errors.Join(errors.New("batch transaction failed"), err2)
+			}
+			return ret, err
+		}
+	}
+	if applied == 0 {
+		return ret, nil
+	}
+
+	ret.Committed = true
+	if len(run.before) > 0 {
+		s.recordHistory(ctx, user, db.HistoryOpBatch, run.before, run.snapshotAfter(ctx))
+	}
+	for _, pTask := range run.done {
+		s.publishTaskDone(user.UserID(), pTask)
+		s.publishUnblocked(ctx, user, pTask.ID)
+	}
+	for _, taskID := range run.created {
+		if task := run.where[taskID].FindTask(ctx, taskID); task != nil {
+			ret.NextTasks = append(ret.NextTasks, task.ToProtocol(ctx))
+		}
+	}
+	return ret, nil
+}
+
+// abortResults 整批放弃时，没有失败的项标记为 batch aborted，版本清空
+func abortResults(results []BatchTaskOpResult) {
+	for i := range results {
+		results[i].Revision = 0
+		if results[i].Err == "" {
+			results[i].Err = errBatchAborted.Error()
+		}
+	}
+}
+
+// batchRun 一次批量操作的执行状态
+type batchRun struct {
+	user *logic.UserLogic
+	// where 任务当前所在的子分组，移动后跟着更新
+	where map[uint32]*logic.SubGroupLogic
+	// before 每个任务第一次被修改前的快照，用于回滚与历史
+	before  []logic.TaskSnapshot
+	touched map[uint32]bool
+	// created 完成重复任务时生成的下一次
+	created []uint32
+	// done 本次完成的任务，整批写入后再通知
+	done []protocol.PTask
+	// subGroups 批量会修改的子分组，包括移动的目标
+	subGroups map[*logic.SubGroupLogic]bool
+}
+
+func (r *batchRun) check(ctx context.Context, req BatchTaskOpsReq, op BatchTaskOp) error {
+	switch op.Op {
+	case BatchOpDone, BatchOpUndone, BatchOpChange, BatchOpDelete:
+	case BatchOpAddTag, BatchOpDelTag:
+		if op.Tag == "" {
+			return logic.ErrTagEmpty
+		}
+	case BatchOpMove:
+		target := r.user.GetSubGroupLogic(ctx, op.TrgDir, op.TrgGroup, op.TrgSubGroup)
+		if target == nil {
+			return errors.New("sub group not exist")
+		}
+		r.subGroups[target] = true
+	default:
+		return errBatchOpInvalid
+	}
+	subGroup := r.user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, op.SubGroupID)
+	if subGroup == nil {
+		return errors.New("sub group not exist")
+	}
+	if subGroup.FindTask(ctx, op.TaskID) == nil {
+		return errors.New("task not exist")
+	}
+	if err := subGroup.CheckTaskRevisions(ctx, []uint32{op.TaskID}, []uint32{op.Revision}); err != nil {
+		return err
+	}
+	r.where[op.TaskID] = subGroup
+	r.subGroups[subGroup] = true
+	return nil
+}
+
+// checkpoint 记下会修改的子分组在内存中的数据，事务回滚后恢复
+func (r *batchRun) checkpoint() ([]logic.SubGroupCheckpoint, error) {
+	res := make([]logic.SubGroupCheckpoint, 0, len(r.subGroups))
+	for subGroup := range r.subGroups {
+		checkpoint, err := subGroup.Checkpoint()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, checkpoint)
+	}
+	return res, nil
+}
+
+// apply 执行一项操作，返回之后的版本
+func (r *batchRun) apply(ctx context.Context, op BatchTaskOp) (uint32, error) {
+	subGroup := r.where[op.TaskID]
+	task := subGroup.FindTask(ctx, op.TaskID)
+	if task == nil {
+		return 0, errors.New("task not exist")
+	}
+	if err := r.touch(ctx, subGroup, task); err != nil {
+		return 0, err
+	}
+	switch op.Op {
+	case BatchOpDone, BatchOpUndone, BatchOpChange:
+		pTask := op.Data
+		if op.Op != BatchOpChange {
+			pTask = task.ToProtocol(ctx)
+			pTask.Done = op.Op == BatchOpDone
+		}
+		pTask.ID = op.TaskID
+		data, err := task.GetTaskData(ctx)
+		if err != nil {
+			return 0, err
+		}
+		// 版本在检查阶段已经确认过，前面的操作可能已经改过同一个任务，这里以当前版本写入
+		pTask.Revision = data.Revision
+		becomeDone := !data.Done && pTask.Done
+		next, err := changeTask(ctx, subGroup, task, pTask)
+		if err != nil {
+			return 0, err
+		}
+		if becomeDone {
+			r.done = append(r.done, pTask)
+		}
+		if next != nil {
+			r.created = append(r.created, next.GetID())
+			r.where[next.GetID()] = subGroup
+		}
+	case BatchOpAddTag:
+		tags, err := task.GetTags(ctx)
+		if err != nil {
+			return 0, err
+		}
+		// 批量打标签时已经有的跳过
+		if !slices.Contains(tags, op.Tag) {
+			if err = task.AddTag(ctx, op.Tag); err != nil {
+				return 0, err
+			}
+		}
+	case BatchOpDelTag:
+		if err := task.RemoveTag(ctx, op.Tag); err != nil {
+			return 0, err
+		}
+	case BatchOpMove:
+		target := r.user.GetSubGroupLogic(ctx, op.TrgDir, op.TrgGroup, op.TrgSubGroup)
+		if target == nil {
+			return 0, errors.New("sub group not exist")
+		}
+		seq, needChangeParent, noNeedChangeParent := subGroup.BeforeTaskMove(ctx, []uint32{op.TaskID}, op.TrgParentID)
+		if seq == nil {
+			return 0, errors.New("move task failed")
+		}
+		if err := target.AfterTaskMove(ctx, seq, needChangeParent, noNeedChangeParent, op.TrgParentID, op.TrgTaskID, op.After); err != nil {
+			return 0, err
+		}
+		// 子任务跟着一起移动
+		for _, taskID := range append(needChangeParent, noNeedChangeParent...) {
+			if _, ok := r.where[taskID]; ok {
+				r.where[taskID] = target
+			}
+		}
+		subGroup = target
+	case BatchOpDelete:
+		if err := subGroup.OnDeleteTasks(ctx, []uint32{op.TaskID}); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	task = subGroup.FindTask(ctx, op.TaskID)
+	if task == nil {
+		return 0, nil
+	}
+	data, err := task.GetTaskData(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return data.Revision, nil
+}
+
+// touch 任务第一次被修改前记下快照
+func (r *batchRun) touch(ctx context.Context, subGroup *logic.SubGroupLogic, task *logic.TaskLogic) error {
+	if r.touched[task.GetID()] {
+		return nil
+	}
+	snapshot, err := subGroup.SnapshotTask(ctx, task)
+	if err != nil {
+		return err
+	}
+	r.touched[task.GetID()] = true
+	r.before = append(r.before, snapshot)
+	return nil
+}
+
+// snapshotAfter 修改过的任务与生成的任务当前的快照，已经删除的任务沿用修改前的快照并标记删除
+func (r *batchRun) snapshotAfter(ctx context.Context) []logic.TaskSnapshot {
+	res := make([]logic.TaskSnapshot, 0, len(r.before)+len(r.created))
+	for _, before := range r.before {
+		taskID := before.Task.TaskID
+		if task := r.where[taskID].FindTask(ctx, taskID); task != nil {
+			if snapshot, err := r.where[taskID].SnapshotTask(ctx, task); err == nil {
+				res = append(res, snapshot)
+				continue
+			}
+		}
+		before.Task.Deleted = true
+		res = append(res, before)
+	}
+	for _, taskID := range r.created {
+		if task := r.where[taskID].FindTask(ctx, taskID); task != nil {
+			if snapshot, err := r.where[taskID].SnapshotTask(ctx, task); err == nil {
+				res = append(res, snapshot)
+			}
+		}
+	}
+	return res
+}
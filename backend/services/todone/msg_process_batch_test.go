@@ -0,0 +1,162 @@
+package todone
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+func TestBatchTaskOps(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskA := createTestTask(t, s, "u1", "work", "a", "")
+	created, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Title: "b"})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	taskB := created.Task.ID
+	later, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, "u1", CmdCreateSubGroup, CreateSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "later"})
+	if err != nil {
+		t.Fatalf("create sub group: %v", err)
+	}
+	getTask := func(subGroup, taskID uint32) (GetTaskRet, error) {
+		return callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroup, TaskID: taskID})
+	}
+	// rev 批量开始前读到的版本
+	rev := func(subGroup, taskID uint32) uint32 {
+		t.Helper()
+		get, err := getTask(subGroup, taskID)
+		if err != nil {
+			t.Fatalf("get task: %v", err)
+		}
+		return get.Task.Revision
+	}
+	batch := func(atomic bool, ops ...BatchTaskOp) BatchTaskOpsRet {
+		t.Helper()
+		ret, err := callLocal[BatchTaskOpsReq, BatchTaskOpsRet](t, s, "u1", CmdBatchTaskOps, BatchTaskOpsReq{UserID: "u1", DirID: dirID, GroupID: groupID, Ops: ops, Atomic: atomic})
+		if err != nil {
+			t.Fatalf("batch: %v", err)
+		}
+		if len(ret.Results) != len(ops) {
+			t.Fatalf("results = %+v", ret.Results)
+		}
+		return ret
+	}
+
+	// 不带版本的项检查不通过
+	ret := batch(false, BatchTaskOp{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskA})
+	if ret.Committed || ret.Results[0].Err != "revision required" {
+		t.Fatalf("without revision = %+v", ret)
+	}
+
+	// 检查不通过时原子模式什么都不写
+	ret = batch(true,
+		BatchTaskOp{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA)},
+		BatchTaskOp{Op: BatchOpAddTag, SubGroupID: subGroupID, TaskID: taskB, Tag: "x", Revision: 99},
+	)
+	if ret.Committed || ret.Results[0].Err != "batch aborted" || ret.Results[1].Err != "task revision conflict" {
+		t.Fatalf("checked abort = %+v", ret)
+	}
+	if get, err := getTask(subGroupID, taskA); err != nil || get.Task.Done {
+		t.Fatalf("task a after abort = %+v err = %v", get.Task, err)
+	}
+
+	// 执行中途失败时回滚已经写入的部分
+	ret = batch(true,
+		BatchTaskOp{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA)},
+		BatchTaskOp{Op: BatchOpDelete, SubGroupID: subGroupID, TaskID: taskB, Revision: rev(subGroupID, taskB)},
+		BatchTaskOp{Op: BatchOpAddTag, SubGroupID: subGroupID, TaskID: taskB, Revision: rev(subGroupID, taskB), Tag: "x"},
+	)
+	if ret.Committed || ret.Results[2].Err != "task not exist" || ret.Results[0].Err != "batch aborted" {
+		t.Fatalf("rollback = %+v", ret)
+	}
+	if get, err := getTask(subGroupID, taskA); err != nil || get.Task.Done {
+		t.Fatalf("task a after rollback = %+v err = %v", get.Task, err)
+	}
+	if get, err := getTask(subGroupID, taskB); err != nil || len(get.Task.Tags) != 0 {
+		t.Fatalf("task b after rollback = %+v err = %v", get.Task, err)
+	}
+
+	// 回滚时内存中的序列一起恢复，回滚的修改也不会推送
+	sub := s.env.Feed.Subscribe("u1")
+	ret = batch(true,
+		BatchTaskOp{Op: BatchOpMove, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA), TrgDir: dirID, TrgGroup: groupID, TrgSubGroup: later.SubGroupID, After: true},
+		BatchTaskOp{Op: BatchOpDelete, SubGroupID: subGroupID, TaskID: taskB, Revision: rev(subGroupID, taskB)},
+		BatchTaskOp{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskB, Revision: rev(subGroupID, taskB)},
+	)
+	s.env.Feed.Unsubscribe(sub)
+	if ret.Committed || ret.Results[2].Err != "task not exist" {
+		t.Fatalf("move rollback = %+v", ret)
+	}
+	if len(sub.C) != 0 {
+		t.Fatalf("rolled back batch published %d changes", len(sub.C))
+	}
+	for _, want := range []struct {
+		subGroup uint32
+		count    int
+	}{{subGroupID, 2}, {later.SubGroupID, 0}} {
+		tasks, err := callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: want.subGroup})
+		if err != nil || len(tasks.Tasks) != want.count {
+			t.Fatalf("sub group %d tasks after rollback = %+v err = %v", want.subGroup, tasks.Tasks, err)
+		}
+	}
+
+	// 一项都没有写入时不算提交
+	ret = batch(false, BatchTaskOp{Op: "unknown", SubGroupID: subGroupID, TaskID: taskA})
+	if ret.Committed || ret.Results[0].Err != "batch op invalid" {
+		t.Fatalf("nothing applied = %+v", ret)
+	}
+
+	// 非原子模式跳过失败的项，之前的移动会被后面的操作跟上
+	ret = batch(false,
+		BatchTaskOp{Op: BatchOpMove, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA), TrgDir: dirID, TrgGroup: groupID, TrgSubGroup: later.SubGroupID, After: true},
+		BatchTaskOp{Op: BatchOpAddTag, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA), Tag: "moved"},
+		BatchTaskOp{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskB, Revision: rev(subGroupID, taskB)},
+		BatchTaskOp{Op: "unknown", SubGroupID: subGroupID, TaskID: taskB},
+	)
+	if !ret.Committed || ret.Results[0].Err != "" || ret.Results[1].Err != "" || ret.Results[2].Err != "" || ret.Results[3].Err != "batch op invalid" {
+		t.Fatalf("partial = %+v", ret)
+	}
+	get, err := getTask(later.SubGroupID, taskA)
+	if err != nil || !slices.Contains(get.Task.Tags, "moved") || get.Task.Revision != ret.Results[1].Revision {
+		t.Fatalf("moved task = %+v results = %+v err = %v", get.Task, ret.Results, err)
+	}
+	if get, err = getTask(subGroupID, taskB); err != nil || !get.Task.Done {
+		t.Fatalf("task b = %+v err = %v", get.Task, err)
+	}
+
+	// 整批记为一条历史，可以一次撤销
+	if _, err = callLocal[UndoReq, UndoRet](t, s, "u1", CmdUndo, UndoReq{UserID: "u1"}); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	if get, err = getTask(subGroupID, taskA); err != nil || len(get.Task.Tags) != 0 {
+		t.Fatalf("task a after undo = %+v err = %v", get.Task, err)
+	}
+	if get, err = getTask(subGroupID, taskB); err != nil || get.Task.Done {
+		t.Fatalf("task b after undo = %+v err = %v", get.Task, err)
+	}
+	tasks, err := callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: later.SubGroupID})
+	if err != nil || len(tasks.Tasks) != 0 {
+		t.Fatalf("later tasks after undo = %+v err = %v", tasks.Tasks, err)
+	}
+
+	if _, err = callLocal[BatchTaskOpsReq, BatchTaskOpsRet](t, s, "u1", CmdBatchTaskOps, BatchTaskOpsReq{UserID: "u1", DirID: dirID, GroupID: groupID, Ops: make([]BatchTaskOp, MaxBatchTaskOps+1)}); err == nil {
+		t.Fatalf("too many ops accepted")
+	}
+
+	// 用SQLite模拟没有事务的D1，原子模式直接拒绝，什么都不写
+	s.db.Setting.Driver = db.DriverWorker
+	_, err = callLocal[BatchTaskOpsReq, BatchTaskOpsRet](t, s, "u1", CmdBatchTaskOps, BatchTaskOpsReq{UserID: "u1", DirID: dirID, GroupID: groupID, Atomic: true,
+		Ops: []BatchTaskOp{{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA)}}})
+	if err == nil || !strings.Contains(err.Error(), "atomic batch unsupported") {
+		t.Fatalf("d1 atomic err = %v", err)
+	}
+	if get, err = getTask(subGroupID, taskA); err != nil || get.Task.Done {
+		t.Fatalf("task a after d1 atomic = %+v err = %v", get.Task, err)
+	}
+	if ret = batch(false, BatchTaskOp{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: taskA, Revision: rev(subGroupID, taskA)}); !ret.Committed {
+		t.Fatalf("d1 non atomic = %+v", ret)
+	}
+}
@@ -0,0 +1,27 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnAddTaskBlocker(ctx context.Context, valid backendshare.Valid, req AddTaskBlockerReq) (ret AddTaskBlockerRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.AddTaskBlocker(ctx, req.TaskID, req.BlockerID)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnDelTaskBlocker(ctx context.Context, valid backendshare.Valid, req DelTaskBlockerReq) (ret DelTaskBlockerRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.DelTaskBlocker(ctx, req.TaskID, req.BlockerID)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
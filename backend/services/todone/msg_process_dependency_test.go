@@ -0,0 +1,109 @@
+package todone
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func TestTaskBlockers(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskA := createTestTask(t, s, "u1", "work", "ship", "")
+	otherDir, otherGroup, otherSub, taskB := createTestTask(t, s, "u1", "home", "review", "")
+	created, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: otherDir, GroupID: otherGroup, SubGroupID: otherSub, Title: "test"})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	taskC := created.Task.ID
+	var events []backendshare.TodoneTaskUnblockedEvent
+	s.share.Publish = func(topic backendshare.Topic, msg backendshare.Msg) {
+		if topic != backendshare.TopicTodoneTaskUnblocked {
+			return
+		}
+		var event backendshare.TodoneTaskUnblockedEvent
+		if err := msg.Data(&event); err != nil {
+			t.Errorf("event data: %v", err)
+		}
+		events = append(events, event)
+	}
+	addBlocker := func(taskID, blockerID uint32) error {
+		_, err := callLocal[AddTaskBlockerReq, AddTaskBlockerRet](t, s, "u1", CmdAddTaskBlocker, AddTaskBlockerReq{UserID: "u1", TaskID: taskID, BlockerID: blockerID})
+		return err
+	}
+	getA := func() GetTaskRet {
+		t.Helper()
+		get, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskA})
+		if err != nil {
+			t.Fatalf("get task: %v", err)
+		}
+		return get
+	}
+
+	// 可以跨子分组阻塞
+	if err = addBlocker(taskA, taskB); err != nil {
+		t.Fatalf("add blocker b: %v", err)
+	}
+	if err = addBlocker(taskA, taskC); err != nil {
+		t.Fatalf("add blocker c: %v", err)
+	}
+	if get := getA(); !get.Task.Blocked || !slices.Equal(get.Task.BlockedBy, []uint32{taskB, taskC}) {
+		t.Fatalf("blocked task = %+v", get.Task)
+	}
+	for _, c := range []struct {
+		taskID, blockerID uint32
+		want              string
+	}{
+		{taskA, taskA, "task can not block itself"},
+		{taskA, taskB, "task dependency already exists"},
+		{taskC, taskA, "task dependency cycle"},
+		{taskA, 9999, "task not exist"},
+	} {
+		if err = addBlocker(c.taskID, c.blockerID); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("add %d <- %d err = %v, want %s", c.taskID, c.blockerID, err, c.want)
+		}
+	}
+
+	// 还有一个阻塞任务没完成，不通知
+	getB, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: otherDir, GroupID: otherGroup, SubGroupID: otherSub, TaskID: taskB})
+	if err != nil {
+		t.Fatalf("get task b: %v", err)
+	}
+	getB.Task.Done = true
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: otherDir, GroupID: otherGroup, SubGroupID: otherSub, Data: getB.Task}); err != nil {
+		t.Fatalf("done b: %v", err)
+	}
+	if get := getA(); !get.Task.Blocked || len(events) != 0 {
+		t.Fatalf("after b done task = %+v events = %+v", get.Task, events)
+	}
+
+	// 最后一个阻塞任务在批量操作中完成
+	getC, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: otherDir, GroupID: otherGroup, SubGroupID: otherSub, TaskID: taskC})
+	if err != nil {
+		t.Fatalf("get task c: %v", err)
+	}
+	batch, err := callLocal[BatchTaskOpsReq, BatchTaskOpsRet](t, s, "u1", CmdBatchTaskOps, BatchTaskOpsReq{UserID: "u1", DirID: otherDir, GroupID: otherGroup,
+		Ops: []BatchTaskOp{{Op: BatchOpDone, SubGroupID: otherSub, TaskID: taskC, Revision: getC.Task.Revision}}})
+	if err != nil || !batch.Committed {
+		t.Fatalf("done c = %+v err = %v", batch, err)
+	}
+	if len(events) != 1 || events[0].TaskID != taskA || events[0].BlockerID != taskC || events[0].Title != "ship" {
+		t.Fatalf("events = %+v", events)
+	}
+	tasks, err := callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID})
+	if err != nil || len(tasks.Tasks) != 1 || tasks.Tasks[0].Blocked || len(tasks.Tasks[0].BlockedBy) != 2 {
+		t.Fatalf("tasks = %+v err = %v", tasks.Tasks, err)
+	}
+
+	if _, err = callLocal[DelTaskBlockerReq, DelTaskBlockerRet](t, s, "u1", CmdDelTaskBlocker, DelTaskBlockerReq{UserID: "u1", TaskID: taskA, BlockerID: taskB}); err != nil {
+		t.Fatalf("del blocker: %v", err)
+	}
+	if get := getA(); !slices.Equal(get.Task.BlockedBy, []uint32{taskC}) {
+		t.Fatalf("after del task = %+v", get.Task)
+	}
+	if _, err = callLocal[DelTaskBlockerReq, DelTaskBlockerRet](t, s, "u1", CmdDelTaskBlocker, DelTaskBlockerReq{UserID: "u1", TaskID: taskA, BlockerID: taskB}); err == nil || !strings.Contains(err.Error(), "task dependency not exist") {
+		t.Fatalf("del missing err = %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+// recordHistory 记录一次任务操作，操作本身已经成功，记录失败只打日志
+func (s *Service) recordHistory(ctx context.Context, user *logic.UserLogic, op string, before, after []logic.TaskSnapshot) {
+	if err := user.RecordHistory(ctx, op, before, after); err != nil {
+		s.share.Log.WarningErr("TODONE", errors.Join(errors.New("record task history failed"), err))
+	}
+}
+
+// snapshotTasks 记录历史用的快照，取不到快照的任务跳过
+func snapshotTasks(ctx context.Context, subGroup *logic.SubGroupLogic, taskIDs []uint32) []logic.TaskSnapshot {
+	res := make([]logic.TaskSnapshot, 0, len(taskIDs))
+	for _, taskID := range taskIDs {
+		task := subGroup.GetTaskLogic(ctx, taskID)
+		if task == nil {
+			continue
+		}
+		snapshot, err := subGroup.SnapshotTask(ctx, task)
+		if err != nil {
+			continue
+		}
+		res = append(res, snapshot)
+	}
+	return res
+}
+
+func (s *Service) OnGetTaskHistory(ctx context.Context, valid backendshare.Valid, req GetTaskHistoryReq) (ret GetTaskHistoryRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Histories, err = user.GetTaskHistory(ctx, req.TaskID, req.BeforeID, req.Limit)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnUndo(ctx context.Context, valid backendshare.Valid, req UndoReq) (ret UndoRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Undone, err = user.Undo(ctx, req.Count)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
@@ -0,0 +1,158 @@
+package todone
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+type testSubGroup struct {
+	dirID, groupID, subGroupID uint32
+}
+
+func (g testSubGroup) createTask(t *testing.T, s *Service, title string) uint32 {
+	t.Helper()
+	ret, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: g.dirID, GroupID: g.groupID, SubGroupID: g.subGroupID, Title: title})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	return ret.Task.ID
+}
+
+// titles 按展示顺序返回未完成任务的标题
+func (g testSubGroup) titles(t *testing.T, s *Service) []string {
+	t.Helper()
+	ret, err := callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: g.dirID, GroupID: g.groupID, SubGroupID: g.subGroupID})
+	if err != nil {
+		t.Fatalf("get tasks: %v", err)
+	}
+	sort.Slice(ret.Tasks, func(i, j int) bool { return ret.Tasks[i].Index < ret.Tasks[j].Index })
+	titles := make([]string, 0, len(ret.Tasks))
+	for _, task := range ret.Tasks {
+		titles = append(titles, task.Title+tagSuffix(task.Tags))
+	}
+	return titles
+}
+
+func tagSuffix(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "#" + tags[0]
+}
+
+func undo(t *testing.T, s *Service, count int) []uint32 {
+	t.Helper()
+	ret, err := callLocal[UndoReq, UndoRet](t, s, "u1", CmdUndo, UndoReq{UserID: "u1", Count: count})
+	if err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	return ret.Undone
+}
+
+func TestUndoRestoresTasks(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, aID := createTestTask(t, s, "u1", "work", "a", "")
+	src := testSubGroup{dirID, groupID, subGroupID}
+	other, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, "u1", CmdCreateSubGroup, CreateSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "other"})
+	if err != nil {
+		t.Fatalf("create sub group: %v", err)
+	}
+	dst := testSubGroup{dirID, groupID, other.SubGroupID}
+	bID := src.createTask(t, s, "b")
+	cID := src.createTask(t, s, "c")
+	if got := src.titles(t, s); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("titles = %v", got)
+	}
+
+	// 删除中间的任务后撤销，回到原来的位置
+	if _, err = callLocal[DelTaskReq, DelTaskRet](t, s, "u1", CmdDelTask, DelTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: []uint32{bID}}); err != nil {
+		t.Fatalf("del task: %v", err)
+	}
+	if got := src.titles(t, s); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Fatalf("titles after delete = %v", got)
+	}
+	undo(t, s, 1)
+	if got := src.titles(t, s); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("titles after undo delete = %v", got)
+	}
+
+	// 改名、加标签、移动到其他子分组，一次撤销三步
+	task, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: aID})
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	data := task.Task
+	data.Title = "a2"
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data}); err != nil {
+		t.Fatalf("change task: %v", err)
+	}
+	if _, err = callLocal[TaskAddTagReq, TaskAddTagRet](t, s, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: aID, Tag: "x"}); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+	if _, err = callLocal[TaskMoveReq, TaskMoveRet](t, s, "u1", CmdTaskMove, TaskMoveReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskIDs: []uint32{aID}, TrgDir: dirID, TrgGroup: groupID, TrgSubGroup: dst.subGroupID, After: true}); err != nil {
+		t.Fatalf("move task: %v", err)
+	}
+	if got := src.titles(t, s); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Fatalf("src titles after move = %v", got)
+	}
+	if got := dst.titles(t, s); !reflect.DeepEqual(got, []string{"a2#x"}) {
+		t.Fatalf("dst titles after move = %v", got)
+	}
+	undone := undo(t, s, 3)
+	if len(undone) != 3 {
+		t.Fatalf("undone = %v", undone)
+	}
+	if got := src.titles(t, s); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("src titles after undo = %v", got)
+	}
+	if got := dst.titles(t, s); len(got) != 0 {
+		t.Fatalf("dst titles after undo = %v", got)
+	}
+
+	// 再往前是新建c，撤销新建就是删除
+	undo(t, s, 1)
+	if got := src.titles(t, s); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("titles after undo create = %v", got)
+	}
+
+	history, err := callLocal[GetTaskHistoryReq, GetTaskHistoryRet](t, s, "u1", CmdGetTaskHistory, GetTaskHistoryReq{UserID: "u1", TaskID: aID})
+	if err != nil {
+		t.Fatalf("get history: %v", err)
+	}
+	var ops []string
+	for _, h := range history.Histories {
+		op := h.Op
+		if h.Reverted {
+			op += "*"
+		}
+		ops = append(ops, op)
+	}
+	want := []string{db.HistoryOpUndo, db.HistoryOpUndo, db.HistoryOpUndo, db.HistoryOpMove + "*", db.HistoryOpTag + "*", db.HistoryOpChange + "*", db.HistoryOpCreate}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("ops = %v want %v", ops, want)
+	}
+	change := history.Histories[5]
+	if change.Before[0].Title != "a" || change.After[0].Title != "a2" {
+		t.Fatalf("change = %+v", change)
+	}
+	// 最近的一条撤销对应最早被撤销的改名
+	if history.Histories[0].RevertID != change.ID {
+		t.Fatalf("undo revert id = %d want %d", history.Histories[0].RevertID, change.ID)
+	}
+	cHistory, err := callLocal[GetTaskHistoryReq, GetTaskHistoryRet](t, s, "u1", CmdGetTaskHistory, GetTaskHistoryReq{UserID: "u1", TaskID: cID, Limit: 1})
+	if err != nil || len(cHistory.Histories) != 1 || cHistory.Histories[0].Op != db.HistoryOpUndo || cHistory.Histories[0].Before[0].Title != "c" {
+		t.Fatalf("c history = %+v err = %v", cHistory.Histories, err)
+	}
+}
+
+func TestUndoNothing(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	getTestDirTree(t, s, "u1")
+	if _, err := callLocal[UndoReq, UndoRet](t, s, "u1", CmdUndo, UndoReq{UserID: "u1"}); err == nil {
+		t.Fatal("undo without history succeeded")
+	}
+}
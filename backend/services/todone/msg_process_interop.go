@@ -0,0 +1,61 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnImportTasks(ctx context.Context, valid backendshare.Valid, req ImportTasksReq) (ret ImportTasksRet, err error) {
+	var items []logic.ImportItem
+	switch req.Format {
+	case protocol.TaskImportFormatMarkdown:
+		items, err = logic.ParseMarkdownChecklist(req.Content)
+	case protocol.TaskImportFormatCSV:
+		items, err = logic.ParseTaskCSV(req.Content)
+	default:
+		err = errors.New("unknown import format")
+	}
+	if err != nil {
+		return
+	}
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		if subGroup == nil {
+			err = errors.New("sub group not exist")
+			return
+		}
+		var tasks []*logic.TaskLogic
+		tasks, err = subGroup.ImportTasks(ctx, user.UserID(), items, req.ParentTask)
+		ids := make([]uint32, 0, len(tasks))
+		for _, task := range tasks {
+			ret.Tasks = append(ret.Tasks, task.ToProtocol(ctx))
+			ids = append(ids, task.GetID())
+		}
+		// 中途失败时已经创建的任务也记一笔，撤销时一起删除
+		if len(ids) > 0 {
+			s.recordHistory(ctx, user, db.HistoryOpCreate, nil, snapshotTasks(ctx, subGroup, ids))
+		}
+	}, func(e error) {
+		err = e
+	})
+	return
+}
+
+func (s *Service) OnExportTasks(ctx context.Context, valid backendshare.Valid, req ExportTasksReq) (ret ExportTasksRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleViewer, func(user *logic.UserLogic) {
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		if subGroup == nil {
+			err = errors.New("sub group not exist")
+			return
+		}
+		ret.Markdown, err = subGroup.ExportMarkdown(ctx)
+	}, func(e error) {
+		err = e
+	})
+	return
+}
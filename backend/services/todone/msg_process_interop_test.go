@@ -0,0 +1,53 @@
+package todone
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func TestImportExportTasksMarkdown(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, _ := createTestTask(t, s, "u1", "work", "existing", "")
+	text := "- [ ] pack #home\n" +
+		"  bring charger\n" +
+		"  - [x] passport\n" +
+		"  - [ ] snacks\n" +
+		"- [ ] leave\n"
+	ret, err := callLocal[ImportTasksReq, ImportTasksRet](t, s, "u1", CmdImportTasks, ImportTasksReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Format: protocol.TaskImportFormatMarkdown, Content: text})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(ret.Tasks) != 4 || ret.Tasks[1].ParentID != ret.Tasks[0].ID || !ret.Tasks[1].Done {
+		t.Fatalf("imported = %+v", ret.Tasks)
+	}
+
+	exported, err := callLocal[ExportTasksReq, ExportTasksRet](t, s, "u1", CmdExportTasks, ExportTasksReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	// 未完成的子任务排在已完成的前面
+	want := "- [ ] existing\n" +
+		"- [ ] pack #home\n" +
+		"  bring charger\n" +
+		"  - [ ] snacks\n" +
+		"  - [x] passport\n" +
+		"- [ ] leave\n"
+	if exported.Markdown != want {
+		t.Fatalf("markdown = %q", exported.Markdown)
+	}
+
+	// 一次导入作为一条历史，撤销后全部删除
+	if _, err = callLocal[UndoReq, UndoRet](t, s, "u1", CmdUndo, UndoReq{UserID: "u1"}); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	sub := testSubGroup{dirID, groupID, subGroupID}
+	if got := sub.titles(t, s); len(got) != 1 || got[0] != "existing" {
+		t.Fatalf("titles after undo = %v", got)
+	}
+
+	if _, err = callLocal[ImportTasksReq, ImportTasksRet](t, s, "u1", CmdImportTasks, ImportTasksReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Format: "xml", Content: text}); err == nil {
+		t.Fatalf("unknown format should fail")
+	}
+}
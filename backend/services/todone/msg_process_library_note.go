@@ -1,6 +1,7 @@
 package todone
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"unicode/utf8"
@@ -19,27 +20,27 @@ type validatedLibraryTask struct {
 	RoundIDs []string
 }
 
-func validateLibraryTask(user *logic.UserLogic, scope LibraryTaskScope) (*validatedLibraryTask, error) {
-	group := user.GetGroupLogic(scope.DirID, scope.GroupID)
+func validateLibraryTask(ctx context.Context, user *logic.UserLogic, scope LibraryTaskScope) (*validatedLibraryTask, error) {
+	group := user.GetGroupLogic(ctx, scope.DirID, scope.GroupID)
 	if group == nil {
 		return nil, errors.New("group not exist")
 	}
-	groupData, err := group.GetGroupData()
+	groupData, err := group.GetGroupData(ctx)
 	if err != nil || groupData == nil {
 		return nil, errors.New("group not exist")
 	}
 	if groupData.Type != db.GroupTypeLibrary {
 		return nil, errors.New("group is not library")
 	}
-	subGroup := group.GetSubGroupLogic(scope.SubGroupID)
+	subGroup := group.GetSubGroupLogic(ctx, scope.SubGroupID)
 	if subGroup == nil {
 		return nil, errors.New("sub group not exist")
 	}
-	task := subGroup.GetTaskLogic(scope.TaskID)
+	task := subGroup.GetTaskLogic(ctx, scope.TaskID)
 	if task == nil {
 		return nil, errors.New("task not exist")
 	}
-	taskData, err := task.GetTaskData()
+	taskData, err := task.GetTaskData(ctx)
 	if err != nil || taskData == nil || taskData.Deleted {
 		return nil, errors.New("task not exist")
 	}
@@ -64,37 +65,30 @@ func validateLibraryNoteContent(content string) (string, error) {
 	return content, nil
 }
 
-func libraryNoteToProtocol(note db.LibraryNoteDB) protocol.PLibraryNote {
-	return protocol.PLibraryNote{
-		ID: note.ID, TaskID: note.TaskID, RoundID: note.RoundID, EventTime: note.EventTime,
-		Content: note.Content, Revision: note.Revision, CreatedAt: note.CreatedAt, UpdatedAt: note.UpdatedAt,
-	}
-}
-
-func (s *Service) OnGetLibraryNotes(_ backendshare.Valid, req GetLibraryNotesReq) (ret GetLibraryNotesRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnGetLibraryNotes(ctx context.Context, _ backendshare.Valid, req GetLibraryNotesReq) (ret GetLibraryNotesRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleViewer, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
 		}
-		conn := db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryNote)
-		notes, getErr := db.GetLibraryNotes(conn, req.UserID, validated.Task.GetID(), validated.RoundIDs)
+		conn := s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryNote)
+		notes, getErr := db.GetLibraryNotes(conn, user.UserID(), validated.Task.GetID(), validated.RoundIDs)
 		if getErr != nil {
 			err = getErr
 			return
 		}
 		ret.Notes = make([]protocol.PLibraryNote, 0, len(notes))
 		for _, note := range notes {
-			ret.Notes = append(ret.Notes, libraryNoteToProtocol(note))
+			ret.Notes = append(ret.Notes, logic.LibraryNoteToProtocol(note))
 		}
-	}, func() { err = errors.New("user not exist") })
+	}, func(e error) { err = e })
 	return
 }
 
-func (s *Service) OnCreateLibraryNote(_ backendshare.Valid, req CreateLibraryNoteReq) (ret CreateLibraryNoteRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnCreateLibraryNote(ctx context.Context, _ backendshare.Valid, req CreateLibraryNoteReq) (ret CreateLibraryNoteRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
@@ -118,22 +112,22 @@ func (s *Service) OnCreateLibraryNote(_ backendshare.Valid, req CreateLibraryNot
 		}
 		requestID := req.ClientRequestID
 		note := &db.LibraryNoteDB{
-			ID: uuid.NewString(), UserID: req.UserID, TaskID: validated.Task.GetID(), RoundID: req.RoundID,
+			ID: uuid.NewString(), UserID: user.UserID(), TaskID: validated.Task.GetID(), RoundID: req.RoundID,
 			EventTime: req.EventTime.UTC(), Content: content, Revision: 1, ClientRequestID: &requestID,
 		}
-		created, createErr := db.CreateLibraryNote(db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryNote), note)
+		created, createErr := db.CreateLibraryNote(s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryNote), note)
 		if createErr != nil {
 			err = createErr
 			return
 		}
-		ret.Note = libraryNoteToProtocol(*created)
-	}, func() { err = errors.New("user not exist") })
+		ret.Note = logic.LibraryNoteToProtocol(*created)
+	}, func(e error) { err = e })
 	return
 }
 
-func (s *Service) OnChangeLibraryNote(_ backendshare.Valid, req ChangeLibraryNoteReq) (ret ChangeLibraryNoteRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnChangeLibraryNote(ctx context.Context, _ backendshare.Valid, req ChangeLibraryNoteReq) (ret ChangeLibraryNoteRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
@@ -147,7 +141,7 @@ func (s *Service) OnChangeLibraryNote(_ backendshare.Valid, req ChangeLibraryNot
 			err = errors.New("library note event time empty")
 			return
 		}
-		existing, getErr := db.GetLibraryNote(db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryNote), req.UserID, validated.Task.GetID(), req.NoteID)
+		existing, getErr := db.GetLibraryNote(s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryNote), user.UserID(), validated.Task.GetID(), req.NoteID)
 		if getErr != nil {
 			err = getErr
 			return
@@ -157,26 +151,26 @@ func (s *Service) OnChangeLibraryNote(_ backendshare.Valid, req ChangeLibraryNot
 			return
 		}
 		updated, updateErr := db.ChangeLibraryNote(
-			db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryNote), req.UserID, validated.Task.GetID(),
+			s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryNote), user.UserID(), validated.Task.GetID(),
 			req.NoteID, req.Revision, content, req.EventTime.UTC(),
 		)
 		if updateErr != nil {
 			err = updateErr
 			return
 		}
-		ret.Note = libraryNoteToProtocol(*updated)
-	}, func() { err = errors.New("user not exist") })
+		ret.Note = logic.LibraryNoteToProtocol(*updated)
+	}, func(e error) { err = e })
 	return
 }
 
-func (s *Service) OnDelLibraryNote(_ backendshare.Valid, req DelLibraryNoteReq) (ret DelLibraryNoteRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnDelLibraryNote(ctx context.Context, _ backendshare.Valid, req DelLibraryNoteReq) (ret DelLibraryNoteRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
 		}
-		existing, getErr := db.GetLibraryNote(db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryNote), req.UserID, validated.Task.GetID(), req.NoteID)
+		existing, getErr := db.GetLibraryNote(s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryNote), user.UserID(), validated.Task.GetID(), req.NoteID)
 		if getErr != nil {
 			err = getErr
 			return
@@ -186,8 +180,8 @@ func (s *Service) OnDelLibraryNote(_ backendshare.Valid, req DelLibraryNoteReq)
 			return
 		}
 		err = db.DeleteLibraryNote(
-			db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryNote), req.UserID, validated.Task.GetID(), req.NoteID, req.Revision,
+			s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryNote), user.UserID(), validated.Task.GetID(), req.NoteID, req.Revision,
 		)
-	}, func() { err = errors.New("user not exist") })
+	}, func(e error) { err = e })
 	return
 }
@@ -1,6 +1,7 @@
 package todone
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"unicode/utf8"
@@ -88,25 +89,8 @@ func scoreDetailInputToDB(userID string, taskID uint32, roundID, scoreID string,
 	}
 }
 
-func dimensionDBToProtocol(value *uint8, adjustment int8, comment string) *protocol.PLibraryScoreDimension {
-	if value == nil {
-		return nil
-	}
-	return &protocol.PLibraryScoreDimension{Value: *value, Adjustment: adjustment, Comment: comment}
-}
-
-func libraryScoreDetailToProtocol(detail db.LibraryScoreDetailDB) protocol.PLibraryScoreDetail {
-	return protocol.PLibraryScoreDetail{
-		ID: detail.ID, TaskID: detail.TaskID, RoundID: detail.RoundID, Mode: detail.Mode, Comment: detail.Comment,
-		ObjScore:      dimensionDBToProtocol(detail.ObjValue, detail.ObjAdjustment, detail.ObjComment),
-		SubScore:      dimensionDBToProtocol(detail.SubValue, detail.SubAdjustment, detail.SubComment),
-		InnovateScore: dimensionDBToProtocol(detail.InnovateValue, detail.InnovateAdjustment, detail.InnovateComment),
-		Revision:      detail.Revision, CreatedAt: detail.CreatedAt, UpdatedAt: detail.UpdatedAt,
-	}
-}
-
-func validateScoreExistsInTask(validated *validatedLibraryTask, scoreID string) (string, error) {
-	taskData, err := validated.Task.GetTaskData()
+func validateScoreExistsInTask(ctx context.Context, validated *validatedLibraryTask, scoreID string) (string, error) {
+	taskData, err := validated.Task.GetTaskData(ctx)
 	if err != nil || taskData == nil {
 		return "", errors.New("task not exist")
 	}
@@ -120,19 +104,19 @@ func validateScoreExistsInTask(validated *validatedLibraryTask, scoreID string)
 	return roundID, nil
 }
 
-func (s *Service) OnGetLibraryScoreDetail(_ backendshare.Valid, req GetLibraryScoreDetailReq) (ret GetLibraryScoreDetailRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnGetLibraryScoreDetail(ctx context.Context, _ backendshare.Valid, req GetLibraryScoreDetailReq) (ret GetLibraryScoreDetailRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleViewer, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
 		}
-		roundID, scoreErr := validateScoreExistsInTask(validated, req.ScoreID)
+		roundID, scoreErr := validateScoreExistsInTask(ctx, validated, req.ScoreID)
 		if scoreErr != nil {
 			err = scoreErr
 			return
 		}
-		detail, getErr := db.GetLibraryScoreDetail(db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryScoreDetail), req.UserID, validated.Task.GetID(), req.ScoreID)
+		detail, getErr := db.GetLibraryScoreDetail(s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryScoreDetail), user.UserID(), validated.Task.GetID(), req.ScoreID)
 		if getErr != nil {
 			err = getErr
 			return
@@ -141,14 +125,14 @@ func (s *Service) OnGetLibraryScoreDetail(_ backendshare.Valid, req GetLibrarySc
 			err = errors.New("library score round mismatch")
 			return
 		}
-		ret.Detail = libraryScoreDetailToProtocol(*detail)
-	}, func() { err = errors.New("user not exist") })
+		ret.Detail = logic.LibraryScoreDetailToProtocol(*detail)
+	}, func(e error) { err = e })
 	return
 }
 
-func (s *Service) OnCreateLibraryScoreDetail(_ backendshare.Valid, req CreateLibraryScoreDetailReq) (ret CreateLibraryScoreDetailRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnCreateLibraryScoreDetail(ctx context.Context, _ backendshare.Valid, req CreateLibraryScoreDetailReq) (ret CreateLibraryScoreDetailRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
@@ -170,28 +154,28 @@ func (s *Service) OnCreateLibraryScoreDetail(_ backendshare.Valid, req CreateLib
 			err = inputErr
 			return
 		}
-		detail := scoreDetailInputToDB(req.UserID, validated.Task.GetID(), req.RoundID, req.ScoreID, input)
+		detail := scoreDetailInputToDB(user.UserID(), validated.Task.GetID(), req.RoundID, req.ScoreID, input)
 		detail.Revision = 1
 		requestID := req.ClientRequestID
 		detail.ClientRequestID = &requestID
-		created, createErr := db.CreateLibraryScoreDetail(db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryScoreDetail), &detail)
+		created, createErr := db.CreateLibraryScoreDetail(s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryScoreDetail), &detail)
 		if createErr != nil {
 			err = createErr
 			return
 		}
-		ret.Detail = libraryScoreDetailToProtocol(*created)
-	}, func() { err = errors.New("user not exist") })
+		ret.Detail = logic.LibraryScoreDetailToProtocol(*created)
+	}, func(e error) { err = e })
 	return
 }
 
-func (s *Service) OnChangeLibraryScoreDetail(_ backendshare.Valid, req ChangeLibraryScoreDetailReq) (ret ChangeLibraryScoreDetailRet, err error) {
-	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
-		validated, validateErr := validateLibraryTask(user, req.LibraryTaskScope)
+func (s *Service) OnChangeLibraryScoreDetail(ctx context.Context, _ backendshare.Valid, req ChangeLibraryScoreDetailReq) (ret ChangeLibraryScoreDetailRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		validated, validateErr := validateLibraryTask(ctx, user, req.LibraryTaskScope)
 		if validateErr != nil {
 			err = validateErr
 			return
 		}
-		roundID, scoreErr := validateScoreExistsInTask(validated, req.ScoreID)
+		roundID, scoreErr := validateScoreExistsInTask(ctx, validated, req.ScoreID)
 		if scoreErr != nil {
 			err = scoreErr
 			return
@@ -201,13 +185,13 @@ func (s *Service) OnChangeLibraryScoreDetail(_ backendshare.Valid, req ChangeLib
 			err = inputErr
 			return
 		}
-		detail := scoreDetailInputToDB(req.UserID, validated.Task.GetID(), roundID, req.ScoreID, input)
-		updated, updateErr := db.ChangeLibraryScoreDetail(db.GTodoneDBMgr.GetConnect(db.ConnectTypeLibraryScoreDetail), &detail, req.Revision)
+		detail := scoreDetailInputToDB(user.UserID(), validated.Task.GetID(), roundID, req.ScoreID, input)
+		updated, updateErr := db.ChangeLibraryScoreDetail(s.db.GetConnectCtx(ctx, db.ConnectTypeLibraryScoreDetail), &detail, req.Revision)
 		if updateErr != nil {
 			err = updateErr
 			return
 		}
-		ret.Detail = libraryScoreDetailToProtocol(*updated)
-	}, func() { err = errors.New("user not exist") })
+		ret.Detail = logic.LibraryScoreDetailToProtocol(*updated)
+	}, func(e error) { err = e })
 	return
 }
@@ -0,0 +1,35 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnSetTaskRepeat(ctx context.Context, valid backendshare.Valid, req SetTaskRepeatReq) (ret SetTaskRepeatRet, err error) {
+	s.userMgr.SafeUseGroup(ctx, req.UserID, req.GroupID, protocol.ShareRoleEditor, func(user *logic.UserLogic) {
+		subGroup := user.GetSubGroupLogic(ctx, req.DirID, req.GroupID, req.SubGroupID)
+		if subGroup == nil {
+			err = errors.New("sub group not exist")
+			return
+		}
+		task := subGroup.GetTaskLogic(ctx, req.TaskID)
+		if task == nil {
+			err = errors.New("task not exist")
+			return
+		}
+		before := snapshotTasks(ctx, subGroup, []uint32{req.TaskID})
+		if err = task.SetRepeat(ctx, req.Rule); err != nil {
+			return
+		}
+		s.recordHistory(ctx, user, db.HistoryOpChange, before, snapshotTasks(ctx, subGroup, []uint32{req.TaskID}))
+		ret.Task = task.ToProtocol(ctx)
+	}, func(e error) {
+		err = e
+	})
+	return
+}
@@ -0,0 +1,107 @@
+package todone
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func TestRepeatTaskCreatesNextOnDone(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "home", "water plants", "balcony")
+	if _, err := callLocal[TaskAddTagReq, TaskAddTagRet](t, s, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID, Tag: "garden"}); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+
+	if _, err := callLocal[SetTaskRepeatReq, SetTaskRepeatRet](t, s, "u1", CmdSetTaskRepeat, SetTaskRepeatReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID, Rule: &protocol.PRepeatRule{Type: "yearly"}}); err == nil {
+		t.Fatal("invalid rule accepted")
+	}
+	due := time.Now().Add(time.Hour).Truncate(time.Second)
+	set, err := callLocal[SetTaskRepeatReq, SetTaskRepeatRet](t, s, "u1", CmdSetTaskRepeat, SetTaskRepeatReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID, Rule: &protocol.PRepeatRule{Type: "daily"}})
+	if err != nil {
+		t.Fatalf("set repeat: %v", err)
+	}
+	if set.Task.Repeat == nil || set.Task.Repeat.Interval != 1 {
+		t.Fatalf("repeat = %+v", set.Task.Repeat)
+	}
+
+	data := set.Task
+	data.EndTime = due
+	changed, err := callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data})
+	if err != nil {
+		t.Fatalf("set due: %v", err)
+	}
+	data.Revision = changed.Revision
+
+	tasks, err := callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Upcoming: 2})
+	if err != nil {
+		t.Fatalf("get tasks: %v", err)
+	}
+	if len(tasks.Upcoming) != 1 || len(tasks.Upcoming[0].Occurrences) != 2 || !tasks.Upcoming[0].Occurrences[0].EndTime.Equal(due.AddDate(0, 0, 1)) {
+		t.Fatalf("upcoming = %+v", tasks.Upcoming)
+	}
+
+	data.Done = true
+	changed, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data})
+	if err != nil {
+		t.Fatalf("done: %v", err)
+	}
+	data.Revision = changed.Revision
+	next := changed.NextTask
+	if next == nil || next.ID == taskID || next.Done || next.Title != "water plants" || next.Note != "balcony" {
+		t.Fatalf("next = %+v", next)
+	}
+	if !next.EndTime.Equal(due.AddDate(0, 0, 1)) || next.Repeat == nil || !reflect.DeepEqual(next.Tags, []string{"garden"}) {
+		t.Fatalf("next = %+v", next)
+	}
+
+	// 重新打开再完成不会再生成一次
+	data.Done = false
+	changed, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data})
+	if err != nil {
+		t.Fatalf("undone: %v", err)
+	}
+	data.Revision = changed.Revision
+	data.Done = true
+	changed, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data})
+	if err != nil || changed.NextTask != nil {
+		t.Fatalf("re-done next = %+v err = %v", changed.NextTask, err)
+	}
+	tasks, err = callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID, ContainDone: true})
+	if err != nil || len(tasks.Tasks) != 2 {
+		t.Fatalf("tasks = %+v err = %v", tasks.Tasks, err)
+	}
+}
+
+func TestMonthlyRepeatKeepsMonthDay(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "home", "pay rent", "")
+	// 设置规则时还没有截止时间，日期在第一次生成时固定
+	set, err := callLocal[SetTaskRepeatReq, SetTaskRepeatRet](t, s, "u1", CmdSetTaskRepeat, SetTaskRepeatReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID, Rule: &protocol.PRepeatRule{Type: "monthly"}})
+	if err != nil {
+		t.Fatalf("set repeat: %v", err)
+	}
+	data := set.Task
+	data.EndTime = time.Date(time.Now().Year()+1, 1, 31, 12, 0, 0, 0, time.UTC)
+	changed, err := callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data})
+	if err != nil {
+		t.Fatalf("set due: %v", err)
+	}
+	data.Revision = changed.Revision
+
+	year := data.EndTime.Year()
+	for _, want := range []time.Time{time.Date(year, 2, 28, 12, 0, 0, 0, time.UTC), time.Date(year, 3, 31, 12, 0, 0, 0, time.UTC)} {
+		data.Done = true
+		changed, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: data})
+		if err != nil || changed.NextTask == nil {
+			t.Fatalf("done %v: next = %+v err = %v", data.EndTime, changed.NextTask, err)
+		}
+		if !changed.NextTask.EndTime.Equal(want) || changed.NextTask.Repeat == nil || changed.NextTask.Repeat.MonthDay != 31 {
+			t.Fatalf("next = %v %+v want %v", changed.NextTask.EndTime, changed.NextTask.Repeat, want)
+		}
+		data = *changed.NextTask
+	}
+}
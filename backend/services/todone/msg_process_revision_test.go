@@ -0,0 +1,267 @@
+package todone
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/intmian/platform/backend/services/todone/db"
+	"gorm.io/gorm"
+)
+
+func TestTaskRevisionConflict(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "work", "draft", "")
+	get, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	stale := get.Task
+	if stale.Revision == 0 {
+		t.Fatalf("revision = 0")
+	}
+
+	// 第一个客户端修改成功，另一个客户端带着旧版本修改时冲突
+	first := stale
+	first.Title = "draft v2"
+	changed, err := callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: first})
+	if err != nil || changed.Revision != stale.Revision+1 {
+		t.Fatalf("change revision = %d err = %v", changed.Revision, err)
+	}
+	second := stale
+	second.Note = "from another device"
+	_, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: second})
+	if err == nil || !strings.Contains(err.Error(), "task revision conflict") {
+		t.Fatalf("stale change err = %v", err)
+	}
+	get, err = callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil || get.Task.Title != "draft v2" || get.Task.Note != "" || get.Task.Revision != changed.Revision {
+		t.Fatalf("task = %+v err = %v", get.Task, err)
+	}
+
+	// 移动同样检查版本，移动后版本加一
+	sub, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, "u1", CmdCreateSubGroup, CreateSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "later"})
+	if err != nil {
+		t.Fatalf("create sub group: %v", err)
+	}
+	move := TaskMoveReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskIDs: []uint32{taskID},
+		TrgDir: dirID, TrgGroup: groupID, TrgSubGroup: sub.SubGroupID, After: true, Revisions: []uint32{stale.Revision}}
+	if _, err = callLocal[TaskMoveReq, TaskMoveRet](t, s, "u1", CmdTaskMove, move); err == nil || !strings.Contains(err.Error(), "task revision conflict") {
+		t.Fatalf("stale move err = %v", err)
+	}
+	move.Revisions = []uint32{changed.Revision}
+	moved, err := callLocal[TaskMoveReq, TaskMoveRet](t, s, "u1", CmdTaskMove, move)
+	if err != nil || len(moved.Revisions) != 1 || moved.Revisions[0] != changed.Revision+1 {
+		t.Fatalf("move revisions = %v err = %v", moved.Revisions, err)
+	}
+
+	// 不带版本的修改直接拒绝
+	legacy := get.Task
+	legacy.Revision = 0
+	legacy.Title = "legacy"
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: sub.SubGroupID, Data: legacy}); err == nil || !strings.Contains(err.Error(), "revision required") {
+		t.Fatalf("legacy change err = %v", err)
+	}
+
+	// 其他实例改过库中的任务时缓存还是旧版本，写库以版本为条件返回冲突，之后读到的是库中的数据
+	conn := s.db.GetConnect(db.ConnectTypeTask)
+	if err = conn.Model(&db.TaskDB{}).Where("task_id = ?", taskID).Updates(map[string]any{"title": "other instance", "revision": moved.Revisions[0] + 1}).Error; err != nil {
+		t.Fatalf("update task in db: %v", err)
+	}
+	cached := legacy
+	cached.Revision = moved.Revisions[0]
+	cached.Title = "overwrite"
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: sub.SubGroupID, Data: cached}); err == nil || !strings.Contains(err.Error(), "task revision conflict") {
+		t.Fatalf("stale cache change err = %v", err)
+	}
+	get, err = callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: sub.SubGroupID, TaskID: taskID})
+	if err != nil || get.Task.Title != "other instance" || get.Task.Revision != moved.Revisions[0]+1 {
+		t.Fatalf("task after stale cache = %+v err = %v", get.Task, err)
+	}
+}
+
+func TestGroupAndSubGroupRevisionConflict(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, _ := createTestTask(t, s, "u1", "work", "draft", "")
+
+	subGroups, err := callLocal[GetSubGroupReq, GetSubGroupRet](t, s, "u1", CmdGetSubGroup, GetSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID})
+	if err != nil {
+		t.Fatalf("get sub groups: %v", err)
+	}
+	for _, subGroup := range subGroups.SubGroups {
+		if subGroup.ID != subGroupID {
+			continue
+		}
+		subGroup.Title = "renamed"
+		changed, err := callLocal[ChangeSubGroupReq, ChangeSubGroupRet](t, s, "u1", CmdChangeSubGroup, ChangeSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Data: subGroup})
+		if err != nil || changed.Revision != subGroup.Revision+1 {
+			t.Fatalf("change sub group revision = %d err = %v", changed.Revision, err)
+		}
+		subGroup.Title = "stale"
+		_, err = callLocal[ChangeSubGroupReq, ChangeSubGroupRet](t, s, "u1", CmdChangeSubGroup, ChangeSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Data: subGroup})
+		if err == nil || !strings.Contains(err.Error(), "sub group revision conflict") {
+			t.Fatalf("stale sub group err = %v", err)
+		}
+	}
+
+	var revision uint32
+	for _, child := range getTestDirTree(t, s, "u1").DirTree.ChildrenDir {
+		for _, group := range child.ChildrenGrp {
+			if group.ID == groupID {
+				revision = group.Revision
+			}
+		}
+	}
+	if revision == 0 {
+		t.Fatalf("group revision not found")
+	}
+	changed, err := callLocal[ChangeGroupReq, ChangeGroupRet](t, s, "u1", CmdChangeGroup, ChangeGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "renamed", Revision: revision})
+	if err != nil || changed.Revision != revision+1 {
+		t.Fatalf("change group revision = %d err = %v", changed.Revision, err)
+	}
+	_, err = callLocal[ChangeGroupReq, ChangeGroupRet](t, s, "u1", CmdChangeGroup, ChangeGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "stale", Revision: revision})
+	if err == nil || !strings.Contains(err.Error(), "group revision conflict") {
+		t.Fatalf("stale group err = %v", err)
+	}
+	_, err = callLocal[ChangeGroupReq, ChangeGroupRet](t, s, "u1", CmdChangeGroup, ChangeGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "legacy"})
+	if err == nil || !strings.Contains(err.Error(), "revision required") {
+		t.Fatalf("group without revision err = %v", err)
+	}
+
+	// 其他实例改过库中的分组与子分组时，带着缓存中的版本写库也会冲突
+	groupConn := s.db.GetConnect(db.ConnectTypeGroup)
+	if err = groupConn.Model(&db.GroupDB{}).Where("id = ?", groupID).Update("revision", changed.Revision+1).Error; err != nil {
+		t.Fatalf("update group in db: %v", err)
+	}
+	_, err = callLocal[ChangeGroupReq, ChangeGroupRet](t, s, "u1", CmdChangeGroup, ChangeGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "overwrite", Revision: changed.Revision})
+	if err == nil || !strings.Contains(err.Error(), "group revision conflict") {
+		t.Fatalf("stale cache group err = %v", err)
+	}
+	subGroupConn := s.db.GetConnect(db.ConnectTypeSubGroup)
+	if err = subGroupConn.Model(&db.SubGroupDB{}).Where("id = ?", subGroupID).Update("revision", gorm.Expr("revision + 1")).Error; err != nil {
+		t.Fatalf("update sub group in db: %v", err)
+	}
+	subGroups, err = callLocal[GetSubGroupReq, GetSubGroupRet](t, s, "u1", CmdGetSubGroup, GetSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID})
+	if err != nil {
+		t.Fatalf("get sub groups: %v", err)
+	}
+	for _, subGroup := range subGroups.SubGroups {
+		if subGroup.ID != subGroupID {
+			continue
+		}
+		subGroup.Title = "overwrite"
+		_, err = callLocal[ChangeSubGroupReq, ChangeSubGroupRet](t, s, "u1", CmdChangeSubGroup, ChangeSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Data: subGroup})
+		if err == nil || !strings.Contains(err.Error(), "sub group revision conflict") {
+			t.Fatalf("stale cache sub group err = %v", err)
+		}
+	}
+}
+
+func TestCreateWithClientRequestID(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, _ := createTestTask(t, s, "u1", "work", "draft", "")
+
+	requestID := uuid.NewString()
+	req := CreateTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Title: "retry me", ClientRequestID: requestID}
+	first, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, req)
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	retry, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, req)
+	if err != nil || retry.Task.ID != first.Task.ID {
+		t.Fatalf("retry = %+v err = %v", retry.Task, err)
+	}
+	tasks, err := callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID})
+	if err != nil || len(tasks.Tasks) != 2 {
+		t.Fatalf("tasks = %+v err = %v", tasks.Tasks, err)
+	}
+	req.Title = "another"
+	if _, err = callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, req); err == nil || !strings.Contains(err.Error(), "task request conflict") {
+		t.Fatalf("reused request err = %v", err)
+	}
+	req.ClientRequestID = "not-a-uuid"
+	if _, err = callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, req); err == nil || !strings.Contains(err.Error(), "client request id invalid") {
+		t.Fatalf("invalid request err = %v", err)
+	}
+
+	subReq := CreateSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Title: "inbox", ClientRequestID: uuid.NewString()}
+	sub1, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, "u1", CmdCreateSubGroup, subReq)
+	if err != nil {
+		t.Fatalf("create sub group: %v", err)
+	}
+	sub2, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, "u1", CmdCreateSubGroup, subReq)
+	if err != nil || sub2.SubGroupID != sub1.SubGroupID {
+		t.Fatalf("retry sub group = %d err = %v", sub2.SubGroupID, err)
+	}
+
+	groupReq := CreateGroupReq{UserID: "u1", ParentDir: dirID, Title: "project", ClientRequestID: uuid.NewString()}
+	group1, err := callLocal[CreateGroupReq, CreateGroupRet](t, s, "u1", CmdCreateGroup, groupReq)
+	if err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	group2, err := callLocal[CreateGroupReq, CreateGroupRet](t, s, "u1", CmdCreateGroup, groupReq)
+	if err != nil || group2.GroupID != group1.GroupID || group2.Index != group1.Index {
+		t.Fatalf("retry group = %+v err = %v", group2, err)
+	}
+}
+
+func TestFailedChangeKeepsCachedRevision(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "work", "draft", "")
+	get, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	subGroups, err := callLocal[GetSubGroupReq, GetSubGroupRet](t, s, "u1", CmdGetSubGroup, GetSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID})
+	if err != nil {
+		t.Fatalf("get sub groups: %v", err)
+	}
+	var subGroup = subGroups.SubGroups[0]
+	for _, sub := range subGroups.SubGroups {
+		if sub.ID == subGroupID {
+			subGroup = sub
+		}
+	}
+
+	// 先加载子分组的任务缓存
+	if _, err = callLocal[GetTasksReq, GetTasksRet](t, s, "u1", CmdGetTasks, GetTasksReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID}); err != nil {
+		t.Fatalf("get tasks: %v", err)
+	}
+
+	// 让写入失败，缓存中的字段与版本不能变
+	conn := s.db.GetConnect(db.ConnectTypeTask)
+	for _, table := range []string{"task_dbs", "sub_group_dbs"} {
+		if err = conn.Exec("CREATE TRIGGER fail_" + table + " BEFORE UPDATE ON " + table + " BEGIN SELECT RAISE(ABORT, 'write failed'); END").Error; err != nil {
+			t.Fatalf("create trigger: %v", err)
+		}
+	}
+	task := get.Task
+	task.Title = "lost"
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: task}); err == nil {
+		t.Fatalf("change task should fail")
+	}
+	sub := subGroup
+	sub.Title = "lost"
+	if _, err = callLocal[ChangeSubGroupReq, ChangeSubGroupRet](t, s, "u1", CmdChangeSubGroup, ChangeSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Data: sub}); err == nil {
+		t.Fatalf("change sub group should fail")
+	}
+	for _, table := range []string{"task_dbs", "sub_group_dbs"} {
+		if err = conn.Exec("DROP TRIGGER fail_" + table).Error; err != nil {
+			t.Fatalf("drop trigger: %v", err)
+		}
+	}
+
+	after, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil || after.Task.Title != "draft" || after.Task.Revision != get.Task.Revision {
+		t.Fatalf("task after failed change = %+v err = %v", after.Task, err)
+	}
+	task.Title = "saved"
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: task}); err != nil {
+		t.Fatalf("retry change task: %v", err)
+	}
+	sub.Title = "saved"
+	if _, err = callLocal[ChangeSubGroupReq, ChangeSubGroupRet](t, s, "u1", CmdChangeSubGroup, ChangeSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, Data: sub}); err != nil {
+		t.Fatalf("retry change sub group: %v", err)
+	}
+}
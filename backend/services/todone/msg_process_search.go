@@ -0,0 +1,38 @@
+package todone
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnSearchTasks(ctx context.Context, valid backendshare.Valid, req SearchTasksReq) (ret SearchTasksRet, err error) {
+	keywords := strings.Fields(req.Keyword)
+	// 没有任何条件时等于列出全部任务，直接拒绝
+	if len(keywords) == 0 && req.Tag == "" && req.GroupID == 0 {
+		err = errors.New("empty search")
+		return
+	}
+	q := db.SearchQuery{
+		Keywords:    keywords,
+		Done:        req.Done,
+		Tag:         req.Tag,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+		Limit:       req.Limit,
+	}
+	if req.TaskType != nil {
+		taskType := db.TaskType(*req.TaskType)
+		q.TaskType = &taskType
+	}
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Hits, err = user.SearchTasks(ctx, q, req.GroupID)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
@@ -0,0 +1,67 @@
+package todone
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// createTestTask 在新建的目录、分组、子分组下创建任务，返回各级ID
+func createTestTask(t *testing.T, s *Service, userID, dirTitle, title, note string) (dirID, groupID, subGroupID, taskID uint32) {
+	t.Helper()
+	root := getTestDirTree(t, s, userID).DirTree.RootDir.ID
+	dir, err := callLocal[CreateDirReq, CreateDirRet](t, s, userID, CmdCreateDir, CreateDirReq{UserID: userID, ParentDirID: root, Title: dirTitle})
+	if err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	group, err := callLocal[CreateGroupReq, CreateGroupRet](t, s, userID, CmdCreateGroup, CreateGroupReq{UserID: userID, ParentDir: dir.DirID, Title: "group"})
+	if err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	subGroup, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, userID, CmdCreateSubGroup, CreateSubGroupReq{UserID: userID, ParentDirID: dir.DirID, GroupID: group.GroupID, Title: "sub"})
+	if err != nil {
+		t.Fatalf("create sub group: %v", err)
+	}
+	task, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, userID, CmdCreateTask, CreateTaskReq{UserID: userID, DirID: dir.DirID, GroupID: group.GroupID, SubGroupID: subGroup.SubGroupID, Title: title, Note: note})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	return dir.DirID, group.GroupID, subGroup.SubGroupID, task.Task.ID
+}
+
+func TestSearchTasksReturnsPath(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "work", "prepare slides", "for monday")
+	createTestTask(t, s, "u1", "home", "buy slides projector", "")
+
+	ret, err := callLocal[SearchTasksReq, SearchTasksRet](t, s, "u1", CmdSearchTasks, SearchTasksReq{UserID: "u1", Keyword: "slides", GroupID: groupID})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(ret.Hits) != 1 {
+		t.Fatalf("hits = %+v", ret.Hits)
+	}
+	hit := ret.Hits[0]
+	if hit.Task.ID != taskID || hit.Path.DirID != dirID || hit.Path.SubGroupID != subGroupID {
+		t.Fatalf("hit = %+v", hit)
+	}
+	if !reflect.DeepEqual(hit.Path.Titles, []string{"work", "group", "sub"}) || !reflect.DeepEqual(hit.MatchFields, []string{"title"}) {
+		t.Fatalf("path = %v fields = %v", hit.Path.Titles, hit.MatchFields)
+	}
+
+	// 删除分组后其中的任务不再出现
+	if _, err = callLocal[DelGroupReq, DelGroupRet](t, s, "u1", CmdDelGroup, DelGroupReq{UserID: "u1", ParentDir: dirID, GroupID: groupID}); err != nil {
+		t.Fatalf("del group: %v", err)
+	}
+	ret, err = callLocal[SearchTasksReq, SearchTasksRet](t, s, "u1", CmdSearchTasks, SearchTasksReq{UserID: "u1", Keyword: "slides"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(ret.Hits) != 1 || ret.Hits[0].Task.Title != "buy slides projector" {
+		t.Fatalf("hits after delete = %+v", ret.Hits)
+	}
+
+	if _, err = callLocal[SearchTasksReq, SearchTasksRet](t, s, "u1", CmdSearchTasks, SearchTasksReq{UserID: "u1"}); err == nil {
+		t.Fatal("empty search accepted")
+	}
+}
@@ -0,0 +1,36 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnShareGroup(ctx context.Context, valid backendshare.Valid, req ShareGroupReq) (ret ShareGroupRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.ShareGroup(ctx, req.GroupID, req.GranteeID, req.Role)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnUnshareGroup(ctx context.Context, valid backendshare.Valid, req UnshareGroupReq) (ret UnshareGroupRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.UnshareGroup(ctx, req.GroupID, req.GranteeID)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnListGroupShares(ctx context.Context, valid backendshare.Valid, req ListGroupSharesReq) (ret ListGroupSharesRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Shares, err = user.ListGroupShares(ctx, req.GroupID)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
@@ -0,0 +1,211 @@
+package todone
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func TestShareGroupRoles(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, _ := createTestTask(t, s, "u1", "work", "a", "")
+	share := func(role string) {
+		t.Helper()
+		if _, err := callLocal[ShareGroupReq, ShareGroupRet](t, s, "u1", CmdShareGroup, ShareGroupReq{UserID: "u1", GroupID: groupID, GranteeID: "u2", Role: role}); err != nil {
+			t.Fatalf("share %s: %v", role, err)
+		}
+	}
+	getTasks := func(userID string) ([]protocol.PTask, error) {
+		ret, err := callLocal[GetTasksReq, GetTasksRet](t, s, userID, CmdGetTasks, GetTasksReq{UserID: userID, ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID})
+		return ret.Tasks, err
+	}
+	createTask := func(userID, title string) error {
+		_, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, userID, CmdCreateTask, CreateTaskReq{UserID: userID, DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Title: title})
+		return err
+	}
+
+	if _, err := getTasks("u2"); err == nil {
+		t.Fatalf("u2 read unshared group")
+	}
+	// 只有所有者可以分享
+	if _, err := callLocal[ShareGroupReq, ShareGroupRet](t, s, "u2", CmdShareGroup, ShareGroupReq{UserID: "u2", GroupID: groupID, GranteeID: "u3", Role: protocol.ShareRoleEditor}); err == nil {
+		t.Fatalf("non owner shared group")
+	}
+
+	share(protocol.ShareRoleViewer)
+	tree := getTestDirTree(t, s, "u2")
+	if len(tree.Shared) != 1 || tree.Shared[0].ID != groupID || tree.Shared[0].ParentDir != dirID || tree.Shared[0].OwnerID != "u1" {
+		t.Fatalf("shared = %+v", tree.Shared)
+	}
+	if tasks, err := getTasks("u2"); err != nil || len(tasks) != 1 {
+		t.Fatalf("viewer get tasks = %+v, %v", tasks, err)
+	}
+	if err := createTask("u2", "b"); err == nil || !strings.Contains(err.Error(), "group is read only") {
+		t.Fatalf("viewer create task err = %v", err)
+	}
+
+	share(protocol.ShareRoleEditor)
+	// 共享分组的变更同时推给所有者与被授权人
+	ownerSub := s.env.Feed.Subscribe("u1")
+	defer s.env.Feed.Unsubscribe(ownerSub)
+	granteeSub := s.env.Feed.Subscribe("u2")
+	defer s.env.Feed.Unsubscribe(granteeSub)
+	if err := createTask("u2", "b"); err != nil {
+		t.Fatalf("editor create task: %v", err)
+	}
+	for userID, sub := range map[string]*logic.ChangeSub{"u1": ownerSub, "u2": granteeSub} {
+		select {
+		case change := <-sub.C:
+			if change.Kind != protocol.ChangeKindTask || change.Op != protocol.ChangeOpCreate || change.ParentID != subGroupID {
+				t.Fatalf("%s change = %+v", userID, change)
+			}
+		default:
+			t.Fatalf("%s got no change", userID)
+		}
+	}
+	// 编辑者创建的任务属于所有者，所有者能直接看到
+	if tasks, err := getTasks("u1"); err != nil || len(tasks) != 2 {
+		t.Fatalf("owner get tasks = %+v, %v", tasks, err)
+	}
+	hits, err := callLocal[SearchTasksReq, SearchTasksRet](t, s, "u1", CmdSearchTasks, SearchTasksReq{UserID: "u1", Keyword: "b", GroupID: groupID})
+	if err != nil || len(hits.Hits) != 1 {
+		t.Fatalf("owner search = %+v, %v", hits, err)
+	}
+	// 编辑者完成的任务，完成事件归属所有者
+	var doneUsers []string
+	s.share.Publish = func(topic backendshare.Topic, msg backendshare.Msg) {
+		if topic != backendshare.TopicTodoneTaskDone {
+			return
+		}
+		var event backendshare.TodoneTaskDoneEvent
+		if err := msg.Data(&event); err != nil {
+			t.Errorf("event data: %v", err)
+		}
+		doneUsers = append(doneUsers, event.UserID)
+	}
+	tasks, err := getTasks("u2")
+	if err != nil || len(tasks) != 2 {
+		t.Fatalf("editor get tasks = %+v, %v", tasks, err)
+	}
+	tasks[0].Done = true
+	if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u2", CmdChangeTask, ChangeTaskReq{UserID: "u2", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Data: tasks[0]}); err != nil {
+		t.Fatalf("editor done task: %v", err)
+	}
+	batch, err := callLocal[BatchTaskOpsReq, BatchTaskOpsRet](t, s, "u2", CmdBatchTaskOps, BatchTaskOpsReq{UserID: "u2", DirID: dirID, GroupID: groupID,
+		Ops: []BatchTaskOp{{Op: BatchOpDone, SubGroupID: subGroupID, TaskID: tasks[1].ID, Revision: tasks[1].Revision}}})
+	if err != nil || !batch.Committed {
+		t.Fatalf("editor batch done = %+v err = %v", batch, err)
+	}
+	if len(doneUsers) != 2 || doneUsers[0] != "u1" || doneUsers[1] != "u1" {
+		t.Fatalf("done event users = %v", doneUsers)
+	}
+	s.share.Publish = nil
+	for len(ownerSub.C) > 0 || len(granteeSub.C) > 0 {
+		select {
+		case <-ownerSub.C:
+		case <-granteeSub.C:
+		}
+	}
+
+	// 分组本身只有所有者可以修改
+	if _, err = callLocal[DelGroupReq, DelGroupRet](t, s, "u2", CmdDelGroup, DelGroupReq{UserID: "u2", ParentDir: dirID, GroupID: groupID}); err == nil {
+		t.Fatalf("editor deleted group")
+	}
+
+	shares, err := callLocal[ListGroupSharesReq, ListGroupSharesRet](t, s, "u1", CmdListGroupShares, ListGroupSharesReq{UserID: "u1", GroupID: groupID})
+	if err != nil || len(shares.Shares) != 1 || shares.Shares[0].Role != protocol.ShareRoleEditor {
+		t.Fatalf("shares = %+v, %v", shares, err)
+	}
+	// 被分享的账号可以自己退出
+	if _, err = callLocal[UnshareGroupReq, UnshareGroupRet](t, s, "u2", CmdUnshareGroup, UnshareGroupReq{UserID: "u2", GroupID: groupID, GranteeID: "u2"}); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if _, err = getTasks("u2"); err == nil {
+		t.Fatalf("u2 read group after leaving")
+	}
+	if tree = getTestDirTree(t, s, "u2"); len(tree.Shared) != 0 {
+		t.Fatalf("shared after leaving = %+v", tree.Shared)
+	}
+	if err = createTask("u1", "c"); err != nil {
+		t.Fatalf("owner create task: %v", err)
+	}
+	if len(granteeSub.C) != 0 {
+		t.Fatalf("change pushed after leaving")
+	}
+}
+
+func TestShareLibraryNoteRoles(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	root := getTestDirTree(t, s, "u1").DirTree.RootDir.ID
+	group, err := callLocal[CreateGroupReq, CreateGroupRet](t, s, "u1", CmdCreateGroup, CreateGroupReq{UserID: "u1", ParentDir: root, Title: "library", GroupType: int(db.GroupTypeLibrary)})
+	if err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	subGroup, err := callLocal[CreateSubGroupReq, CreateSubGroupRet](t, s, "u1", CmdCreateSubGroup, CreateSubGroupReq{UserID: "u1", ParentDirID: root, GroupID: group.GroupID, Title: "sub"})
+	if err != nil {
+		t.Fatalf("create sub group: %v", err)
+	}
+	roundID := uuid.NewString()
+	task, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: root, GroupID: group.GroupID, SubGroupID: subGroup.SubGroupID, Title: "book", Note: `{"rounds":[{"id":"` + roundID + `"}]}`})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	scope := LibraryTaskScope{DirID: root, GroupID: group.GroupID, SubGroupID: subGroup.SubGroupID, TaskID: task.Task.ID}
+	share := func(role string) {
+		t.Helper()
+		if _, err := callLocal[ShareGroupReq, ShareGroupRet](t, s, "u1", CmdShareGroup, ShareGroupReq{UserID: "u1", GroupID: group.GroupID, GranteeID: "u2", Role: role}); err != nil {
+			t.Fatalf("share %s: %v", role, err)
+		}
+	}
+	createNote := func(userID, content string) (protocol.PLibraryNote, error) {
+		ret, err := callLocal[CreateLibraryNoteReq, CreateLibraryNoteRet](t, s, userID, CmdCreateLibraryNote, CreateLibraryNoteReq{UserID: userID, LibraryTaskScope: scope, RoundID: roundID, EventTime: time.Now(), Content: content, ClientRequestID: uuid.NewString()})
+		return ret.Note, err
+	}
+	getNotes := func(userID string) []protocol.PLibraryNote {
+		t.Helper()
+		ret, err := callLocal[GetLibraryNotesReq, GetLibraryNotesRet](t, s, userID, CmdGetLibraryNotes, GetLibraryNotesReq{UserID: userID, LibraryTaskScope: scope})
+		if err != nil {
+			t.Fatalf("%s get notes: %v", userID, err)
+		}
+		return ret.Notes
+	}
+
+	owned, err := createNote("u1", "owner note")
+	if err != nil {
+		t.Fatalf("owner create note: %v", err)
+	}
+
+	// 只读成员可以看笔记，但不能写
+	share(protocol.ShareRoleViewer)
+	if notes := getNotes("u2"); len(notes) != 1 {
+		t.Fatalf("viewer notes = %+v", notes)
+	}
+	if _, err = createNote("u2", "viewer note"); err == nil || !strings.Contains(err.Error(), "group is read only") {
+		t.Fatalf("viewer create note err = %v", err)
+	}
+	if _, err = callLocal[ChangeLibraryNoteReq, ChangeLibraryNoteRet](t, s, "u2", CmdChangeLibraryNote, ChangeLibraryNoteReq{UserID: "u2", LibraryTaskScope: scope, NoteID: owned.ID, EventTime: time.Now(), Content: "changed", Revision: owned.Revision}); err == nil {
+		t.Fatalf("viewer changed note")
+	}
+	if _, err = callLocal[DelLibraryNoteReq, DelLibraryNoteRet](t, s, "u2", CmdDelLibraryNote, DelLibraryNoteReq{UserID: "u2", LibraryTaskScope: scope, NoteID: owned.ID, Revision: owned.Revision}); err == nil {
+		t.Fatalf("viewer deleted note")
+	}
+
+	// 编辑者写的笔记属于所有者，双方都能看到
+	share(protocol.ShareRoleEditor)
+	if _, err = createNote("u2", "editor note"); err != nil {
+		t.Fatalf("editor create note: %v", err)
+	}
+	if notes := getNotes("u1"); len(notes) != 2 {
+		t.Fatalf("owner notes = %+v", notes)
+	}
+	if notes := getNotes("u2"); len(notes) != 2 {
+		t.Fatalf("editor notes = %+v", notes)
+	}
+}
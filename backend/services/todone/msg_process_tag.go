@@ -0,0 +1,54 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnListTags(ctx context.Context, valid backendshare.Valid, req ListTagsReq) (ret ListTagsRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Tags, err = user.ListTags(ctx)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnRenameTag(ctx context.Context, valid backendshare.Valid, req RenameTagReq) (ret RenameTagRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.RenameTag(ctx, req.From, req.To)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnMergeTags(ctx context.Context, valid backendshare.Valid, req MergeTagsReq) (ret MergeTagsRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.MergeTags(ctx, req.From, req.To)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnDeleteTag(ctx context.Context, valid backendshare.Valid, req DeleteTagReq) (ret DeleteTagRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.DeleteTag(ctx, req.Tag)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnSetTagMeta(ctx context.Context, valid backendshare.Valid, req SetTagMetaReq) (ret SetTagMetaRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.SetTagMeta(ctx, req.Tag, req.Color, req.Pinned)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
@@ -0,0 +1,86 @@
+package todone
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTagCatalogue(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "work", "draft", "")
+	second, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, Title: "review"})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	addTag := func(id uint32, tag string) {
+		t.Helper()
+		if _, err := callLocal[TaskAddTagReq, TaskAddTagRet](t, s, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: id, Tag: tag}); err != nil {
+			t.Fatalf("add tag %s: %v", tag, err)
+		}
+	}
+	addTag(taskID, "work")
+	addTag(taskID, "urgent")
+	addTag(second.Task.ID, "work")
+	addTag(second.Task.ID, "asap")
+
+	list, err := callLocal[ListTagsReq, ListTagsRet](t, s, "u1", CmdListTags, ListTagsReq{UserID: "u1"})
+	if err != nil || len(list.Tags) != 3 || list.Tags[0].Tag != "work" || list.Tags[0].Count != 2 {
+		t.Fatalf("tags = %+v err = %v", list.Tags, err)
+	}
+
+	// 改名到已经在使用的标签时失败，需要显式合并
+	if _, err = callLocal[RenameTagReq, RenameTagRet](t, s, "u1", CmdRenameTag, RenameTagReq{UserID: "u1", From: "urgent", To: "work"}); err == nil || !strings.Contains(err.Error(), "tag already exists") {
+		t.Fatalf("rename to used err = %v", err)
+	}
+	if _, err = callLocal[RenameTagReq, RenameTagRet](t, s, "u1", CmdRenameTag, RenameTagReq{UserID: "u1", From: "work", To: "job"}); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	get, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil || !slices.Contains(get.Task.Tags, "job") || slices.Contains(get.Task.Tags, "work") {
+		t.Fatalf("task tags after rename = %v err = %v", get.Task.Tags, err)
+	}
+
+	// 合并时同一个任务上的重复标签只保留一个
+	addTag(taskID, "asap")
+	if _, err = callLocal[MergeTagsReq, MergeTagsRet](t, s, "u1", CmdMergeTags, MergeTagsReq{UserID: "u1", From: []string{"urgent", "asap"}, To: "job"}); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	list, err = callLocal[ListTagsReq, ListTagsRet](t, s, "u1", CmdListTags, ListTagsReq{UserID: "u1"})
+	if err != nil || len(list.Tags) != 1 || list.Tags[0].Tag != "job" || list.Tags[0].Count != 2 {
+		t.Fatalf("tags after merge = %+v err = %v", list.Tags, err)
+	}
+	get, err = callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil || len(get.Task.Tags) != 1 || get.Task.Tags[0] != "job" {
+		t.Fatalf("task tags after merge = %v err = %v", get.Task.Tags, err)
+	}
+	if _, err = callLocal[MergeTagsReq, MergeTagsRet](t, s, "u1", CmdMergeTags, MergeTagsReq{UserID: "u1", From: []string{"missing"}, To: "job"}); err == nil || !strings.Contains(err.Error(), "tag not exist") {
+		t.Fatalf("merge missing err = %v", err)
+	}
+
+	// 展示信息
+	if _, err = callLocal[SetTagMetaReq, SetTagMetaRet](t, s, "u1", CmdSetTagMeta, SetTagMetaReq{UserID: "u1", Tag: "job", Color: "red"}); err == nil || !strings.Contains(err.Error(), "tag color invalid") {
+		t.Fatalf("invalid color err = %v", err)
+	}
+	if _, err = callLocal[SetTagMetaReq, SetTagMetaRet](t, s, "u1", CmdSetTagMeta, SetTagMetaReq{UserID: "u1", Tag: "later", Color: "#FFAA00", Pinned: true}); err != nil {
+		t.Fatalf("set meta: %v", err)
+	}
+	list, err = callLocal[ListTagsReq, ListTagsRet](t, s, "u1", CmdListTags, ListTagsReq{UserID: "u1"})
+	if err != nil || len(list.Tags) != 2 || list.Tags[0].Tag != "later" || list.Tags[0].Color != "#ffaa00" || !list.Tags[0].Pinned || list.Tags[0].Count != 0 {
+		t.Fatalf("tags with meta = %+v err = %v", list.Tags, err)
+	}
+
+	// 删除
+	if _, err = callLocal[DeleteTagReq, DeleteTagRet](t, s, "u1", CmdDeleteTag, DeleteTagReq{UserID: "u1", Tag: "job"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	get, err = callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID})
+	if err != nil || len(get.Task.Tags) != 0 {
+		t.Fatalf("task tags after delete = %v err = %v", get.Task.Tags, err)
+	}
+	list, err = callLocal[ListTagsReq, ListTagsRet](t, s, "u1", CmdListTags, ListTagsReq{UserID: "u1"})
+	if err != nil || len(list.Tags) != 1 || list.Tags[0].Tag != "later" {
+		t.Fatalf("tags after delete = %+v err = %v", list.Tags, err)
+	}
+}
@@ -0,0 +1,36 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnListTrash(ctx context.Context, valid backendshare.Valid, req ListTrashReq) (ret ListTrashRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Items, err = user.ListTrash(ctx, s.trashRetention)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnRestoreItem(ctx context.Context, valid backendshare.Valid, req RestoreItemReq) (ret RestoreItemRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		err = user.RestoreTrash(ctx, req.Type, req.ID, req.ParentID)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnPurgeTrash(ctx context.Context, valid backendshare.Valid, req PurgeTrashReq) (ret PurgeTrashRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Purged, err = user.PurgeTrash(ctx, req.Items)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
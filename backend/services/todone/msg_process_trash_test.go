@@ -0,0 +1,156 @@
+package todone
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func listTrash(t *testing.T, s *Service) []protocol.PTrashItem {
+	t.Helper()
+	ret, err := callLocal[ListTrashReq, ListTrashRet](t, s, "u1", CmdListTrash, ListTrashReq{UserID: "u1"})
+	if err != nil {
+		t.Fatalf("list trash: %v", err)
+	}
+	return ret.Items
+}
+
+func restoreItem(t *testing.T, s *Service, itemType string, id, parentID uint32) error {
+	t.Helper()
+	_, err := callLocal[RestoreItemReq, RestoreItemRet](t, s, "u1", CmdRestoreItem, RestoreItemReq{UserID: "u1", Type: itemType, ID: id, ParentID: parentID})
+	return err
+}
+
+func TestTrashRestore(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, aID := createTestTask(t, s, "u1", "work", "a", "")
+	sub := testSubGroup{dirID, groupID, subGroupID}
+	ret, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, ParentTask: aID, Title: "a1"})
+	if err != nil {
+		t.Fatalf("create sub task: %v", err)
+	}
+	a1ID := ret.Task.ID
+	sub.createTask(t, s, "b")
+
+	// 父任务与子任务一起删除，回收站只列出父任务，恢复时一起回来
+	if _, err = callLocal[DelTaskReq, DelTaskRet](t, s, "u1", CmdDelTask, DelTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: []uint32{aID, a1ID}}); err != nil {
+		t.Fatalf("del task: %v", err)
+	}
+	items := listTrash(t, s)
+	if len(items) != 1 || items[0].Type != protocol.TrashTypeTask || items[0].ID != aID || items[0].ParentMissing || items[0].ExpireAt.IsZero() {
+		t.Fatalf("trash = %+v", items)
+	}
+	if err = restoreItem(t, s, protocol.TrashTypeTask, aID, 0); err != nil {
+		t.Fatalf("restore task: %v", err)
+	}
+	got := sub.titles(t, s)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"a", "a1", "b"}) {
+		t.Fatalf("titles after restore = %v", got)
+	}
+	if items = listTrash(t, s); len(items) != 0 {
+		t.Fatalf("trash after restore = %+v", items)
+	}
+
+	// 子分组删除后任务跟着进入回收站，恢复后原样回来
+	if _, err = callLocal[DelSubGroupReq, DelSubGroupRet](t, s, "u1", CmdDelSubGroup, DelSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID, SubGroupID: subGroupID}); err != nil {
+		t.Fatalf("del sub group: %v", err)
+	}
+	subGroups, err := callLocal[GetSubGroupReq, GetSubGroupRet](t, s, "u1", CmdGetSubGroup, GetSubGroupReq{UserID: "u1", ParentDirID: dirID, GroupID: groupID})
+	if err != nil {
+		t.Fatalf("get sub groups: %v", err)
+	}
+	for _, subGroup := range subGroups.SubGroups {
+		if subGroup.ID == subGroupID {
+			t.Fatalf("deleted sub group still listed")
+		}
+	}
+	if err = restoreItem(t, s, protocol.TrashTypeSubGroup, subGroupID, 0); err != nil {
+		t.Fatalf("restore sub group: %v", err)
+	}
+	if got := sub.titles(t, s); len(got) != 3 {
+		t.Fatalf("titles after restore sub group = %v", got)
+	}
+
+	// 分组所在的目录已经删除时需要指定新的目录
+	if _, err = callLocal[DelGroupReq, DelGroupRet](t, s, "u1", CmdDelGroup, DelGroupReq{UserID: "u1", ParentDir: dirID, GroupID: groupID}); err != nil {
+		t.Fatalf("del group: %v", err)
+	}
+	if _, err = callLocal[DelDirReq, DelDirRet](t, s, "u1", CmdDelDir, DelDirReq{UserID: "u1", DirID: dirID}); err != nil {
+		t.Fatalf("del dir: %v", err)
+	}
+	items = listTrash(t, s)
+	if len(items) != 2 {
+		t.Fatalf("trash = %+v", items)
+	}
+	for _, item := range items {
+		if item.Type == protocol.TrashTypeGroup && !item.ParentMissing {
+			t.Fatalf("group parent should be missing: %+v", item)
+		}
+	}
+	if err = restoreItem(t, s, protocol.TrashTypeGroup, groupID, 0); err == nil {
+		t.Fatalf("restore group into deleted dir should fail")
+	}
+	root := getTestDirTree(t, s, "u1").DirTree.RootDir.ID
+	if err = restoreItem(t, s, protocol.TrashTypeGroup, groupID, root); err != nil {
+		t.Fatalf("restore group to root: %v", err)
+	}
+	tree := getTestDirTree(t, s, "u1").DirTree
+	if len(tree.ChildrenGrp) != 1 || tree.ChildrenGrp[0].ID != groupID {
+		t.Fatalf("root groups = %+v", tree.ChildrenGrp)
+	}
+	moved := testSubGroup{root, groupID, subGroupID}
+	if got := moved.titles(t, s); len(got) != 3 {
+		t.Fatalf("titles after restore group = %v", got)
+	}
+	if err = restoreItem(t, s, protocol.TrashTypeDir, dirID, 0); err != nil {
+		t.Fatalf("restore dir: %v", err)
+	}
+	if got := len(getTestDirTree(t, s, "u1").DirTree.ChildrenDir); got != 1 {
+		t.Fatalf("root dirs = %d", got)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, aID := createTestTask(t, s, "u1", "work", "a", "")
+	sub := testSubGroup{dirID, groupID, subGroupID}
+	bID := sub.createTask(t, s, "b")
+	for _, id := range []uint32{aID, bID} {
+		if _, err := callLocal[DelTaskReq, DelTaskRet](t, s, "u1", CmdDelTask, DelTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: []uint32{id}}); err != nil {
+			t.Fatalf("del task: %v", err)
+		}
+	}
+
+	ret, err := callLocal[PurgeTrashReq, PurgeTrashRet](t, s, "u1", CmdPurgeTrash, PurgeTrashReq{UserID: "u1", Items: []protocol.PTrashKey{{Type: protocol.TrashTypeTask, ID: aID}}})
+	if err != nil || ret.Purged != 1 {
+		t.Fatalf("purge a = %+v, %v", ret, err)
+	}
+	if _, err = db.GetTaskByID(s.db.GetConnect(db.ConnectTypeTask), aID); err == nil {
+		t.Fatalf("purged task still in db")
+	}
+	if err = restoreItem(t, s, protocol.TrashTypeTask, aID, 0); err == nil {
+		t.Fatalf("restore purged task should fail")
+	}
+
+	// 还没过期的不会被自动清理
+	purger := newTrashPurger(trashRetention(0), s.db, s.userMgr, s.share.Log)
+	if err = purger.purge(context.Background(), time.Now()); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if items := listTrash(t, s); len(items) != 1 || items[0].ID != bID {
+		t.Fatalf("trash = %+v", items)
+	}
+	if err = purger.purge(context.Background(), time.Now().Add(31*24*time.Hour)); err != nil {
+		t.Fatalf("purge expired: %v", err)
+	}
+	if items := listTrash(t, s); len(items) != 0 {
+		t.Fatalf("trash after expire = %+v", items)
+	}
+}
@@ -0,0 +1,34 @@
+package todone
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+const (
+	defaultViewLimit = 200
+	maxViewLimit     = 1000
+)
+
+func (s *Service) OnGetView(ctx context.Context, valid backendshare.Valid, req GetViewReq) (ret GetViewRet, err error) {
+	// 日期按服务器时区计算，与提醒的每日汇总一致
+	filter, err := logic.ViewFilter(req.View, req.Tag, req.Filter, time.Now())
+	if err != nil {
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultViewLimit
+	}
+	limit = min(limit, maxViewLimit)
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Tasks, err = user.GetView(ctx, filter, limit)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
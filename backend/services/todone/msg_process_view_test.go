@@ -0,0 +1,93 @@
+package todone
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func viewTitles(t *testing.T, s *Service, req GetViewReq) []string {
+	t.Helper()
+	req.UserID = "u1"
+	ret, err := callLocal[GetViewReq, GetViewRet](t, s, "u1", CmdGetView, req)
+	if err != nil {
+		t.Fatalf("get view %s: %v", req.View, err)
+	}
+	titles := make([]string, 0, len(ret.Tasks))
+	for _, task := range ret.Tasks {
+		titles = append(titles, task.Task.Title)
+	}
+	return titles
+}
+
+func TestGetView(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 1, 0, 0, now.Location())
+	change := func(sub testSubGroup, id uint32, f func(task *protocol.PTask)) {
+		t.Helper()
+		ret, err := callLocal[GetTaskReq, GetTaskRet](t, s, "u1", CmdGetTask, GetTaskReq{UserID: "u1", DirID: sub.dirID, GroupID: sub.groupID, SubGroupID: sub.subGroupID, TaskID: id})
+		if err != nil {
+			t.Fatalf("get task: %v", err)
+		}
+		task := ret.Task
+		f(&task)
+		if _, err = callLocal[ChangeTaskReq, ChangeTaskRet](t, s, "u1", CmdChangeTask, ChangeTaskReq{UserID: "u1", DirID: sub.dirID, GroupID: sub.groupID, SubGroupID: sub.subGroupID, Data: task}); err != nil {
+			t.Fatalf("change task: %v", err)
+		}
+	}
+
+	dirID, groupID, subGroupID, aID := createTestTask(t, s, "u1", "work", "a", "")
+	work := testSubGroup{dirID, groupID, subGroupID}
+	change(work, aID, func(task *protocol.PTask) { task.EndTime = today })
+	bID := work.createTask(t, s, "b")
+	change(work, bID, func(task *protocol.PTask) { task.EndTime = today.AddDate(0, 0, 30) })
+	if _, err := callLocal[TaskAddTagReq, TaskAddTagRet](t, s, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: bID, Tag: "x"}); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+	dirID, groupID, subGroupID, cID := createTestTask(t, s, "u1", "home", "c", "")
+	home := testSubGroup{dirID, groupID, subGroupID}
+	change(home, cID, func(task *protocol.PTask) { task.Started = true; task.Wait4 = "bob" })
+	// 父任务删除后子任务不出现在视图中
+	pID := home.createTask(t, s, "p")
+	ret, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, ParentTask: pID, Title: "q"})
+	if err != nil {
+		t.Fatalf("create sub task: %v", err)
+	}
+	change(home, ret.Task.ID, func(task *protocol.PTask) { task.EndTime = today })
+	if _, err = callLocal[DelTaskReq, DelTaskRet](t, s, "u1", CmdDelTask, DelTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: []uint32{pID}}); err != nil {
+		t.Fatalf("del task: %v", err)
+	}
+
+	if got := viewTitles(t, s, GetViewReq{View: protocol.ViewToday}); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("today = %v", got)
+	}
+	if got := viewTitles(t, s, GetViewReq{View: protocol.ViewStarted}); len(got) != 1 || got[0] != "c" {
+		t.Fatalf("started = %v", got)
+	}
+	if got := viewTitles(t, s, GetViewReq{View: protocol.ViewWaiting}); len(got) != 1 || got[0] != "c" {
+		t.Fatalf("waiting = %v", got)
+	}
+	if got := viewTitles(t, s, GetViewReq{View: protocol.ViewTag, Tag: "x"}); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("tag = %v", got)
+	}
+	// 有截止时间的在前并按截止时间排序
+	got := viewTitles(t, s, GetViewReq{View: protocol.ViewCustom})
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("custom = %v", got)
+	}
+
+	res, err := callLocal[GetViewReq, GetViewRet](t, s, "u1", CmdGetView, GetViewReq{UserID: "u1", View: protocol.ViewTag, Tag: "x"})
+	if err != nil || len(res.Tasks) != 1 {
+		t.Fatalf("tag view = %+v, %v", res, err)
+	}
+	path := res.Tasks[0].Path
+	if path.SubGroupID != work.subGroupID || len(path.Titles) != 3 || path.Titles[0] != "work" || len(res.Tasks[0].Task.Tags) != 1 {
+		t.Fatalf("path = %+v, task = %+v", path, res.Tasks[0].Task)
+	}
+	if _, err = callLocal[GetViewReq, GetViewRet](t, s, "u1", CmdGetView, GetViewReq{UserID: "u1", View: "nope"}); err == nil {
+		t.Fatalf("unknown view should fail")
+	}
+}
@@ -0,0 +1,35 @@
+package todone
+
+import (
+	"context"
+	"errors"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+func (s *Service) OnExportWorkspace(ctx context.Context, valid backendshare.Valid, req ExportWorkspaceReq) (ret ExportWorkspaceRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		ret.Archive, err = user.ExportWorkspace(ctx)
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
+
+func (s *Service) OnImportWorkspace(ctx context.Context, valid backendshare.Valid, req ImportWorkspaceReq) (ret ImportWorkspaceRet, err error) {
+	s.userMgr.SafeUseUserLogic(req.UserID, func(user *logic.UserLogic) {
+		var res *logic.ImportResult
+		res, err = user.ImportWorkspace(ctx, req.Archive, req.ParentDirID)
+		if res != nil {
+			// 导出时的根目录映射到已有目录，不算新建
+			ret.Dirs = max(len(res.DirIDs)-1, 0)
+			ret.Groups = len(res.GroupIDs)
+			ret.SubGroups = len(res.SubGroupIDs)
+			ret.Tasks = len(res.TaskIDs)
+		}
+	}, func() {
+		err = errors.New("user not exist")
+	})
+	return
+}
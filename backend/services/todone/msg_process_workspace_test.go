@@ -0,0 +1,179 @@
+package todone
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/protocol"
+)
+
+func TestWorkspaceExportImport(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, aID := createTestTask(t, s, "u1", "work", "a", "note")
+	sub := testSubGroup{dirID, groupID, subGroupID}
+	a1, err := callLocal[CreateTaskReq, CreateTaskRet](t, s, "u1", CmdCreateTask, CreateTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, ParentTask: aID, Title: "a1"})
+	if err != nil {
+		t.Fatalf("create sub task: %v", err)
+	}
+	bID := sub.createTask(t, s, "b")
+	if _, err = callLocal[TaskAddTagReq, TaskAddTagRet](t, s, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: aID, Tag: "x"}); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+	if _, err = callLocal[SetTagMetaReq, SetTagMetaRet](t, s, "u1", CmdSetTagMeta, SetTagMetaReq{UserID: "u1", Tag: "x", Color: "#AABBCC", Pinned: true}); err != nil {
+		t.Fatalf("set tag meta: %v", err)
+	}
+	// 阻塞任务已删除的关系不导出
+	for _, blockerID := range []uint32{aID, bID} {
+		if _, err = callLocal[AddTaskBlockerReq, AddTaskBlockerRet](t, s, "u1", CmdAddTaskBlocker, AddTaskBlockerReq{UserID: "u1", TaskID: a1.Task.ID, BlockerID: blockerID}); err != nil {
+			t.Fatalf("add blocker %d: %v", blockerID, err)
+		}
+	}
+	// 已删除的任务不导出
+	if _, err = callLocal[DelTaskReq, DelTaskRet](t, s, "u1", CmdDelTask, DelTaskReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: []uint32{bID}}); err != nil {
+		t.Fatalf("del task: %v", err)
+	}
+
+	exported, err := callLocal[ExportWorkspaceReq, ExportWorkspaceRet](t, s, "u1", CmdExportWorkspace, ExportWorkspaceReq{UserID: "u1"})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	archive := exported.Archive
+	if archive.Version != protocol.WorkspaceArchiveVersion || len(archive.Dirs) != 2 || len(archive.Groups) != 1 || len(archive.Tasks) != 2 {
+		t.Fatalf("archive = %+v", archive)
+	}
+	if !reflect.DeepEqual(archive.Blockers, []protocol.PArchiveBlocker{{TaskID: a1.Task.ID, BlockerID: aID}}) || len(archive.TagMetas) != 1 {
+		t.Fatalf("blockers = %+v tag metas = %+v", archive.Blockers, archive.TagMetas)
+	}
+	// 模拟下载后再上传
+	data, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var uploaded protocol.PWorkspaceArchive
+	if err = json.Unmarshal(data, &uploaded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	target := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	getTestDirTree(t, target, "u1")
+	imported, err := callLocal[ImportWorkspaceReq, ImportWorkspaceRet](t, target, "u1", CmdImportWorkspace, ImportWorkspaceReq{UserID: "u1", Archive: &uploaded})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if imported.Dirs != 1 || imported.Groups != 1 || imported.SubGroups != len(archive.SubGroups) || imported.Tasks != 2 {
+		t.Fatalf("imported = %+v", imported)
+	}
+	tree := getTestDirTree(t, target, "u1").DirTree
+	if len(tree.ChildrenDir) != 1 || tree.ChildrenDir[0].RootDir.Title != "work" || len(tree.ChildrenDir[0].ChildrenGrp) != 1 {
+		t.Fatalf("tree = %+v", tree)
+	}
+	newDir := tree.ChildrenDir[0].RootDir.ID
+	newGroup := tree.ChildrenDir[0].ChildrenGrp[0].ID
+	subGroups, err := callLocal[GetSubGroupReq, GetSubGroupRet](t, target, "u1", CmdGetSubGroup, GetSubGroupReq{UserID: "u1", ParentDirID: newDir, GroupID: newGroup})
+	if err != nil {
+		t.Fatalf("get sub groups: %v", err)
+	}
+	var newSub testSubGroup
+	for _, subGroup := range subGroups.SubGroups {
+		if subGroup.Title == "sub" {
+			newSub = testSubGroup{newDir, newGroup, subGroup.ID}
+		}
+	}
+	got := newSub.titles(t, target)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"a#x", "a1"}) {
+		t.Fatalf("titles = %v", got)
+	}
+	// 阻塞关系换成新的任务ID，标签展示信息一起导入
+	var deps []db.TaskDependencyDB
+	if err = target.db.GetConnect(db.ConnectTypeDependency).Find(&deps).Error; err != nil || len(deps) != 1 {
+		t.Fatalf("deps = %+v err = %v", deps, err)
+	}
+	for id, title := range map[uint32]string{deps[0].TaskID: "a1", deps[0].BlockerID: "a"} {
+		var task db.TaskDB
+		if err = target.db.GetConnect(db.ConnectTypeTask).Where("task_id = ?", id).First(&task).Error; err != nil || task.Title != title || task.ParentSubGroupID != newSub.subGroupID {
+			t.Fatalf("dep task %d = %+v err = %v, want %s", id, task, err, title)
+		}
+	}
+	tags, err := callLocal[ListTagsReq, ListTagsRet](t, target, "u1", CmdListTags, ListTagsReq{UserID: "u1"})
+	if err != nil || len(tags.Tags) != 1 || tags.Tags[0].Color != "#aabbcc" || !tags.Tags[0].Pinned {
+		t.Fatalf("tags = %+v err = %v", tags.Tags, err)
+	}
+
+	// 版本不对的导出内容不写入任何数据
+	uploaded.Version = 0
+	if _, err = callLocal[ImportWorkspaceReq, ImportWorkspaceRet](t, target, "u1", CmdImportWorkspace, ImportWorkspaceReq{UserID: "u1", Archive: &uploaded}); err == nil {
+		t.Fatalf("import unsupported version should fail")
+	}
+	if got := len(getTestDirTree(t, target, "u1").DirTree.ChildrenDir); got != 1 {
+		t.Fatalf("root dirs = %d", got)
+	}
+}
+
+// 导入到一半失败时不留下任何数据：SQLite 靠事务回滚，D1 靠按ID清理
+func TestWorkspaceImportFailureLeavesNothing(t *testing.T) {
+	source := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	dirID, groupID, subGroupID, taskID := createTestTask(t, source, "u1", "work", "a", "note")
+	if _, err := callLocal[TaskAddTagReq, TaskAddTagRet](t, source, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID, Tag: "x"}); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+	if _, err := callLocal[SetTagMetaReq, SetTagMetaRet](t, source, "u1", CmdSetTagMeta, SetTagMetaReq{UserID: "u1", Tag: "x", Color: "#aabbcc"}); err != nil {
+		t.Fatalf("set tag meta: %v", err)
+	}
+	blockerID := testSubGroup{dirID, groupID, subGroupID}.createTask(t, source, "b")
+	if _, err := callLocal[AddTaskBlockerReq, AddTaskBlockerRet](t, source, "u1", CmdAddTaskBlocker, AddTaskBlockerReq{UserID: "u1", TaskID: taskID, BlockerID: blockerID}); err != nil {
+		t.Fatalf("add blocker: %v", err)
+	}
+	exported, err := callLocal[ExportWorkspaceReq, ExportWorkspaceRet](t, source, "u1", CmdExportWorkspace, ExportWorkspaceReq{UserID: "u1"})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	for _, driver := range []db.Driver{db.DriverSqlite, db.DriverWorker} {
+		t.Run(string(driver), func(t *testing.T) {
+			target := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+			getTestDirTree(t, target, "u1")
+			// 用SQLite模拟没有事务的D1，走清理的路径
+			target.db.Setting.Driver = driver
+			conn := target.db.GetConnect(db.ConnectTypeTask)
+			counts := func() []int64 {
+				res := make([]int64, 0, 7)
+				for _, model := range []any{&db.DirDB{}, &db.GroupDB{}, &db.SubGroupDB{}, &db.TaskDB{}, &db.TagsDB{}, &db.TaskDependencyDB{}, &db.TagMetaDB{}} {
+					var count int64
+					if err := conn.Model(model).Count(&count).Error; err != nil {
+						t.Fatalf("count: %v", err)
+					}
+					res = append(res, count)
+				}
+				return res
+			}
+			before := counts()
+			// 目录、分组、子分组、任务、标签、阻塞关系与标签展示信息都写入后，写任务序列时失败
+			if err := conn.Exec("CREATE TRIGGER fail_import BEFORE UPDATE ON sub_group_dbs BEGIN SELECT RAISE(ABORT, 'import failed'); END").Error; err != nil {
+				t.Fatalf("create trigger: %v", err)
+			}
+			if _, err := callLocal[ImportWorkspaceReq, ImportWorkspaceRet](t, target, "u1", CmdImportWorkspace, ImportWorkspaceReq{UserID: "u1", Archive: exported.Archive}); err == nil {
+				t.Fatal("import should fail")
+			}
+			if after := counts(); !reflect.DeepEqual(before, after) {
+				t.Fatalf("rows before = %v after = %v", before, after)
+			}
+			if got := len(getTestDirTree(t, target, "u1").DirTree.ChildrenDir); got != 0 {
+				t.Fatalf("root dirs after failed import = %d", got)
+			}
+
+			// 失败不影响之后重新导入
+			if err := conn.Exec("DROP TRIGGER fail_import").Error; err != nil {
+				t.Fatalf("drop trigger: %v", err)
+			}
+			imported, err := callLocal[ImportWorkspaceReq, ImportWorkspaceRet](t, target, "u1", CmdImportWorkspace, ImportWorkspaceReq{UserID: "u1", Archive: exported.Archive})
+			if err != nil || imported.Tasks != 2 {
+				t.Fatalf("import after failure = %+v err = %v", imported, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdSetTaskRepeat share.Cmd = "setTaskRepeat"
+
+type SetTaskRepeatReq struct {
+	UserID     string
+	DirID      uint32
+	GroupID    uint32
+	SubGroupID uint32
+	TaskID     uint32
+	// Rule 为nil时取消重复
+	Rule *protocol.PRepeatRule
+}
+
+type SetTaskRepeatRet struct {
+	Task protocol.PTask
+}
@@ -0,0 +1,28 @@
+package todone
+
+import (
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdSearchTasks share.Cmd = "searchTasks"
+
+type SearchTasksReq struct {
+	UserID string
+	// Keyword 空格分隔多个关键词，需要同时命中
+	Keyword string
+	// 以下为可选筛选，nil或零值表示不限制
+	Done        *bool
+	TaskType    *int
+	Tag         string
+	GroupID     uint32
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Limit       int
+}
+
+type SearchTasksRet struct {
+	Hits []protocol.PSearchHit
+}
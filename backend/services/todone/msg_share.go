@@ -0,0 +1,41 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdShareGroup share.Cmd = "shareGroup"
+
+type ShareGroupReq struct {
+	UserID    string
+	GroupID   uint32
+	GranteeID string
+	Role      string // viewer editor
+}
+
+type ShareGroupRet struct {
+}
+
+const CmdUnshareGroup share.Cmd = "unshareGroup"
+
+type UnshareGroupReq struct {
+	UserID  string
+	GroupID uint32
+	// GranteeID 所有者取消分享时填对方，被分享的账号退出时填自己
+	GranteeID string
+}
+
+type UnshareGroupRet struct {
+}
+
+const CmdListGroupShares share.Cmd = "listGroupShares"
+
+type ListGroupSharesReq struct {
+	UserID  string
+	GroupID uint32
+}
+
+type ListGroupSharesRet struct {
+	Shares []protocol.PGroupShare
+}
@@ -0,0 +1,61 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdListTags share.Cmd = "listTags"
+
+type ListTagsReq struct {
+	UserID string
+}
+
+type ListTagsRet struct {
+	Tags []protocol.PTag
+}
+
+const CmdRenameTag share.Cmd = "renameTag"
+
+type RenameTagReq struct {
+	UserID string
+	From   string
+	// To 已经在使用时失败，合并使用 mergeTags
+	To string
+}
+
+type RenameTagRet struct {
+}
+
+const CmdMergeTags share.Cmd = "mergeTags"
+
+type MergeTagsReq struct {
+	UserID string
+	From   []string
+	To     string
+}
+
+type MergeTagsRet struct {
+}
+
+const CmdDeleteTag share.Cmd = "deleteTag"
+
+type DeleteTagReq struct {
+	UserID string
+	Tag    string
+}
+
+type DeleteTagRet struct {
+}
+
+const CmdSetTagMeta share.Cmd = "setTagMeta"
+
+type SetTagMetaReq struct {
+	UserID string
+	Tag    string
+	Color  string // #rrggbb，空表示默认样式
+	Pinned bool
+}
+
+type SetTagMetaRet struct {
+}
@@ -0,0 +1,41 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdListTrash share.Cmd = "listTrash"
+
+type ListTrashReq struct {
+	UserID string
+}
+
+type ListTrashRet struct {
+	Items []protocol.PTrashItem
+}
+
+const CmdRestoreItem share.Cmd = "restoreItem"
+
+type RestoreItemReq struct {
+	UserID string
+	Type   string // dir group subGroup task
+	ID     uint32
+	// ParentID 恢复到哪里，0表示原来的父节点。目录与分组填目录，子分组填分组，任务填子分组
+	ParentID uint32
+}
+
+type RestoreItemRet struct {
+}
+
+const CmdPurgeTrash share.Cmd = "purgeTrash"
+
+type PurgeTrashReq struct {
+	UserID string
+	// Items 为空时清空整个回收站
+	Items []protocol.PTrashKey
+}
+
+type PurgeTrashRet struct {
+	Purged int
+}
@@ -0,0 +1,23 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdGetView share.Cmd = "getView"
+
+type GetViewReq struct {
+	UserID string
+	View   string // today week started tag waiting custom
+	// Tag tag视图使用
+	Tag string
+	// Filter custom视图使用
+	Filter protocol.PViewFilter
+	// Limit 默认200，最多1000
+	Limit int
+}
+
+type GetViewRet struct {
+	Tasks []protocol.PViewTask
+}
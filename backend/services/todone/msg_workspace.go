@@ -0,0 +1,32 @@
+package todone
+
+import (
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	"github.com/intmian/platform/backend/share"
+)
+
+const CmdExportWorkspace share.Cmd = "exportWorkspace"
+
+type ExportWorkspaceReq struct {
+	UserID string
+}
+
+type ExportWorkspaceRet struct {
+	Archive *protocol.PWorkspaceArchive
+}
+
+const CmdImportWorkspace share.Cmd = "importWorkspace"
+
+type ImportWorkspaceReq struct {
+	UserID  string
+	Archive *protocol.PWorkspaceArchive
+	// ParentDirID 导入到哪个目录下，0表示根目录。导出时的根目录不会新建，其内容直接放进这个目录
+	ParentDirID uint32
+}
+
+type ImportWorkspaceRet struct {
+	Dirs      int
+	Groups    int
+	SubGroups int
+	Tasks     int
+}
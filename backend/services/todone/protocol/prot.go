@@ -10,11 +10,12 @@ type PDir struct {
 }
 
 type PGroup struct {
-	ID    uint32
-	Title string
-	Note  string
-	Index float32
-	Type  int
+	ID       uint32
+	Title    string
+	Note     string
+	Index    float32
+	Type     int
+	Revision uint32
 }
 
 type PDirTree struct {
@@ -28,6 +29,8 @@ type PSubGroup struct {
 	Title string
 	Note  string
 	Index float32
+	// Revision 修改时必须带回，不一致时返回冲突
+	Revision uint32
 }
 
 type PTask struct {
@@ -47,6 +50,40 @@ type PTask struct {
 	// 结束时间或者截止时间
 	EndTime time.Time
 	Wait4   string
+	// Repeat 重复规则，nil表示不重复，通过 setTaskRepeat 修改
+	Repeat *PRepeatRule
+	// Revision 修改时必须带回，不一致时返回冲突
+	Revision uint32
+	// BlockedBy 阻塞这个任务的任务，通过 addTaskBlocker/delTaskBlocker 修改
+	BlockedBy []uint32
+	// Blocked 还有未完成的阻塞任务
+	Blocked bool
+}
+
+// PRepeatRule 重复规则，完成后按规则生成下一次任务
+type PRepeatRule struct {
+	Type string // daily weekly monthly cron afterDone
+	// Interval 每隔几个周期，afterDone 为完成后的天数，0按1处理
+	Interval int
+	// Weekdays weekly 可选，0为周日，为空时按间隔整周重复
+	Weekdays []int
+	// MonthDay monthly 可选，0表示沿用任务当前的日期(设置或首次生成时固定下来)，超过当月天数时取月末
+	MonthDay int
+	// Cron 标准5段cron表达式
+	Cron string
+	// Until 可选，晚于该时间的不再生成
+	Until time.Time
+}
+
+// POccurrence 重复任务之后的某一次
+type POccurrence struct {
+	BeginTime time.Time
+	EndTime   time.Time
+}
+
+type PUpcoming struct {
+	TaskID      uint32
+	Occurrences []POccurrence
 }
 
 type PLibraryNote struct {
@@ -79,3 +116,220 @@ type PLibraryScoreDetail struct {
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
+
+// PTaskPath 任务所在位置，用于从检索结果跳转
+type PTaskPath struct {
+	DirID      uint32
+	GroupID    uint32
+	SubGroupID uint32
+	// Titles 从顶层目录到子分组依次的标题，不含根目录
+	Titles []string
+}
+
+type PSearchHit struct {
+	Task  PTask
+	Path  PTaskPath
+	Score float64
+	// MatchFields 命中的字段，取值 title note tag libraryNote
+	MatchFields   []string
+	LibraryNoteID string
+	Snippet       string
+}
+
+// PTaskHistory 一次任务操作的记录，Before 中没有而 After 中有的任务是本次新建的
+type PTaskHistory struct {
+	ID     uint32
+	Op     string // create change move tag delete undo
+	Before []PTask
+	After  []PTask
+	// RevertID undo 记录撤销的是哪一条
+	RevertID uint32
+	// Reverted 这条记录已经被撤销
+	Reverted  bool
+	CreatedAt time.Time
+}
+
+const (
+	TrashTypeDir      = "dir"
+	TrashTypeGroup    = "group"
+	TrashTypeSubGroup = "subGroup"
+	TrashTypeTask     = "task"
+)
+
+// PTrashKey 指定回收站中的一项
+type PTrashKey struct {
+	Type string
+	ID   uint32
+}
+
+// PTrashItem 回收站中的一项，与它一起删除的子任务不单独列出
+type PTrashItem struct {
+	Type  string // dir group subGroup task
+	ID    uint32
+	Title string
+	// ParentID 原来的父节点，目录与分组是目录，子分组是分组，任务是子分组
+	ParentID     uint32
+	ParentTaskID uint32
+	// ParentMissing 原来的父节点已经删除，需要指定新的父节点才能恢复
+	ParentMissing bool
+	DeletedAt     time.Time
+	// ExpireAt 自动清理的时间，不自动清理时为零值
+	ExpireAt time.Time
+}
+
+// WorkspaceArchiveVersion 导出格式的版本，格式不兼容时递增
+const WorkspaceArchiveVersion = 2
+
+// PWorkspaceArchive 一个用户全部未删除的todone数据。
+// 其中的ID都是导出时的ID，只用于互相引用，导入时全部重新分配。
+type PWorkspaceArchive struct {
+	Version    int
+	ExportedAt time.Time
+	// Dirs 父目录在前，第一个是根目录
+	Dirs      []PArchiveDir
+	Groups    []PArchiveGroup
+	SubGroups []PArchiveSubGroup
+	// Tasks 父任务在前
+	Tasks               []PArchiveTask
+	LibraryNotes        []PLibraryNote
+	LibraryScoreDetails []PLibraryScoreDetail
+	// Blockers 两端都在导出内容中的阻塞关系
+	Blockers []PArchiveBlocker
+	// TagMetas 设置过的标签展示信息
+	TagMetas []PArchiveTagMeta
+}
+
+type PArchiveDir struct {
+	PDir
+	ParentID uint32
+}
+
+type PArchiveGroup struct {
+	PGroup
+	ParentDir uint32
+}
+
+type PArchiveSubGroup struct {
+	PSubGroup
+	ParentGroupID uint32
+	// TaskSequence 未完成任务的顺序，格式同 SubGroupDB.TaskSequence
+	TaskSequence string
+}
+
+// PArchiveTask 任务，PTask.ParentID 是父任务
+type PArchiveTask struct {
+	PTask
+	SubGroupID   uint32
+	RepeatNextID uint32
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// PArchiveBlocker BlockerID 完成前 TaskID 处于阻塞状态
+type PArchiveBlocker struct {
+	TaskID    uint32
+	BlockerID uint32
+}
+
+type PArchiveTagMeta struct {
+	Tag    string
+	Color  string
+	Pinned bool
+}
+
+// 导入任务时支持的格式
+const (
+	TaskImportFormatMarkdown = "markdown"
+	TaskImportFormatCSV      = "csv"
+)
+
+// 内置的任务视图
+const (
+	ViewToday   = "today"   // 今天及之前截止
+	ViewWeek    = "week"    // 本周日及之前截止，周一为一周的开始
+	ViewStarted = "started" // 已经开始
+	ViewTag     = "tag"     // 带有指定标签
+	ViewWaiting = "waiting" // 有等待对象
+	ViewCustom  = "custom"  // 使用请求中的筛选条件
+)
+
+// PViewFilter 视图的筛选条件，各条件之间是与的关系
+type PViewFilter struct {
+	// Tags 需要同时带有这些标签
+	Tags    []string
+	Started bool
+	Waiting bool
+	// DueFrom DueTo 截止时间范围[DueFrom, DueTo)，设置任一项时没有截止时间的任务不返回
+	DueFrom     time.Time
+	DueTo       time.Time
+	ContainDone bool
+}
+
+// PViewTask 视图中的任务与它所在的位置
+type PViewTask struct {
+	Task PTask
+	Path PTaskPath
+}
+
+// 分组分享给其他账号时的角色，所有者不需要记录
+const (
+	ShareRoleViewer = "viewer" // 只能查看
+	ShareRoleEditor = "editor" // 可以修改子分组与任务
+)
+
+// PGroupShare 分组的一条分享
+type PGroupShare struct {
+	GranteeID string
+	Role      string
+	CreatedAt time.Time
+}
+
+// PSharedGroup 其他账号分享给自己的分组，ParentDir 是分组在所有者目录树中的目录，访问分组时作为目录ID使用
+type PSharedGroup struct {
+	PGroup
+	ParentDir uint32
+	OwnerID   string
+	Role      string
+}
+
+// 变更流中被修改的对象
+const (
+	ChangeKindDir      = "dir"
+	ChangeKindGroup    = "group"
+	ChangeKindSubGroup = "subGroup"
+	ChangeKindTask     = "task"
+)
+
+// 变更流中的操作
+const (
+	ChangeOpCreate = "create"
+	ChangeOpChange = "change"
+	ChangeOpMove   = "move"
+	ChangeOpDelete = "delete"
+	ChangeOpTag    = "tag"
+	// ChangeOpUnblock 最后一个阻塞任务完成，任务不再被阻塞
+	ChangeOpUnblock = "unblock"
+)
+
+// PChange 变更流中的一条记录，只说明哪里变了，客户端据此重新拉取。
+// ParentID 是对象所在的容器：目录与分组为目录，子分组为分组，任务为子分组；
+// 移动到其他容器时 FromParentID 为原来的容器，否则为0
+type PChange struct {
+	Seq          uint32
+	Kind         string
+	Op           string
+	ID           uint32
+	ParentID     uint32
+	FromParentID uint32
+	CreatedAt    time.Time
+}
+
+// PTag 标签目录中的一项，PTask.Tags 中的名称据此找到展示信息
+type PTag struct {
+	Tag string
+	// Count 使用该标签的任务数，回收站中的不计入
+	Count int
+	// Color #rrggbb，空表示默认样式
+	Color  string
+	Pinned bool
+}
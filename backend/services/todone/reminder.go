@@ -0,0 +1,349 @@
+package todone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/mian_go_lib/xstorage"
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+	"gorm.io/gorm"
+)
+
+const (
+	// reminderScanInterval 扫描未完成任务的间隔
+	reminderScanInterval = time.Minute
+	// reminderKeep 提醒登记保留的时间，早于此的截止提醒和汇总都不会再触发
+	reminderKeep      = 7 * 24 * time.Hour
+	reminderPushTitle = "待办"
+)
+
+var defaultReminderOffsets = []time.Duration{24 * time.Hour, time.Hour}
+
+// defaultSummaryAt 每日汇总默认在早上9点
+const defaultSummaryAt = 9 * time.Hour
+
+type reminderSetting struct {
+	Enable bool
+	// Offsets 截止前多久提醒
+	Offsets []time.Duration
+	// SummaryAt 每日汇总在当天的时刻，小于0时不推送汇总
+	SummaryAt time.Duration
+}
+
+// loadReminderConfig 读取 todone.reminder.* 配置，提醒默认关闭
+func loadReminderConfig(serviceShare backendshare.ServiceShare) (reminderSetting, error) {
+	setting := reminderSetting{
+		Offsets:   defaultReminderOffsets,
+		SummaryAt: defaultSummaryAt,
+	}
+	params := []*xstorage.CfgParam{
+		{
+			Key:       xstorage.Join("todone", "reminder", "enable"),
+			ValueType: xstorage.ValueTypeBool,
+		},
+		{
+			Key:       xstorage.Join("todone", "reminder", "offsets"),
+			ValueType: xstorage.ValueTypeSliceString,
+		},
+		{
+			Key:       xstorage.Join("todone", "reminder", "summary_time"),
+			ValueType: xstorage.ValueTypeString,
+		},
+	}
+	for _, param := range params {
+		if err := serviceShare.Cfg.AddParam(param); err != nil && !errors.Is(err, xstorage.ErrKeyAlreadyExist) {
+			return setting, errors.Join(errors.New("add reminder cfg param failed"), err)
+		}
+	}
+	enableUnit, err := serviceShare.Cfg.Get("todone", "reminder", "enable")
+	if err != nil {
+		return setting, errors.Join(errors.New("get reminder enable failed"), err)
+	}
+	offsetsUnit, err := serviceShare.Cfg.Get("todone", "reminder", "offsets")
+	if err != nil {
+		return setting, errors.Join(errors.New("get reminder offsets failed"), err)
+	}
+	summaryUnit, err := serviceShare.Cfg.Get("todone", "reminder", "summary_time")
+	if err != nil {
+		return setting, errors.Join(errors.New("get reminder summary time failed"), err)
+	}
+	setting.Enable = xstorage.ToBase[bool](enableUnit)
+	setting.Offsets, err = parseReminderOffsets(xstorage.ToBase[[]string](offsetsUnit))
+	if err != nil {
+		return setting, err
+	}
+	setting.SummaryAt, err = parseSummaryTime(xstorage.ToBase[string](summaryUnit))
+	if err != nil {
+		return setting, err
+	}
+	return setting, nil
+}
+
+// parseReminderOffsets 解析 24h、30m 这样的时长，为空时使用默认值
+func parseReminderOffsets(values []string) ([]time.Duration, error) {
+	offsets := make([]time.Duration, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		offset, err := time.ParseDuration(value)
+		if err != nil || offset <= 0 {
+			return nil, errors.Join(errors.New("invalid todone.reminder.offsets "+value), err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		return defaultReminderOffsets, nil
+	}
+	return offsets, nil
+}
+
+// parseSummaryTime 解析 HH:MM，为空时使用默认值，off 表示关闭每日汇总
+func parseSummaryTime(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "":
+		return defaultSummaryAt, nil
+	case "off":
+		return -1, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.Join(errors.New("invalid todone.reminder.summary_time "+value), err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// reminder 定时扫描所有用户的未完成任务，推送截止提醒、逾期提醒与每日汇总。
+// 每条提醒推送前先在数据库登记，重启后不会重复推送。
+type reminder struct {
+	setting   reminderSetting
+	db        *db.Mgr
+	log       *xlog.XLog
+	push      func(title, content string) error
+	lastPrune time.Time
+	// summaryDay 已经完成每日汇总的那一天的0点，之后当天的扫描只读取截止提醒需要的任务
+	summaryDay time.Time
+	done       chan struct{}
+}
+
+func newReminder(setting reminderSetting, dbMgr *db.Mgr, log *xlog.XLog, push func(title, content string) error) *reminder {
+	return &reminder{
+		setting: setting,
+		db:      dbMgr,
+		log:     log,
+		push:    push,
+		done:    make(chan struct{}),
+	}
+}
+
+// run 阻塞直到ctx取消，结束后关闭done
+func (r *reminder) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(reminderScanInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.scan(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			r.log.WarningErr("TODONE", errors.Join(errors.New("scan reminders failed"), err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wait 等待扫描协程退出，超时返回false
+func (r *reminder) wait(timeout time.Duration) bool {
+	select {
+	case <-r.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+type remindItem struct {
+	task db.TaskDB
+	kind string
+}
+
+func (r *reminder) scan(ctx context.Context, now time.Time) error {
+	conn := r.db.GetConnectCtx(ctx, db.ConnectTypeReminder)
+	if now.Sub(r.lastPrune) >= 24*time.Hour {
+		if err := db.PruneReminders(conn, now.Add(-reminderKeep)); err != nil {
+			return errors.Join(errors.New("prune reminders failed"), err)
+		}
+		r.lastPrune = now
+	}
+	// 平时只读取截止时间落在提醒窗口内的任务；到了汇总时间的第一次扫描再读取全部逾期、今天截止与等待中的任务
+	filter := db.RemindFilter{EndFrom: now.Add(-logic.OverdueRemindWindow), EndTo: now.Add(maxOffset(r.setting.Offsets))}
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	summary := r.setting.SummaryAt >= 0 && !now.Before(dayStart.Add(r.setting.SummaryAt)) && !r.summaryDay.Equal(dayStart)
+	if summary {
+		filter.EndBefore = dayStart.AddDate(0, 0, 1)
+		filter.Waiting = true
+	}
+	tasks, err := db.GetOpenTasksForRemind(r.db.GetConnectCtx(ctx, db.ConnectTypeTask), filter)
+	if err != nil {
+		return errors.Join(errors.New("load open tasks failed"), err)
+	}
+	var userIDs []string
+	userTasks := make(map[string][]db.TaskDB)
+	for _, task := range tasks {
+		if _, ok := userTasks[task.UserID]; !ok {
+			userIDs = append(userIDs, task.UserID)
+		}
+		userTasks[task.UserID] = append(userTasks[task.UserID], task)
+	}
+	var errs []error
+	for _, userID := range userIDs {
+		errs = append(errs, r.remindUser(conn, userID, userTasks[userID], now, summary))
+	}
+	err = errors.Join(errs...)
+	// 有失败时下次扫描重新汇总，已经推送的由登记去重
+	if summary && err == nil {
+		r.summaryDay = dayStart
+	}
+	return err
+}
+
+// maxOffset 最早的截止前提醒
+func maxOffset(offsets []time.Duration) time.Duration {
+	var res time.Duration
+	for _, offset := range offsets {
+		res = max(res, offset)
+	}
+	return res
+}
+
+// remindUser summary 为 true 时tasks包含汇总需要的任务，顺带推送每日汇总。
+// 等待中的任务只出现在每日汇总中，不单独提醒
+func (r *reminder) remindUser(conn *gorm.DB, userID string, tasks []db.TaskDB, now time.Time, summary bool) error {
+	var errs []error
+	var items []remindItem
+	var claimed []uint32
+	for _, task := range tasks {
+		kind, ok := logic.ReminderKind(r.setting.Offsets, task.EndTime, now)
+		if !ok {
+			continue
+		}
+		record := &db.ReminderDB{UserID: userID, TaskID: task.TaskID, Kind: kind, DueUnix: task.EndTime.Unix(), SentAt: now}
+		newClaim, err := db.ClaimReminder(conn, record)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if newClaim {
+			items = append(items, remindItem{task: task, kind: kind})
+			claimed = append(claimed, record.ID)
+		}
+	}
+	if len(items) > 0 {
+		errs = append(errs, r.pushOrRelease(conn, formatTaskReminders(userID, items, now), claimed))
+	}
+
+	if !summary {
+		return errors.Join(errs...)
+	}
+	dailySummary := logic.BuildDailySummary(tasks, now)
+	if dailySummary.Empty() {
+		return errors.Join(errs...)
+	}
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	record := &db.ReminderDB{UserID: userID, Kind: logic.ReminderKindSummary, DueUnix: dayStart.Unix(), SentAt: now}
+	newClaim, err := db.ClaimReminder(conn, record)
+	if err != nil || !newClaim {
+		return errors.Join(append(errs, err)...)
+	}
+	errs = append(errs, r.pushOrRelease(conn, formatDailySummary(userID, dailySummary, now), []uint32{record.ID}))
+	return errors.Join(errs...)
+}
+
+// pushOrRelease 推送失败时撤销登记，下次扫描重新推送
+func (r *reminder) pushOrRelease(conn *gorm.DB, content string, claimed []uint32) error {
+	pushErr := r.push(reminderPushTitle, content)
+	if pushErr == nil {
+		return nil
+	}
+	errs := []error{errors.Join(errors.New("push reminder failed"), pushErr)}
+	for _, id := range claimed {
+		errs = append(errs, db.ReleaseReminder(conn, id))
+	}
+	return errors.Join(errs...)
+}
+
+func formatTime(t time.Time, now time.Time) string {
+	t = t.In(now.Location())
+	if t.Year() == now.Year() && t.YearDay() == now.YearDay() {
+		return t.Format("15:04")
+	}
+	return t.Format("01-02 15:04")
+}
+
+// formatRemain 剩余时间取整到分钟，如 1小时5分钟
+func formatRemain(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	switch {
+	case minutes < 60:
+		return fmt.Sprintf("%d分钟", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d小时", minutes/60)
+	default:
+		return fmt.Sprintf("%d小时%d分钟", minutes/60, minutes%60)
+	}
+}
+
+func formatTaskLine(task db.TaskDB, status string) string {
+	line := fmt.Sprintf("- %s：%s", task.Title, status)
+	if task.Wait4 != "" {
+		line += fmt.Sprintf("（等待：%s）", task.Wait4)
+	}
+	return line + "\n"
+}
+
+func formatTaskReminders(userID string, items []remindItem, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("**%s 的待办提醒**\n", userID))
+	for _, item := range items {
+		end := formatTime(item.task.EndTime, now)
+		if item.kind == logic.ReminderKindOverdue {
+			b.WriteString(formatTaskLine(item.task, "已逾期，截止于 "+end))
+			continue
+		}
+		b.WriteString(formatTaskLine(item.task, fmt.Sprintf("%s 截止，还剩%s", end, formatRemain(item.task.EndTime.Sub(now)))))
+	}
+	return b.String()
+}
+
+func formatDailySummary(userID string, summary logic.DailySummary, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("**%s 的今日待办 %s**\n", userID, now.Format("2006-01-02")))
+	if len(summary.Overdue) > 0 {
+		b.WriteString("\n**已逾期**\n")
+		for _, task := range summary.Overdue {
+			b.WriteString(formatTaskLine(task, "截止于 "+formatTime(task.EndTime, now)))
+		}
+	}
+	if len(summary.Due) > 0 {
+		b.WriteString("\n**今天截止**\n")
+		for _, task := range summary.Due {
+			b.WriteString(formatTaskLine(task, formatTime(task.EndTime, now)))
+		}
+	}
+	if len(summary.Waiting) > 0 {
+		b.WriteString("\n**等待中**\n")
+		for _, task := range summary.Waiting {
+			b.WriteString(fmt.Sprintf("- %s：等待 %s\n", task.Title, task.Wait4))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package todone
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/db"
+)
+
+type pushRecorder struct {
+	contents []string
+	fail     bool
+}
+
+func (p *pushRecorder) push(title, content string) error {
+	if p.fail {
+		return errors.New("push down")
+	}
+	p.contents = append(p.contents, content)
+	return nil
+}
+
+func setTestTaskTime(t *testing.T, s *Service, taskID uint32, end time.Time, wait4 string) {
+	t.Helper()
+	conn := s.db.GetConnect(db.ConnectTypeTask)
+	task, err := db.GetTaskByID(conn, taskID)
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	task.EndTime = end
+	task.Wait4 = wait4
+	if err = db.UpdateTask(conn, task); err != nil {
+		t.Fatalf("update task: %v", err)
+	}
+}
+
+func TestReminderPushesOnceAcrossRestart(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	now := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+	_, _, _, soon := createTestTask(t, s, "u1", "a", "send report", "")
+	_, _, _, late := createTestTask(t, s, "u1", "b", "pay rent", "")
+	_, _, _, waiting := createTestTask(t, s, "u1", "c", "contract", "")
+	setTestTaskTime(t, s, soon, now.Add(30*time.Minute), "")
+	setTestTaskTime(t, s, late, now.Add(-2*time.Hour), "")
+	setTestTaskTime(t, s, waiting, now.Add(48*time.Hour), "bob")
+
+	setting := reminderSetting{Enable: true, Offsets: defaultReminderOffsets, SummaryAt: defaultSummaryAt}
+	recorder := &pushRecorder{}
+	r := newReminder(setting, s.db, s.share.Log, recorder.push)
+	if err := r.scan(context.Background(), now); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(recorder.contents) != 2 {
+		t.Fatalf("pushes = %q", recorder.contents)
+	}
+	remind, summary := recorder.contents[0], recorder.contents[1]
+	if !strings.Contains(remind, "send report") || !strings.Contains(remind, "还剩30分钟") || !strings.Contains(remind, "pay rent") || strings.Contains(remind, "contract") {
+		t.Fatalf("remind = %q", remind)
+	}
+	if !strings.Contains(summary, "已逾期") || !strings.Contains(summary, "今天截止") || !strings.Contains(summary, "等待 bob") {
+		t.Fatalf("summary = %q", summary)
+	}
+
+	// 同一实例再次扫描与重启后的新实例都不会重复推送
+	if err := r.scan(context.Background(), now.Add(time.Minute)); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	restarted := newReminder(setting, s.db, s.share.Log, recorder.push)
+	if err := restarted.scan(context.Background(), now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("scan after restart: %v", err)
+	}
+	if len(recorder.contents) != 2 {
+		t.Fatalf("duplicate pushes = %q", recorder.contents[2:])
+	}
+
+	// 推迟截止时间后重新提醒，推送失败时下次扫描重试
+	setTestTaskTime(t, s, soon, now.Add(50*time.Minute), "")
+	recorder.fail = true
+	if err := restarted.scan(context.Background(), now.Add(3*time.Minute)); err == nil {
+		t.Fatal("push failure not reported")
+	}
+	recorder.fail = false
+	if err := restarted.scan(context.Background(), now.Add(4*time.Minute)); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(recorder.contents) != 3 || !strings.Contains(recorder.contents[2], "send report") || strings.Contains(recorder.contents[2], "pay rent") {
+		t.Fatalf("pushes = %q", recorder.contents)
+	}
+}
+
+func TestGetOpenTasksForRemindFilter(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	zone := time.FixedZone("UTC+8", 8*60*60)
+	now := time.Date(2026, 3, 10, 10, 0, 0, 0, zone)
+	ids := make(map[string]uint32)
+	for _, task := range []struct {
+		title string
+		end   time.Time
+		wait4 string
+	}{
+		// 存入时的时区后缀不同也要按真实时间比较
+		{"soon", now.Add(30 * time.Minute).UTC(), ""},
+		{"evening", now.Add(8 * time.Hour).UTC(), ""},
+		{"old", now.AddDate(0, 0, -5), ""},
+		{"far", now.AddDate(0, 0, 10), ""},
+		{"waiting", now.AddDate(0, 0, 10), "bob"},
+		{"no end", time.Time{}, ""},
+	} {
+		_, _, _, id := createTestTask(t, s, "u1", task.title, task.title, "private note")
+		setTestTaskTime(t, s, id, task.end, task.wait4)
+		ids[task.title] = id
+	}
+	load := func(filter db.RemindFilter) []string {
+		t.Helper()
+		tasks, err := db.GetOpenTasksForRemind(s.db.GetConnect(db.ConnectTypeTask), filter)
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		var titles []string
+		for _, task := range tasks {
+			if task.Note != "" {
+				t.Fatalf("note loaded for %s", task.Title)
+			}
+			titles = append(titles, task.Title)
+		}
+		return titles
+	}
+
+	filter := db.RemindFilter{EndFrom: now.Add(-24 * time.Hour), EndTo: now.Add(time.Hour)}
+	if got := load(filter); !slices.Equal(got, []string{"soon"}) {
+		t.Fatalf("remind window = %v", got)
+	}
+	filter.EndBefore = time.Date(2026, 3, 11, 0, 0, 0, 0, zone)
+	filter.Waiting = true
+	if got := load(filter); !slices.Equal(got, []string{"soon", "evening", "old", "waiting"}) {
+		t.Fatalf("summary window = %v", got)
+	}
+}
+
+func TestParseReminderConfig(t *testing.T) {
+	offsets, err := parseReminderOffsets([]string{"48h", " 30m "})
+	if err != nil || len(offsets) != 2 || offsets[1] != 30*time.Minute {
+		t.Fatalf("offsets = %v err = %v", offsets, err)
+	}
+	if _, err = parseReminderOffsets([]string{"soon"}); err == nil {
+		t.Fatal("invalid offset accepted")
+	}
+	if at, err := parseSummaryTime("07:30"); err != nil || at != 7*time.Hour+30*time.Minute {
+		t.Fatalf("summary at = %v err = %v", at, err)
+	}
+	if at, err := parseSummaryTime("off"); err != nil || at >= 0 {
+		t.Fatalf("off summary at = %v err = %v", at, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/intmian/mian_go_lib/tool/misc"
@@ -14,11 +15,98 @@ import (
 	backendshare "github.com/intmian/platform/backend/share"
 )
 
+func init() {
+	backendshare.RegisterService(backendshare.ServiceDesc{
+		Name: backendshare.NameTodone,
+		Flag: backendshare.FlagTodone,
+		// todone 是日常使用的服务，默认随平台启动
+		DefaultBoot: backendshare.BootAuto,
+		New: func() backendshare.IService {
+			s := &Service{}
+			s.initRpc()
+			return s
+		},
+	})
+}
+
+// autoSaveWaitTimeout 停止服务时等待自动保存落盘的最长时间，自动保存的轮询间隔是5s
+const autoSaveWaitTimeout = 10 * time.Second
+
+// errServiceNotStarted 未启动或已经停止时收到请求
+var errServiceNotStarted = errors.New("service not started")
+
 // Service 业务
 type Service struct {
-	share   backendshare.ServiceShare
-	userMgr logic.UserMgr
-	cancel  context.CancelFunc
+	// lock 保护下面的实例状态。rpc处理期间持有读锁，Start、Stop持有写锁，所以Stop会等正在处理的请求结束再释放状态
+	lock     sync.RWMutex
+	share    backendshare.ServiceShare
+	db       *db.Mgr
+	env      *logic.Env
+	userMgr  *logic.UserMgr
+	cancel   context.CancelFunc
+	rpc      *backendshare.RpcRouter
+	reminder *reminder
+	// trashRetention 回收站保留期限，0表示不自动清理
+	trashRetention time.Duration
+	trashPurger    *trashPurger
+}
+
+// defaultSqlitePath 选择本地SQLite但未配置路径时使用的文件，相对于后端运行目录
+const defaultSqlitePath = "todone.db"
+
+// loadDBConfig 读取 todone.db.driver 并加载对应驱动的配置，未配置驱动时沿用D1 Worker
+func loadDBConfig(serviceShare backendshare.ServiceShare, setting *db.Setting) error {
+	params := []*xstorage.CfgParam{
+		{
+			Key:       xstorage.Join("todone", "db", "driver"),
+			ValueType: xstorage.ValueTypeString,
+		},
+		{
+			Key:       xstorage.Join("todone", "db", "sqlite_path"),
+			ValueType: xstorage.ValueTypeString,
+		},
+	}
+	for _, param := range params {
+		if err := serviceShare.Cfg.AddParam(param); err != nil && !errors.Is(err, xstorage.ErrKeyAlreadyExist) {
+			return errors.Join(errors.New("add db cfg param failed"), err)
+		}
+	}
+	driverUnit, err := serviceShare.Cfg.Get("todone", "db", "driver")
+	if err != nil {
+		return errors.Join(errors.New("get db driver failed"), err)
+	}
+	pathUnit, err := serviceShare.Cfg.Get("todone", "db", "sqlite_path")
+	if err != nil {
+		return errors.Join(errors.New("get sqlite path failed"), err)
+	}
+	driver := db.Driver(strings.TrimSpace(xstorage.ToBase[string](driverUnit)))
+	sqlitePath := strings.TrimSpace(xstorage.ToBase[string](pathUnit))
+	if value := strings.TrimSpace(os.Getenv("PLATFORM_TODONE_DB_DRIVER")); value != "" {
+		driver = db.Driver(value)
+	}
+	if value := strings.TrimSpace(os.Getenv("PLATFORM_TODONE_SQLITE_PATH")); value != "" {
+		sqlitePath = value
+	}
+
+	switch driver {
+	case db.DriverWorker, "":
+		workerEndpoint, workerToken, err := loadWorkerConfig(serviceShare)
+		if err != nil {
+			return err
+		}
+		setting.Driver = db.DriverWorker
+		setting.WorkerEndpoint = workerEndpoint
+		setting.WorkerToken = workerToken
+	case db.DriverSqlite:
+		if sqlitePath == "" {
+			sqlitePath = defaultSqlitePath
+		}
+		setting.Driver = db.DriverSqlite
+		setting.SqlitePath = sqlitePath
+	default:
+		return errors.Join(db.ErrUnknownDriver, errors.New(string(driver)))
+	}
+	return nil
 }
 
 func loadWorkerConfig(serviceShare backendshare.ServiceShare) (string, string, error) {
@@ -64,27 +152,50 @@ func loadWorkerConfig(serviceShare backendshare.ServiceShare) (string, string, e
 }
 
 func (s *Service) Start(share backendshare.ServiceShare) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.share = share
-	logic.GTodoneShare = &s.share
-	logic.GTodoneCtx, s.cancel = context.WithCancel(s.share.Ctx)
 	begin := time.Now()
 	s.share.Log.Info("TODONE", "启动服务")
-	workerEndpoint, workerToken, err := loadWorkerConfig(share)
-	if err != nil {
+	dbSetting := db.Setting{
+		Ctx:  share.Ctx,
+		XBi:  share.Bi,
+		XLog: share.Log,
+	}
+	if err := loadDBConfig(share, &dbSetting); err != nil {
 		return err
 	}
-	err = db.InitGMgr(db.Setting{
-		WorkerEndpoint: workerEndpoint,
-		WorkerToken:    workerToken,
-		Ctx:            share.Ctx,
-		XBi:            share.Bi,
-		XLog:           share.Log,
-	})
+	dbMgr, err := db.Open(dbSetting)
 	if err != nil {
 		return errors.Join(errors.New("init db mgr failed"), err)
 	}
 
-	// 因为stop时会释放资源，所以这里不需要重新初始化
+	// 所有状态都挂在本实例上，stop时整体丢弃，下次start重新创建
+	ctx, cancel := context.WithCancel(share.Ctx)
+	s.db = dbMgr
+	s.cancel = cancel
+	s.env = logic.NewEnv(ctx, dbMgr, share.Log)
+	s.userMgr = logic.NewUserMgr(s.env)
+
+	// 提醒不影响核心功能，配置错误时只记录不阻止启动
+	remindSetting, err := loadReminderConfig(share)
+	if err != nil {
+		s.share.Log.WarningErr("TODONE", errors.Join(errors.New("load reminder config failed"), err))
+	} else if remindSetting.Enable && share.Push != nil {
+		s.reminder = newReminder(remindSetting, dbMgr, share.Log, func(title, content string) error {
+			return share.Push.Push(title, content, true)
+		})
+		go s.reminder.run(ctx)
+	}
+
+	// 配置错误时同样只记录，此时不自动清理回收站
+	s.trashRetention, err = loadTrashRetention(share)
+	if err != nil {
+		s.share.Log.WarningErr("TODONE", errors.Join(errors.New("load trash config failed"), err))
+	} else if s.trashRetention > 0 {
+		s.trashPurger = newTrashPurger(s.trashRetention, dbMgr, s.userMgr, share.Log)
+		go s.trashPurger.run(ctx)
+	}
 
 	s.share.Log.Info("TODONE", "启动成功耗时 %.2fs", time.Since(begin).Seconds())
 
@@ -92,23 +203,61 @@ func (s *Service) Start(share backendshare.ServiceShare) error {
 }
 
 func (s *Service) Stop() error {
-	// 释放管理器以释放资源
-	s.userMgr = logic.UserMgr{}
-	logic.GTodoneShare = nil
-	s.cancel()
-	logic.GTodoneCtx = nil
+	// 拿到写锁时已经没有正在处理的请求，再取消ctx让自动保存做最后一次落盘，等待结束后释放全局资源与数据库连接
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.env != nil && !s.env.WaitAutoSave(autoSaveWaitTimeout) {
+		s.share.Log.Warning("TODONE", "等待自动保存超时")
+	}
+	if s.reminder != nil && !s.reminder.wait(autoSaveWaitTimeout) {
+		s.share.Log.Warning("TODONE", "等待提醒扫描结束超时")
+	}
+	if s.trashPurger != nil && !s.trashPurger.wait(autoSaveWaitTimeout) {
+		s.share.Log.Warning("TODONE", "等待回收站清理结束超时")
+	}
+	// 释放本实例的全部状态
+	dbMgr := s.db
+	s.userMgr = nil
+	s.reminder = nil
+	s.trashPurger = nil
+	s.env = nil
+	s.db = nil
+	s.cancel = nil
+	if dbMgr == nil {
+		return nil
+	}
+	err := dbMgr.Close()
+	if err != nil {
+		return errors.Join(errors.New("close db failed"), err)
+	}
 	return nil
 }
 
+// HealthCheck 数据库不可用时整个服务都无法工作，直接视为失败
+func (s *Service) HealthCheck(ctx context.Context) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.db == nil {
+		return db.ErrConnectDbFailed
+	}
+	return s.db.Ping(ctx)
+}
+
 func (s *Service) Handle(msg backendshare.Msg, valid backendshare.Valid) {
 	return
 }
 
-func (s *Service) HandleRpc(msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
-	// 进行权限校验
-	if !valid.HasOnePermission("admin", "todone") {
-		return nil, errors.New("no permission")
+func (s *Service) HandleRpc(ctx context.Context, msg backendshare.Msg, valid backendshare.Valid) (interface{}, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	// 停止后实例状态已经释放
+	if s.userMgr == nil {
+		return nil, errServiceNotStarted
 	}
+	// 权限已经由 core 按命令校验，这里只校验操作的是自己的数据
 	var user struct {
 		UserID string
 	}
@@ -120,66 +269,69 @@ func (s *Service) HandleRpc(msg backendshare.Msg, valid backendshare.Valid) (int
 		return nil, errors.New("user err")
 	}
 
-	switch msg.Cmd() {
-	case CmdGetDirTree:
-		return backendshare.HandleRpcTool("getDirTree", msg, valid, s.OnGetDirTree)
-	case CmdMoveDir:
-		return backendshare.HandleRpcTool("moveDir", msg, valid, s.OnMoveDir)
-	case CmdMoveGroup:
-		return backendshare.HandleRpcTool("moveGroup", msg, valid, s.OnMoveGroup)
-	case CmdCreateDir:
-		return backendshare.HandleRpcTool("createDir", msg, valid, s.OnCreateDir)
-	case CmdChangeDir:
-		return backendshare.HandleRpcTool("changeDir", msg, valid, s.OnChangeDir)
-	case CmdDelDir:
-		return backendshare.HandleRpcTool("delDir", msg, valid, s.OnDelDir)
-	case CmdDelGroup:
-		return backendshare.HandleRpcTool("delGroup", msg, valid, s.OnDelGroup)
-	case CmdCreateGroup:
-		return backendshare.HandleRpcTool("createGroup", msg, valid, s.OnCreateGroup)
-	case CmdChangeGroup:
-		return backendshare.HandleRpcTool("changeGroup", msg, valid, s.OnChangeGroup)
-	case CmdGetSubGroup:
-		return backendshare.HandleRpcTool("getSubGroup", msg, valid, s.OnGetSubGroup)
-	case CmdGetTask:
-		return backendshare.HandleRpcTool("getTask", msg, valid, s.OnGetTask)
-	case CmdChangeTask:
-		return backendshare.HandleRpcTool("changeTask", msg, valid, s.OnChangeTask)
-	case CmdCreateTask:
-		return backendshare.HandleRpcTool("createTask", msg, valid, s.OnCreateTask)
-	case CmdDelTask:
-		return backendshare.HandleRpcTool("delTask", msg, valid, s.OnDelTask)
-	case CmdCreateSubGroup:
-		return backendshare.HandleRpcTool("createSubGroup", msg, valid, s.OnCreateSubGroup)
-	case CmdDelSubGroup:
-		return backendshare.HandleRpcTool("delSubGroup", msg, valid, s.OnDelSubGroup)
-	case CmdGetTasks:
-		return backendshare.HandleRpcTool("getTasks", msg, valid, s.OnGetTasks)
-	case CmdChangeSubGroup:
-		return backendshare.HandleRpcTool("subGroup", msg, valid, s.OnSubGroup)
-	case CmdTaskMove:
-		return backendshare.HandleRpcTool("taskMove", msg, valid, s.OnTaskMove)
-	case CmdTaskAddTag:
-		return backendshare.HandleRpcTool("taskAddTag", msg, valid, s.OnTaskAddTag)
-	case CmdTaskDelTag:
-		return backendshare.HandleRpcTool("taskDelTag", msg, valid, s.OnTaskDelTag)
-	case CmdGetLibraryNotes:
-		return backendshare.HandleRpcTool("getLibraryNotes", msg, valid, s.OnGetLibraryNotes)
-	case CmdCreateLibraryNote:
-		return backendshare.HandleRpcTool("createLibraryNote", msg, valid, s.OnCreateLibraryNote)
-	case CmdChangeLibraryNote:
-		return backendshare.HandleRpcTool("changeLibraryNote", msg, valid, s.OnChangeLibraryNote)
-	case CmdDelLibraryNote:
-		return backendshare.HandleRpcTool("delLibraryNote", msg, valid, s.OnDelLibraryNote)
-	case CmdGetLibraryScoreDetail:
-		return backendshare.HandleRpcTool("getLibraryScoreDetail", msg, valid, s.OnGetLibraryScoreDetail)
-	case CmdCreateLibraryScoreDetail:
-		return backendshare.HandleRpcTool("createLibraryScoreDetail", msg, valid, s.OnCreateLibraryScoreDetail)
-	case CmdChangeLibraryScoreDetail:
-		return backendshare.HandleRpcTool("changeLibraryScoreDetail", msg, valid, s.OnChangeLibraryScoreDetail)
-	}
-
-	return nil, errors.New("cmd not found")
+	return s.rpc.Handle(ctx, msg, valid)
+}
+
+// initRpc 注册全部rpc命令，新增命令只需要在这里登记
+func (s *Service) initRpc() {
+	s.rpc = backendshare.NewRpcRouter()
+	pers := []backendshare.Permission{backendshare.PermissionAdmin, backendshare.PermissionTodone}
+	backendshare.RegisterCtx(s.rpc, CmdGetDirTree, s.OnGetDirTree, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdMoveDir, s.OnMoveDir, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdMoveGroup, s.OnMoveGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdCreateDir, s.OnCreateDir, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdChangeDir, s.OnChangeDir, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDelDir, s.OnDelDir, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDelGroup, s.OnDelGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdCreateGroup, s.OnCreateGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdChangeGroup, s.OnChangeGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetSubGroup, s.OnGetSubGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetTask, s.OnGetTask, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdChangeTask, s.OnChangeTask, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdCreateTask, s.OnCreateTask, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDelTask, s.OnDelTask, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdCreateSubGroup, s.OnCreateSubGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDelSubGroup, s.OnDelSubGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetTasks, s.OnGetTasks, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdChangeSubGroup, s.OnSubGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdTaskMove, s.OnTaskMove, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdTaskAddTag, s.OnTaskAddTag, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdTaskDelTag, s.OnTaskDelTag, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdSearchTasks, s.OnSearchTasks, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdSetTaskRepeat, s.OnSetTaskRepeat, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetTaskHistory, s.OnGetTaskHistory, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdUndo, s.OnUndo, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdListTrash, s.OnListTrash, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdRestoreItem, s.OnRestoreItem, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdPurgeTrash, s.OnPurgeTrash, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetLibraryNotes, s.OnGetLibraryNotes, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdCreateLibraryNote, s.OnCreateLibraryNote, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdChangeLibraryNote, s.OnChangeLibraryNote, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDelLibraryNote, s.OnDelLibraryNote, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetLibraryScoreDetail, s.OnGetLibraryScoreDetail, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdCreateLibraryScoreDetail, s.OnCreateLibraryScoreDetail, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdChangeLibraryScoreDetail, s.OnChangeLibraryScoreDetail, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdExportWorkspace, s.OnExportWorkspace, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdImportWorkspace, s.OnImportWorkspace, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdImportTasks, s.OnImportTasks, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdExportTasks, s.OnExportTasks, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdGetView, s.OnGetView, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdShareGroup, s.OnShareGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdUnshareGroup, s.OnUnshareGroup, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdListGroupShares, s.OnListGroupShares, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdListTags, s.OnListTags, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdRenameTag, s.OnRenameTag, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdMergeTags, s.OnMergeTags, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDeleteTag, s.OnDeleteTag, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdSetTagMeta, s.OnSetTagMeta, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdBatchTaskOps, s.OnBatchTaskOps, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdAddTaskBlocker, s.OnAddTaskBlocker, pers...)
+	backendshare.RegisterCtx(s.rpc, CmdDelTaskBlocker, s.OnDelTaskBlocker, pers...)
+	backendshare.RegisterStream[ChangesReq, ChangesEvent](s.rpc, CmdChanges, pers...)
+}
+
+func (s *Service) RpcRouter() *backendshare.RpcRouter {
+	return s.rpc
 }
 
 func (s *Service) GetProp() backendshare.ServiceProp {
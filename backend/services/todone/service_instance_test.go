@@ -0,0 +1,162 @@
+package todone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intmian/mian_go_lib/xbi"
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/platform/backend/services/todone/db"
+	backendshare "github.com/intmian/platform/backend/share"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newLocalTestService 在本地SQLite上启动一个独立的todone实例，测试结束时自动停止
+func newLocalTestService(t *testing.T, dbPath string) *Service {
+	t.Helper()
+	logS := xlog.DefaultSetting()
+	logS.LogAddr = t.TempDir()
+	logS.IfPush = false
+	log, err := xlog.NewXLog(logS)
+	if err != nil {
+		t.Fatalf("new log: %v", err)
+	}
+	biDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "bi.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open bi db: %v", err)
+	}
+	bi, err := xbi.NewXBi(xbi.Setting{Db: biDB, ErrorChan: make(chan error, 16), Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("new bi: %v", err)
+	}
+	t.Setenv("PLATFORM_TODONE_DB_DRIVER", string(db.DriverSqlite))
+	t.Setenv("PLATFORM_TODONE_SQLITE_PATH", dbPath)
+	s := &Service{}
+	s.initRpc()
+	share := newWorkerConfigTestShare(t)
+	share.Ctx = context.Background()
+	share.Log = log
+	share.Bi = bi
+	if err = s.Start(share); err != nil {
+		t.Fatalf("start todone: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Stop()
+	})
+	return s
+}
+
+// callLocal 按网页请求的方式用json消息调用实例
+func callLocal[ReqT, RetT any](t *testing.T, s *Service, userID string, cmd backendshare.Cmd, req ReqT) (RetT, error) {
+	t.Helper()
+	var zero RetT
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal %s: %v", cmd, err)
+	}
+	ret, err := s.HandleRpc(context.Background(), backendshare.MakeMsgJson(cmd, string(data)), backendshare.Valid{User: userID})
+	if err != nil {
+		return zero, err
+	}
+	return ret.(RetT), nil
+}
+
+func createTestDir(t *testing.T, s *Service, userID, title string) {
+	t.Helper()
+	root := getTestDirTree(t, s, userID).DirTree.RootDir.ID
+	if _, err := callLocal[CreateDirReq, CreateDirRet](t, s, userID, CmdCreateDir, CreateDirReq{UserID: userID, ParentDirID: root, Title: title}); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+}
+
+func getTestDirTree(t *testing.T, s *Service, userID string) GetDirTreeRet {
+	t.Helper()
+	ret, err := callLocal[GetDirTreeReq, GetDirTreeRet](t, s, userID, CmdGetDirTree, GetDirTreeReq{UserID: userID})
+	if err != nil {
+		t.Fatalf("get dir tree: %v", err)
+	}
+	return ret
+}
+
+func TestServiceInstancesAreIsolated(t *testing.T) {
+	a := newLocalTestService(t, filepath.Join(t.TempDir(), "a.sqlite"))
+	b := newLocalTestService(t, filepath.Join(t.TempDir(), "b.sqlite"))
+
+	createTestDir(t, a, "u1", "only in a")
+	if got := len(getTestDirTree(t, a, "u1").DirTree.ChildrenDir); got != 1 {
+		t.Fatalf("instance a dirs = %d", got)
+	}
+	if got := len(getTestDirTree(t, b, "u1").DirTree.ChildrenDir); got != 0 {
+		t.Fatalf("instance b sees dirs of a: %d", got)
+	}
+}
+
+func TestServiceStopReleasesState(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "todone.sqlite")
+	s := newLocalTestService(t, dbPath)
+	createTestDir(t, s, "u1", "dir")
+	if err := s.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if s.db != nil || s.env != nil || s.userMgr != nil {
+		t.Fatal("stop kept instance state")
+	}
+	if _, err := callLocal[GetDirTreeReq, GetDirTreeRet](t, s, "u1", CmdGetDirTree, GetDirTreeReq{UserID: "u1"}); err == nil {
+		t.Fatal("stopped service handled rpc")
+	}
+	if err := s.HealthCheck(context.Background()); err == nil {
+		t.Fatal("stopped service is healthy")
+	}
+
+	// 重新启动后从数据库重新加载
+	restarted := newLocalTestService(t, dbPath)
+	if got := len(getTestDirTree(t, restarted, "u1").DirTree.ChildrenDir); got != 1 {
+		t.Fatalf("restarted dirs = %d", got)
+	}
+}
+
+// 停止与请求并发时请求要么正常完成要么返回未启动，需要用 -race 运行
+func TestServiceStopWhileHandling(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	createTestDir(t, s, "u1", "dir")
+	msg := backendshare.MakeMsgJson(CmdGetDirTree, `{"UserID":"u1"}`)
+
+	done := make(chan struct{})
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := s.HandleRpc(context.Background(), msg, backendshare.Valid{User: "u1"}); err != nil && !errors.Is(err, errServiceNotStarted) {
+					t.Errorf("rpc during stop: %v", err)
+					return
+				}
+				_ = s.HealthCheck(context.Background())
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	close(done)
+	wait.Wait()
+	if _, err := s.HandleRpc(context.Background(), msg, backendshare.Valid{User: "u1"}); !errors.Is(err, errServiceNotStarted) {
+		t.Fatalf("rpc after stop err = %v", err)
+	}
+}
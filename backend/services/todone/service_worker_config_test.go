@@ -1,11 +1,13 @@
 package todone
 
 import (
+	"errors"
 	"path/filepath"
 	"testing"
 
 	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/mian_go_lib/xstorage"
+	"github.com/intmian/platform/backend/services/todone/db"
 	backendshare "github.com/intmian/platform/backend/share"
 )
 
@@ -94,3 +96,33 @@ func TestLoadWorkerConfigDoesNotUseLegacyToken(t *testing.T) {
 		t.Fatal("legacy token must not satisfy Worker configuration")
 	}
 }
+
+func TestLoadDBConfigSelectsDriver(t *testing.T) {
+	t.Setenv("PLATFORM_TODONE_WORKER_ENDPOINT", "https://override.example.com")
+	t.Setenv("PLATFORM_TODONE_WORKER_TOKEN", "override-token")
+	t.Setenv("PLATFORM_TODONE_SQLITE_PATH", "")
+
+	// 未配置驱动时沿用Worker
+	t.Setenv("PLATFORM_TODONE_DB_DRIVER", "")
+	var setting db.Setting
+	if err := loadDBConfig(newWorkerConfigTestShare(t), &setting); err != nil {
+		t.Fatalf("load default driver: %v", err)
+	}
+	if setting.Driver != db.DriverWorker || setting.WorkerEndpoint != "https://override.example.com" {
+		t.Fatalf("unexpected default setting: %#v", setting)
+	}
+
+	t.Setenv("PLATFORM_TODONE_DB_DRIVER", "sqlite")
+	setting = db.Setting{}
+	if err := loadDBConfig(newWorkerConfigTestShare(t), &setting); err != nil {
+		t.Fatalf("load sqlite driver: %v", err)
+	}
+	if setting.Driver != db.DriverSqlite || setting.SqlitePath != defaultSqlitePath || setting.WorkerToken != "" {
+		t.Fatalf("unexpected sqlite setting: %#v", setting)
+	}
+
+	t.Setenv("PLATFORM_TODONE_DB_DRIVER", "mysql")
+	if err := loadDBConfig(newWorkerConfigTestShare(t), &db.Setting{}); !errors.Is(err, db.ErrUnknownDriver) {
+		t.Fatalf("unknown driver err = %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package todone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+const (
+	// changesSubscribeTimeout 连接后等待客户端发送订阅消息的最长时间
+	changesSubscribeTimeout = 15 * time.Second
+	// changesPingInterval 没有变更时的保活间隔，避免被代理当作空闲连接断开
+	changesPingInterval = 30 * time.Second
+)
+
+// HandleStream 目前只有变更流一个长连接命令
+func (s *Service) HandleStream(ctx context.Context, cmd backendshare.Cmd, valid backendshare.Valid, conn backendshare.StreamConn) error {
+	if cmd != CmdChanges {
+		return errors.Join(backendshare.ErrRpcCmdNotFound, errors.New(string(cmd)))
+	}
+	// core 已经按 initRpc 中声明的权限校验过，这里保留校验，不经过core直接调用时同样生效
+	if !valid.HasOnePermission(backendshare.PermissionAdmin, backendshare.PermissionTodone) {
+		return writeChangesErr(conn, backendshare.ErrRpcNoPermission)
+	}
+	// 长连接不持有读锁，否则Stop要等所有连接断开。这里持有启动时的实例，并随服务的ctx一起结束
+	s.lock.RLock()
+	env := s.env
+	s.lock.RUnlock()
+	if env == nil {
+		return writeChangesErr(conn, errServiceNotStarted)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(env.Ctx, cancel)
+	defer stop()
+
+	// 读协程：第一条是订阅消息，之后读到错误说明连接已经断开
+	subscribed := make(chan ChangesReq, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		var req ChangesReq
+		if err := conn.ReadJSON(&req); err != nil {
+			readErr <- err
+			return
+		}
+		subscribed <- req
+		for {
+			var ignore json.RawMessage
+			if err := conn.ReadJSON(&ignore); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	var req ChangesReq
+	select {
+	case req = <-subscribed:
+	case err := <-readErr:
+		return err
+	case <-ctx.Done():
+		return nil
+	case <-time.After(changesSubscribeTimeout):
+		return writeChangesErr(conn, errors.New("subscribe timeout"))
+	}
+	if req.UserID == "" || req.UserID != valid.User {
+		return writeChangesErr(conn, errors.New("user err"))
+	}
+
+	// 先订阅再补发，补发期间产生的变更会同时出现在两边，按Seq去重
+	sub := env.Feed.Subscribe(req.UserID)
+	defer env.Feed.Unsubscribe(sub)
+	changes, latest, reset, err := env.Feed.Replay(ctx, req.UserID, req.Cursor)
+	if err != nil {
+		return writeChangesErr(conn, err)
+	}
+	if reset {
+		if err = conn.WriteJSON(ChangesEvent{Type: ChangesEventReset, Cursor: latest}); err != nil {
+			return err
+		}
+	}
+	for i := range changes {
+		if err = conn.WriteJSON(ChangesEvent{Type: ChangesEventChange, Cursor: changes[i].Seq, Change: &changes[i]}); err != nil {
+			return err
+		}
+	}
+	if err = conn.WriteJSON(ChangesEvent{Type: ChangesEventReady, Cursor: latest}); err != nil {
+		return err
+	}
+
+	sent := latest
+	ticker := time.NewTicker(changesPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case change := <-sub.C:
+			// 只跳过补发过的；共享分组的变更在所有者的用户锁内发布，与自己的变更之间Seq可能不按顺序到达
+			if change.Seq <= latest {
+				continue
+			}
+			if err = conn.WriteJSON(ChangesEvent{Type: ChangesEventChange, Cursor: change.Seq, Change: &change}); err != nil {
+				return err
+			}
+			sent = max(sent, change.Seq)
+		case <-sub.Lost:
+			// 推送跟不上时断开，客户端带着最后的游标重连即可补齐
+			return writeChangesErr(conn, logic.ErrChangeSubOverflow)
+		case <-ticker.C:
+			if err = conn.WriteJSON(ChangesEvent{Type: ChangesEventPing, Cursor: sent}); err != nil {
+				return err
+			}
+		case <-readErr:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func writeChangesErr(conn backendshare.StreamConn, err error) error {
+	_ = conn.WriteJSON(ChangesEvent{Type: ChangesEventError, Message: err.Error()})
+	return err
+}
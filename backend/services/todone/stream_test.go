@@ -0,0 +1,170 @@
+package todone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intmian/platform/backend/services/todone/protocol"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+// fakeStreamConn 用通道模拟WebSocket，关闭in表示客户端断开
+type fakeStreamConn struct {
+	in  chan any
+	out chan ChangesEvent
+}
+
+func (c *fakeStreamConn) ReadJSON(v interface{}) error {
+	msg, ok := <-c.in
+	if !ok {
+		return errors.New("closed")
+	}
+	data, _ := json.Marshal(msg)
+	return json.Unmarshal(data, v)
+}
+
+func (c *fakeStreamConn) WriteJSON(v interface{}) error {
+	c.out <- v.(ChangesEvent)
+	return nil
+}
+
+func testStreamValid(userID string) backendshare.Valid {
+	return backendshare.Valid{
+		User:        userID,
+		Permissions: []backendshare.Permission{backendshare.PermissionTodone},
+		ValidTime:   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+type testChangeStream struct {
+	conn      *fakeStreamConn
+	done      chan error
+	closeOnce sync.Once
+}
+
+func openTestChangeStream(t *testing.T, s *Service, userID string, cursor uint32) *testChangeStream {
+	t.Helper()
+	stream := &testChangeStream{
+		conn: &fakeStreamConn{in: make(chan any, 1), out: make(chan ChangesEvent, 64)},
+		done: make(chan error, 1),
+	}
+	go func() {
+		stream.done <- s.HandleStream(context.Background(), CmdChanges, testStreamValid(userID), stream.conn)
+	}()
+	stream.conn.in <- ChangesReq{UserID: userID, Cursor: cursor}
+	t.Cleanup(stream.close)
+	return stream
+}
+
+func (s *testChangeStream) next(t *testing.T) ChangesEvent {
+	t.Helper()
+	select {
+	case event := <-s.conn.out:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event")
+		return ChangesEvent{}
+	}
+}
+
+// until 读到type类型的消息为止，返回途中收到的变更
+func (s *testChangeStream) until(t *testing.T, eventType string) ([]protocol.PChange, ChangesEvent) {
+	t.Helper()
+	var changes []protocol.PChange
+	for {
+		event := s.next(t)
+		if event.Type == eventType {
+			return changes, event
+		}
+		if event.Type != ChangesEventChange {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		changes = append(changes, *event.Change)
+	}
+}
+
+func (s *testChangeStream) close() {
+	s.closeOnce.Do(func() {
+		close(s.conn.in)
+		<-s.done
+	})
+}
+
+func changeKinds(changes []protocol.PChange) []string {
+	res := make([]string, 0, len(changes))
+	for _, change := range changes {
+		res = append(res, change.Kind+":"+change.Op)
+	}
+	return res
+}
+
+func TestChangeStreamLiveAndResume(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	getTestDirTree(t, s, "u1")
+	stream := openTestChangeStream(t, s, "u1", 0)
+	if _, ready := stream.until(t, ChangesEventReady); ready.Cursor != 0 {
+		t.Fatalf("ready cursor = %d", ready.Cursor)
+	}
+
+	dirID, groupID, subGroupID, taskID := createTestTask(t, s, "u1", "work", "a", "")
+	createTestTask(t, s, "u2", "other", "b", "")
+	var live []protocol.PChange
+	// 新建分组时会带一个默认子分组
+	for len(live) < 5 {
+		event := stream.next(t)
+		if event.Type != ChangesEventChange || event.Cursor != event.Change.Seq {
+			t.Fatalf("event = %+v", event)
+		}
+		live = append(live, *event.Change)
+	}
+	if got := strings.Join(changeKinds(live), ","); got != "dir:create,group:create,subGroup:create,subGroup:create,task:create" {
+		t.Fatalf("live = %s", got)
+	}
+	if live[1].ID != groupID || live[1].ParentID != dirID || live[3].ID != subGroupID || live[4].ID != taskID || live[4].ParentID != subGroupID {
+		t.Fatalf("live = %+v", live)
+	}
+	stream.close()
+
+	// 断线期间的修改在重连时按游标补发，其他用户的变更不会出现
+	if _, err := callLocal[TaskAddTagReq, TaskAddTagRet](t, s, "u1", CmdTaskAddTag, TaskAddTagReq{UserID: "u1", DirID: dirID, GroupID: groupID, SubGroupID: subGroupID, TaskID: taskID, Tag: "x"}); err != nil {
+		t.Fatalf("add tag: %v", err)
+	}
+	resumed := openTestChangeStream(t, s, "u1", live[2].Seq)
+	replay, ready := resumed.until(t, ChangesEventReady)
+	if got := strings.Join(changeKinds(replay), ","); got != "subGroup:create,task:create,task:tag" {
+		t.Fatalf("replay = %s", got)
+	}
+	if ready.Cursor < replay[2].Seq {
+		t.Fatalf("ready cursor %d < %d", ready.Cursor, replay[2].Seq)
+	}
+}
+
+func TestChangeStreamResetAndReject(t *testing.T) {
+	s := newLocalTestService(t, filepath.Join(t.TempDir(), "todone.sqlite"))
+	createTestTask(t, s, "u1", "work", "a", "")
+
+	stream := openTestChangeStream(t, s, "u1", 100000)
+	reset := stream.next(t)
+	if reset.Type != ChangesEventReset || reset.Cursor == 0 {
+		t.Fatalf("reset = %+v", reset)
+	}
+	if _, ready := stream.until(t, ChangesEventReady); ready.Cursor != reset.Cursor {
+		t.Fatalf("ready = %+v", ready)
+	}
+
+	// 订阅别人的变更与没有权限都会被拒绝
+	for _, valid := range []backendshare.Valid{testStreamValid("u2"), {User: "u1"}} {
+		conn := &fakeStreamConn{in: make(chan any, 1), out: make(chan ChangesEvent, 4)}
+		conn.in <- ChangesReq{UserID: "u1"}
+		err := s.HandleStream(context.Background(), CmdChanges, valid, conn)
+		if err == nil || (<-conn.out).Type != ChangesEventError {
+			t.Fatalf("valid %+v err = %v", valid, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package todone
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/intmian/mian_go_lib/xlog"
+	"github.com/intmian/mian_go_lib/xstorage"
+	"github.com/intmian/platform/backend/services/todone/db"
+	"github.com/intmian/platform/backend/services/todone/logic"
+	backendshare "github.com/intmian/platform/backend/share"
+)
+
+const (
+	// defaultTrashRetentionDays 未配置时回收站保留的天数
+	defaultTrashRetentionDays = 30
+	// trashPurgeInterval 检查过期内容的间隔
+	trashPurgeInterval = time.Hour
+)
+
+// loadTrashRetention 读取 todone.trash.retention_days，未配置时为30天，小于0表示不自动清理并返回0
+func loadTrashRetention(serviceShare backendshare.ServiceShare) (time.Duration, error) {
+	param := &xstorage.CfgParam{
+		Key:       xstorage.Join("todone", "trash", "retention_days"),
+		ValueType: xstorage.ValueTypeInt,
+	}
+	if err := serviceShare.Cfg.AddParam(param); err != nil && !errors.Is(err, xstorage.ErrKeyAlreadyExist) {
+		return 0, errors.Join(errors.New("add trash cfg param failed"), err)
+	}
+	unit, err := serviceShare.Cfg.Get("todone", "trash", "retention_days")
+	if err != nil {
+		return 0, errors.Join(errors.New("get trash retention failed"), err)
+	}
+	return trashRetention(xstorage.ToBase[int](unit)), nil
+}
+
+func trashRetention(days int) time.Duration {
+	switch {
+	case days < 0:
+		return 0
+	case days == 0:
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// trashPurger 定时彻底删除回收站中超过保留期限的内容
+type trashPurger struct {
+	retention time.Duration
+	db        *db.Mgr
+	userMgr   *logic.UserMgr
+	log       *xlog.XLog
+	done      chan struct{}
+}
+
+func newTrashPurger(retention time.Duration, dbMgr *db.Mgr, userMgr *logic.UserMgr, log *xlog.XLog) *trashPurger {
+	return &trashPurger{
+		retention: retention,
+		db:        dbMgr,
+		userMgr:   userMgr,
+		log:       log,
+		done:      make(chan struct{}),
+	}
+}
+
+// run 阻塞直到ctx取消，结束后关闭done
+func (p *trashPurger) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.purge(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			p.log.WarningErr("TODONE", errors.Join(errors.New("purge trash failed"), err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wait 等待清理协程退出，超时返回false
+func (p *trashPurger) wait(timeout time.Duration) bool {
+	select {
+	case <-p.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// purge 按用户加锁清理，与用户的其他操作互斥
+func (p *trashPurger) purge(ctx context.Context, now time.Time) error {
+	conn := p.db.GetConnectCtx(ctx, db.ConnectTypeTask)
+	if err := db.StampTrash(conn, now.Unix()); err != nil {
+		return errors.Join(errors.New("stamp trash failed"), err)
+	}
+	before := now.Add(-p.retention)
+	userIDs, err := db.GetExpiredTrashUsers(conn, before.Unix())
+	if err != nil {
+		return errors.Join(errors.New("load expired trash users failed"), err)
+	}
+	var errs []error
+	for _, userID := range userIDs {
+		p.userMgr.SafeUseUserLogic(userID, func(user *logic.UserLogic) {
+			if _, err := user.PurgeExpiredTrash(ctx, before); err != nil {
+				errs = append(errs, errors.Join(errors.New("purge trash of "+userID+" failed"), err))
+			}
+		}, func() {})
+	}
+	return errors.Join(errs...)
+}
@@ -1,9 +1,51 @@
 package web_storage
 
 import (
+	"context"
+	"errors"
+
+	"github.com/intmian/mian_go_lib/tool/misc"
 	"github.com/intmian/platform/backend/share"
 )
 
+const Name share.SvrName = "web-storage"
+
+var ErrUnknownCmd = errors.New("unknown cmd")
+
+func init() {
+	share.RegisterService(share.ServiceDesc{
+		Name: Name,
+		New: func() share.IService {
+			return &Service{}
+		},
+	})
+}
+
 type Service struct {
 	share share.ServiceShare
 }
+
+func (s *Service) Start(share share.ServiceShare) error {
+	s.share = share
+	return nil
+}
+
+func (s *Service) Stop() error {
+	return nil
+}
+
+func (s *Service) Handle(msg share.Msg, valid share.Valid) {
+	// NOTHING
+}
+
+func (s *Service) HandleRpc(ctx context.Context, msg share.Msg, valid share.Valid) (interface{}, error) {
+	return nil, ErrUnknownCmd
+}
+
+func (s *Service) GetProp() share.ServiceProp {
+	return misc.CreateProperty(share.SvrPropMicro)
+}
+
+func (s *Service) DebugCommand(req share.DebugReq) interface{} {
+	return nil
+}
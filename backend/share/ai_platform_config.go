@@ -537,6 +537,7 @@ func (c AIPlatformConfig) findModel(providerID string, modelID ai.ModelID) (AIPr
 type SceneAI struct {
 	config AIPlatformConfig
 	scene  AIScene
+	ctx    context.Context // Chat 使用的ctx，为空时不可取消
 }
 
 func NewSceneAI(cfg *xstorage.CfgExt, scene AIScene) (*SceneAI, error) {
@@ -557,8 +558,22 @@ func NewSceneAIWithConfig(config AIPlatformConfig, scene AIScene) (*SceneAI, err
 	return &SceneAI{config: config, scene: scene}, nil
 }
 
+// WithContext 返回绑定了ctx的副本，之后的 Chat 会随ctx取消，方便传给只认 Chat 的调用方
+func (c *SceneAI) WithContext(ctx context.Context) *SceneAI {
+	if c == nil {
+		return nil
+	}
+	ret := *c
+	ret.ctx = ctx
+	return &ret
+}
+
 func (c *SceneAI) Chat(prompt string) (string, error) {
-	return c.ChatContext(context.Background(), prompt)
+	ctx := context.Background()
+	if c != nil && c.ctx != nil {
+		ctx = c.ctx
+	}
+	return c.ChatContext(ctx, prompt)
 }
 
 func (c *SceneAI) ChatContext(ctx context.Context, prompt string) (string, error) {
@@ -52,18 +52,46 @@ type ServiceStatus int
 const (
 	StatusStop ServiceStatus = iota
 	StatusStart
+	StatusFailed   // 启动失败或健康检查失败，等待自动重启
+	StatusDegraded // 健康检查报告降级，仍在提供服务
 )
 
+// BootPolicy 服务在平台启动时的策略，按服务名分别持久化
+type BootPolicy string
+
+const (
+	BootAuto     BootPolicy = "auto"     // 随平台启动
+	BootManual   BootPolicy = "manual"   // 不随平台启动，可以在后台手动启动
+	BootDisabled BootPolicy = "disabled" // 禁用，不允许启动
+)
+
+func (b BootPolicy) Valid() bool {
+	switch b {
+	case BootAuto, BootManual, BootDisabled:
+		return true
+	default:
+		return false
+	}
+}
+
 type ServiceMeta struct {
-	StartTime time.Time
-	Status    ServiceStatus
+	StartTime  time.Time
+	Status     ServiceStatus
+	BootPolicy BootPolicy
+	FailCount  int       // 连续失败次数，用于计算重启退避
+	LastErr    string    // 最近一次失败或降级的原因
+	NextRetry  time.Time // 失败后下一次允许自动重启的时间
 }
 
 type ServicesInfo struct {
-	Name      string
-	Status    string
-	StartTime string
-	Props     int
+	Name       string
+	Status     string
+	StartTime  string
+	Props      int
+	Deps       []string
+	BootPolicy string
+	FailCount  int
+	LastErr    string
 }
 
 type Setting struct {
@@ -78,6 +106,7 @@ const (
 	PermissionAuto       Permission = "auto"
 	PermissionAutoReport Permission = "auto.report"
 	PermissionAI         Permission = "ai"
+	PermissionTodone     Permission = "todone"
 )
 
 type Valid struct {
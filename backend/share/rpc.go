@@ -0,0 +1,223 @@
+package share
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// DefaultRpcTimeout 命令默认的超时时间，耗时长的命令用 SetTimeout 单独调整
+const DefaultRpcTimeout = 30 * time.Second
+
+var (
+	ErrRpcCmdNotFound  = errors.New("cmd not found")
+	ErrRpcNoPermission = errors.New("no permission")
+)
+
+// RpcCmdInfo 命令的自描述，用于后台自省
+type RpcCmdInfo struct {
+	Cmd         Cmd
+	Permissions []Permission
+	Timeout     time.Duration
+	// Stream 长连接命令，ReqSchema 为订阅消息，RetSchema 为推送的事件
+	Stream    bool
+	ReqSchema map[string]interface{}
+	RetSchema map[string]interface{}
+}
+
+type rpcHandler struct {
+	reqType     reflect.Type
+	retType     reflect.Type
+	permissions []Permission
+	timeout     time.Duration // 为0时使用路由的默认超时
+	stream      bool          // 长连接命令，只声明权限与消息结构，由 IStreamService 处理
+	handle      func(ctx context.Context, msg Msg, valid Valid) (interface{}, error)
+}
+
+// RpcRouter 服务内命令到处理函数的路由，用 Register 注册后在 HandleRpc 中调用 Handle 分发
+type RpcRouter struct {
+	handlers       map[Cmd]*rpcHandler
+	cmds           []Cmd // 保持注册顺序，方便自省展示
+	defaultTimeout time.Duration
+}
+
+func NewRpcRouter() *RpcRouter {
+	return &RpcRouter{
+		handlers:       make(map[Cmd]*rpcHandler),
+		defaultTimeout: DefaultRpcTimeout,
+	}
+}
+
+// Register 注册不关心取消的命令，请求与返回的类型由处理函数推导，重复注册属于编码错误，直接panic
+// permissions 为调用该命令需要的权限，满足其一即可，不填时只有系统调用可以访问
+func Register[ReqT any, RetT any](r *RpcRouter, cmd Cmd, handle func(Valid, ReqT) (RetT, error), permissions ...Permission) {
+	RegisterCtx(r, cmd, func(_ context.Context, valid Valid, req ReqT) (RetT, error) {
+		return handle(valid, req)
+	}, permissions...)
+}
+
+// RegisterCtx 注册需要ctx的命令，ctx在请求方断开或命令超时后取消，处理函数应把它传给数据库、AI等下游调用
+func RegisterCtx[ReqT any, RetT any](r *RpcRouter, cmd Cmd, handle func(context.Context, Valid, ReqT) (RetT, error), permissions ...Permission) {
+	if _, ok := r.handlers[cmd]; ok {
+		panic("rpc cmd duplicate: " + string(cmd))
+	}
+	r.handlers[cmd] = &rpcHandler{
+		reqType:     reflect.TypeOf((*ReqT)(nil)).Elem(),
+		retType:     reflect.TypeOf((*RetT)(nil)).Elem(),
+		permissions: permissions,
+		handle: func(ctx context.Context, msg Msg, valid Valid) (interface{}, error) {
+			return HandleRpcTool(string(cmd), msg, valid, func(valid Valid, req ReqT) (RetT, error) {
+				return handle(ctx, valid, req)
+			})
+		},
+	}
+	r.cmds = append(r.cmds, cmd)
+}
+
+// RegisterStream 声明长连接命令，权限与 Register 相同由 core 在升级后统一校验，
+// ReqT 为客户端的订阅消息，EventT 为推送的事件。长连接命令不能通过 Handle 调用
+func RegisterStream[ReqT any, EventT any](r *RpcRouter, cmd Cmd, permissions ...Permission) {
+	if _, ok := r.handlers[cmd]; ok {
+		panic("rpc cmd duplicate: " + string(cmd))
+	}
+	r.handlers[cmd] = &rpcHandler{
+		reqType:     reflect.TypeOf((*ReqT)(nil)).Elem(),
+		retType:     reflect.TypeOf((*EventT)(nil)).Elem(),
+		permissions: permissions,
+		stream:      true,
+	}
+	r.cmds = append(r.cmds, cmd)
+}
+
+// SetDefaultTimeout 修改未单独设置超时的命令的超时时间，0 表示不限制
+func (r *RpcRouter) SetDefaultTimeout(timeout time.Duration) {
+	r.defaultTimeout = timeout
+}
+
+// SetTimeout 单独设置某个命令的超时时间，命令不存在属于编码错误，直接panic
+func (r *RpcRouter) SetTimeout(cmd Cmd, timeout time.Duration) {
+	h, ok := r.handlers[cmd]
+	if !ok {
+		panic("rpc cmd not registered: " + string(cmd))
+	}
+	h.timeout = timeout
+}
+
+func (r *RpcRouter) getTimeout(h *rpcHandler) time.Duration {
+	if h.timeout > 0 {
+		return h.timeout
+	}
+	return r.defaultTimeout
+}
+
+// Handle 按命令分发，未注册的命令返回 ErrRpcCmdNotFound，ctx 已经取消时不再执行
+func (r *RpcRouter) Handle(ctx context.Context, msg Msg, valid Valid) (interface{}, error) {
+	h, ok := r.handlers[msg.Cmd()]
+	if !ok || h.stream {
+		return nil, errors.Join(ErrRpcCmdNotFound, errors.New(string(msg.Cmd())))
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ReqIDFromContext(ctx) == "" {
+		ctx = ContextWithReqID(ctx, msg.ReqID())
+	}
+	if timeout := r.getTimeout(h); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Join(err, errors.New(string(msg.Cmd())+" canceled"))
+	}
+	return h.handle(ctx, msg, valid)
+}
+
+func (r *RpcRouter) Has(cmd Cmd) bool {
+	_, ok := r.handlers[cmd]
+	return ok
+}
+
+// IsStream 命令是否是用 RegisterStream 声明的长连接命令
+func (r *RpcRouter) IsStream(cmd Cmd) bool {
+	h, ok := r.handlers[cmd]
+	return ok && h.stream
+}
+
+// CheckPermission 校验调用方是否拥有命令声明的权限，由 core 在分发前统一调用
+func (r *RpcRouter) CheckPermission(cmd Cmd, valid Valid) error {
+	h, ok := r.handlers[cmd]
+	if !ok {
+		return errors.Join(ErrRpcCmdNotFound, errors.New(string(cmd)))
+	}
+	if valid.FromSys {
+		return nil
+	}
+	if len(h.permissions) == 0 || !valid.HasOnePermission(h.permissions...) {
+		return ErrRpcNoPermission
+	}
+	return nil
+}
+
+// Permissions 返回命令声明的权限
+func (r *RpcRouter) Permissions(cmd Cmd) ([]Permission, bool) {
+	h, ok := r.handlers[cmd]
+	if !ok {
+		return nil, false
+	}
+	return h.permissions, true
+}
+
+// Commands 按注册顺序列出全部命令与请求、返回的JSON Schema
+func (r *RpcRouter) Commands() []RpcCmdInfo {
+	ret := make([]RpcCmdInfo, 0, len(r.cmds))
+	for _, cmd := range r.cmds {
+		h := r.handlers[cmd]
+		ret = append(ret, RpcCmdInfo{
+			Cmd:         cmd,
+			Permissions: h.permissions,
+			Timeout:     r.getTimeout(h),
+			Stream:      h.stream,
+			ReqSchema:   JsonSchema(h.reqType),
+			RetSchema:   JsonSchema(h.retType),
+		})
+	}
+	return ret
+}
+
+// IRpcService 使用 RpcRouter 的服务实现该接口，core 据此对外提供命令自省
+type IRpcService interface {
+	RpcRouter() *RpcRouter
+}
+
+// Call 带类型的跨服务调用，call 一般传 ServiceShare.CallOtherRpc
+func Call[ReqT any, RetT any](ctx context.Context, call func(ctx context.Context, to SvrFlag, msg Msg) (interface{}, error), to SvrFlag, cmd Cmd, req ReqT) (RetT, error) {
+	var ret RetT
+	raw, err := call(ctx, to, MakeMsg(cmd, req))
+	if err != nil {
+		return ret, err
+	}
+	switch v := raw.(type) {
+	case nil:
+		return ret, nil
+	case RetT:
+		return v, nil
+	case *RetT:
+		if v != nil {
+			ret = *v
+		}
+		return ret, nil
+	}
+	// 返回的类型和声明的不一致时走一遍json，兼容字段相同的其他类型
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return ret, errors.Join(errors.New(string(cmd)+" ret marshal err"), err)
+	}
+	err = json.Unmarshal(b, &ret)
+	if err != nil {
+		return ret, errors.Join(errors.New(string(cmd)+" ret type err"), err)
+	}
+	return ret, nil
+}
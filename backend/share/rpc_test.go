@@ -0,0 +1,149 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type addReq struct {
+	A    int
+	B    int      `json:"b"`
+	Tags []string `json:"tags,omitempty"`
+	At   time.Time
+}
+
+type addRet struct {
+	Sum int
+}
+
+type otherAddRet struct {
+	Sum int
+}
+
+func TestRpcRouterHandleAndCall(t *testing.T) {
+	r := NewRpcRouter()
+	Register(r, "add", func(valid Valid, req addReq) (addRet, error) {
+		return addRet{Sum: req.A + req.B}, nil
+	})
+	call := func(ctx context.Context, to SvrFlag, msg Msg) (interface{}, error) {
+		return r.Handle(ctx, msg, MakeSysValid())
+	}
+
+	ret, err := Call[addReq, addRet](context.Background(), call, FlagAuto, "add", addReq{A: 1, B: 2})
+	if err != nil || ret.Sum != 3 {
+		t.Fatalf("call ret = %+v err = %v", ret, err)
+	}
+	// 返回类型不一致但字段兼容时通过json转换
+	other, err := Call[addReq, otherAddRet](context.Background(), call, FlagAuto, "add", addReq{A: 2, B: 2})
+	if err != nil || other.Sum != 4 {
+		t.Fatalf("call other ret = %+v err = %v", other, err)
+	}
+
+	_, err = Call[addReq, addRet](context.Background(), call, FlagAuto, "sub", addReq{})
+	if !errors.Is(err, ErrRpcCmdNotFound) {
+		t.Fatalf("unknown cmd err = %v", err)
+	}
+}
+
+func TestRpcRouterRegisterDuplicatePanics(t *testing.T) {
+	r := NewRpcRouter()
+	handle := func(valid Valid, req addReq) (addRet, error) { return addRet{}, nil }
+	Register(r, "add", handle)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on duplicate cmd")
+		}
+	}()
+	Register(r, "add", handle)
+}
+
+func TestRpcRouterCommandsSchema(t *testing.T) {
+	r := NewRpcRouter()
+	Register(r, "add", func(valid Valid, req addReq) (addRet, error) { return addRet{}, nil })
+	cmds := r.Commands()
+	if len(cmds) != 1 || cmds[0].Cmd != "add" {
+		t.Fatalf("commands = %+v", cmds)
+	}
+	props := cmds[0].ReqSchema["properties"].(map[string]interface{})
+	want := map[string]interface{}{
+		"A":    map[string]interface{}{"type": "integer"},
+		"b":    map[string]interface{}{"type": "integer"},
+		"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"At":   map[string]interface{}{"type": "string", "format": "date-time"},
+	}
+	if !reflect.DeepEqual(props, want) {
+		t.Fatalf("req schema = %+v", props)
+	}
+}
+
+func TestRpcRouterCheckPermission(t *testing.T) {
+	r := NewRpcRouter()
+	handle := func(valid Valid, req addReq) (addRet, error) { return addRet{}, nil }
+	Register(r, "add", handle, PermissionAdmin, PermissionAuto)
+	Register(r, "sysOnly", handle)
+
+	user := Valid{User: "u", Permissions: []Permission{PermissionAuto}, ValidTime: time.Now().Add(time.Hour).Unix()}
+	if err := r.CheckPermission("add", user); err != nil {
+		t.Fatalf("auto user denied: %v", err)
+	}
+	if err := r.CheckPermission("sysOnly", user); !errors.Is(err, ErrRpcNoPermission) {
+		t.Fatalf("undeclared cmd err = %v", err)
+	}
+	if err := r.CheckPermission("sysOnly", MakeSysValid()); err != nil {
+		t.Fatalf("sys denied: %v", err)
+	}
+	user.Permissions = []Permission{PermissionCmd}
+	if err := r.CheckPermission("add", user); !errors.Is(err, ErrRpcNoPermission) {
+		t.Fatalf("cmd user err = %v", err)
+	}
+	if err := r.CheckPermission("sub", user); !errors.Is(err, ErrRpcCmdNotFound) {
+		t.Fatalf("unknown cmd err = %v", err)
+	}
+}
+
+func TestRpcRouterStream(t *testing.T) {
+	r := NewRpcRouter()
+	RegisterStream[addReq, addRet](r, "watch", PermissionAuto)
+
+	user := Valid{User: "u", Permissions: []Permission{PermissionAuto}, ValidTime: time.Now().Add(time.Hour).Unix()}
+	if !r.IsStream("watch") || r.CheckPermission("watch", user) != nil {
+		t.Fatalf("stream cmd not declared")
+	}
+	user.Permissions = []Permission{PermissionCmd}
+	if err := r.CheckPermission("watch", user); !errors.Is(err, ErrRpcNoPermission) {
+		t.Fatalf("stream cmd user err = %v", err)
+	}
+	// 长连接命令不能当作普通rpc调用
+	if _, err := r.Handle(context.Background(), MakeMsg("watch", addReq{}), MakeSysValid()); !errors.Is(err, ErrRpcCmdNotFound) {
+		t.Fatalf("handle stream cmd err = %v", err)
+	}
+	if cmds := r.Commands(); len(cmds) != 1 || !cmds[0].Stream || cmds[0].RetSchema == nil {
+		t.Fatalf("commands = %+v", cmds)
+	}
+}
+
+func TestRpcRouterTimeout(t *testing.T) {
+	r := NewRpcRouter()
+	RegisterCtx(r, "wait", func(ctx context.Context, valid Valid, req addReq) (addRet, error) {
+		<-ctx.Done()
+		return addRet{}, ctx.Err()
+	})
+	r.SetTimeout("wait", 10*time.Millisecond)
+	if got := r.Commands()[0].Timeout; got != 10*time.Millisecond {
+		t.Fatalf("timeout in commands = %s", got)
+	}
+	_, err := r.Handle(context.Background(), MakeMsg("wait", addReq{}), MakeSysValid())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("timeout err = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.Handle(ctx, MakeMsg("wait", addReq{}), MakeSysValid())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled err = %v", err)
+	}
+}
@@ -15,21 +15,46 @@ import (
 // ServiceShare 服务共享的资源
 // 例如配置、日志、推送、存储等等
 type ServiceShare struct {
-	Log          *xlog.XLog                                     // 共用的日志服务
-	Push         *xpush.XPush                                   // 共用的推送服务
-	Storage      *xstorage.XStorage                             // 共用的存储服务，如果有自己私有的数据，在用户内部自己起一个
-	Cfg          *xstorage.CfgExt                               // 共用的配置服务
-	Bi           *xbi.XBi                                       // 公用的日志服务
-	CallOther    func(to SvrFlag, msg Msg)                      // 向别的服务发送请求，可能没有返回值或者通过msg返回，错误也自己处理吧
-	CallOtherRpc func(to SvrFlag, msg Msg) (interface{}, error) // 向别的服务发送rpc请求
+	Log          *xlog.XLog                                                          // 共用的日志服务
+	Push         *xpush.XPush                                                        // 共用的推送服务
+	Storage      *xstorage.XStorage                                                  // 共用的存储服务，如果有自己私有的数据，在用户内部自己起一个
+	Cfg          *xstorage.CfgExt                                                    // 共用的配置服务
+	Bi           *xbi.XBi                                                            // 公用的日志服务
+	CallOther    func(to SvrFlag, msg Msg)                                           // 向别的服务发送请求，可能没有返回值或者通过msg返回，错误也自己处理吧
+	CallOtherRpc func(ctx context.Context, to SvrFlag, msg Msg) (interface{}, error) // 向别的服务发送rpc请求，ctx取消或超时后对方应尽快返回
+	Publish      func(topic Topic, msg Msg)                                          // 向总线发布事件，订阅者异步收到，投递失败进入死信日志
+	Subscribe    func(topic Topic, handler func(msg Msg))                            // 订阅事件，handler在本服务的队列中串行执行，服务停止时自动退订
 	BaseSetting  BaseSetting
 	Ctx          context.Context
 }
 
+// Topic 消息总线上的事件主题
+type Topic string
+
+const (
+	TopicTodoneTaskDone      Topic = "todone.task.done"      // 任务被标记为完成，数据为 TodoneTaskDoneEvent
+	TopicTodoneTaskUnblocked Topic = "todone.task.unblocked" // 任务的最后一个阻塞任务完成，数据为 TodoneTaskUnblockedEvent
+)
+
+type TodoneTaskDoneEvent struct {
+	UserID string
+	TaskID uint32
+	Title  string
+}
+
+type TodoneTaskUnblockedEvent struct {
+	UserID string
+	TaskID uint32
+	Title  string
+	// BlockerID 刚刚完成的阻塞任务
+	BlockerID uint32
+}
+
 type Msg struct {
 	cmd     Cmd
 	data    interface{}
 	dataStr string
+	reqID   string // 发起请求的ID，用于串联一次请求在各服务中的日志
 }
 
 type ServiceProp uint32
@@ -63,6 +88,16 @@ func (m *Msg) Cmd() Cmd {
 	return m.cmd
 }
 
+// WithReqID 返回带有请求ID的消息
+func (m Msg) WithReqID(reqID string) Msg {
+	m.reqID = reqID
+	return m
+}
+
+func (m *Msg) ReqID() string {
+	return m.reqID
+}
+
 func (m *Msg) Data(bind interface{}) error {
 	// 判断是否为指针
 	if reflect.TypeOf(bind).Kind() != reflect.Ptr {
@@ -131,11 +166,32 @@ type IService interface {
 	Start(share ServiceShare) error
 	Stop() error
 	Handle(msg Msg, valid Valid)
-	HandleRpc(msg Msg, valid Valid) (interface{}, error)
+	HandleRpc(ctx context.Context, msg Msg, valid Valid) (interface{}, error) // ctx 随请求方断开或超时取消
 	GetProp() ServiceProp
 	DebugCommand(req DebugReq) interface{}
 }
 
+// ErrServiceDegraded 健康检查返回的错误能被 errors.Is 识别为该错误时视为降级，服务仍然可用，不会被重启
+var ErrServiceDegraded = errors.New("service degraded")
+
+// IHealthProbe 服务可选实现的健康探测接口，core 会定期调用，返回其他错误视为失败并按退避重启
+type IHealthProbe interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// StreamConn 长连接的一端，同一时刻只能有一个协程读、一个协程写
+type StreamConn interface {
+	ReadJSON(v interface{}) error
+	WriteJSON(v interface{}) error
+}
+
+// IStreamService 服务可选实现的长连接接口，web 层把 GET /service/:name/:cmd 升级为 WebSocket 后交给服务，
+// 使用 RpcRouter 的服务用 RegisterStream 声明命令，由 core 按声明的权限统一校验，其他服务自己校验。
+// ctx 随连接断开、服务停止或平台退出取消，返回后连接关闭
+type IStreamService interface {
+	HandleStream(ctx context.Context, cmd Cmd, valid Valid, conn StreamConn) error
+}
+
 type Cmd string
 
 func HandleRpcTool[ReqT any, RetT any](name string, msg Msg, valid Valid, handle func(Valid, ReqT) (RetT, error)) (RetT, error) {
@@ -0,0 +1,161 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// flagDynamicBegin 未指定Flag的服务从这里开始自动分配，避免和 def.go 中的固定Flag冲突
+const flagDynamicBegin SvrFlag = 1000
+
+// ServiceDesc 服务的自描述，服务在自己的包内通过 RegisterService 注册，core 启动时据此发现服务
+// 属性通过 IService.GetProp 提供，这里只描述身份与依赖
+type ServiceDesc struct {
+	Name        SvrName         // 服务名，同时用于web路由与存储key
+	Flag        SvrFlag         // 可选，为FlagNone时由注册表自动分配
+	Deps        []SvrName       // 依赖的服务，启动时会先启动依赖，停止时后停止依赖
+	DefaultBoot BootPolicy      // 没有持久化过启动策略时使用，为空时视为BootAuto
+	New         func() IService // 创建服务实例
+}
+
+var (
+	ErrServiceDescInvalid   = errors.New("service desc invalid")
+	ErrServiceNameDuplicate = errors.New("service name duplicate")
+	ErrServiceFlagDuplicate = errors.New("service flag duplicate")
+	ErrServiceDepNotExist   = errors.New("service dependency not exist")
+	ErrServiceDepCycle      = errors.New("service dependency cycle")
+)
+
+// ServiceRegistry 服务注册表，负责分配Flag并根据依赖计算启动顺序
+type ServiceRegistry struct {
+	lock     sync.RWMutex
+	descs    map[SvrName]ServiceDesc
+	flags    map[SvrFlag]SvrName
+	nextFlag SvrFlag
+}
+
+func NewServiceRegistry() *ServiceRegistry {
+	return &ServiceRegistry{
+		descs:    make(map[SvrName]ServiceDesc),
+		flags:    make(map[SvrFlag]SvrName),
+		nextFlag: flagDynamicBegin,
+	}
+}
+
+// Register 注册一个服务，返回最终分配到的Flag
+func (r *ServiceRegistry) Register(desc ServiceDesc) (SvrFlag, error) {
+	if desc.Name == "" || desc.New == nil {
+		return FlagNone, ErrServiceDescInvalid
+	}
+	if desc.DefaultBoot == "" {
+		desc.DefaultBoot = BootAuto
+	}
+	if !desc.DefaultBoot.Valid() {
+		return FlagNone, errors.Join(ErrServiceDescInvalid, fmt.Errorf("boot policy %s", desc.DefaultBoot))
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.descs[desc.Name]; ok {
+		return FlagNone, errors.Join(ErrServiceNameDuplicate, fmt.Errorf("name %s", desc.Name))
+	}
+	if desc.Flag == FlagNone {
+		for {
+			_, used := r.flags[r.nextFlag]
+			if !used {
+				break
+			}
+			r.nextFlag++
+		}
+		desc.Flag = r.nextFlag
+		r.nextFlag++
+	} else if other, ok := r.flags[desc.Flag]; ok {
+		return FlagNone, errors.Join(ErrServiceFlagDuplicate, fmt.Errorf("flag %d used by %s", desc.Flag, other))
+	}
+	desc.Deps = append([]SvrName(nil), desc.Deps...)
+	r.descs[desc.Name] = desc
+	r.flags[desc.Flag] = desc.Name
+	return desc.Flag, nil
+}
+
+// Get 根据服务名获取描述
+func (r *ServiceRegistry) Get(name SvrName) (ServiceDesc, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	desc, ok := r.descs[name]
+	return desc, ok
+}
+
+// Descs 返回按名字排序的全部服务描述
+func (r *ServiceRegistry) Descs() []ServiceDesc {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ret := make([]ServiceDesc, 0, len(r.descs))
+	for _, desc := range r.descs {
+		ret = append(ret, desc)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// StartOrder 按依赖关系返回启动顺序，依赖总是排在被依赖者前面，没有依赖关系的按名字排序保证稳定
+func (r *ServiceRegistry) StartOrder() ([]ServiceDesc, error) {
+	descs := r.Descs()
+	byName := make(map[SvrName]ServiceDesc, len(descs))
+	for _, desc := range descs {
+		byName[desc.Name] = desc
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[SvrName]int, len(descs))
+	ret := make([]ServiceDesc, 0, len(descs))
+	var visit func(name SvrName, path []SvrName) error
+	visit = func(name SvrName, path []SvrName) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Join(ErrServiceDepCycle, fmt.Errorf("%v -> %s", path, name))
+		}
+		state[name] = visiting
+		desc := byName[name]
+		for _, dep := range desc.Deps {
+			if _, ok := byName[dep]; !ok {
+				return errors.Join(ErrServiceDepNotExist, fmt.Errorf("%s depends on %s", name, dep))
+			}
+			err := visit(dep, append(path, name))
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ret = append(ret, desc)
+		return nil
+	}
+	for _, desc := range descs {
+		err := visit(desc.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// GServiceRegistry 进程内默认的服务注册表，服务包在 init 中向其注册
+var GServiceRegistry = NewServiceRegistry()
+
+// RegisterService 向默认注册表注册服务，供服务包在 init 中调用，注册失败属于编码错误，直接panic
+func RegisterService(desc ServiceDesc) SvrFlag {
+	flag, err := GServiceRegistry.Register(desc)
+	if err != nil {
+		panic(errors.Join(errors.New("RegisterService failed"), err))
+	}
+	return flag
+}
@@ -0,0 +1,110 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type emptyService struct{}
+
+func (e *emptyService) Start(share ServiceShare) error { return nil }
+func (e *emptyService) Stop() error                    { return nil }
+func (e *emptyService) Handle(msg Msg, valid Valid)    {}
+func (e *emptyService) HandleRpc(ctx context.Context, msg Msg, valid Valid) (interface{}, error) {
+	return nil, nil
+}
+func (e *emptyService) GetProp() ServiceProp                  { return SvrPropMicro }
+func (e *emptyService) DebugCommand(req DebugReq) interface{} { return nil }
+
+func newEmptyService() IService {
+	return &emptyService{}
+}
+
+func TestServiceRegistryStartOrder(t *testing.T) {
+	r := NewServiceRegistry()
+	descs := []ServiceDesc{
+		{Name: "c", Deps: []SvrName{"b"}, New: newEmptyService},
+		{Name: "a", Flag: FlagAuto, New: newEmptyService},
+		{Name: "b", Deps: []SvrName{"a"}, New: newEmptyService},
+		{Name: "d", New: newEmptyService},
+	}
+	for _, desc := range descs {
+		if _, err := r.Register(desc); err != nil {
+			t.Fatalf("register %s: %v", desc.Name, err)
+		}
+	}
+
+	order, err := r.StartOrder()
+	if err != nil {
+		t.Fatalf("start order: %v", err)
+	}
+	var names []SvrName
+	for _, desc := range order {
+		names = append(names, desc.Name)
+	}
+	want := []SvrName{"a", "b", "c", "d"}
+	if len(names) != len(want) {
+		t.Fatalf("order = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("order = %v, want %v", names, want)
+		}
+	}
+
+	a, _ := r.Get("a")
+	if a.Flag != FlagAuto {
+		t.Fatalf("fixed flag = %d, want %d", a.Flag, FlagAuto)
+	}
+	b, _ := r.Get("b")
+	c, _ := r.Get("c")
+	if b.Flag < flagDynamicBegin || c.Flag < flagDynamicBegin || b.Flag == c.Flag {
+		t.Fatalf("dynamic flags not allocated: b=%d c=%d", b.Flag, c.Flag)
+	}
+}
+
+func TestServiceRegistryRejectsBadDesc(t *testing.T) {
+	r := NewServiceRegistry()
+	if _, err := r.Register(ServiceDesc{Name: "a"}); !errors.Is(err, ErrServiceDescInvalid) {
+		t.Fatalf("missing New err = %v", err)
+	}
+	if _, err := r.Register(ServiceDesc{Name: "a", Flag: FlagAuto, New: newEmptyService}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := r.Register(ServiceDesc{Name: "a", New: newEmptyService}); !errors.Is(err, ErrServiceNameDuplicate) {
+		t.Fatalf("duplicate name err = %v", err)
+	}
+	if _, err := r.Register(ServiceDesc{Name: "b", Flag: FlagAuto, New: newEmptyService}); !errors.Is(err, ErrServiceFlagDuplicate) {
+		t.Fatalf("duplicate flag err = %v", err)
+	}
+}
+
+func TestServiceRegistryDependencyErrors(t *testing.T) {
+	r := NewServiceRegistry()
+	_, _ = r.Register(ServiceDesc{Name: "a", Deps: []SvrName{"missing"}, New: newEmptyService})
+	if _, err := r.StartOrder(); !errors.Is(err, ErrServiceDepNotExist) {
+		t.Fatalf("missing dep err = %v", err)
+	}
+
+	r = NewServiceRegistry()
+	_, _ = r.Register(ServiceDesc{Name: "a", Deps: []SvrName{"b"}, New: newEmptyService})
+	_, _ = r.Register(ServiceDesc{Name: "b", Deps: []SvrName{"a"}, New: newEmptyService})
+	if _, err := r.StartOrder(); !errors.Is(err, ErrServiceDepCycle) {
+		t.Fatalf("cycle err = %v", err)
+	}
+}
+
+func TestServiceRegistryDefaultBoot(t *testing.T) {
+	r := NewServiceRegistry()
+	_, _ = r.Register(ServiceDesc{Name: "a", New: newEmptyService})
+	_, _ = r.Register(ServiceDesc{Name: "b", DefaultBoot: BootManual, New: newEmptyService})
+	if _, err := r.Register(ServiceDesc{Name: "c", DefaultBoot: "sometimes", New: newEmptyService}); !errors.Is(err, ErrServiceDescInvalid) {
+		t.Fatalf("invalid boot policy err = %v", err)
+	}
+	a, _ := r.Get("a")
+	b, _ := r.Get("b")
+	if a.DefaultBoot != BootAuto || b.DefaultBoot != BootManual {
+		t.Fatalf("default boot a=%s b=%s", a.DefaultBoot, b.DefaultBoot)
+	}
+}
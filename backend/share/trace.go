@@ -0,0 +1,24 @@
+package share
+
+import "context"
+
+// ReqIDHeader 请求ID的http头，前端或网关传入时沿用，否则由平台生成
+const ReqIDHeader = "X-Request-ID"
+
+type reqIDKey struct{}
+
+// ContextWithReqID 将请求ID放入ctx，数据库等下游通过 ReqIDFromContext 取出用于串联日志
+func ContextWithReqID(ctx context.Context, reqID string) context.Context {
+	if reqID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, reqIDKey{}, reqID)
+}
+
+func ReqIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	reqID, _ := ctx.Value(reqIDKey{}).(string)
+	return reqID
+}
@@ -50,6 +50,7 @@ function DirTreeNodeTitle({
                               title,
                               isDir,
                               note,
+                              revision,
                               onAddDir,
                               onAddGroup,
                               onChange,
@@ -60,9 +61,11 @@ function DirTreeNodeTitle({
     title: string,
     isDir: boolean,
     note: string,
+    // 分组读取时的版本，修改时带回
+    revision?: number,
     onAddDir?: (dir: PDir) => void,
     onAddGroup?: (group: PGroup) => void,
-    onChange: (title: string, note: string) => void,
+    onChange: (title: string, note: string, revision?: number) => void,
     onMove: (parentDirID: number, newIndex: number) => void,
     onDelSelf: () => void,
     addr: Addr,
@@ -163,11 +166,12 @@ function DirTreeNodeTitle({
                     addr={addr}
                     title={title}
                     note={note}
+                    revision={revision}
                     onCancel={function (): void {
                         setStartChange(false);
                     }}
-                    onChange={function (title: string, note: string): void {
-                        onChange(title, note);
+                    onChange={function (title: string, note: string, revision?: number): void {
+                        onChange(title, note, revision);
                         setStartChange(false);
                     }}
                     onMove={onMove}
@@ -210,8 +214,9 @@ interface DirChangePanelProps {
     addr: Addr,
     title: string,
     note: string,
+    revision?: number,
     onCancel: () => void,
-    onChange: (title: string, note: string) => void,
+    onChange: (title: string, note: string, revision?: number) => void,
     onMove: (parentDirID: number, newIndex: number) => void,
 }
 
@@ -254,11 +259,12 @@ function DirChangePanel(props: DirChangePanelProps) {
                     GroupID: props.addr.getLastUnit().ID,
                     Title: values.title,
                     Note: values.note,
+                    Revision: props.revision ?? 0,
                 }
                 sendChangeGroup(req, (ret) => {
                     setLoading(false);
                     if (ret.ok) {
-                        props.onChange(values.title, values.note);
+                        props.onChange(values.title, values.note, ret.data.Revision);
                         message.success("修改成功").then();
                     } else {
                         props.onCancel();
@@ -528,9 +534,11 @@ function PDir2TreeDataNode(pDir: PDirTree, addr: Addr, onRefresh: () => void, on
                 isDir={false}
                 title={grp.Title}
                 note={grp.Note}
-                onChange={(title: string, note: string) => {
+                revision={grp.Revision}
+                onChange={(title: string, note: string, revision?: number) => {
                     grp.Title = title;
                     grp.Note = note;
+                    grp.Revision = revision;
                     onRefresh();
                 }}
                 onDelSelf={() => {
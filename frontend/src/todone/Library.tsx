@@ -1128,8 +1128,9 @@ export default function Library({addr, groupTitle}: LibraryProps) {
         return new Promise((resolve) => {
             sendChangeTask(req, (ret) => {
                 if (ret.ok) {
-                    setTasks(prev => prev.map(t => t.ID === item.taskId ? updatedTask : t));
-                    const savedItem = parseLibraryFromTask(updatedTask);
+                    const savedTask: PTask = {...updatedTask, Revision: ret.data.Revision};
+                    setTasks(prev => prev.map(t => t.ID === item.taskId ? savedTask : t));
+                    const savedItem = parseLibraryFromTask(savedTask);
                     setDetailItem(prev => prev && prev.taskId === item.taskId ? savedItem : prev);
                     message.success('保存成功');
                     resolve(true);
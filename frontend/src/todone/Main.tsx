@@ -17,8 +17,14 @@ import Library from "./Library";
 import {useLoginGate} from "../common/useLoginGate";
 
 const TodoneConfigs = new ConfigsCtr(ConfigsType.Server, 'todone')
+TodoneConfigs.addBaseConfig('db.driver', '存储驱动(worker/sqlite)', ConfigType.String, 'worker')
+TodoneConfigs.addBaseConfig('db.sqlite_path', 'SQLite 文件', ConfigType.String, 'todone.db')
 TodoneConfigs.addBaseConfig('db.worker_endpoint', 'Worker Endpoint', ConfigType.String, 'https://worker.example.com')
 TodoneConfigs.addBaseConfig('db.worker_token', 'Worker Token', ConfigType.String, '', {secret: true})
+TodoneConfigs.addBaseConfig('reminder.enable', '截止提醒推送', ConfigType.Bool, '开启后通过推送发送截止、逾期提醒与每日汇总')
+TodoneConfigs.addBaseConfig('reminder.offsets', '截止前提醒', ConfigType.SliceString, '如 24h、1h，为空时使用 24h 与 1h')
+TodoneConfigs.addBaseConfig('reminder.summary_time', '每日汇总时间', ConfigType.String, 'HH:MM，默认 09:00，off 关闭')
+TodoneConfigs.addBaseConfig('trash.retention_days', '回收站保留天数', ConfigType.Int, '超过天数后彻底删除，0 为默认 30 天，负数不自动清理')
 TodoneConfigs.addCallback((isInit: boolean) => {
     if (!isInit) {
         message.warning('配置已经更新，需要重启服务').then()
@@ -26,7 +32,7 @@ TodoneConfigs.addCallback((isInit: boolean) => {
 })
 
 export function TodoneSetting() {
-    return <Card title="Todone Worker 配置" style={{marginBottom: 16}}>
+    return <Card title="Todone 配置" style={{marginBottom: 16}}>
         <UniConfig configCtr={TodoneConfigs}/>
     </Card>
 }
@@ -133,6 +133,7 @@ function SubGroupEditPanel(props: SubGroupEditPanelProps) {
                 // 发送请求
                 sendChangeSubGroup(req, (ret) => {
                     if (ret.ok) {
+                        newData.Revision = ret.data.Revision;
                         message.success("修改分组成功").then();
                         props.onOk(values.title, values.note);
                     } else {
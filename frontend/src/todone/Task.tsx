@@ -727,6 +727,7 @@ export function Task(props: TaskProps) {
                         }
                         sendChangeTask(req, (ret) => {
                             if (ret.ok) {
+                                pTask.task.Revision = ret.data.Revision;
                                 props.refreshTree();
                             } else {
                                 // 失败了
@@ -124,6 +124,7 @@ export function TaskDetail(props: TaskDetailProps) {
         sendChangeTask(req, (ret) => {
             setEditing(false);
             if (ret.ok) {
+                task.Revision = ret.data.Revision;
                 props.refreshApi();
             } else {
                 message.error("修改任务失败").then();
@@ -11,6 +11,7 @@ export interface PGroup {
     Note: string;
     Index: number;
     Type?: number; // GroupType: 0=Normal, 1=Library
+    Revision?: number; // 修改时必须带回，不一致时返回冲突
 }
 
 export interface PDirTree {
@@ -27,6 +28,7 @@ export interface PSubGroup {
     Title: string;
     Note: string;
     Index: number;
+    Revision?: number; // 修改时必须带回，不一致时返回冲突
 }
 
 export interface PTask {
@@ -47,6 +49,188 @@ export interface PTask {
     EndTime: string
 
     Wait4: string
+    // 重复规则，null表示不重复，通过 setTaskRepeat 修改
+    Repeat?: PRepeatRule | null
+    // 修改时带回，不一致时返回冲突，不带表示不检查
+    Revision?: number
+    // 阻塞这个任务的任务，通过 sendAddTaskBlocker/sendDelTaskBlocker 修改，只在读取时返回
+    BlockedBy?: number[] | null
+    // 还有未完成的阻塞任务
+    Blocked?: boolean
+}
+
+// 重复规则，完成后按规则生成下一次任务
+export interface PRepeatRule {
+    Type: 'daily' | 'weekly' | 'monthly' | 'cron' | 'afterDone'
+    // 每隔几个周期，afterDone 为完成后的天数，0按1处理
+    Interval?: number
+    // weekly 使用，0为周日
+    Weekdays?: number[] | null
+    // monthly 使用，0为沿用任务原日期(设置时固定)，超过当月天数时取月末
+    MonthDay?: number
+    // cron 使用，标准5段表达式
+    Cron?: string
+    // 截止时间，零值表示不结束
+    Until?: string
+}
+
+export interface POccurrence {
+    BeginTime: string
+    EndTime: string
+}
+
+// 一次任务操作的记录，Before 中没有而 After 中有的任务是本次新建的
+export interface PTaskHistory {
+    ID: number
+    Op: 'create' | 'change' | 'move' | 'tag' | 'delete' | 'undo' | 'batch'
+    Before: PTask[] | null
+    After: PTask[] | null
+    // undo 记录撤销的是哪一条
+    RevertID: number
+    // 这条记录已经被撤销
+    Reverted: boolean
+    CreatedAt: string
+}
+
+export type TrashType = 'dir' | 'group' | 'subGroup' | 'task'
+
+// 回收站中的一项，与它一起删除的子任务不单独列出
+export interface PTrashItem {
+    Type: TrashType
+    ID: number
+    Title: string
+    // 原来的父节点，目录与分组是目录，子分组是分组，任务是子分组
+    ParentID: number
+    ParentTaskID: number
+    // 原来的父节点已经删除，需要指定新的父节点才能恢复
+    ParentMissing: boolean
+    DeletedAt: string
+    // 自动清理的时间，不自动清理时为零值
+    ExpireAt: string
+}
+
+export interface PTrashKey {
+    Type: TrashType
+    ID: number
+}
+
+// 导出格式的版本，与后端 WorkspaceArchiveVersion 一致
+export const WorkspaceArchiveVersion = 2
+
+// 一个用户全部未删除的数据，其中的ID只用于互相引用，导入时全部重新分配
+export interface PWorkspaceArchive {
+    Version: number
+    ExportedAt: string
+    // 父目录在前，第一个是根目录
+    Dirs: PArchiveDir[] | null
+    Groups: PArchiveGroup[] | null
+    SubGroups: PArchiveSubGroup[] | null
+    // 父任务在前
+    Tasks: PArchiveTask[] | null
+    LibraryNotes: LibraryNote[] | null
+    LibraryScoreDetails: LibraryScoreDetail[] | null
+    // 两端都在导出内容中的阻塞关系
+    Blockers: PArchiveBlocker[] | null
+    // 设置过的标签展示信息
+    TagMetas: PArchiveTagMeta[] | null
+}
+
+// BlockerID 完成前 TaskID 处于阻塞状态
+export interface PArchiveBlocker {
+    TaskID: number
+    BlockerID: number
+}
+
+export interface PArchiveTagMeta {
+    Tag: string
+    Color: string
+    Pinned: boolean
+}
+
+export interface PArchiveDir extends PDir {
+    ParentID: number
+}
+
+export interface PArchiveGroup extends PGroup {
+    ParentDir: number
+}
+
+export interface PArchiveSubGroup extends PSubGroup {
+    ParentGroupID: number
+    TaskSequence: string
+}
+
+export interface PArchiveTask extends PTask {
+    SubGroupID: number
+    RepeatNextID: number
+    CreatedAt: string
+    UpdatedAt: string
+}
+
+export interface PUpcoming {
+    TaskID: number
+    Occurrences: POccurrence[] | null
+}
+
+// 任务所在位置，Titles 从顶层目录到子分组，不含根目录
+export interface PTaskPath {
+    DirID: number
+    GroupID: number
+    SubGroupID: number
+    Titles: string[] | null
+}
+
+export type ViewName = 'today' | 'week' | 'started' | 'tag' | 'waiting' | 'custom'
+
+// 视图的筛选条件，各条件之间是与的关系
+export interface PViewFilter {
+    // 需要同时带有这些标签
+    Tags?: string[] | null
+    Started?: boolean
+    Waiting?: boolean
+    // 截止时间范围[DueFrom, DueTo)，设置任一项时没有截止时间的任务不返回
+    DueFrom?: string
+    DueTo?: string
+    ContainDone?: boolean
+}
+
+export interface PViewTask {
+    Task: PTask
+    Path: PTaskPath
+}
+
+export type ShareRole = 'viewer' | 'editor'
+
+// 分组被分享给的账号
+export interface PGroupShare {
+    GranteeID: string
+    Role: ShareRole
+    CreatedAt: string
+}
+
+// 标签目录中的一项，Count为未删除任务上的使用次数
+export interface PTag {
+    Tag: string
+    Count: number
+    Color: string // #rrggbb，空表示默认样式
+    Pinned: boolean
+}
+
+// 别人分享给自己的分组，访问时DirID使用ParentDir
+export interface PSharedGroup extends PGroup {
+    ParentDir: number
+    OwnerID: string
+    Role: ShareRole
+}
+
+export interface PSearchHit {
+    Task: PTask
+    Path: PTaskPath
+    Score: number
+    // title note tag libraryNote
+    MatchFields: string[] | null
+    LibraryNoteID: string
+    Snippet: string
 }
 
 export enum TaskType {
@@ -268,3 +452,18 @@ export enum GroupType {
     Normal = 0,
     Library = 1,
 }
+
+export type ChangeKind = 'dir' | 'group' | 'subGroup' | 'task'
+export type ChangeOp = 'create' | 'change' | 'move' | 'delete' | 'tag' | 'unblock'
+
+// 变更流中的一条记录，只说明哪里变了，收到后重新拉取对应的数据。
+// ParentID 是所在的容器：目录与分组为目录，子分组为分组，任务为子分组；换了容器时 FromParentID 为原来的容器
+export interface PChange {
+    Seq: number
+    Kind: ChangeKind
+    Op: ChangeOp
+    ID: number
+    ParentID: number
+    FromParentID: number
+    CreatedAt: string
+}
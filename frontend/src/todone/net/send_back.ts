@@ -1,5 +1,5 @@
 import {UniPost, UniResult} from "../../common/newSendHttp";
-import {LibraryNote, LibraryScoreDetail, LibraryScoreDetailDimension, PDirTree, PSubGroup, PTask} from "./protocal";
+import {LibraryNote, LibraryScoreDetail, LibraryScoreDetailDimension, PChange, PDirTree, PGroupShare, PRepeatRule, PSearchHit, PSharedGroup, PSubGroup, PTag, PTask, PTaskHistory, PTrashItem, PTrashKey, PUpcoming, PViewFilter, PViewTask, PWorkspaceArchive, ShareRole, TrashType, ViewName} from "./protocal";
 import config from "../../config.json";
 
 export interface GetDirTreeReq {
@@ -8,6 +8,8 @@ export interface GetDirTreeReq {
 
 export interface GetDirTreeRet {
     DirTree: PDirTree
+    // 别人分享给自己的分组
+    Shared: PSharedGroup[] | null
 }
 
 
@@ -84,6 +86,8 @@ export interface CreateGroupReq {
     ParentDir: number
     AfterID: number
     GroupType: number
+    // 可选的UUID，重试同一个请求时返回已经创建的分组
+    ClientRequestID?: string
 }
 
 export interface CreateGroupRet {
@@ -98,9 +102,13 @@ export interface ChangeGroupReq {
     GroupID: number
     Title: string
     Note: string
+    // 读取时的版本，必须带上，不一致时返回冲突
+    Revision: number
 }
 
-export type ChangeGroupRet = object
+export interface ChangeGroupRet {
+    Revision: number
+}
 
 
 export interface GetSubGroupReq {
@@ -135,7 +143,12 @@ export interface ChangeTaskReq {
     Data: PTask
 }
 
-export type ChangeTaskRet = object
+export interface ChangeTaskRet {
+    // 重复任务被标记完成时生成的下一次
+    NextTask?: PTask | null
+    // 修改后的版本，下次修改时带回
+    Revision: number
+}
 
 export interface LibraryTaskScope {
     DirID: number
@@ -215,6 +228,8 @@ export interface CreateTaskReq {
     AfterID: number
     Started: boolean
     TaskType: number
+    // 可选的UUID，重试同一个请求时返回已经创建的任务
+    ClientRequestID?: string
 }
 
 export interface CreateTaskRet {
@@ -445,6 +460,8 @@ export interface CreateSubGroupReq {
     Title: string
     Note: string
     AfterID: number
+    // 可选的UUID，重试同一个请求时返回已经创建的子分组
+    ClientRequestID?: string
 }
 
 export interface CreateSubGroupRet {
@@ -494,10 +511,13 @@ export interface GetTasksReq {
     GroupID: number
     SubGroupID: number
     ContainDone: boolean
+    // 大于0时同时返回未完成的重复任务之后几次的时间，最多10次
+    Upcoming?: number
 }
 
 export interface GetTasksRet {
     Tasks: PTask[] | null
+    Upcoming?: PUpcoming[] | null
 }
 
 
@@ -519,7 +539,9 @@ export interface ChangeSubGroupReq {
     Data: PSubGroup
 }
 
-export type ChangeSubGroupRet = object
+export interface ChangeSubGroupRet {
+    Revision: number
+}
 
 
 export function sendChangeSubGroup(req: ChangeSubGroupReq, callback: (ret: {
@@ -548,9 +570,14 @@ export interface TaskMoveReq {
     TrgParentID: number
     TrgTaskID: number
     After: boolean
+    // 与TaskIDs一一对应的读取时版本，不带表示不检查
+    Revisions?: number[]
 }
 
-export type TaskMoveRet = object
+export interface TaskMoveRet {
+    // 与TaskIDs一一对应的移动后版本
+    Revisions: number[]
+}
 
 
 export function sendTaskMove(req: TaskMoveReq, callback: (ret: { data: TaskMoveRet, ok: boolean }) => void) {
@@ -608,3 +635,562 @@ export function sendTaskDelTag(req: TaskDelTagReq, callback: (ret: { data: TaskD
         callback(result);
     });
 }
+
+export interface SearchTasksReq {
+    UserID: string
+    Keyword: string // 空格分隔多个关键词
+    Done?: boolean
+    TaskType?: number
+    Tag?: string
+    GroupID?: number
+    CreatedFrom?: string
+    CreatedTo?: string
+    Limit?: number
+}
+
+export interface SearchTasksRet {
+    Hits: PSearchHit[] | null
+}
+
+export function sendSearchTasks(req: SearchTasksReq, callback: (ret: { data: SearchTasksRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'searchTasks', req).then((res: UniResult) => {
+        const result: { data: SearchTasksRet, ok: boolean } = {
+            data: res.data as SearchTasksRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface SetTaskRepeatReq {
+    UserID: string
+    DirID: number
+    GroupID: number
+    SubGroupID: number
+    TaskID: number
+    // null时取消重复
+    Rule: PRepeatRule | null
+}
+
+export interface SetTaskRepeatRet {
+    Task: PTask
+}
+
+export function sendSetTaskRepeat(req: SetTaskRepeatReq, callback: (ret: { data: SetTaskRepeatRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'setTaskRepeat', req).then((res: UniResult) => {
+        const result: { data: SetTaskRepeatRet, ok: boolean } = {
+            data: res.data as SetTaskRepeatRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface GetTaskHistoryReq {
+    UserID: string
+    // 非0时只返回涉及该任务的记录
+    TaskID?: number
+    // 非0时返回比它更早的记录，用于翻页
+    BeforeID?: number
+    Limit?: number
+}
+
+export interface GetTaskHistoryRet {
+    Histories: PTaskHistory[] | null
+}
+
+export function sendGetTaskHistory(req: GetTaskHistoryReq, callback: (ret: { data: GetTaskHistoryRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'getTaskHistory', req).then((res: UniResult) => {
+        const result: { data: GetTaskHistoryRet, ok: boolean } = {
+            data: res.data as GetTaskHistoryRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface UndoReq {
+    UserID: string
+    // 撤销最近几次操作，默认1，最多20
+    Count?: number
+}
+
+export interface UndoRet {
+    Undone: number[] | null
+}
+
+export function sendUndo(req: UndoReq, callback: (ret: { data: UndoRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'undo', req).then((res: UniResult) => {
+        const result: { data: UndoRet, ok: boolean } = {
+            data: res.data as UndoRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ListTrashReq {
+    UserID: string
+}
+
+export interface ListTrashRet {
+    Items: PTrashItem[] | null
+}
+
+export function sendListTrash(req: ListTrashReq, callback: (ret: { data: ListTrashRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'listTrash', req).then((res: UniResult) => {
+        const result: { data: ListTrashRet, ok: boolean } = {
+            data: res.data as ListTrashRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface RestoreItemReq {
+    UserID: string
+    Type: TrashType
+    ID: number
+    // 恢复到哪里，0表示原来的父节点。目录与分组填目录，子分组填分组，任务填子分组
+    ParentID?: number
+}
+
+export interface RestoreItemRet {
+}
+
+export function sendRestoreItem(req: RestoreItemReq, callback: (ret: { data: RestoreItemRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'restoreItem', req).then((res: UniResult) => {
+        const result: { data: RestoreItemRet, ok: boolean } = {
+            data: res.data as RestoreItemRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface PurgeTrashReq {
+    UserID: string
+    // 为空时清空整个回收站
+    Items?: PTrashKey[]
+}
+
+export interface PurgeTrashRet {
+    Purged: number
+}
+
+export function sendPurgeTrash(req: PurgeTrashReq, callback: (ret: { data: PurgeTrashRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'purgeTrash', req).then((res: UniResult) => {
+        const result: { data: PurgeTrashRet, ok: boolean } = {
+            data: res.data as PurgeTrashRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ExportWorkspaceReq {
+    UserID: string
+}
+
+export interface ExportWorkspaceRet {
+    Archive: PWorkspaceArchive
+}
+
+export function sendExportWorkspace(req: ExportWorkspaceReq, callback: (ret: { data: ExportWorkspaceRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'exportWorkspace', req).then((res: UniResult) => {
+        const result: { data: ExportWorkspaceRet, ok: boolean } = {
+            data: res.data as ExportWorkspaceRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ImportWorkspaceReq {
+    UserID: string
+    Archive: PWorkspaceArchive
+    // 导入到哪个目录下，0表示根目录
+    ParentDirID?: number
+}
+
+export interface ImportWorkspaceRet {
+    Dirs: number
+    Groups: number
+    SubGroups: number
+    Tasks: number
+}
+
+export function sendImportWorkspace(req: ImportWorkspaceReq, callback: (ret: { data: ImportWorkspaceRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'importWorkspace', req).then((res: UniResult) => {
+        const result: { data: ImportWorkspaceRet, ok: boolean } = {
+            data: res.data as ImportWorkspaceRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export type TaskImportFormat = 'markdown' | 'csv'
+
+export interface ImportTasksReq {
+    UserID: string
+    DirID: number
+    GroupID: number
+    SubGroupID: number
+    // 不为0时导入的顶层任务都挂在这个任务下
+    ParentTask?: number
+    Format: TaskImportFormat
+    Content: string
+}
+
+export interface ImportTasksRet {
+    Tasks: PTask[] | null
+}
+
+export function sendImportTasks(req: ImportTasksReq, callback: (ret: { data: ImportTasksRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'importTasks', req).then((res: UniResult) => {
+        const result: { data: ImportTasksRet, ok: boolean } = {
+            data: res.data as ImportTasksRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ExportTasksReq {
+    UserID: string
+    DirID: number
+    GroupID: number
+    SubGroupID: number
+}
+
+export interface ExportTasksRet {
+    Markdown: string
+}
+
+export function sendExportTasks(req: ExportTasksReq, callback: (ret: { data: ExportTasksRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'exportTasks', req).then((res: UniResult) => {
+        const result: { data: ExportTasksRet, ok: boolean } = {
+            data: res.data as ExportTasksRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface GetViewReq {
+    UserID: string
+    View: ViewName
+    // tag视图使用
+    Tag?: string
+    // custom视图使用
+    Filter?: PViewFilter
+    // 默认200，最多1000
+    Limit?: number
+}
+
+export interface GetViewRet {
+    Tasks: PViewTask[] | null
+}
+
+export function sendGetView(req: GetViewReq, callback: (ret: { data: GetViewRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'getView', req).then((res: UniResult) => {
+        const result: { data: GetViewRet, ok: boolean } = {
+            data: res.data as GetViewRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ShareGroupReq {
+    UserID: string
+    GroupID: number
+    GranteeID: string
+    Role: ShareRole
+}
+
+export interface ShareGroupRet {
+}
+
+export function sendShareGroup(req: ShareGroupReq, callback: (ret: { data: ShareGroupRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'shareGroup', req).then((res: UniResult) => {
+        const result: { data: ShareGroupRet, ok: boolean } = {
+            data: res.data as ShareGroupRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface UnshareGroupReq {
+    UserID: string
+    GroupID: number
+    // 被分享的账号传自己的ID即可退出分享
+    GranteeID: string
+}
+
+export interface UnshareGroupRet {
+}
+
+export function sendUnshareGroup(req: UnshareGroupReq, callback: (ret: { data: UnshareGroupRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'unshareGroup', req).then((res: UniResult) => {
+        const result: { data: UnshareGroupRet, ok: boolean } = {
+            data: res.data as UnshareGroupRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ListGroupSharesReq {
+    UserID: string
+    GroupID: number
+}
+
+export interface ListGroupSharesRet {
+    Shares: PGroupShare[] | null
+}
+
+export function sendListGroupShares(req: ListGroupSharesReq, callback: (ret: { data: ListGroupSharesRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'listGroupShares', req).then((res: UniResult) => {
+        const result: { data: ListGroupSharesRet, ok: boolean } = {
+            data: res.data as ListGroupSharesRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface ListTagsReq {
+    UserID: string
+}
+
+export interface ListTagsRet {
+    Tags: PTag[] | null
+}
+
+export function sendListTags(req: ListTagsReq, callback: (ret: { data: ListTagsRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'listTags', req).then((res: UniResult) => {
+        const result: { data: ListTagsRet, ok: boolean } = {
+            data: res.data as ListTagsRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+// To 已经在使用时失败，合并使用 sendMergeTags
+export interface RenameTagReq {
+    UserID: string
+    From: string
+    To: string
+}
+
+export interface RenameTagRet {
+}
+
+export function sendRenameTag(req: RenameTagReq, callback: (ret: { data: RenameTagRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'renameTag', req).then((res: UniResult) => {
+        const result: { data: RenameTagRet, ok: boolean } = {
+            data: res.data as RenameTagRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface MergeTagsReq {
+    UserID: string
+    From: string[]
+    To: string
+}
+
+export interface MergeTagsRet {
+}
+
+export function sendMergeTags(req: MergeTagsReq, callback: (ret: { data: MergeTagsRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'mergeTags', req).then((res: UniResult) => {
+        const result: { data: MergeTagsRet, ok: boolean } = {
+            data: res.data as MergeTagsRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface DeleteTagReq {
+    UserID: string
+    Tag: string
+}
+
+export interface DeleteTagRet {
+}
+
+export function sendDeleteTag(req: DeleteTagReq, callback: (ret: { data: DeleteTagRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'deleteTag', req).then((res: UniResult) => {
+        const result: { data: DeleteTagRet, ok: boolean } = {
+            data: res.data as DeleteTagRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface SetTagMetaReq {
+    UserID: string
+    Tag: string
+    Color: string
+    Pinned: boolean
+}
+
+export interface SetTagMetaRet {
+}
+
+export function sendSetTagMeta(req: SetTagMetaReq, callback: (ret: { data: SetTagMetaRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'setTagMeta', req).then((res: UniResult) => {
+        const result: { data: SetTagMetaRet, ok: boolean } = {
+            data: res.data as SetTagMetaRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export type BatchOpName = 'done' | 'undone' | 'addTag' | 'delTag' | 'move' | 'delete' | 'change'
+
+// SubGroupID 为批量开始前任务所在的子分组；Revision 为批量开始前读到的版本，必须带上
+export interface BatchTaskOp {
+    Op: BatchOpName
+    SubGroupID: number
+    TaskID: number
+    Revision: number
+    Tag?: string
+    Data?: PTask
+    TrgDir?: number
+    TrgGroup?: number
+    TrgSubGroup?: number
+    TrgParentID?: number
+    TrgTaskID?: number
+    After?: boolean
+}
+
+export interface BatchTaskOpResult {
+    // 为空表示成功
+    Err: string
+    Revision: number
+}
+
+// 一次最多100项；Atomic 为 true 时任意一项失败整批不写入（事务执行，D1 不支持，返回 atomic batch unsupported）
+export interface BatchTaskOpsReq {
+    UserID: string
+    DirID: number
+    GroupID: number
+    Ops: BatchTaskOp[]
+    Atomic: boolean
+}
+
+export interface BatchTaskOpsRet {
+    Results: BatchTaskOpResult[]
+    // 至少写入了一项
+    Committed: boolean
+    NextTasks: PTask[] | null
+}
+
+export function sendBatchTaskOps(req: BatchTaskOpsReq, callback: (ret: { data: BatchTaskOpsRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'batchTaskOps', req).then((res: UniResult) => {
+        const result: { data: BatchTaskOpsRet, ok: boolean } = {
+            data: res.data as BatchTaskOpsRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+// TaskID 在 BlockerID 完成前处于阻塞状态，两个任务可以在不同的子分组中
+export interface AddTaskBlockerReq {
+    UserID: string
+    TaskID: number
+    BlockerID: number
+}
+
+export interface AddTaskBlockerRet {
+}
+
+export function sendAddTaskBlocker(req: AddTaskBlockerReq, callback: (ret: { data: AddTaskBlockerRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'addTaskBlocker', req).then((res: UniResult) => {
+        const result: { data: AddTaskBlockerRet, ok: boolean } = {
+            data: res.data as AddTaskBlockerRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+export interface DelTaskBlockerReq {
+    UserID: string
+    TaskID: number
+    BlockerID: number
+}
+
+export interface DelTaskBlockerRet {
+}
+
+export function sendDelTaskBlocker(req: DelTaskBlockerReq, callback: (ret: { data: DelTaskBlockerRet, ok: boolean }) => void) {
+    UniPost(api_base_url + 'delTaskBlocker', req).then((res: UniResult) => {
+        const result: { data: DelTaskBlockerRet, ok: boolean } = {
+            data: res.data as DelTaskBlockerRet,
+            ok: res.ok
+        };
+
+        callback(result);
+    });
+}
+
+// 变更流，连接后先发送订阅消息，Cursor 为上次收到的最后一个游标，0表示只接收之后的变更
+export interface ChangesReq {
+    UserID: string
+    Cursor: number
+}
+
+// change 一条变更；ready 补发结束；reset 需要全量刷新后从Cursor继续；ping 保活；error 出错后连接关闭
+export interface ChangesEvent {
+    Type: 'change' | 'ready' | 'reset' | 'ping' | 'error'
+    Cursor: number
+    Change?: PChange
+    Message?: string
+}
+
+// openChangeStream 返回的WebSocket由调用方关闭，断开后带着最后的Cursor重新打开即可补齐
+export function openChangeStream(req: ChangesReq, onEvent: (event: ChangesEvent) => void): WebSocket {
+    const url = new URL(api_base_url + 'changes', window.location.href);
+    url.protocol = url.protocol === "https:" ? "wss:" : "ws:";
+    const socket = new WebSocket(url.toString());
+    socket.onopen = () => socket.send(JSON.stringify(req));
+    socket.onmessage = (msg: MessageEvent) => {
+        onEvent(JSON.parse(msg.data) as ChangesEvent);
+    };
+    return socket;
+}